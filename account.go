@@ -0,0 +1,140 @@
+package oauth2
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+)
+
+// AccountSession describes an user's active session that will be returned to user.
+type AccountSession struct {
+	TokenID     string    `json:"token_id"`
+	ClientID    string    `json:"client_id"`
+	CreatedTime time.Time `json:"created_time"`
+	ExpiredTime time.Time `json:"expired_time"`
+	IsCurrent   bool      `json:"is_current"`
+}
+
+// Account describes a self-service controller for the current user.
+type Account struct {
+}
+
+// BindRoutes binds all account routes under a prefix URI. All routes require a valid token.
+//
+// @param
+// - prefixURI {string} (the prefix for url)
+func (a *Account) BindRoutes(prefixURI string) {
+	BindGet(prefixURI, nil, a.HandleProfile)
+	BindPut(prefixURI+"/password", nil, a.HandleChangePassword)
+	BindGet(prefixURI+"/sessions", nil, a.HandleListSessions)
+	BindDelete(prefixURI+"/sessions", nil, a.HandleRevokeOtherSessions)
+}
+
+// HandleProfile returns current user's profile.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Account) HandleProfile(c *server.RequestContext) {
+	s := currentContext(c)
+	c.OutputJSON(util.Status200(), createAdminUser(s.User))
+}
+
+// HandleChangePassword replaces current user's password after re-verifying the old one. All
+// other sessions will be revoked.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Account) HandleChangePassword(c *server.RequestContext) {
+	s := currentContext(c)
+
+	var inputJSON struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	/* Condition validation: Validate failed login attempts */
+	failedEvent := createSecurityEvent(c, LoginFailedEvent, s, InvalidCredentialsReason)
+	attemptKeys := loginAttemptKeys(c, s.User.Username())
+	validateLoginAttempts(c, attemptKeys, failedEvent)
+
+	/* Condition validation: Re-verify old password */
+	if RealmOf(c).Store.FindUserWithCredential(s.User.Username(), inputJSON.OldPassword) == nil {
+		saveLoginFailure(c, attemptKeys)
+		publishEvent(failedEvent)
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "old_password")))
+	}
+	unlockUser(RealmOf(c), s.User.Username())
+
+	/* Condition validation: Validate new password */
+	if !passwordValidation.MatchString(inputJSON.NewPassword) {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "new_password")))
+	}
+
+	if !adminStore(c).UpdateUserPassword(s.User.UserID(), inputJSON.NewPassword, s.AccessToken) {
+		panic(util.Status500())
+	}
+
+	// Current token is kept, so it should not be reported as revoked
+	event := createSecurityEvent(c, TokenRevokedEvent, s, AccountReason)
+	event.TokenID = ""
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleListSessions returns a page of current user's active sessions.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Account) HandleListSessions(c *server.RequestContext) {
+	s := currentContext(c)
+	offset, limit := parsePagination(c)
+	tokens, total := adminStore(c).FindAccessTokens(s.User.UserID(), "", offset, limit)
+
+	records := make([]*AccountSession, 0, len(tokens))
+	for _, token := range tokens {
+		records = append(records, &AccountSession{
+			TokenID:     tokenID(token),
+			ClientID:    token.ClientID(),
+			CreatedTime: token.CreatedTime(),
+			ExpiredTime: token.ExpiredTime(),
+			IsCurrent:   s.AccessToken != nil && tokenID(s.AccessToken) == tokenID(token),
+		})
+	}
+	c.OutputJSON(util.Status200(), &AdminPage{Offset: offset, Limit: limit, Total: total, Data: records})
+}
+
+// HandleRevokeOtherSessions revokes all current user's tokens, except the current one.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Account) HandleRevokeOtherSessions(c *server.RequestContext) {
+	s := currentContext(c)
+	adminStore(c).DeleteUserTokensExcept(s.User.UserID(), s.AccessToken)
+
+	// Current token is kept, so it should not be reported as revoked
+	event := createSecurityEvent(c, TokenRevokedEvent, s, AccountReason)
+	event.TokenID = ""
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// currentContext returns current oauth context.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - s {OAuthContext} (an oauth context)
+func currentContext(c *server.RequestContext) *OAuthContext {
+	if s, ok := c.GetExtra(oauthKey.Context).(*OAuthContext); ok && s.User != nil {
+		return s
+	}
+	panic(util.Status401())
+}
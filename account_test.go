@@ -0,0 +1,226 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+)
+
+func Test_Account_ChangePassword(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Account)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		f := server.Adapt(controller.HandleChangePassword, ValidateToken())
+		f(context)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+
+	// [Test 1] Invalid old password
+	request, _ := http.NewRequest("PUT", ts.URL, strings.NewReader(`{"old_password":"Invalid","new_password":"NewPassword"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token()))
+	response, _ := http.DefaultClient.Do(request)
+
+	status := util.ParseStatus(response)
+	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "old_password") {
+		t.Errorf(expectedFormat.InvalidParameter, "old_password", status.Description)
+	}
+
+	// [Test 2] Valid old password
+	request, _ = http.NewRequest("PUT", ts.URL, strings.NewReader(`{"old_password":"Password","new_password":"NewPassword"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token()))
+	response, _ = http.DefaultClient.Do(request)
+
+	if response.StatusCode != 204 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 204, response.StatusCode)
+	}
+	if user := Store.FindUserWithCredential(u.Username, "NewPassword"); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_Account_RevokeOtherSessions(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Account)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		f := server.Adapt(controller.HandleRevokeOtherSessions, ValidateToken())
+		f(context)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	currentToken := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	otherToken := Store.CreateAccessToken("web-app-test", u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+
+	request, _ := http.NewRequest("DELETE", ts.URL, nil)
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", currentToken.Token()))
+	http.DefaultClient.Do(request)
+
+	if token := Store.FindAccessTokenWithCredential(u.ClientID, u.UserID.Hex()); token == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if token := Store.FindAccessTokenWithCredential(otherToken.ClientID(), u.UserID.Hex()); token != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if status := callProtectedRoute(otherToken.Token()); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
+
+func Test_Account_ChangePassword_RevokesSessions(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Account)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		f := server.Adapt(controller.HandleChangePassword, ValidateToken())
+		f(context)
+	}))
+	defer ts.Close()
+
+	browser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		f := ValidateLoginSession("")(func(c *server.RequestContext) {})
+		f(context)
+	}))
+	defer browser.Close()
+
+	now := time.Now()
+	currentToken := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	otherToken := Store.CreateAccessToken("web-app-test", u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	cookie := &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, createLoginSession(defaultRealm(), u.UserID.Hex()))}
+
+	// Browser's session is valid before password is changed
+	request, _ := http.NewRequest("GET", browser.URL, nil)
+	request.AddCookie(cookie)
+	if response, _ := http.DefaultClient.Do(request); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+
+	request, _ = http.NewRequest("PUT", ts.URL, strings.NewReader(`{"old_password":"Password","new_password":"NewPassword"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", currentToken.Token()))
+	if response, _ := http.DefaultClient.Do(request); response.StatusCode != 204 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 204, response.StatusCode)
+	}
+
+	// [Test 1] Old token of other client is rejected, current token is kept
+	if status := callProtectedRoute(otherToken.Token()); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+	if status := callProtectedRoute(currentToken.Token()); status != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, status)
+	}
+
+	// [Test 2] Browser's session is rejected
+	request, _ = http.NewRequest("GET", browser.URL, nil)
+	request.AddCookie(cookie)
+	if response, _ := http.DefaultClient.Do(request); response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+}
+
+func Test_Account_ChangePassword_Lockout(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+
+	controller := new(Account)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		f := server.Adapt(controller.HandleChangePassword, ValidateToken())
+		f(context)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	changePassword := func(oldPassword string) *http.Response {
+		request, _ := http.NewRequest("PUT", ts.URL, strings.NewReader(fmt.Sprintf(`{"old_password":"%s","new_password":"NewPassword"}`, oldPassword)))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token()))
+		response, _ := http.DefaultClient.Do(request)
+		return response
+	}
+
+	// [Test 1] First failure
+	if response := changePassword("InvalidPassword"); response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+
+	// [Test 2] Retry immediately should be rejected, even with valid password
+	if response := changePassword(u.Password); response.StatusCode != 429 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 429, response.StatusCode)
+	}
+	if user := Store.FindUserWithCredential(u.Username, u.Password); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_Account_ListSessions(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Account)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		f := server.Adapt(controller.HandleListSessions, ValidateToken())
+		f(context)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	currentToken := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	Store.CreateAccessToken("web-app-test", u.UserID.Hex(), now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	request, _ := http.NewRequest("GET", ts.URL, nil)
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", currentToken.Token()))
+	response, _ := http.DefaultClient.Do(request)
+
+	// Expired sessions are neither listed nor counted
+	page := new(AdminPage)
+	data, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	json.Unmarshal(data, page)
+	if page.Total != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, page.Total)
+	}
+}
@@ -0,0 +1,782 @@
+package oauth2
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Pagination's limits.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// AdminUser describes an user's information that will be returned to administrator.
+type AdminUser struct {
+	UserID   string   `json:"user_id"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+}
+
+// AdminClient describes a client's information that will be returned to administrator.
+type AdminClient struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	GrantTypes   []string `json:"grant_types,omitempty"`
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	Roles        []string `json:"roles,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	FirstParty   bool     `json:"first_party"`
+
+	Metadata *ClientMetadata `json:"metadata,omitempty"`
+}
+
+// AdminClientSecret describes a client's secret metadata that will be returned to administrator.
+type AdminClientSecret struct {
+	SecretID    string    `json:"secret_id"`
+	Secret      string    `json:"secret,omitempty"`
+	Label       string    `json:"label,omitempty"`
+	IsExpired   bool      `json:"is_expired"`
+	CreatedTime time.Time `json:"created_time"`
+	ExpiredTime time.Time `json:"expired_time,omitempty"`
+}
+
+// AdminToken describes an access token's information that will be returned to administrator.
+type AdminToken struct {
+	TokenID     string    `json:"token_id"`
+	UserID      string    `json:"user_id"`
+	ClientID    string    `json:"client_id"`
+	CreatedTime time.Time `json:"created_time"`
+	ExpiredTime time.Time `json:"expired_time"`
+}
+
+// AdminPage describes a page of records that will be returned to administrator.
+type AdminPage struct {
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Total  int         `json:"total"`
+	Data   interface{} `json:"data"`
+}
+
+// Administration describes an administration controller that manages users, clients and tokens.
+type Administration struct {
+}
+
+// BindRoutes binds all administration routes under a prefix URI. All routes require
+// administrator's role.
+//
+// @param
+// - prefixURI {string} (the prefix for url)
+func (a *Administration) BindRoutes(prefixURI string) {
+	roles := []string{oauthRole.Admin}
+
+	BindGet(prefixURI+"/users", roles, a.HandleListUsers)
+	BindPost(prefixURI+"/users", roles, a.HandleCreateUser)
+	BindGet(prefixURI+"/users/{user_id}", roles, a.HandleReadUser)
+	BindDelete(prefixURI+"/users/{user_id}", roles, a.HandleDeleteUser)
+	BindPut(prefixURI+"/users/{user_id}/roles", roles, a.HandleUpdateUserRoles)
+	BindPut(prefixURI+"/users/{user_id}/password", roles, a.HandleUpdateUserPassword)
+	BindDelete(prefixURI+"/users/{user_id}/tokens", roles, a.HandleRevokeUserTokens)
+	BindDelete(prefixURI+"/users/{user_id}/lockout", roles, a.HandleUnlockUser)
+
+	BindGet(prefixURI+"/clients", roles, a.HandleListClients)
+	BindPost(prefixURI+"/clients", roles, a.HandleCreateClient)
+	BindGet(prefixURI+"/clients/{client_id}", roles, a.HandleReadClient)
+	BindPut(prefixURI+"/clients/{client_id}", roles, a.HandleUpdateClient)
+	BindDelete(prefixURI+"/clients/{client_id}", roles, a.HandleDeleteClient)
+	BindPut(prefixURI+"/clients/{client_id}/restrictions", roles, a.HandleUpdateClientRestrictions)
+	BindPut(prefixURI+"/clients/{client_id}/first_party", roles, a.HandleUpdateClientFirstParty)
+	BindGet(prefixURI+"/clients/{client_id}/secrets", roles, a.HandleListClientSecrets)
+	BindPost(prefixURI+"/clients/{client_id}/secrets", roles, a.HandleAddClientSecret)
+	BindDelete(prefixURI+"/clients/{client_id}/secrets/{secret_id}", roles, a.HandleRetireClientSecret)
+
+	BindGet(prefixURI+"/tokens", roles, a.HandleListTokens)
+	BindDelete(prefixURI+"/tokens/{token_id}", roles, a.HandleRevokeToken)
+
+	BindGet(prefixURI+"/policies", roles, a.HandleListPolicies)
+
+	// Audit log is only available if it is enabled
+	if Audit != nil {
+		BindGet(prefixURI+"/audit", roles, a.HandleListAuditRecords)
+		BindGet(prefixURI+"/audit/verify", roles, a.HandleVerifyAuditLog)
+	}
+}
+
+// HandleListUsers returns a page of users.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleListUsers(c *server.RequestContext) {
+	offset, limit := parsePagination(c)
+	users, total := adminStore(c).FindUsers(offset, limit)
+
+	records := make([]*AdminUser, len(users))
+	for i, user := range users {
+		records[i] = createAdminUser(user)
+	}
+	c.OutputJSON(util.Status200(), &AdminPage{Offset: offset, Limit: limit, Total: total, Data: records})
+}
+
+// HandleCreateUser creates a new user.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleCreateUser(c *server.RequestContext) {
+	var inputJSON struct {
+		Username string   `json:"username"`
+		Password string   `json:"password"`
+		Roles    []string `json:"roles"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	/* Condition validation: Validate username and password */
+	if len(inputJSON.Username) == 0 {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "username")))
+	}
+	if !passwordValidation.MatchString(inputJSON.Password) {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "password")))
+	}
+
+	user := adminStore(c).CreateUser(inputJSON.Username, inputJSON.Password, inputJSON.Roles)
+	if user == nil {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "username")))
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, CreateUserAction)
+	event.UserID = user.UserID()
+	event.Username = user.Username()
+	publishEvent(event)
+	c.OutputJSON(util.Status201(), createAdminUser(user))
+}
+
+// HandleReadUser returns an user.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleReadUser(c *server.RequestContext) {
+	user := RealmOf(c).Store.FindUserWithID(c.PathParams["user_id"])
+	if user == nil {
+		panic(util.Status404())
+	}
+	c.OutputJSON(util.Status200(), createAdminUser(user))
+}
+
+// HandleDeleteUser deletes an user and all of its tokens.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleDeleteUser(c *server.RequestContext) {
+	userID := c.PathParams["user_id"]
+	if !adminStore(c).DeleteUser(userID) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, DeleteUserAction)
+	event.UserID = userID
+	publishEvent(event)
+
+	event = createAdminEvent(c, TokenRevokedEvent, AdminReason)
+	event.UserID = userID
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleUpdateUserRoles replaces user's roles.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleUpdateUserRoles(c *server.RequestContext) {
+	var inputJSON struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	userID := c.PathParams["user_id"]
+	if RealmOf(c).Store.FindUserWithID(userID) == nil || !adminStore(c).UpdateUserRoles(userID, inputJSON.Roles) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, UpdateUserRolesAction)
+	event.UserID = userID
+	publishEvent(event)
+	c.OutputJSON(util.Status200(), createAdminUser(RealmOf(c).Store.FindUserWithID(userID)))
+}
+
+// HandleUpdateUserPassword replaces user's password.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleUpdateUserPassword(c *server.RequestContext) {
+	var inputJSON struct {
+		Password string `json:"password"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	/* Condition validation: Validate password */
+	if !passwordValidation.MatchString(inputJSON.Password) {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "password")))
+	}
+
+	userID := c.PathParams["user_id"]
+	if RealmOf(c).Store.FindUserWithID(userID) == nil || !adminStore(c).UpdateUserPassword(userID, inputJSON.Password, nil) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, UpdateUserPasswordAction)
+	event.UserID = userID
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleRevokeUserTokens deletes all tokens of an user.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleRevokeUserTokens(c *server.RequestContext) {
+	userID := c.PathParams["user_id"]
+	if RealmOf(c).Store.FindUserWithID(userID) == nil {
+		panic(util.Status404())
+	}
+
+	adminStore(c).DeleteUserTokens(userID)
+
+	event := createAdminEvent(c, TokenRevokedEvent, AdminReason)
+	event.UserID = userID
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleUnlockUser resets failed login attempts of an user.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleUnlockUser(c *server.RequestContext) {
+	user := RealmOf(c).Store.FindUserWithID(c.PathParams["user_id"])
+	if user == nil {
+		panic(util.Status404())
+	}
+
+	unlockUser(RealmOf(c), user.Username())
+
+	event := createAdminEvent(c, AdminChangeEvent, UnlockUserAction)
+	event.UserID = user.UserID()
+	event.Username = user.Username()
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleListClients returns a page of clients.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleListClients(c *server.RequestContext) {
+	offset, limit := parsePagination(c)
+	clients, total := adminStore(c).FindClients(offset, limit)
+
+	records := make([]*AdminClient, len(clients))
+	for i, client := range clients {
+		records[i] = createAdminClient(c, client)
+	}
+	c.OutputJSON(util.Status200(), &AdminPage{Offset: offset, Limit: limit, Total: total, Data: records})
+}
+
+// HandleCreateClient creates a new client. The client's secret will only be returned once.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleCreateClient(c *server.RequestContext) {
+	var inputJSON struct {
+		ClientID   string   `json:"client_id"`
+		Roles      []string `json:"roles"`
+		Scopes     []string `json:"scopes"`
+		FirstParty bool     `json:"first_party"`
+		ClientMetadata
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	/* Condition validation: Validate client_id and metadata */
+	if len(inputJSON.ClientID) == 0 {
+		inputJSON.ClientID = bson.NewObjectId().Hex()
+	} else if !clientIDValidation.MatchString(inputJSON.ClientID) || RealmOf(c).Store.FindClientWithID(inputJSON.ClientID) != nil {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "client_id")))
+	}
+	metadata := &inputJSON.ClientMetadata
+	new(ClientRegistration).validateMetadata(c, metadata, privilegedGrantTypes)
+
+	clientSecret, err := GenerateClientSecret()
+	if err != nil {
+		panic(util.Status500())
+	}
+
+	registrationStore := clientRegistrationStore(c)
+	client := registrationStore.CreateClient(inputJSON.ClientID, clientSecret, "", metadata)
+	if client == nil {
+		panic(util.Status500())
+	}
+
+	// Restrict client's roles and scopes if neccessary
+	if inputJSON.Roles != nil || len(inputJSON.Scopes) > 0 {
+		if !adminStore(c).UpdateClientRestrictions(client.ClientID(), inputJSON.Roles, inputJSON.Scopes) {
+			panic(util.Status500())
+		}
+		client = RealmOf(c).Store.FindClientWithID(client.ClientID())
+	}
+	if inputJSON.FirstParty {
+		if !adminStore(c).UpdateClientFirstParty(client.ClientID(), true) {
+			panic(util.Status500())
+		}
+		client = RealmOf(c).Store.FindClientWithID(client.ClientID())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, CreateClientAction)
+	event.ClientID = client.ClientID()
+	publishEvent(event)
+
+	record := createAdminClient(c, client)
+	record.ClientSecret = clientSecret
+	c.OutputJSON(util.Status201(), record)
+}
+
+// HandleReadClient returns a client.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleReadClient(c *server.RequestContext) {
+	client := RealmOf(c).Store.FindClientWithID(c.PathParams["client_id"])
+	if client == nil {
+		panic(util.Status404())
+	}
+	c.OutputJSON(util.Status200(), createAdminClient(c, client))
+}
+
+// HandleUpdateClient replaces client's metadata.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleUpdateClient(c *server.RequestContext) {
+	metadata := new(ClientMetadata)
+	if err := c.BindJSON(metadata); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+	new(ClientRegistration).validateMetadata(c, metadata, privilegedGrantTypes)
+
+	clientID := c.PathParams["client_id"]
+	registrationStore := clientRegistrationStore(c)
+	if RealmOf(c).Store.FindClientWithID(clientID) == nil || !registrationStore.UpdateClientMetadata(clientID, metadata) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, UpdateClientAction)
+	event.ClientID = clientID
+	publishEvent(event)
+	c.OutputJSON(util.Status200(), createAdminClient(c, RealmOf(c).Store.FindClientWithID(clientID)))
+}
+
+// HandleUpdateClientRestrictions replaces client's allowed roles and scopes. Omitted roles means
+// client is not restricted. All client's tokens are revoked.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleUpdateClientRestrictions(c *server.RequestContext) {
+	var inputJSON struct {
+		Roles  []string `json:"roles"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	clientID := c.PathParams["client_id"]
+	if RealmOf(c).Store.FindClientWithID(clientID) == nil || !adminStore(c).UpdateClientRestrictions(clientID, inputJSON.Roles, inputJSON.Scopes) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, RestrictClientAction)
+	event.ClientID = clientID
+	publishEvent(event)
+
+	event = createAdminEvent(c, TokenRevokedEvent, AdminReason)
+	event.ClientID = clientID
+	publishEvent(event)
+	c.OutputJSON(util.Status200(), createAdminClient(c, RealmOf(c).Store.FindClientWithID(clientID)))
+}
+
+// HandleUpdateClientFirstParty marks or unmarks client as a first-party client. Users are not
+// asked for consent when they authorize first-party clients.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleUpdateClientFirstParty(c *server.RequestContext) {
+	var inputJSON struct {
+		FirstParty bool `json:"first_party"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	clientID := c.PathParams["client_id"]
+	if RealmOf(c).Store.FindClientWithID(clientID) == nil || !adminStore(c).UpdateClientFirstParty(clientID, inputJSON.FirstParty) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, FirstPartyClientAction)
+	event.ClientID = clientID
+	publishEvent(event)
+	c.OutputJSON(util.Status200(), createAdminClient(c, RealmOf(c).Store.FindClientWithID(clientID)))
+}
+
+// HandleDeleteClient deletes a client and all of its tokens.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleDeleteClient(c *server.RequestContext) {
+	registrationStore := clientRegistrationStore(c)
+	clientID := c.PathParams["client_id"]
+	if !registrationStore.DeleteClient(clientID) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, DeleteClientAction)
+	event.ClientID = clientID
+	publishEvent(event)
+
+	event = createAdminEvent(c, TokenRevokedEvent, AdminReason)
+	event.ClientID = clientID
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleListClientSecrets returns all secrets' metadata of a client.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleListClientSecrets(c *server.RequestContext) {
+	secretStore := clientSecretStore(c)
+	clientID := c.PathParams["client_id"]
+	if RealmOf(c).Store.FindClientWithID(clientID) == nil {
+		panic(util.Status404())
+	}
+
+	secrets := secretStore.FindClientSecrets(clientID)
+	records := make([]*AdminClientSecret, len(secrets))
+	for i, secret := range secrets {
+		records[i] = createAdminClientSecret(secret)
+	}
+	c.OutputJSON(util.Status200(), records)
+}
+
+// HandleAddClientSecret generates a new secret for a client. The secret will only be returned
+// once.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleAddClientSecret(c *server.RequestContext) {
+	var inputJSON struct {
+		Label       string    `json:"label"`
+		ExpiredTime time.Time `json:"expired_time"`
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	clientID := c.PathParams["client_id"]
+	if RealmOf(c).Store.FindClientWithID(clientID) == nil {
+		panic(util.Status404())
+	}
+
+	secret, record := clientSecretStore(c).AddClientSecret(clientID, inputJSON.Label, inputJSON.ExpiredTime)
+	if record == nil {
+		panic(util.Status500())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, AddClientSecretAction)
+	event.ClientID = clientID
+	publishEvent(event)
+
+	response := createAdminClientSecret(record)
+	response.Secret = secret
+	c.OutputJSON(util.Status201(), response)
+}
+
+// HandleRetireClientSecret expires a client's secret immediately.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleRetireClientSecret(c *server.RequestContext) {
+	clientID := c.PathParams["client_id"]
+	if !clientSecretStore(c).RetireClientSecret(clientID, c.PathParams["secret_id"]) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, AdminChangeEvent, RetireClientSecretAction)
+	event.ClientID = clientID
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleListTokens returns a page of access tokens, filtered by user_id and client_id query
+// params.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleListTokens(c *server.RequestContext) {
+	offset, limit := parsePagination(c)
+	tokens, total := adminStore(c).FindAccessTokens(c.QueryParams["user_id"], c.QueryParams["client_id"], offset, limit)
+
+	records := make([]*AdminToken, len(tokens))
+	for i, token := range tokens {
+		records[i] = createAdminToken(token)
+	}
+	c.OutputJSON(util.Status200(), &AdminPage{Offset: offset, Limit: limit, Total: total, Data: records})
+}
+
+// HandleRevokeToken deletes an access token.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleRevokeToken(c *server.RequestContext) {
+	tokenID := c.PathParams["token_id"]
+	if !adminStore(c).DeleteAccessTokenWithID(tokenID) {
+		panic(util.Status404())
+	}
+
+	event := createAdminEvent(c, TokenRevokedEvent, AdminReason)
+	event.TokenID = tokenID
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// HandleListPolicies returns the effective policy of every bound route.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleListPolicies(c *server.RequestContext) {
+	c.OutputJSON(util.Status200(), PolicyTable())
+}
+
+// HandleListAuditRecords returns a page of audit records, filtered by user_id, client_id, type,
+// from and to query params. Time range must be in RFC 3339 format. Administrator of a realm only
+// sees the records of that realm.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleListAuditRecords(c *server.RequestContext) {
+	filter := &AuditFilter{
+		Realm:    RealmOf(c).Name,
+		UserID:   c.QueryParams["user_id"],
+		ClientID: c.QueryParams["client_id"],
+		Type:     c.QueryParams["type"],
+	}
+	filter.From = parseTimeParam(c, "from")
+	filter.To = parseTimeParam(c, "to")
+
+	offset, limit := parsePagination(c)
+	records, total := auditStore().FindRecords(filter, offset, limit)
+	if records == nil {
+		records = []*AuditRecord{}
+	}
+	c.OutputJSON(util.Status200(), &AdminPage{Offset: offset, Limit: limit, Total: total, Data: records})
+}
+
+// HandleVerifyAuditLog validates the whole audit chain.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *Administration) HandleVerifyAuditLog(c *server.RequestContext) {
+	sequence, isValid := VerifyAuditLog(auditStore())
+
+	var response struct {
+		IsValid  bool  `json:"is_valid"`
+		Sequence int64 `json:"broken_sequence,omitempty"`
+	}
+	response.IsValid = isValid
+	response.Sequence = sequence
+	c.OutputJSON(util.Status200(), &response)
+}
+
+// adminStore returns realm's token store as an admin store.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - store {AdminStore} (an admin store's instance)
+func adminStore(c *server.RequestContext) AdminStore {
+	store := RealmOf(c).Store
+	if _, ok := unwrapStore(store).(AdminStore); ok {
+		return store.(AdminStore)
+	}
+	panic(util.Status404())
+}
+
+// clientSecretStore returns realm's token store as a client secret store.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - store {ClientSecretStore} (a client secret store's instance)
+func clientSecretStore(c *server.RequestContext) ClientSecretStore {
+	store := RealmOf(c).Store
+	if _, ok := unwrapStore(store).(ClientSecretStore); ok {
+		return store.(ClientSecretStore)
+	}
+	panic(util.Status404())
+}
+
+// clientRegistrationStore returns realm's token store as a client registration store.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - store {ClientRegistrationStore} (a client registration store's instance)
+func clientRegistrationStore(c *server.RequestContext) ClientRegistrationStore {
+	store := RealmOf(c).Store
+	if _, ok := unwrapStore(store).(ClientRegistrationStore); ok {
+		return store.(ClientRegistrationStore)
+	}
+	panic(util.Status404())
+}
+
+// auditStore returns global audit store.
+//
+// @return
+// - store {AuditStore} (an audit store's instance)
+func auditStore() AuditStore {
+	if Audit != nil {
+		return Audit
+	}
+	panic(util.Status404())
+}
+
+// parseTimeParam parses a RFC 3339 time query param.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - name {string} (query param's name)
+//
+// @return
+// - t {time.Time} (parsed time or zero time if query param is not available)
+func parseTimeParam(c *server.RequestContext, name string) time.Time {
+	value := c.QueryParams[name]
+	if len(value) == 0 {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, name)))
+	}
+	return t
+}
+
+// parsePagination parses offset and limit query params.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - offset {int} (number of records to skip)
+// - limit {int} (maximum number of records to return)
+func parsePagination(c *server.RequestContext) (offset int, limit int) {
+	offset, _ = strconv.Atoi(c.QueryParams["offset"])
+	limit, _ = strconv.Atoi(c.QueryParams["limit"])
+
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	} else if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return
+}
+
+// createAdminEvent returns a security event that had been triggered by current administrator.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - eventType {string} (security event's type)
+// - reason {string} (security event's reason)
+//
+// @return
+// - event {SecurityEvent} (a security event)
+func createAdminEvent(c *server.RequestContext, eventType string, reason string) SecurityEvent {
+	event := createSecurityEvent(c, eventType, nil, reason)
+	if s, ok := c.GetExtra(oauthKey.Context).(*OAuthContext); ok && s.User != nil {
+		event.ActorID = s.User.UserID()
+	}
+	return event
+}
+
+// createAdminUser converts an user entity to administrator's response.
+func createAdminUser(user User) *AdminUser {
+	return &AdminUser{
+		UserID:   user.UserID(),
+		Username: user.Username(),
+		Roles:    user.UserRoles(),
+	}
+}
+
+// createAdminClient converts a client entity to administrator's response.
+func createAdminClient(c *server.RequestContext, client Client) *AdminClient {
+	record := &AdminClient{
+		ClientID:     client.ClientID(),
+		GrantTypes:   client.GrantTypes(),
+		RedirectURIs: client.RedirectURIs(),
+	}
+	if restrictedClient, ok := client.(RoleRestrictedClient); ok {
+		record.Roles = restrictedClient.ClientRoles()
+	}
+	if scopedClient, ok := client.(ScopedClient); ok {
+		record.Scopes = scopedClient.ClientScopes()
+	}
+	if firstPartyClient, ok := client.(FirstPartyClient); ok {
+		record.FirstParty = firstPartyClient.IsFirstParty()
+	}
+	store := RealmOf(c).Store
+	if _, ok := unwrapStore(store).(ClientRegistrationStore); ok {
+		record.Metadata = store.(ClientRegistrationStore).FindClientMetadata(client.ClientID())
+	}
+	return record
+}
+
+// createAdminClientSecret converts a client's secret metadata to administrator's response.
+func createAdminClientSecret(secret ClientSecret) *AdminClientSecret {
+	return &AdminClientSecret{
+		SecretID:    secret.SecretID(),
+		Label:       secret.Label(),
+		IsExpired:   secret.IsExpired(),
+		CreatedTime: secret.CreatedTime(),
+		ExpiredTime: secret.ExpiredTime(),
+	}
+}
+
+// createAdminToken converts a token entity to administrator's response.
+func createAdminToken(token Token) *AdminToken {
+	return &AdminToken{
+		TokenID:     tokenID(token),
+		UserID:      token.UserID(),
+		ClientID:    token.ClientID(),
+		CreatedTime: token.CreatedTime(),
+		ExpiredTime: token.ExpiredTime(),
+	}
+}
@@ -0,0 +1,195 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+)
+
+// sendJSON sends a JSON request and decodes JSON response.
+func sendJSON(method string, url string, body string, result interface{}) *http.Response {
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil
+	}
+
+	if result != nil && response.StatusCode < 300 {
+		data, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		json.Unmarshal(data, result)
+	}
+	return response
+}
+
+func Test_Administration_CreateUser(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Administration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleCreateUser(context)
+	}))
+	defer ts.Close()
+
+	// [Test 1] Valid user
+	user := new(AdminUser)
+	sendJSON("POST", ts.URL, `{"username":"manager","password":"Password","roles":["r_manager"]}`, user)
+	if len(user.UserID) == 0 {
+		t.Error(expectedFormat.NotNil)
+	}
+	if recordUser := Store.FindUserWithCredential("manager", "Password"); recordUser == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// [Test 2] Duplicated username
+	response := sendJSON("POST", ts.URL, `{"username":"admin","password":"Password"}`, nil)
+	status := util.ParseStatus(response)
+	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "username") {
+		t.Errorf(expectedFormat.InvalidParameter, "username", status.Description)
+	}
+
+	// [Test 3] Weak password
+	response = sendJSON("POST", ts.URL, `{"username":"guest","password":"123"}`, nil)
+	status = util.ParseStatus(response)
+	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "password") {
+		t.Errorf(expectedFormat.InvalidParameter, "password", status.Description)
+	}
+}
+
+func Test_Administration_ListUsers(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Administration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleListUsers(context)
+	}))
+	defer ts.Close()
+
+	var page struct {
+		Offset int          `json:"offset"`
+		Limit  int          `json:"limit"`
+		Total  int          `json:"total"`
+		Data   []*AdminUser `json:"data"`
+	}
+	sendJSON("GET", fmt.Sprintf("%s?offset=1&limit=1", ts.URL), "", &page)
+
+	if page.Total != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, page.Total)
+	}
+	if page.Offset != 1 || page.Limit != 1 || len(page.Data) != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, len(page.Data))
+	}
+}
+
+func Test_Administration_UpdateUserRoles(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Administration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		context.PathParams = map[string]string{"user_id": u.UserID.Hex()}
+		controller.HandleUpdateUserRoles(context)
+	}))
+	defer ts.Close()
+
+	sendJSON("PUT", ts.URL, `{"roles":["r_manager"]}`, nil)
+	if user := Store.FindUserWithID(u.UserID.Hex()); user == nil || len(user.UserRoles()) != 1 || user.UserRoles()[0] != "r_manager" {
+		t.Error("Expected user's roles should be updated.")
+	}
+}
+
+func Test_Administration_RevokeToken(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+
+	controller := new(Administration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		if r.Method == "GET" {
+			controller.HandleListTokens(context)
+		} else {
+			context.PathParams = map[string]string{"token_id": tokenID(token)}
+			controller.HandleRevokeToken(context)
+		}
+	}))
+	defer ts.Close()
+
+	var page struct {
+		Total int           `json:"total"`
+		Data  []*AdminToken `json:"data"`
+	}
+	sendJSON("GET", fmt.Sprintf("%s?user_id=%s", ts.URL, u.UserID.Hex()), "", &page)
+	if page.Total != 1 || len(page.Data) != 1 || page.Data[0].TokenID != tokenID(token) {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, page.Total)
+	}
+
+	if response := sendJSON("DELETE", ts.URL, "", nil); response.StatusCode != 204 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 204, response.StatusCode)
+	}
+	if recordToken := Store.FindAccessTokenWithCredential(u.ClientID, u.UserID.Hex()); recordToken != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
+func Test_Administration_CreateClient(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(Administration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleCreateClient(context)
+	}))
+	defer ts.Close()
+
+	client := new(AdminClient)
+	sendJSON("POST", ts.URL, `{"client_id":"android-app-prod","grant_types":["password","refresh_token"]}`, client)
+	if client.ClientID != "android-app-prod" {
+		t.Errorf(expectedFormat.StringButFoundString, "android-app-prod", client.ClientID)
+	}
+	if recordClient := Store.FindClientWithCredential(client.ClientID, client.ClientSecret); recordClient == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// Client ID must be unique
+	response := sendJSON("POST", ts.URL, fmt.Sprintf(`{"client_id":"%s","grant_types":["password"]}`, u.ClientID), nil)
+	status := util.ParseStatus(response)
+	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "client_id") {
+		t.Errorf(expectedFormat.InvalidParameter, "client_id", status.Description)
+	}
+}
@@ -0,0 +1,149 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Number of records to be loaded at once during chain verification.
+const auditVerificationPageSize = 100
+
+// AuditRecord describes a persisted security event. Each record is chained to the previous one
+// by hash, so a modified, inserted or deleted record will break the chain.
+type AuditRecord struct {
+	Sequence      int64 `json:"sequence" bson:"_id"`
+	SecurityEvent `bson:",inline"`
+
+	PreviousHash string `json:"previous_hash" bson:"previous_hash"`
+	Hash         string `json:"hash" bson:"hash"`
+}
+
+// AuditFilter describes audit records' filter. Empty fields will not be used as filter.
+type AuditFilter struct {
+	Realm    string
+	UserID   string
+	ClientID string
+	Type     string
+	From     time.Time // Inclusive
+	To       time.Time // Exclusive
+}
+
+// Match checks if an audit record is matched with filter.
+//
+// @param
+// - record {AuditRecord} (an audit record)
+//
+// @return
+// - isMatched {bool} (true if record is matched)
+func (f *AuditFilter) Match(record *AuditRecord) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Realm) > 0 && record.Realm != f.Realm {
+		return false
+	}
+	if len(f.UserID) > 0 && record.UserID != f.UserID {
+		return false
+	}
+	if len(f.ClientID) > 0 && record.ClientID != f.ClientID {
+		return false
+	}
+	if len(f.Type) > 0 && record.Type != f.Type {
+		return false
+	}
+	if !f.From.IsZero() && record.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !record.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// CreateAuditSubscriber returns an event subscriber that persists all security events to an
+// audit store.
+//
+// @param
+// - auditStore {AuditStore} (an audit store's instance)
+//
+// @return
+// - subscriber {EventSubscriber} (an event subscriber's instance)
+func CreateAuditSubscriber(auditStore AuditStore) EventSubscriber {
+	return EventSubscriberFunc(func(event SecurityEvent) {
+		auditStore.AppendRecord(event)
+	})
+}
+
+// VerifyAuditLog walks through the whole audit chain and validates every record's hash.
+//
+// @param
+// - auditStore {AuditStore} (an audit store's instance)
+//
+// @return
+// - sequence {int64} (the first broken record's sequence, 0 if chain is valid)
+// - isValid {bool} (true if chain had not been tampered)
+func VerifyAuditLog(auditStore AuditStore) (int64, bool) {
+	var previous *AuditRecord
+	for offset := 0; ; offset += auditVerificationPageSize {
+		records, _ := auditStore.FindRecords(nil, offset, auditVerificationPageSize)
+		for _, record := range records {
+			expected := createAuditRecord(previous, record.SecurityEvent)
+			if record.Sequence != expected.Sequence || record.PreviousHash != expected.PreviousHash || record.Hash != expected.Hash {
+				return expected.Sequence, false
+			}
+			previous = record
+		}
+
+		if len(records) < auditVerificationPageSize {
+			return 0, true
+		}
+	}
+}
+
+// createAuditRecord chains a security event to the previous audit record.
+//
+// @param
+// - previous {AuditRecord} (the last audit record, null if chain is empty)
+// - event {SecurityEvent} (a security event)
+//
+// @return
+// - record {AuditRecord} (a new audit record)
+func createAuditRecord(previous *AuditRecord, event SecurityEvent) *AuditRecord {
+	// Database might not keep nanoseconds nor location
+	event.Time = event.Time.UTC().Truncate(time.Millisecond)
+
+	record := &AuditRecord{
+		Sequence:      1,
+		SecurityEvent: event,
+	}
+	if previous != nil {
+		record.Sequence = previous.Sequence + 1
+		record.PreviousHash = previous.Hash
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n%s\n%d\n", record.Sequence, record.PreviousHash, event.Time.UnixNano())
+	for _, field := range []string{
+		event.Type,
+		event.ClientID,
+		event.UserID,
+		event.Username,
+		event.TokenID,
+		event.ActorID,
+		event.GrantType,
+		event.IP,
+		event.UserAgent,
+		event.Reason,
+	} {
+		fmt.Fprintf(hash, "%q\n", field)
+	}
+
+	// Realm is only chained if it is available, so records before realms remain valid
+	if len(event.Realm) > 0 {
+		fmt.Fprintf(hash, "%q\n", event.Realm)
+	}
+	record.Hash = hex.EncodeToString(hash.Sum(nil))
+	return record
+}
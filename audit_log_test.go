@@ -0,0 +1,114 @@
+package oauth2
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_MemoryAuditStore_AppendRecord(t *testing.T) {
+	auditStore := CreateMemoryAuditStore()
+
+	record1 := auditStore.AppendRecord(SecurityEvent{Type: LoginFailedEvent, Username: "admin"})
+	record2 := auditStore.AppendRecord(SecurityEvent{Type: TokenIssuedEvent, UserID: "user-1"})
+
+	if record1.Sequence != 1 || record2.Sequence != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, record2.Sequence)
+	}
+	if record2.PreviousHash != record1.Hash {
+		t.Errorf(expectedFormat.StringButFoundString, record1.Hash, record2.PreviousHash)
+	}
+	if sequence, isValid := VerifyAuditLog(auditStore); !isValid {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, sequence)
+	}
+}
+
+func Test_MemoryAuditStore_Tampering(t *testing.T) {
+	auditStore := CreateMemoryAuditStore()
+	auditStore.AppendRecord(SecurityEvent{Type: LoginFailedEvent, Username: "admin"})
+	auditStore.AppendRecord(SecurityEvent{Type: TokenIssuedEvent, UserID: "user-1"})
+	auditStore.AppendRecord(SecurityEvent{Type: TokenRevokedEvent, UserID: "user-1"})
+
+	// Modify a record
+	memoryStore := auditStore.(*MemoryAuditStore)
+	memoryStore.records[1].UserID = "user-2"
+	if sequence, isValid := VerifyAuditLog(auditStore); isValid || sequence != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, sequence)
+	}
+
+	// Delete a record
+	memoryStore.records = append(memoryStore.records[:1], memoryStore.records[2:]...)
+	if sequence, isValid := VerifyAuditLog(auditStore); isValid || sequence != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, sequence)
+	}
+}
+
+func Test_MemoryAuditStore_FindRecords(t *testing.T) {
+	auditStore := CreateMemoryAuditStore()
+	now := time.Now()
+
+	auditStore.AppendRecord(SecurityEvent{Type: LoginFailedEvent, Username: "admin", Time: now.Add(-time.Hour)})
+	auditStore.AppendRecord(SecurityEvent{Type: TokenIssuedEvent, UserID: "user-1", ClientID: "ios", Time: now})
+	auditStore.AppendRecord(SecurityEvent{Type: TokenIssuedEvent, UserID: "user-2", ClientID: "ios", Time: now})
+
+	// [Test 1] Filter by type and client
+	if records, total := auditStore.FindRecords(&AuditFilter{Type: TokenIssuedEvent, ClientID: "ios"}, 0, 10); total != 2 || len(records) != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, total)
+	}
+
+	// [Test 2] Filter by user
+	if records, total := auditStore.FindRecords(&AuditFilter{UserID: "user-2"}, 0, 10); total != 1 || records[0].Sequence != 3 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, total)
+	}
+
+	// [Test 3] Filter by time range
+	if _, total := auditStore.FindRecords(&AuditFilter{From: now.Add(-2 * time.Hour), To: now.Add(-time.Minute)}, 0, 10); total != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, total)
+	}
+
+	// [Test 4] Pagination
+	if records, total := auditStore.FindRecords(nil, 1, 1); total != 3 || len(records) != 1 || records[0].Sequence != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 3, total)
+	}
+}
+
+func Test_Administration_ListAuditRecords(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	Audit = CreateMemoryAuditStore()
+	defer func() { Audit = nil }()
+
+	Audit.AppendRecord(SecurityEvent{Type: LoginFailedEvent, Username: "admin"})
+	Audit.AppendRecord(SecurityEvent{Type: TokenIssuedEvent, UserID: u.UserID.Hex(), ClientID: u.ClientID})
+
+	controller := new(Administration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleListAuditRecords(context)
+	}))
+	defer ts.Close()
+
+	// [Test 1] Valid filter
+	var page struct {
+		Total int            `json:"total"`
+		Data  []*AuditRecord `json:"data"`
+	}
+	sendJSON("GET", fmt.Sprintf("%s?user_id=%s&type=%s", ts.URL, u.UserID.Hex(), TokenIssuedEvent), "", &page)
+	if page.Total != 1 || len(page.Data) != 1 || page.Data[0].ClientID != u.ClientID {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, page.Total)
+	}
+
+	// [Test 2] Invalid time range
+	if response := sendJSON("GET", fmt.Sprintf("%s?from=yesterday", ts.URL), "", nil); response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+}
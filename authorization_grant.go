@@ -1,92 +1,331 @@
 package oauth2
 
-import "github.com/phuc0302/go-server"
+import (
+	"fmt"
+	"net/url"
+	"strings"
 
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+)
+
+// Authorization endpoint's errors, they are returned to client through redirect URI.
+const (
+	AccessDeniedError            = "access_denied"
+	InvalidScopeError            = "invalid_scope"
+	ServerError                  = "server_error"
+	UnauthorizedClientError      = "unauthorized_client"
+	UnsupportedResponseTypeError = "unsupported_response_type"
+)
+
+// Authorization request's prompt that forces consent page.
+const consentPrompt = "consent"
+
+// AuthorizationGrant describes an authorization endpoint's controller. User must be authenticated
+// before reaching this controller, see ValidateLoginSession.
 type AuthorizationGrant struct {
 }
 
-func (a *AuthorizationGrant) HandleForm(c *server.RequestContext, s *OAuthContext) {
-	var inputForm struct {
-		ClientID     string `field:"client_id" validation:"^\\w+$"`
-		ResponseType string `field:"response_type" validation:"^\\w+$"`
-		RedirectURI  string `field:"redirect_uri" validation:"^\\w+$"`
-
-		GrantType string `field:"grant_type"`
-
-		ClientSecret string `field:"client_secret" validation:"^\\w+$"`
-	}
-	c.BindForm(&inputForm)
-
-	//	client := createAuthClientDefault(c)
-	//	values := c.Queries
-	//	/* Condition validation: Validate client_id */
-	//	if len(client.GetClientID()) == 0 {
-	//		err := utils.Status400()
-	//		err.Description = "Missing client_id parameter."
-	//		c.OutputError(err)
-	//		return
-	//	}
-
-	//	/* Condition validation: Validate response_type */
-	//	responseType := values.Get("response_type")
-	//	if len(responseType) == 0 {
-	//		err := utils.Status400()
-	//		err.Description = "Missing response_type parameter."
-	//		c.OutputError(err)
-	//		return
-	//	} else if responseType != "code" {
-	//		err := utils.Status400()
-	//		err.Description = "Invalid response_type parameter (must be \"code\")."
-	//		c.OutputError(err)
-	//		return
-	//}
-
-	//	// Note: Allow missing redirect_uri parameter
-	//	//	/* Condition validation: Validate redirect_uri */
-	//	//	redirectUri := values.Get(RedirectUri)
-	//	//	if len(RedirectUri) == 0 {
-	//	//		err := common.Status{Status: 400, Error: "Invalid Request", ErrorDescription: "Missing redirect_uri parameter."}
-	//	//		c.OutputError(&err)
-	//	//		return
-	//	//	}
-
-	//	/* Condition validation: Validate client credentials */
-	//	client = a.store.FindClientWithCredential(client.ClientID, client.ClientSecret)
-	//	if client == nil {
-	//		err := utils.Status400()
-	//		err.Description = "Invalid client credentials."
-	//		c.OutputError(err)
-	//		return
-	//	}
-
-	//	// Generate authorization code
-	//	authorizationCode := utils.GenerateToken()
-	//	a.store.SaveAuthorizationCode(authorizationCode, client.ClientID, time.Now().Add(a.config.DurationAuthorizationCode))
-
-	//	// Redirect
-	//	state := values.Get("state")
-	//	if len(state) == 0 {
-	//		c.OutputRedirect(utils.Status302(), fmt.Sprintf("%s?code=%s", client.RedirectURI, authorizationCode))
-	//	} else {
-	//		c.OutputRedirect(utils.Status302(), fmt.Sprintf("%s?code=%s&state=%s", client.RedirectURI, authorizationCode, state))
-	//	}
+// authorizationRequest describes a validated authorization request.
+type authorizationRequest struct {
+	Client      Client
+	RedirectURI string
+	Scopes      []string
+	State       string
+	Prompt      string
+
+	// Redirect URI as it had been sent by client, it must be matched at token endpoint.
+	rawRedirectURI string
+}
+
+// HandleAuthorize handles authorization request. If consent is not required, user is redirected
+// back to client with an authorization code, otherwise consent page is rendered.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *AuthorizationGrant) HandleAuthorize(c *server.RequestContext) {
+	s := a.authenticatedContext(c)
+	request, errCode := a.validateRequest(c)
+	if len(errCode) > 0 {
+		a.redirect(c, request, url.Values{"error": {errCode}})
+		return
+	}
+
+	if !a.requiresConsent(c, s, request) {
+		a.issueCode(c, s, request)
+		return
+	}
+
+	hidden := map[string]string{
+		"response_type": "code",
+		"client_id":     request.Client.ClientID(),
+		"scope":         strings.Join(request.Scopes, " "),
+	}
+	if len(request.rawRedirectURI) > 0 {
+		hidden["redirect_uri"] = request.rawRedirectURI
+	}
+	if len(request.State) > 0 {
+		hidden["state"] = request.State
+	}
+	if session, ok := c.GetExtra(oauthKey.Session).(*LoginSession); ok {
+		hidden[csrfField] = session.CSRF
+	}
+
+	renderTemplate(c, util.Status200(), ConsentTemplate, &ConsentPage{
+		ClientID:    request.Client.ClientID(),
+		ClientName:  clientName(request.Client),
+		Scopes:      request.Scopes,
+		Hidden:      hidden,
+		Action:      c.Path,
+		ApproveName: ApproveDecision,
+		DenyName:    DenyDecision,
+	})
+}
+
+// HandleDecision handles user's decision from consent page. An approval is persisted, so user
+// will not be asked again for the same client and scopes.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (a *AuthorizationGrant) HandleDecision(c *server.RequestContext) {
+	s := a.authenticatedContext(c)
+
+	/* Condition validation: Browser's decision must carry session's CSRF token */
+	if session, ok := c.GetExtra(oauthKey.Session).(*LoginSession); ok && !compareCSRF(session, c.QueryParams[csrfField]) {
+		panic(util.Status403())
+	}
+
+	request, errCode := a.validateRequest(c)
+	if len(errCode) > 0 {
+		a.redirect(c, request, url.Values{"error": {errCode}})
+		return
+	}
+
+	event := createSecurityEvent(c, ConsentDeniedEvent, s, "")
+	event.ClientID = request.Client.ClientID()
+	event.TokenID = ""
+
+	/* Condition validation: Validate user's decision */
+	if c.QueryParams["decision"] != ApproveDecision {
+		publishEvent(event)
+		a.redirect(c, request, url.Values{"error": {AccessDeniedError}})
+		return
+	}
+
+	if !authorizationStore(c).SaveConsent(s.User.UserID(), request.Client.ClientID(), request.Scopes) {
+		a.redirect(c, request, url.Values{"error": {ServerError}})
+		return
+	}
+	event.Type = ConsentGrantedEvent
+	event.Reason = strings.Join(request.Scopes, " ")
+	publishEvent(event)
+
+	a.issueCode(c, s, request)
+}
+
+// authenticatedContext returns authenticated user's oauth context.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - s {OAuthContext} (an oauth context)
+func (a *AuthorizationGrant) authenticatedContext(c *server.RequestContext) *OAuthContext {
+	oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext)
+	if !ok || oauthContext.User == nil {
+		panic(util.Status401())
+	}
+	return oauthContext
+}
+
+// validateRequest validates authorization request's parameters. Invalid client and redirect URI
+// are reported to user directly, other errors should be reported to client through redirect URI.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - request {authorizationRequest} (a validated authorization request)
+// - errCode {string} (an error that should be returned to client or empty string)
+func (a *AuthorizationGrant) validateRequest(c *server.RequestContext) (*authorizationRequest, string) {
+	request := &authorizationRequest{
+		State:          c.QueryParams["state"],
+		Prompt:         c.QueryParams["prompt"],
+		rawRedirectURI: c.QueryParams["redirect_uri"],
+	}
+
+	/* Condition validation: Validate client_id */
+	realm := RealmOf(c)
+	if request.Client = realm.Store.FindClientWithID(c.QueryParams["client_id"]); request.Client == nil {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "client_id")))
+	}
+
+	/* Condition validation: Validate redirect_uri, it can only be omitted if client has one */
+	redirectURIs := request.Client.RedirectURIs()
+	if len(request.rawRedirectURI) == 0 && len(redirectURIs) == 1 {
+		request.RedirectURI = redirectURIs[0]
+	} else {
+		for _, redirectURI := range redirectURIs {
+			if redirectURI == request.rawRedirectURI {
+				request.RedirectURI = redirectURI
+				break
+			}
+		}
+	}
+	if len(request.RedirectURI) == 0 {
+		panic(util.Status400WithDescription("The \"redirect_uri\" had not been registered for this \"client_id\"."))
+	}
+
+	/* Condition validation: Validate response_type */
+	if c.QueryParams["response_type"] != "code" {
+		return request, UnsupportedResponseTypeError
+	}
+
+	/* Condition validation: Validate grant_type for realm and client */
+	isGranted := false
+	for _, grantType := range request.Client.GrantTypes() {
+		if grantType == AuthorizationCodeGrant {
+			isGranted = true
+			break
+		}
+	}
+	if !isGranted || !realm.AllowsGrant(AuthorizationCodeGrant) {
+		return request, UnauthorizedClientError
+	}
+
+	/* Condition validation: Validate scope, by default, all client's scopes are requested */
+	var clientScopes []string
+	if client, ok := request.Client.(ScopedClient); ok {
+		clientScopes = client.ClientScopes()
+	}
+	request.Scopes = strings.Fields(c.QueryParams["scope"])
+	if len(request.Scopes) == 0 {
+		request.Scopes = clientScopes
+	}
+	if !containsScopes(clientScopes, request.Scopes) {
+		return request, InvalidScopeError
+	}
+	return request, ""
+}
+
+// requiresConsent checks if user must be asked for consent.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - s {OAuthContext} (an oauth context)
+// - request {authorizationRequest} (a validated authorization request)
+//
+// @return
+// - isRequired {bool} (true if consent page should be rendered)
+func (a *AuthorizationGrant) requiresConsent(c *server.RequestContext, s *OAuthContext, request *authorizationRequest) bool {
+	if request.Prompt == consentPrompt {
+		return true
+	}
+	if client, ok := request.Client.(FirstPartyClient); ok && client.IsFirstParty() {
+		return false
+	}
+
+	consentedScopes := authorizationStore(c).FindConsent(s.User.UserID(), request.Client.ClientID())
+	return consentedScopes == nil || !containsScopes(consentedScopes, request.Scopes)
 }
 
-///**
-// * Check client against model
-// *
-// * @param  {Function} done
-// * @this   OAuth
-// */
-//func (a *AuthorizationGrant) CheckUserApproved() {
-//	// var self = this;
-//	// this.check(this.req, function (err, allowed, user) {
-//	//   if (err) return done(error('server_error', false, err));
-//	//   if (!allowed) {
-//	//     return done(error('access_denied',
-//	//       'The user denied access to your application'));
-//	//   }
-//	//   self.user = user;
-//	//   done();
-//	// });
-//}
+// issueCode redirects user back to client with a new authorization code.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - s {OAuthContext} (an oauth context)
+// - request {authorizationRequest} (a validated authorization request)
+func (a *AuthorizationGrant) issueCode(c *server.RequestContext, s *OAuthContext, request *authorizationRequest) {
+	realm := RealmOf(c)
+	code := authorizationStore(c).CreateAuthorizationCode(
+		request.Client.ClientID(),
+		s.User.UserID(),
+		request.rawRedirectURI,
+		request.Scopes,
+		realm.now().Add(realm.Config.AuthorizationCodeDuration),
+	)
+
+	if len(code) == 0 {
+		a.redirect(c, request, url.Values{"error": {ServerError}})
+		return
+	}
+	a.redirect(c, request, url.Values{"code": {code}})
+}
+
+// redirect redirects user back to client's redirect URI, state is always included.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - request {authorizationRequest} (a validated authorization request)
+// - values {url.Values} (query parameters that should be returned to client)
+func (a *AuthorizationGrant) redirect(c *server.RequestContext, request *authorizationRequest, values url.Values) {
+	redirectURL, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "redirect_uri")))
+	}
+
+	query := redirectURL.Query()
+	for key := range values {
+		query.Set(key, values.Get(key))
+	}
+	if len(request.State) > 0 {
+		query.Set("state", request.State)
+	}
+	redirectURL.RawQuery = query.Encode()
+	c.OutputRedirect(util.Status302(), redirectURL.String())
+}
+
+// authorizationStore returns realm's token store as an authorization store.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - store {AuthorizationStore} (an authorization store)
+func authorizationStore(c *server.RequestContext) AuthorizationStore {
+	store := RealmOf(c).Store
+	if _, ok := unwrapStore(store).(AuthorizationStore); ok {
+		return store.(AuthorizationStore)
+	}
+	panic(util.Status404())
+}
+
+// clientName returns client's human readable name or client's ID.
+//
+// @param
+// - client {Client} (a client entity)
+//
+// @return
+// - name {string} (client's name)
+func clientName(client Client) string {
+	if namedClient, ok := client.(NamedClient); ok && len(namedClient.ClientName()) > 0 {
+		return namedClient.ClientName()
+	}
+	return client.ClientID()
+}
+
+// containsScopes checks if a set of scopes covers all required scopes.
+//
+// @param
+// - scopes {[]string} (a set of granted scopes)
+// - requiredScopes {[]string} (a list of required scopes)
+//
+// @return
+// - isCovered {bool} (true if all required scopes are granted)
+func containsScopes(scopes []string, requiredScopes []string) bool {
+	for _, requiredScope := range requiredScopes {
+		isFound := false
+		for _, scope := range scopes {
+			if scope == requiredScope {
+				isFound = true
+				break
+			}
+		}
+		if !isFound {
+			return false
+		}
+	}
+	return true
+}
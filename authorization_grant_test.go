@@ -0,0 +1,190 @@
+package oauth2
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+// noRedirectClient returns redirect responses instead of following them.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func Test_ConsentTemplate(t *testing.T) {
+	var buffer bytes.Buffer
+	page := &ConsentPage{
+		ClientID:    "web-app",
+		ClientName:  "<script>alert(1)</script>",
+		Scopes:      []string{"profile", "offline"},
+		Hidden:      map[string]string{"client_id": "web-app", "state": `"><img>`},
+		Action:      "/authorize",
+		ApproveName: ApproveDecision,
+		DenyName:    DenyDecision,
+	}
+	if err := ConsentTemplate.Execute(&buffer, page); err != nil {
+		t.Fatal(err)
+	}
+
+	html := buffer.String()
+	if strings.Contains(html, "<script>") || strings.Contains(html, `"><img>`) {
+		t.Error("Expected consent page should escape client's input.")
+	}
+	if !strings.Contains(html, "<li>offline</li>") || !strings.Contains(html, `name="client_id" value="web-app"`) {
+		t.Error("Expected consent page should render scopes and hidden fields.")
+	}
+}
+
+func Test_containsScopes(t *testing.T) {
+	if !containsScopes([]string{"profile", "offline"}, []string{"offline"}) {
+		t.Error("Expected subset of scopes should be covered.")
+	}
+	if containsScopes([]string{"profile"}, []string{"profile", "offline"}) {
+		t.Error("Expected missing scope should not be covered.")
+	}
+	if !containsScopes(nil, nil) {
+		t.Error("Expected empty scopes should be covered.")
+	}
+}
+
+func Test_AuthorizationGrant_CodeFlow(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	Store.(AdminStore).UpdateClientRestrictions(u.ClientID, nil, []string{"profile", "offline"})
+	redirectURI := u.Client.RedirectURIs()[0]
+
+	// Setup server, user is always authenticated
+	controller := new(AuthorizationGrant)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		context.SetExtra(oauthKey.Context, &OAuthContext{User: Store.FindUserWithID(u.UserID.Hex())})
+		if r.Method == "POST" {
+			controller.HandleDecision(context)
+		} else {
+			controller.HandleAuthorize(context)
+		}
+	}))
+	defer ts.Close()
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {u.ClientID},
+		"redirect_uri":  {redirectURI},
+		"scope":         {"profile"},
+		"state":         {"xyz"},
+	}
+
+	// [Test 1] Consent page is rendered on first visit
+	response, _ := noRedirectClient.Get(ts.URL + "?" + query.Encode())
+	if response.StatusCode != 200 || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+
+	// [Test 2] Denied decision is returned to client
+	query.Set("decision", DenyDecision)
+	response, _ = noRedirectClient.PostForm(ts.URL, query)
+	if location, _ := response.Location(); location == nil || location.Query().Get("error") != AccessDeniedError || location.Query().Get("state") != "xyz" {
+		t.Errorf(expectedFormat.StringButFoundString, AccessDeniedError, response.Header.Get("Location"))
+	}
+
+	// [Test 3] Approved decision is returned with code
+	query.Set("decision", ApproveDecision)
+	response, _ = noRedirectClient.PostForm(ts.URL, query)
+	location, _ := response.Location()
+	if location == nil || len(location.Query().Get("code")) == 0 {
+		t.Fatal(expectedFormat.NotNil)
+	}
+	code := location.Query().Get("code")
+
+	// [Test 4] Repeat visit skips consent, prompt=consent forces it
+	query.Del("decision")
+	if response, _ = noRedirectClient.Get(ts.URL + "?" + query.Encode()); response.StatusCode != 302 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 302, response.StatusCode)
+	}
+	query.Set("prompt", "consent")
+	if response, _ = noRedirectClient.Get(ts.URL + "?" + query.Encode()); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+
+	// [Test 5] Scope that client is not allowed to carry
+	query.Del("prompt")
+	query.Set("scope", "admin")
+	response, _ = noRedirectClient.Get(ts.URL + "?" + query.Encode())
+	if location, _ := response.Location(); location == nil || location.Query().Get("error") != InvalidScopeError {
+		t.Errorf(expectedFormat.StringButFoundString, InvalidScopeError, response.Header.Get("Location"))
+	}
+
+	// [Test 6] Code can be exchanged once, token only carries consented scopes
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		new(TokenGrant).HandleForm(context)
+	}))
+	defer tokenServer.Close()
+
+	form := fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&code=%s&redirect_uri=%s",
+		AuthorizationCodeGrant,
+		u.ClientID,
+		u.ClientSecret,
+		code,
+		url.QueryEscape(redirectURI),
+	)
+	response, _ = http.Post(tokenServer.URL, "application/x-www-form-urlencoded", strings.NewReader(form))
+	if token := parseResult(response); token.Scope != "profile" {
+		t.Errorf(expectedFormat.StringButFoundString, "profile", token.Scope)
+	}
+	if response, _ = http.Post(tokenServer.URL, "application/x-www-form-urlencoded", strings.NewReader(form)); response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+}
+
+func Test_AuthorizationGrant_FirstPartyClient(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	Store.(AdminStore).UpdateClientFirstParty(u.ClientID, true)
+
+	controller := new(AuthorizationGrant)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		context.SetExtra(oauthKey.Context, &OAuthContext{User: Store.FindUserWithID(u.UserID.Hex())})
+		controller.HandleAuthorize(context)
+	}))
+	defer ts.Close()
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {u.ClientID},
+		"redirect_uri":  {u.Client.RedirectURIs()[0]},
+	}
+
+	// First-party client skips consent
+	response, _ := noRedirectClient.Get(ts.URL + "?" + query.Encode())
+	if location, _ := response.Location(); location == nil || len(location.Query().Get("code")) == 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 302, response.StatusCode)
+	}
+
+	// Unless consent is forced
+	query.Set("prompt", "consent")
+	if response, _ = noRedirectClient.Get(ts.URL + "?" + query.Encode()); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+}
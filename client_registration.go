@@ -0,0 +1,333 @@
+package oauth2
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Token endpoint authentication methods.
+const (
+	// Client sends its credentials with HTTP Basic authentication.
+	ClientSecretBasic = "client_secret_basic"
+
+	// Client sends its credentials in the request body.
+	ClientSecretPost = "client_secret_post"
+
+	// Client authenticates with a certificate that is issued by a trusted CA (RFC 8705).
+	TLSClientAuth = "tls_client_auth"
+
+	// Client authenticates with one of its registered self-signed certificates (RFC 8705).
+	SelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+// ClientMetadata describes a client's registered metadata (RFC 7591).
+type ClientMetadata struct {
+	ClientName              string   `json:"client_name,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+
+	// Required subject DN of client's certificate, for tls_client_auth.
+	TLSClientAuthSubjectDN string `json:"tls_client_auth_subject_dn,omitempty"`
+
+	// SHA-256 thumbprints (x5t#S256) of client's certificates, for self_signed_tls_client_auth.
+	TLSClientCertificates []string `json:"tls_client_certificates,omitempty"`
+}
+
+// ClientRegistrationResponse describes a registered client's information that will be returned
+// to client.
+type ClientRegistrationResponse struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+
+	ClientMetadata
+}
+
+// Grant types that let a client act without user's consent. Dynamically registered clients may
+// only request them with an initial access token.
+var privilegedGrantTypes = []string{PasswordGrant, ClientCredentialsGrant}
+
+// ClientRegistration describes a dynamic client registration controller (RFC 7591) and its
+// management protocol (RFC 7592).
+type ClientRegistration struct {
+}
+
+// HandleRegister registers a new client.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (r *ClientRegistration) HandleRegister(c *server.RequestContext) {
+	/* Condition validation: Validate initial access token */
+	var privilegedGrants []string
+	if len(RealmOf(c).Config.InitialAccessTokens) > 0 {
+		if !r.validateInitialAccessToken(c) {
+			panic(util.Status401())
+		}
+		privilegedGrants = privilegedGrantTypes
+	}
+
+	/* Condition validation: Validate binding process */
+	metadata := new(ClientMetadata)
+	if err := c.BindJSON(metadata); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+	r.validateMetadata(c, metadata, privilegedGrants)
+
+	// Generate client's credentials, clients that authenticate with certificates do not have secret
+	clientID := bson.NewObjectId().Hex()
+	clientSecret := ""
+	if !isTLSAuthMethod(metadata.TokenEndpointAuthMethod) {
+		secret, err := GenerateClientSecret()
+		if err != nil {
+			panic(util.Status500())
+		}
+		clientSecret = secret
+	}
+	registrationToken, err := GenerateClientSecret()
+	if err != nil {
+		panic(util.Status500())
+	}
+
+	registrationStore := clientRegistrationStore(c)
+	if client := registrationStore.CreateClient(clientID, clientSecret, registrationToken, metadata); client == nil {
+		panic(util.Status500())
+	}
+
+	response := r.createResponse(c, clientID, metadata)
+	response.ClientSecret = clientSecret
+	response.ClientIDIssuedAt = time.Now().UTC().Unix()
+	response.RegistrationAccessToken = registrationToken
+	c.OutputJSON(util.Status201(), response)
+}
+
+// HandleRead returns a registered client's metadata.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (r *ClientRegistration) HandleRead(c *server.RequestContext) {
+	client := r.authenticate(c)
+
+	registrationStore := clientRegistrationStore(c)
+	metadata := registrationStore.FindClientMetadata(client.ClientID())
+	if metadata == nil {
+		panic(util.Status401())
+	}
+	c.OutputJSON(util.Status200(), r.createResponse(c, client.ClientID(), metadata))
+}
+
+// HandleUpdate replaces a registered client's metadata.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (r *ClientRegistration) HandleUpdate(c *server.RequestContext) {
+	client := r.authenticate(c)
+
+	/* Condition validation: Validate binding process */
+	var inputJSON struct {
+		ClientID string `json:"client_id"`
+		ClientMetadata
+	}
+	if err := c.BindJSON(&inputJSON); err != nil {
+		panic(util.Status400WithDescription(err.Error()))
+	}
+
+	/* Condition validation: client_id must be matched with the registered one */
+	if inputJSON.ClientID != client.ClientID() {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "client_id")))
+	}
+	// Client keeps the privileged grants that it had been registered with
+	var privilegedGrants []string
+	for _, grantType := range privilegedGrantTypes {
+		if containsString(client.GrantTypes(), grantType) {
+			privilegedGrants = append(privilegedGrants, grantType)
+		}
+	}
+
+	metadata := &inputJSON.ClientMetadata
+	r.validateMetadata(c, metadata, privilegedGrants)
+
+	registrationStore := clientRegistrationStore(c)
+	if !registrationStore.UpdateClientMetadata(client.ClientID(), metadata) {
+		panic(util.Status500())
+	}
+	c.OutputJSON(util.Status200(), r.createResponse(c, client.ClientID(), metadata))
+}
+
+// HandleDelete deletes a registered client.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (r *ClientRegistration) HandleDelete(c *server.RequestContext) {
+	client := r.authenticate(c)
+
+	registrationStore := clientRegistrationStore(c)
+	if !registrationStore.DeleteClient(client.ClientID()) {
+		panic(util.Status500())
+	}
+
+	event := createSecurityEvent(c, TokenRevokedEvent, nil, RegistrationReason)
+	event.ClientID = client.ClientID()
+	publishEvent(event)
+	c.OutputStatus(util.Status204())
+}
+
+// authenticate validates registration access token against client_id path param.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - client {Client} (a registered client entity)
+func (r *ClientRegistration) authenticate(c *server.RequestContext) Client {
+	tokenString := c.Header["authorization"]
+
+	/* Condition validation: Validate existing of authorization header */
+	if !bearerFinder.MatchString(tokenString) {
+		panic(util.Status401())
+	}
+
+	registrationStore := clientRegistrationStore(c)
+	client := registrationStore.FindClientWithRegistrationToken(c.PathParams["client_id"], tokenString[7:])
+	if client == nil {
+		panic(util.Status401())
+	}
+	return client
+}
+
+// validateInitialAccessToken validates bearer token against configured initial access tokens.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - isValid {bool} (true if the initial access token is accepted)
+func (r *ClientRegistration) validateInitialAccessToken(c *server.RequestContext) bool {
+	tokenString := c.Header["authorization"]
+	if !bearerFinder.MatchString(tokenString) {
+		return false
+	}
+	tokenString = tokenString[7:]
+
+	isValid := false
+	for _, initialToken := range RealmOf(c).Config.InitialAccessTokens {
+		if subtle.ConstantTimeCompare([]byte(initialToken), []byte(tokenString)) == 1 {
+			isValid = true
+		}
+	}
+	return isValid
+}
+
+// validateMetadata validates client's metadata and fills in default values.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - metadata {ClientMetadata} (client's metadata)
+// - privilegedGrants {[]string} (privileged grant types that client may request)
+func (r *ClientRegistration) validateMetadata(c *server.RequestContext, metadata *ClientMetadata, privilegedGrants []string) {
+	// Apply default values
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{AuthorizationCodeGrant}
+	}
+	if len(metadata.TokenEndpointAuthMethod) == 0 {
+		metadata.TokenEndpointAuthMethod = ClientSecretBasic
+	}
+
+	/* Condition validation: Validate grant_types */
+	isRedirectRequired := false
+	for _, grantType := range metadata.GrantTypes {
+		if !RealmOf(c).AllowsGrant(grantType) {
+			panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "grant_types")))
+		}
+		if containsString(privilegedGrantTypes, grantType) && !containsString(privilegedGrants, grantType) {
+			panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "grant_types")))
+		}
+		if grantType == AuthorizationCodeGrant || grantType == ImplicitGrant {
+			isRedirectRequired = true
+		}
+	}
+
+	/* Condition validation: Validate token_endpoint_auth_method */
+	switch metadata.TokenEndpointAuthMethod {
+
+	case ClientSecretBasic, ClientSecretPost:
+		metadata.TLSClientAuthSubjectDN = ""
+		metadata.TLSClientCertificates = nil
+
+	case TLSClientAuth:
+		if len(metadata.TLSClientAuthSubjectDN) == 0 {
+			panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "tls_client_auth_subject_dn")))
+		}
+		metadata.TLSClientCertificates = nil
+
+	case SelfSignedTLSClientAuth:
+		if len(metadata.TLSClientCertificates) == 0 {
+			panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "tls_client_certificates")))
+		}
+		metadata.TLSClientAuthSubjectDN = ""
+
+	default:
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "token_endpoint_auth_method")))
+	}
+
+	/* Condition validation: Validate redirect_uris */
+	if isRedirectRequired && len(metadata.RedirectURIs) == 0 {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "redirect_uris")))
+	}
+	for _, redirectURI := range metadata.RedirectURIs {
+		if u, err := url.Parse(redirectURI); err != nil || !u.IsAbs() || len(u.Host) == 0 || len(u.Fragment) > 0 {
+			panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "redirect_uris")))
+		}
+	}
+}
+
+// createResponse generates registration response.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - clientID {string} (client's client_id)
+// - metadata {ClientMetadata} (client's metadata)
+//
+// @return
+// - response {ClientRegistrationResponse} (a registration response)
+func (r *ClientRegistration) createResponse(c *server.RequestContext, clientID string, metadata *ClientMetadata) *ClientRegistrationResponse {
+	response := &ClientRegistrationResponse{
+		ClientID:       clientID,
+		ClientMetadata: *metadata,
+	}
+
+	// Client's URI is only available if server's public base URL is configured
+	realm := RealmOf(c)
+	if baseURL := strings.TrimSuffix(realm.Config.BaseURL, "/"); len(baseURL) > 0 {
+		response.RegistrationClientURI = fmt.Sprintf("%s%s/register/%s", baseURL, realm.PathPrefix, clientID)
+	}
+	return response
+}
+
+// containsString checks if a list contains a value.
+//
+// @param
+// - list {[]string} (a list of values)
+// - value {string} (a value to look for)
+//
+// @return
+// - isFound {bool} (true if value is in list)
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
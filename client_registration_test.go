@@ -0,0 +1,232 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/string_format"
+	"github.com/phuc0302/go-server/util"
+)
+
+// registerClient sends a registration request and parses registration response.
+func registerClient(url string, body string, initialToken string) (*http.Response, *ClientRegistrationResponse) {
+	request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if len(initialToken) > 0 {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", initialToken))
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != 201 {
+		return response, nil
+	}
+
+	data, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	registration := new(ClientRegistrationResponse)
+	json.Unmarshal(data, registration)
+	return response, registration
+}
+
+func Test_ClientRegistration_HandleRegister(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(ClientRegistration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleRegister(context)
+	}))
+	defer ts.Close()
+
+	_, registration := registerClient(ts.URL, `{"client_name":"Partner","redirect_uris":["https://partner.com/callback"],"grant_types":["authorization_code","refresh_token"]}`, "")
+	if registration == nil {
+		t.Error(expectedFormat.NotNil)
+	} else {
+		if len(registration.ClientSecret) == 0 || len(registration.RegistrationAccessToken) == 0 {
+			t.Error(expectedFormat.NotNil)
+		}
+		if registration.TokenEndpointAuthMethod != ClientSecretBasic {
+			t.Errorf(expectedFormat.StringButFoundString, ClientSecretBasic, registration.TokenEndpointAuthMethod)
+		}
+		if client := Store.FindClientWithCredential(registration.ClientID, registration.ClientSecret); client == nil {
+			t.Error(expectedFormat.NotNil)
+		}
+	}
+}
+
+func Test_ClientRegistration_HandleRegister_RegistrationClientURI(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Cfg.BaseURL = "https://auth.example.com/"
+	defer func() { Cfg.BaseURL = "" }()
+
+	controller := new(ClientRegistration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleRegister(context)
+	}))
+	defer ts.Close()
+
+	// Forwarded headers must not affect client's URI
+	request, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"redirect_uris":["https://partner.com/callback"],"grant_types":["authorization_code"]}`))
+	request.Host = "attacker.com"
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-Proto", "javascript")
+	response, _ := http.DefaultClient.Do(request)
+
+	registration := new(ClientRegistrationResponse)
+	data, _ := ioutil.ReadAll(response.Body)
+	json.Unmarshal(data, registration)
+
+	if expected := "https://auth.example.com/register/" + registration.ClientID; registration.RegistrationClientURI != expected {
+		t.Errorf(expectedFormat.StringButFoundString, expected, registration.RegistrationClientURI)
+	}
+}
+
+func Test_ClientRegistration_HandleRegister_InvalidMetadata(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(ClientRegistration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleRegister(context)
+	}))
+	defer ts.Close()
+
+	// [Test 1] Unsupported grant type
+	response, _ := registerClient(ts.URL, `{"redirect_uris":["https://partner.com/callback"],"grant_types":["urn:custom"]}`, "")
+	status := util.ParseStatus(response)
+	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "grant_types") {
+		t.Errorf(expectedFormat.InvalidParameter, "grant_types", status.Description)
+	}
+
+	// [Test 2] Missing redirect uris
+	response, _ = registerClient(ts.URL, `{"grant_types":["authorization_code"]}`, "")
+	status = util.ParseStatus(response)
+	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "redirect_uris") {
+		t.Errorf(expectedFormat.InvalidParameter, "redirect_uris", status.Description)
+	}
+
+	// [Test 3] Privileged grant types without initial access token
+	for _, grantType := range privilegedGrantTypes {
+		response, _ = registerClient(ts.URL, fmt.Sprintf(`{"grant_types":["%s"]}`, grantType), "")
+		status = util.ParseStatus(response)
+		if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "grant_types") {
+			t.Errorf(expectedFormat.InvalidParameter, "grant_types", status.Description)
+		}
+	}
+}
+
+func Test_ClientRegistration_HandleRegister_InitialAccessToken(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Cfg.InitialAccessTokens = []string{"initial_token"}
+
+	controller := new(ClientRegistration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleRegister(context)
+	}))
+	defer ts.Close()
+
+	body := `{"grant_types":["password"]}`
+	if response, _ := registerClient(ts.URL, body, ""); response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+	if _, registration := registerClient(ts.URL, body, "initial_token"); registration == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_ClientRegistration_Management(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	controller := new(ClientRegistration)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		if r.Method == "POST" {
+			controller.HandleRegister(context)
+			return
+		}
+
+		context.PathParams = map[string]string{"client_id": r.URL.Path[len("/register/"):]}
+		switch r.Method {
+		case "GET":
+			controller.HandleRead(context)
+		case "PUT":
+			controller.HandleUpdate(context)
+		case "DELETE":
+			controller.HandleDelete(context)
+		}
+	}))
+	defer ts.Close()
+
+	_, registration := registerClient(ts.URL, `{"redirect_uris":["https://partner.com/callback"],"grant_types":["authorization_code"]}`, "")
+	clientURL := fmt.Sprintf("%s/register/%s", ts.URL, registration.ClientID)
+
+	// [Test 1] Read without registration access token
+	response, _ := http.Get(clientURL)
+	if response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+
+	// [Test 2] Update metadata cannot add privileged grant types
+	request, _ := http.NewRequest("PUT", clientURL, strings.NewReader(fmt.Sprintf(`{"client_id":"%s","grant_types":["password"]}`, registration.ClientID)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", registration.RegistrationAccessToken))
+	response, _ = http.DefaultClient.Do(request)
+	if response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+
+	// [Test 3] Update metadata
+	request, _ = http.NewRequest("PUT", clientURL, strings.NewReader(fmt.Sprintf(`{"client_id":"%s","client_name":"Renamed","redirect_uris":["https://partner.com/callback"],"grant_types":["authorization_code"]}`, registration.ClientID)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", registration.RegistrationAccessToken))
+	response, _ = http.DefaultClient.Do(request)
+	if response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+
+	registrationStore, _ := Store.(ClientRegistrationStore)
+	if metadata := registrationStore.FindClientMetadata(registration.ClientID); metadata == nil || metadata.ClientName != "Renamed" {
+		t.Error("Expected client_name should be updated.")
+	}
+
+	// [Test 4] Delete
+	request, _ = http.NewRequest("DELETE", clientURL, nil)
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", registration.RegistrationAccessToken))
+	response, _ = http.DefaultClient.Do(request)
+	if response.StatusCode != 204 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 204, response.StatusCode)
+	}
+	if client := Store.FindClientWithID(registration.ClientID); client != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
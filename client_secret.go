@@ -0,0 +1,60 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// Number of random bytes that will be used to generate a client's secret.
+const clientSecretLength = 32
+
+// PrimarySecretLabel labels the secret that was issued together with the client.
+const PrimarySecretLabel = "primary"
+
+// GenerateClientSecret generates a new client's secret from a cryptographically secure random
+// source. The secret is only shown once, only its hash should be persisted.
+//
+// @return
+// - secret {string} (a hex encoded client's secret)
+// - err {error} (an error if the random source failed)
+func GenerateClientSecret() (secret string, err error) {
+	buffer := make([]byte, clientSecretLength)
+	if _, err = rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// HashClientSecret returns the form of client's secret that will be stored in database. Because
+// client's secrets are generated with high entropy, a fast hash function is sufficient.
+//
+// @param
+// - secret {string} (a client's secret in plain text)
+//
+// @return
+// - hash {string} (a hex encoded SHA-256 hash of client's secret)
+func HashClientSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// CompareClientSecret compares a plain text client's secret with a hashed client's secret in
+// constant time.
+//
+// @param
+// - hashedSecret {string} (a hashed client's secret from database)
+// - secret {string} (a client's secret in plain text)
+//
+// @return
+// - isMatched {bool} (true if both secrets are matched)
+func CompareClientSecret(hashedSecret string, secret string) bool {
+	/* Condition validation */
+	if len(hashedSecret) == 0 || len(secret) == 0 {
+		return false
+	}
+
+	hash := HashClientSecret(secret)
+	return subtle.ConstantTimeCompare([]byte(hashedSecret), []byte(hash)) == 1
+}
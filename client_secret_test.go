@@ -0,0 +1,40 @@
+package oauth2
+
+import (
+	"testing"
+
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_GenerateClientSecret(t *testing.T) {
+	secret1, err := GenerateClientSecret()
+	if err != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if len(secret1) != clientSecretLength*2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, clientSecretLength*2, len(secret1))
+	}
+
+	secret2, _ := GenerateClientSecret()
+	if secret1 == secret2 {
+		t.Errorf("Expected different secrets but found \"%s\".", secret2)
+	}
+}
+
+func Test_CompareClientSecret(t *testing.T) {
+	secret, _ := GenerateClientSecret()
+	hashedSecret := HashClientSecret(secret)
+
+	if hashedSecret == secret {
+		t.Errorf("Expected hashed secret but found \"%s\".", hashedSecret)
+	}
+	if !CompareClientSecret(hashedSecret, secret) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if CompareClientSecret(hashedSecret, hashedSecret) {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+	if CompareClientSecret("", "") {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/phuc0302/go-oauth2/oauth_key"
 	"github.com/phuc0302/go-server"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // OAuth2.0 flows.
@@ -32,14 +33,101 @@ const (
 // Config describes a configuration object that will be used during application life time.
 type Config struct {
 	AllowRefreshToken bool `json:"allow_refresh_token"`
+	AllowRegistration bool `json:"allow_registration"`
+
+	AllowAccount        bool `json:"allow_account"`
+	AllowAdministration bool `json:"allow_administration"`
+	AllowAudit          bool `json:"allow_audit"`
+	AllowMetrics        bool `json:"allow_metrics"`
+
+	// Issuer of tokens, it is stamped into every token and every token must carry it.
+	Issuer string `json:"issuer,omitempty"`
+
+	// Public base URL of this server, e.g. https://auth.example.com. It is used to build absolute
+	// URLs in responses, request's headers are never trusted for this purpose.
+	BaseURL string `json:"base_url,omitempty"`
+
+	// Addresses or CIDR ranges of reverse proxies, X-Forwarded-For and X-Real-IP are only
+	// honoured if request comes from one of them.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// Header that reverse proxy sets to connection's remote address, e.g. X-Real-IP. Routes that
+	// are bound to go-server's router do not see connection's remote address, so it is read from
+	// this header instead. Leave it empty if clients can reach server without the proxy.
+	RemoteAddrHeader string `json:"remote_addr_header,omitempty"`
+
+	// Isolated realms that are served by this deployment, see Realm. Requests that are not
+	// matched by any realm belong to the default realm, which is described by this config.
+	Realms []RealmDefinition `json:"realms,omitempty"`
+
+	// Initial access tokens that are required to register a client. If empty, registration is
+	// open to everyone.
+	InitialAccessTokens []string `json:"initial_access_tokens,omitempty"`
 
 	GrantTypes                []string      `json:"grant_types"`
 	AccessTokenDuration       time.Duration `json:"access_token_duration"`       // In seconds
 	RefreshTokenDuration      time.Duration `json:"refresh_token_duration"`      // In seconds
 	AuthorizationCodeDuration time.Duration `json:"authorization_code_duration"` // In seconds
+
+	PasswordAlgorithm string `json:"password_algorithm"` // bcrypt, argon2id or pbkdf2
+	BcryptCost        int    `json:"bcrypt_cost"`
+	Argon2Time        uint32 `json:"argon2_time"`
+	Argon2Memory      uint32 `json:"argon2_memory"` // In KiB
+	Argon2Threads     uint8  `json:"argon2_threads"`
+	PBKDF2Iterations  int    `json:"pbkdf2_iterations"`
+
+	MaxLoginAttempts     int           `json:"max_login_attempts"`     // Negative value disables lockout
+	LoginBackoffDuration time.Duration `json:"login_backoff_duration"` // In seconds
+	LoginLockoutDuration time.Duration `json:"login_lockout_duration"` // In seconds
+
+	// Per-client rate limits, keyed by endpoint's name.
+	RateLimits map[string]RateLimit `json:"rate_limits,omitempty"`
+
+	// Role definitions with inheritance and permissions. Token store's definitions, if any, take
+	// precedence.
+	Roles []RoleDefinition `json:"roles,omitempty"`
+
+	// Path to a JSON or YAML route policy manifest. If defined, every route that is bound through
+	// Bind* wrappers must have a policy.
+	PolicyManifest string `json:"policy_manifest,omitempty"`
+
+	// Path to HTML templates that replace the default consent page and login page, see
+	// ConsentPage and LoginPage.
+	ConsentTemplate string `json:"consent_template,omitempty"`
+	LoginTemplate   string `json:"login_template,omitempty"`
+
+	// Browser's login session. SessionSecret encrypts session cookie, if it is empty, a random
+	// secret is generated and sessions will not survive restart.
+	SessionSecret         string        `json:"session_secret,omitempty"`
+	SessionLifetime       time.Duration `json:"session_lifetime"`     // In seconds
+	SessionIdleTimeout    time.Duration `json:"session_idle_timeout"` // In seconds
+	SessionInsecureCookie bool          `json:"session_insecure_cookie,omitempty"`
+
+	// DPoP proofs (RFC 9449). A proof is accepted if its iat is within DPoPProofLifetime of
+	// server's time. If RequireDPoPNonce is true, proofs must carry a server nonce, which is
+	// rotated every DPoPNonceLifetime.
+	DPoPProofLifetime time.Duration `json:"dpop_proof_lifetime"` // In seconds
+	DPoPNonceLifetime time.Duration `json:"dpop_nonce_lifetime"` // In seconds
+	RequireDPoPNonce  bool          `json:"require_dpop_nonce,omitempty"`
+
+	// Mutual TLS (RFC 8705). go-server's router does not expose client's certificates, so if
+	// MutualTLS is true, token endpoint and protected routes cannot be bound to it; serve them
+	// with Handler or Middleware over MutualTLSConfig instead.
+	MutualTLS bool `json:"mutual_tls,omitempty"`
 }
 
-// createConfig generates a default oauth2 configuration.
+// DefaultConfig returns a default oauth2 configuration with converted durations. It is a base for
+// CreateOAuthServer's config.
+//
+// @return
+// - config {Config} (an instance of oauth2's configuration)
+func DefaultConfig() *Config {
+	config := defaultConfig()
+	normalizeConfig(config)
+	return config
+}
+
+// createConfig generates a default oauth2 configuration and saves it to config file.
 //
 // @return
 // - config {Config} (an instance of oauth2's configuration)
@@ -48,7 +136,17 @@ func createConfig() (config *Config) {
 		panic("Server is not yet being initialized! Please run: 'server.Initialize'.")
 	}
 
-	// Create default config
+	config = defaultConfig()
+	server.Cfg.SetExtension(oauthKey.Config, *config)
+	server.Cfg.Save()
+	return
+}
+
+// defaultConfig returns a default oauth2 configuration, its durations are in seconds.
+//
+// @return
+// - config {Config} (an instance of oauth2's configuration)
+func defaultConfig() (config *Config) {
 	config = &Config{
 		GrantTypes: []string{AuthorizationCodeGrant, ClientCredentialsGrant, PasswordGrant, RefreshTokenGrant},
 
@@ -56,10 +154,17 @@ func createConfig() (config *Config) {
 		AuthorizationCodeDuration: 300,
 		AccessTokenDuration:       259200,
 		RefreshTokenDuration:      7776000,
-	}
 
-	server.Cfg.SetExtension(oauthKey.Config, *config)
-	server.Cfg.Save()
+		RateLimits: map[string]RateLimit{
+			TokenEndpoint:         {Limit: 60, Period: 60},
+			IntrospectionEndpoint: {Limit: 600, Period: 60},
+		},
+		Roles: defaultRoleDefinitions(),
+	}
+	applyDefaultPasswordPolicy(config)
+	applyDefaultLoginPolicy(config)
+	applyDefaultSessionPolicy(config)
+	applyDefaultDPoPPolicy(config)
 	return
 }
 
@@ -89,9 +194,121 @@ func loadConfig() (config *Config) {
 		config = createConfig()
 	}
 
-	grantsValidation = regexp.MustCompile(fmt.Sprintf("^(%s)$", strings.Join(config.GrantTypes, "|")))
+	// Realm's config overrides default config before it is normalized
+	for i, definition := range config.Realms {
+		realmConfig, err := loadRealmConfig(config, definition)
+		if err != nil {
+			panic(err)
+		}
+		normalizeConfig(realmConfig)
+		config.Realms[i].config = realmConfig
+	}
+
+	normalizeConfig(config)
+	grantsValidation = compileGrantsValidation(config.GrantTypes)
+	return
+}
+
+// compileGrantsValidation returns a regex that matches allowed grant types.
+//
+// @param
+// - grantTypes {[]string} (a list of allowed grant types)
+//
+// @return
+// - regex {regexp.Regexp} (grant types' regex)
+func compileGrantsValidation(grantTypes []string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("^(%s)$", strings.Join(grantTypes, "|")))
+}
+
+// normalizeConfig fills in default policies for missing values and converts durations from
+// seconds.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+func normalizeConfig(config *Config) {
+	applyDefaultPasswordPolicy(config)
+	applyDefaultLoginPolicy(config)
+	applyDefaultSessionPolicy(config)
+	applyDefaultDPoPPolicy(config)
+
+	// Generated secret is never saved to config file, so it does not leak into shared configs
+	if len(config.SessionSecret) == 0 {
+		config.SessionSecret, _ = GenerateClientSecret()
+	}
 	config.AuthorizationCodeDuration *= time.Second
 	config.RefreshTokenDuration *= time.Second
 	config.AccessTokenDuration *= time.Second
-	return
+	config.LoginBackoffDuration *= time.Second
+	config.LoginLockoutDuration *= time.Second
+	config.SessionLifetime *= time.Second
+	config.SessionIdleTimeout *= time.Second
+	config.DPoPProofLifetime *= time.Second
+	config.DPoPNonceLifetime *= time.Second
+}
+
+// applyDefaultPasswordPolicy fills in default password hashing policy for missing values.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+func applyDefaultPasswordPolicy(config *Config) {
+	if len(config.PasswordAlgorithm) == 0 {
+		config.PasswordAlgorithm = BcryptAlgorithm
+	}
+	if config.BcryptCost == 0 {
+		config.BcryptCost = bcrypt.DefaultCost
+	}
+	if config.Argon2Time == 0 {
+		config.Argon2Time = 3
+	}
+	if config.Argon2Memory == 0 {
+		config.Argon2Memory = 64 * 1024
+	}
+	if config.Argon2Threads == 0 {
+		config.Argon2Threads = 4
+	}
+	if config.PBKDF2Iterations == 0 {
+		config.PBKDF2Iterations = 600000
+	}
+}
+
+// applyDefaultLoginPolicy fills in default brute-force protection policy for missing values.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+func applyDefaultLoginPolicy(config *Config) {
+	if config.MaxLoginAttempts == 0 {
+		config.MaxLoginAttempts = 5
+	}
+	if config.LoginBackoffDuration == 0 {
+		config.LoginBackoffDuration = 1
+	}
+	if config.LoginLockoutDuration == 0 {
+		config.LoginLockoutDuration = 900
+	}
+}
+
+// applyDefaultSessionPolicy fills in default browser's session policy for missing values.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+func applyDefaultSessionPolicy(config *Config) {
+	if config.SessionLifetime == 0 {
+		config.SessionLifetime = 28800
+	}
+	if config.SessionIdleTimeout == 0 {
+		config.SessionIdleTimeout = 1800
+	}
+}
+
+// applyDefaultDPoPPolicy fills in default DPoP proofs' policy for missing values.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+func applyDefaultDPoPPolicy(config *Config) {
+	if config.DPoPProofLifetime == 0 {
+		config.DPoPProofLifetime = 60
+	}
+	if config.DPoPNonceLifetime == 0 {
+		config.DPoPNonceLifetime = 300
+	}
 }
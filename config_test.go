@@ -38,11 +38,17 @@ func Test_LoadConfigWithPanic(t *testing.T) {
 func Test_CreateConfig(t *testing.T) {
 	defer os.Remove(server.Debug)
 	server.Initialize(true)
-	createConfig()
+	config := createConfig()
 
 	if server.Cfg.GetExtension(oauthKey.Config) == nil {
 		t.Error(expectedFormat.NotNil)
 	}
+	if len(config.SessionSecret) > 0 {
+		t.Error("Expected session secret should not be saved to config file.")
+	}
+	if normalizeConfig(config); len(config.SessionSecret) == 0 {
+		t.Error(expectedFormat.NotNil)
+	}
 }
 
 func Test_LoadConfig(t *testing.T) {
@@ -0,0 +1,84 @@
+package oauth2
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// ConsentPage describes the model that will be rendered by consent template. The form must be
+// submitted to "/authorize" by POST with all hidden fields and a "decision" field, which is
+// either "approve" or "deny".
+type ConsentPage struct {
+	ClientID    string
+	ClientName  string
+	Scopes      []string
+	Hidden      map[string]string
+	Action      string
+	ApproveName string
+	DenyName    string
+}
+
+// ConsentTemplate renders consent page. It can be replaced directly or by Config's
+// ConsentTemplate path.
+var ConsentTemplate = template.Must(template.New("consent").Parse(defaultConsentTemplate))
+
+// Default consent page.
+const defaultConsentTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Authorize {{.ClientName}}</title>
+</head>
+<body>
+	<h1>{{.ClientName}} would like to access your account</h1>
+	{{if .Scopes}}
+	<p>This application is requesting permission to:</p>
+	<ul>
+		{{range .Scopes}}<li>{{.}}</li>{{end}}
+	</ul>
+	{{end}}
+	<form method="post" action="{{.Action}}">
+		{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">{{end}}
+		<button type="submit" name="decision" value="{{.ApproveName}}">Allow</button>
+		<button type="submit" name="decision" value="{{.DenyName}}">Deny</button>
+	</form>
+</body>
+</html>
+`
+
+// Consent's decisions.
+const (
+	ApproveDecision = "approve"
+	DenyDecision    = "deny"
+)
+
+// loadConsentTemplate replaces consent template with a template file.
+//
+// @param
+// - path {string} (template file's path)
+func loadConsentTemplate(path string) {
+	ConsentTemplate = template.Must(template.ParseFiles(path))
+}
+
+// renderTemplate renders an HTML page.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - status {util.Status} (response's status)
+// - tmpl {template.Template} (page's template)
+// - model {interface{}} (page's model)
+func renderTemplate(c *server.RequestContext, status *util.Status, tmpl *template.Template, model interface{}) {
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, model); err != nil {
+		panic(util.Status500())
+	}
+
+	// Pages must not be framed by other sites
+	c.OutputHeader("Content-Type", "text/html; charset=utf-8")
+	c.OutputHeader("X-Frame-Options", "DENY")
+	c.OutputHeader("Cache-Control", "no-store")
+	c.OutputText(status, buffer.String())
+}
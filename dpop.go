@@ -0,0 +1,446 @@
+package oauth2
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// DPoP's token type and errors (RFC 9449).
+const (
+	DPoPTokenType = "DPoP"
+
+	InvalidDPoPProofError = "invalid_dpop_proof"
+	InvalidDPoPTokenError = "invalid_token"
+	UseDPoPNonceError     = "use_dpop_nonce"
+)
+
+// DPoP proof's JWT type.
+const dpopProofType = "dpop+jwt"
+
+// Signing algorithms that are accepted for DPoP proofs.
+const dpopAlgorithms = "RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512"
+
+// dpopTarget describes the request that a DPoP proof must be bound to.
+type dpopTarget struct {
+	method      string
+	scheme      string // Empty if request's scheme is unknown
+	host        string
+	path        string
+	accessToken string // Empty at token endpoint
+}
+
+// JWKThumbprint returns the JWK SHA-256 thumbprint of a public key (RFC 7638).
+//
+// @param
+// - publicKey {crypto.PublicKey} (a RSA or EC public key)
+//
+// @return
+// - thumbprint {string} (base64url encoded SHA-256 digest of key's JWK, empty if key is not supported)
+func JWKThumbprint(publicKey crypto.PublicKey) string {
+	jwk := publicJWK(publicKey)
+	if jwk == nil {
+		return ""
+	}
+
+	// Members are sorted and there is no whitespace
+	data, _ := json.Marshal(jwk)
+	digest := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// publicJWK returns the required members of a public key's JWK.
+//
+// @param
+// - publicKey {crypto.PublicKey} (a RSA or EC public key)
+//
+// @return
+// - jwk {map[string]string} (key's JWK or null if key is not supported)
+func publicJWK(publicKey crypto.PublicKey) map[string]string {
+	switch key := publicKey.(type) {
+
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": key.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}
+	}
+	return nil
+}
+
+// parseJWK converts a proof's jwk header to a public key.
+//
+// @param
+// - value {interface{}} (proof's jwk header)
+//
+// @return
+// - publicKey {crypto.PublicKey} (a RSA or EC public key)
+// - err {error} (an error if jwk is not a supported public key)
+func parseJWK(value interface{}) (crypto.PublicKey, error) {
+	jwk, ok := value.(map[string]interface{})
+
+	/* Condition validation: jwk must be a public key */
+	if !ok {
+		return nil, fmt.Errorf("Missing jwk header.")
+	}
+	if _, isPrivate := jwk["d"]; isPrivate {
+		return nil, fmt.Errorf("Invalid jwk header: private key.")
+	}
+
+	switch jwk["kty"] {
+
+	case "RSA":
+		n, errN := decodeJWKInt(jwk, "n")
+		e, errE := decodeJWKInt(jwk, "e")
+		if errN != nil || errE != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || n.BitLen() < 2048 {
+			return nil, fmt.Errorf("Invalid jwk header: RSA key.")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Invalid jwk header: unsupported curve %v.", jwk["crv"])
+		}
+
+		x, errX := decodeJWKInt(jwk, "x")
+		y, errY := decodeJWKInt(jwk, "y")
+		if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("Invalid jwk header: EC key.")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("Invalid jwk header: unsupported key type %v.", jwk["kty"])
+}
+
+// decodeJWKInt decodes a base64url encoded integer member of a JWK.
+//
+// @param
+// - jwk {map[string]interface{}} (a JWK)
+// - name {string} (member's name)
+//
+// @return
+// - value {big.Int} (member's value)
+// - err {error} (an error if member is missing or is not base64url encoded)
+func decodeJWKInt(jwk map[string]interface{}, name string) (*big.Int, error) {
+	encoded, _ := jwk[name].(string)
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("Invalid jwk member: %s.", name)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// verifyDPoPProof validates a DPoP proof against the request that carries it. A proof can only be
+// used once.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - proof {string} (DPoP header's value)
+// - target {dpopTarget} (the request that carries proof)
+//
+// @return
+// - keyThumbprint {string} (JWK SHA-256 thumbprint of proof's key)
+// - errorCode {string} (DPoP error, empty if proof is valid)
+func verifyDPoPProof(realm *Realm, proof string, target dpopTarget) (string, string) {
+	/* Condition validation: Validate existing of proof */
+	if len(proof) == 0 {
+		return "", InvalidDPoPProofError
+	}
+
+	var publicKey crypto.PublicKey
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(proof, func(t *jwt.Token) (interface{}, error) {
+		/* Condition validation: jwt method must be an asymmetric signing method */
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("Invalid signing method: %v", t.Header["alg"])
+		}
+		if proofType, _ := t.Header["typ"].(string); proofType != dpopProofType {
+			return nil, fmt.Errorf("Invalid proof type: %v", t.Header["typ"])
+		}
+
+		key, err := parseJWK(t.Header["jwk"])
+		publicKey = key
+		return key, err
+	})
+	if err != nil || !token.Valid {
+		return "", InvalidDPoPProofError
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	tokenID, _ := claims["jti"].(string)
+	method, _ := claims["htm"].(string)
+	uri, _ := claims["htu"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+
+	/* Condition validation: Proof must be bound to this request */
+	if len(tokenID) == 0 || method != target.method || !target.matchesURI(uri) {
+		return "", InvalidDPoPProofError
+	}
+
+	/* Condition validation: Proof must be fresh */
+	now := realm.now()
+	lifetime := realm.Config.DPoPProofLifetime
+	issuedTime := time.Unix(int64(issuedAt), 0)
+	if issuedAt <= 0 || issuedTime.Before(now.Add(-lifetime)) || issuedTime.After(now.Add(lifetime)) {
+		return "", InvalidDPoPProofError
+	}
+
+	/* Condition validation: Proof must be bound to access token */
+	if len(target.accessToken) > 0 {
+		digest := sha256.Sum256([]byte(target.accessToken))
+		if accessTokenHash, _ := claims["ath"].(string); accessTokenHash != base64.RawURLEncoding.EncodeToString(digest[:]) {
+			return "", InvalidDPoPProofError
+		}
+	}
+
+	/* Condition validation: Proof must carry server's nonce */
+	if nonce, _ := claims["nonce"].(string); realm.Config.RequireDPoPNonce && !isValidDPoPNonce(realm, nonce) {
+		return "", UseDPoPNonceError
+	}
+
+	/* Condition validation: Proof must not be replayed */
+	keyThumbprint := JWKThumbprint(publicKey)
+	if Replays != nil && !Replays.SaveProof(realmKey(realm, fmt.Sprintf("dpop:%s:%s", keyThumbprint, tokenID)), realm.now(), issuedTime.Add(lifetime)) {
+		return "", InvalidDPoPProofError
+	}
+	return keyThumbprint, ""
+}
+
+// verifyDPoPBinding checks if a request proves possession of the DPoP key that its access token
+// is bound to. Unbound tokens do not require proof.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - proof {string} (DPoP header's value)
+// - isDPoP {bool} (true if access token had been sent with DPoP authorization scheme)
+// - accessToken {Token} (request's access token)
+// - target {dpopTarget} (the request that carries proof)
+//
+// @return
+// - errorCode {string} (DPoP error, empty if request is accepted)
+func verifyDPoPBinding(realm *Realm, proof string, isDPoP bool, accessToken Token, target dpopTarget) string {
+	boundToken, ok := accessToken.(DPoPBoundToken)
+	if !ok || len(boundToken.KeyThumbprint()) == 0 {
+		return ""
+	}
+
+	/* Condition validation: Bound token must not be sent as bearer token */
+	if !isDPoP {
+		return InvalidDPoPTokenError
+	}
+
+	keyThumbprint, errorCode := verifyDPoPProof(realm, proof, target)
+	if len(errorCode) == 0 && keyThumbprint != boundToken.KeyThumbprint() {
+		errorCode = InvalidDPoPProofError
+	}
+	return errorCode
+}
+
+// matchesURI checks if a proof's htu is the target's URI, query and fragment are ignored.
+//
+// @param
+// - uri {string} (proof's htu)
+//
+// @return
+// - isMatched {bool} (true if htu is the target's URI)
+func (t dpopTarget) matchesURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if len(t.scheme) > 0 && u.Scheme != t.scheme {
+		return false
+	}
+	return strings.EqualFold(u.Host, t.host) && u.Path == t.path
+}
+
+// createDPoPTarget describes an HTTP request that a DPoP proof must be bound to.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - accessToken {string} (request's access token, empty at token endpoint)
+//
+// @return
+// - target {dpopTarget} (the request that carries proof)
+func createDPoPTarget(c *server.RequestContext, accessToken string) dpopTarget {
+	return dpopTarget{
+		method:      c.Method(),
+		scheme:      c.Header["x-forwarded-proto"],
+		host:        c.Header["host"],
+		path:        c.Path,
+		accessToken: accessToken,
+	}
+}
+
+// boundKeyThumbprint returns the DPoP key that a token is bound to.
+//
+// @param
+// - token {Token} (a token's instance)
+//
+// @return
+// - keyThumbprint {string} (JWK SHA-256 thumbprint of the key, empty if token is not bound)
+func boundKeyThumbprint(token Token) string {
+	if boundToken, ok := token.(DPoPBoundToken); ok {
+		return boundToken.KeyThumbprint()
+	}
+	return ""
+}
+
+// validateTokenRequestProof validates the DPoP proof of a token request. Requests without proof
+// are issued bearer tokens.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - realm {Realm} (request's realm)
+//
+// @return
+// - keyThumbprint {string} (JWK SHA-256 thumbprint of proof's key, empty if there is no proof)
+func validateTokenRequestProof(c *server.RequestContext, realm *Realm) string {
+	proof := c.Header["dpop"]
+	if len(proof) == 0 {
+		return ""
+	}
+
+	keyThumbprint, errorCode := verifyDPoPProof(realm, proof, createDPoPTarget(c, ""))
+	outputDPoPNonce(c, realm)
+	if len(errorCode) > 0 {
+		panic(&util.Status{Code: 400, Title: errorCode, Description: dpopErrorDescription(errorCode)})
+	}
+	return keyThumbprint
+}
+
+// outputDPoPChallenge outputs the challenge of a request that had been rejected by DPoP binding.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - realm {Realm} (request's realm)
+// - errorCode {string} (DPoP error)
+func outputDPoPChallenge(c *server.RequestContext, realm *Realm, errorCode string) {
+	c.OutputHeader("WWW-Authenticate", fmt.Sprintf("DPoP error=\"%s\", error_description=\"%s\", algs=\"%s\"", errorCode, dpopErrorDescription(errorCode), dpopAlgorithms))
+	outputDPoPNonce(c, realm)
+}
+
+// outputDPoPNonce outputs realm's current nonce if realm requires nonce, so client can use it in
+// its next proof.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - realm {Realm} (request's realm)
+func outputDPoPNonce(c *server.RequestContext, realm *Realm) {
+	if realm.Config.RequireDPoPNonce {
+		c.OutputHeader("DPoP-Nonce", dpopNonce(realm, dpopNonceWindow(realm)))
+	}
+}
+
+// dpopErrorDescription returns a human readable description of a DPoP error.
+//
+// @param
+// - errorCode {string} (DPoP error)
+//
+// @return
+// - description {string} (error's description)
+func dpopErrorDescription(errorCode string) string {
+	switch errorCode {
+	case UseDPoPNonceError:
+		return "\"DPoP\" proof must carry server's nonce."
+	case InvalidDPoPTokenError:
+		return "DPoP-bound access token must be sent with \"DPoP\" scheme."
+	}
+	return "Invalid \"DPoP\" proof."
+}
+
+// accessTokenType returns the token_type of an access token.
+//
+// @param
+// - token {Token} (an access token)
+//
+// @return
+// - tokenType {string} (DPoP if token is bound to a DPoP key, otherwise Bearer)
+func accessTokenType(token Token) string {
+	if boundToken, ok := token.(DPoPBoundToken); ok && len(boundToken.KeyThumbprint()) > 0 {
+		return DPoPTokenType
+	}
+	return "Bearer"
+}
+
+// dpopNonce returns realm's DPoP nonce of a time window. Nonces are derived from realm's session
+// secret, so processes that share config accept each other's nonces.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - window {int64} (nonce's time window)
+//
+// @return
+// - nonce {string} (window's nonce)
+func dpopNonce(realm *Realm, window int64) string {
+	mac := hmac.New(sha256.New, []byte(realm.Config.SessionSecret))
+	fmt.Fprintf(mac, "dpop_nonce:%d", window)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// dpopNonceWindow returns the current time window of realm's DPoP nonces.
+//
+// @param
+// - realm {Realm} (request's realm)
+//
+// @return
+// - window {int64} (current time window)
+func dpopNonceWindow(realm *Realm) int64 {
+	lifetime := realm.Config.DPoPNonceLifetime
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	return realm.now().UnixNano() / int64(lifetime)
+}
+
+// isValidDPoPNonce checks if a nonce is realm's nonce of the current or the previous time window.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - nonce {string} (proof's nonce)
+//
+// @return
+// - isValid {bool} (true if nonce is accepted)
+func isValidDPoPNonce(realm *Realm, nonce string) bool {
+	window := dpopNonceWindow(realm)
+	for _, w := range []int64{window, window - 1} {
+		if hmac.Equal([]byte(nonce), []byte(dpopNonce(realm, w))) {
+			return true
+		}
+	}
+	return false
+}
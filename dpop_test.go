@@ -0,0 +1,400 @@
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// createDPoPProof signs a DPoP proof with a private key, claims override the default claims.
+func createDPoPProof(t *testing.T, privateKey *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+	proofClaims := jwt.MapClaims{
+		"jti": bson.NewObjectId().Hex(),
+		"htm": "POST",
+		"htu": "https://example.com/token",
+		"iat": time.Now().Unix(),
+	}
+	for key, value := range claims {
+		proofClaims[key] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, proofClaims)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = publicJWK(&privateKey.PublicKey)
+
+	proof, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// createDPoPRealm returns a realm with default DPoP policy.
+func createDPoPRealm() *Realm {
+	return &Realm{Config: DefaultConfig()}
+}
+
+// tokenTarget describes a token request.
+var tokenTarget = dpopTarget{method: "POST", host: "example.com", path: "/token"}
+
+func Test_JWKThumbprint(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for _, publicKey := range []interface{}{&ecKey.PublicKey, &rsaKey.PublicKey} {
+		thumbprint := JWKThumbprint(publicKey)
+		if len(thumbprint) != 43 {
+			t.Errorf(expectedFormat.NumberButFoundNumber, 43, len(thumbprint))
+		}
+
+		// Thumbprint of the parsed jwk must be the same
+		jwk := make(map[string]interface{})
+		for key, value := range publicJWK(publicKey) {
+			jwk[key] = value
+		}
+		parsedKey, err := parseJWK(jwk)
+		if err != nil || JWKThumbprint(parsedKey) != thumbprint {
+			t.Errorf("Expected parsed key should have the same thumbprint but found %v.", err)
+		}
+	}
+
+	// [Test] Private key is rejected
+	if _, err := parseJWK(map[string]interface{}{"kty": "EC", "crv": "P-256", "d": "secret"}); err == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_verifyDPoPProof(t *testing.T) {
+	realm := createDPoPRealm()
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// [Test 1] Valid proof
+	proof := createDPoPProof(t, privateKey, nil)
+	thumbprint, errorCode := verifyDPoPProof(realm, proof, tokenTarget)
+	if len(errorCode) > 0 || thumbprint != JWKThumbprint(&privateKey.PublicKey) {
+		t.Errorf(expectedFormat.StringButFoundString, JWKThumbprint(&privateKey.PublicKey), thumbprint)
+	}
+
+	// [Test 2] Replayed proof
+	if _, errorCode = verifyDPoPProof(realm, proof, tokenTarget); errorCode != InvalidDPoPProofError {
+		t.Errorf(expectedFormat.StringButFoundString, InvalidDPoPProofError, errorCode)
+	}
+
+	// [Test 3] Proof of another request
+	invalidProofs := []string{
+		createDPoPProof(t, privateKey, jwt.MapClaims{"htm": "GET"}),
+		createDPoPProof(t, privateKey, jwt.MapClaims{"htu": "https://example.com/authorize"}),
+		createDPoPProof(t, privateKey, jwt.MapClaims{"htu": "https://attacker.com/token"}),
+		createDPoPProof(t, privateKey, jwt.MapClaims{"iat": time.Now().Add(-time.Hour).Unix()}),
+		createDPoPProof(t, privateKey, jwt.MapClaims{"jti": ""}),
+		"",
+	}
+	for i, invalidProof := range invalidProofs {
+		if _, errorCode = verifyDPoPProof(realm, invalidProof, tokenTarget); errorCode != InvalidDPoPProofError {
+			t.Errorf("[%d] Expected proof should be rejected but found %s.", i, errorCode)
+		}
+	}
+
+	// [Test 4] Query is ignored
+	proof = createDPoPProof(t, privateKey, jwt.MapClaims{"htu": "https://example.com/token?grant_type=password"})
+	if _, errorCode = verifyDPoPProof(realm, proof, tokenTarget); len(errorCode) > 0 {
+		t.Errorf(expectedFormat.StringButFoundString, "", errorCode)
+	}
+
+	// [Test 5] Proof that is not signed by its jwk
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"jti": "1", "htm": "POST", "htu": "https://example.com/token", "iat": time.Now().Unix()})
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = publicJWK(&privateKey.PublicKey)
+	proof, _ = token.SignedString(otherKey)
+	if _, errorCode = verifyDPoPProof(realm, proof, tokenTarget); errorCode != InvalidDPoPProofError {
+		t.Errorf(expectedFormat.StringButFoundString, InvalidDPoPProofError, errorCode)
+	}
+}
+
+func Test_verifyDPoPProof_Nonce(t *testing.T) {
+	realm := createDPoPRealm()
+	realm.Config.RequireDPoPNonce = true
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// [Test 1] Proof without nonce
+	if _, errorCode := verifyDPoPProof(realm, createDPoPProof(t, privateKey, nil), tokenTarget); errorCode != UseDPoPNonceError {
+		t.Errorf(expectedFormat.StringButFoundString, UseDPoPNonceError, errorCode)
+	}
+
+	// [Test 2] Proof with server's nonce
+	nonce := dpopNonce(realm, dpopNonceWindow(realm))
+	if _, errorCode := verifyDPoPProof(realm, createDPoPProof(t, privateKey, jwt.MapClaims{"nonce": nonce}), tokenTarget); len(errorCode) > 0 {
+		t.Errorf(expectedFormat.StringButFoundString, "", errorCode)
+	}
+
+	// [Test 3] Previous nonce is still accepted, older nonces are not
+	now := time.Now()
+	realm.clock = fixedClock(now.Add(realm.Config.DPoPNonceLifetime))
+	if !isValidDPoPNonce(realm, nonce) {
+		t.Error("Expected previous nonce should be accepted.")
+	}
+	realm.clock = fixedClock(now.Add(3 * realm.Config.DPoPNonceLifetime))
+	if isValidDPoPNonce(realm, nonce) {
+		t.Error("Expected expired nonce should be rejected.")
+	}
+}
+
+func Test_verifyDPoPBinding(t *testing.T) {
+	realm := createDPoPRealm()
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	accessToken := &MongoDBToken{JWKThumbprint: JWKThumbprint(&privateKey.PublicKey)}
+	target := dpopTarget{method: "GET", host: "api.example.com", path: "/orders", accessToken: "token"}
+	digest := sha256.Sum256([]byte("token"))
+	claims := jwt.MapClaims{"htm": "GET", "htu": "https://api.example.com/orders", "ath": base64.RawURLEncoding.EncodeToString(digest[:])}
+
+	// [Test 1] Unbound token does not require proof
+	if errorCode := verifyDPoPBinding(realm, "", false, &MongoDBToken{}, target); len(errorCode) > 0 {
+		t.Errorf(expectedFormat.StringButFoundString, "", errorCode)
+	}
+
+	// [Test 2] Bound token is sent as bearer token
+	if errorCode := verifyDPoPBinding(realm, createDPoPProof(t, privateKey, claims), false, accessToken, target); errorCode != InvalidDPoPTokenError {
+		t.Errorf(expectedFormat.StringButFoundString, InvalidDPoPTokenError, errorCode)
+	}
+
+	// [Test 3] Bound token with a proof of its key
+	if errorCode := verifyDPoPBinding(realm, createDPoPProof(t, privateKey, claims), true, accessToken, target); len(errorCode) > 0 {
+		t.Errorf(expectedFormat.StringButFoundString, "", errorCode)
+	}
+
+	// [Test 4] Bound token with a proof of another key
+	if errorCode := verifyDPoPBinding(realm, createDPoPProof(t, otherKey, claims), true, accessToken, target); errorCode != InvalidDPoPProofError {
+		t.Errorf(expectedFormat.StringButFoundString, InvalidDPoPProofError, errorCode)
+	}
+
+	// [Test 5] Proof of another access token
+	claims["ath"] = "other"
+	if errorCode := verifyDPoPBinding(realm, createDPoPProof(t, privateKey, claims), true, accessToken, target); errorCode != InvalidDPoPProofError {
+		t.Errorf(expectedFormat.StringButFoundString, InvalidDPoPProofError, errorCode)
+	}
+}
+
+func Test_MemoryDPoPReplayStore(t *testing.T) {
+	store := CreateMemoryDPoPReplayStore()
+	now := time.Now()
+
+	if !store.SaveProof("proof", now, now.Add(time.Minute)) {
+		t.Error("Expected new proof should be saved.")
+	}
+	if store.SaveProof("proof", now, now.Add(time.Minute)) {
+		t.Error("Expected used proof should be rejected.")
+	}
+	if !store.SaveProof("expired", now, now.Add(-time.Second)) || !store.SaveProof("expired", now, now.Add(time.Minute)) {
+		t.Error("Expected expired proof should be forgotten.")
+	}
+}
+
+func Test_MongoDBStore_DPoPToken(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	store := &MongoDBStore{privateKey: privateKey}
+
+	now := time.Now()
+	token := &MongoDBToken{
+		ID:      bson.NewObjectId(),
+		User:    bson.NewObjectId(),
+		Client:  "mobile",
+		Created: now,
+		Expired: now.Add(time.Hour),
+
+		JWKThumbprint: "thumbprint",
+
+		privateKey: privateKey,
+	}
+
+	parsedToken := store.parseToken(token.Token())
+	if boundToken, ok := parsedToken.(DPoPBoundToken); !ok || boundToken.KeyThumbprint() != "thumbprint" {
+		t.Errorf("Expected token should be bound to DPoP key but found %v.", parsedToken)
+	}
+	if tokenType := accessTokenType(parsedToken); tokenType != DPoPTokenType {
+		t.Errorf(expectedFormat.StringButFoundString, DPoPTokenType, tokenType)
+	}
+	if tokenType := accessTokenType(&MongoDBToken{}); tokenType != "Bearer" {
+		t.Errorf(expectedFormat.StringButFoundString, "Bearer", tokenType)
+	}
+}
+
+func Test_hasSameBinding(t *testing.T) {
+	token := &MongoDBToken{JWKThumbprint: "key"}
+
+	if !hasSameBinding(token, tokenBinding{keyThumbprint: "key"}) {
+		t.Error("Expected token should be reused with the same key.")
+	}
+	if hasSameBinding(token, tokenBinding{}) || hasSameBinding(token, tokenBinding{keyThumbprint: "other"}) {
+		t.Error("Expected token should not be reused with another binding.")
+	}
+	if !hasSameBinding(&MongoDBToken{}, tokenBinding{}) {
+		t.Error("Expected unbound token should be reused without binding.")
+	}
+}
+
+func Test_boundKeyThumbprint(t *testing.T) {
+	if keyThumbprint := boundKeyThumbprint(&MongoDBToken{JWKThumbprint: "key"}); keyThumbprint != "key" {
+		t.Errorf(expectedFormat.StringButFoundString, "key", keyThumbprint)
+	}
+	if keyThumbprint := boundKeyThumbprint(&MongoDBToken{}); len(keyThumbprint) > 0 {
+		t.Errorf(expectedFormat.StringButFoundString, "", keyThumbprint)
+	}
+}
+
+// postTokenForm sends a token request with an optional DPoP proof.
+func postTokenForm(t *testing.T, tokenURL string, form url.Values, proof string) *http.Response {
+	request, _ := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(proof) > 0 {
+		request.Header.Set("DPoP", proof)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func Test_TokenGrant_DPoP(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	ts := httptest.NewServer(TokenHandler())
+	defer ts.Close()
+
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keyThumbprint := JWKThumbprint(&privateKey.PublicKey)
+	proofClaims := jwt.MapClaims{"htu": ts.URL + "/"}
+
+	// [Test 1] Token is bound to proof's key
+	response := postTokenForm(t, ts.URL, url.Values{
+		"grant_type":    {PasswordGrant},
+		"client_id":     {u.ClientID},
+		"client_secret": {u.ClientSecret},
+		"username":      {u.Username},
+		"password":      {u.Password},
+	}, createDPoPProof(t, privateKey, proofClaims))
+	if response.StatusCode != 200 {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+	token := parseResult(response)
+	if token.TokenType != DPoPTokenType {
+		t.Errorf(expectedFormat.StringButFoundString, DPoPTokenType, token.TokenType)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token.AccessToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	if confirmation, _ := claims["cnf"].(map[string]interface{}); confirmation == nil || confirmation["jkt"] != keyThumbprint {
+		t.Errorf("Expected token's cnf.jkt should be %s but found %v.", keyThumbprint, claims["cnf"])
+	}
+
+	// [Test 2] Token cannot be refreshed without proof, nor with a proof of another key
+	refreshForm := url.Values{
+		"grant_type":    {RefreshTokenGrant},
+		"client_id":     {u.ClientID},
+		"client_secret": {u.ClientSecret},
+		"refresh_token": {token.RefreshToken},
+	}
+	for _, proof := range []string{"", createDPoPProof(t, otherKey, proofClaims)} {
+		if response := postTokenForm(t, ts.URL, refreshForm, proof); response.StatusCode != 400 {
+			t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+		}
+	}
+
+	// Refresh token carries its own binding, so it cannot be refreshed even without access token
+	refreshToken := Store.FindRefreshToken(token.RefreshToken)
+	if boundThumbprint := boundKeyThumbprint(refreshToken); boundThumbprint != keyThumbprint {
+		t.Errorf(expectedFormat.StringButFoundString, keyThumbprint, boundThumbprint)
+	}
+	Store.DeleteAccessToken(Store.FindAccessTokenWithCredential(refreshToken.ClientID(), refreshToken.UserID()))
+	if response := postTokenForm(t, ts.URL, refreshForm, ""); response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+
+	// [Test 3] Token is refreshed with a proof of the same key
+	response = postTokenForm(t, ts.URL, refreshForm, createDPoPProof(t, privateKey, proofClaims))
+	if response.StatusCode != 200 {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+	if token = parseResult(response); token.TokenType != DPoPTokenType {
+		t.Errorf(expectedFormat.StringButFoundString, DPoPTokenType, token.TokenType)
+	}
+}
+
+func Test_ValidateToken_DPoP(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	ts := httptest.NewServer(Handler(server.Adapt(func(c *server.RequestContext) {
+		c.OutputStatus(util.Status200())
+	}, ValidateToken())))
+	defer ts.Close()
+
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	token := Store.(DPoPTokenStore).CreateDPoPAccessToken(u.ClientID, u.UserID.Hex(), nil, JWKThumbprint(&privateKey.PublicKey), now, now.Add(Cfg.AccessTokenDuration))
+
+	digest := sha256.Sum256([]byte(token.Token()))
+	proofClaims := jwt.MapClaims{"htm": "GET", "htu": ts.URL + "/", "ath": base64.RawURLEncoding.EncodeToString(digest[:])}
+
+	callResource := func(scheme string, proof string) *http.Response {
+		request, _ := http.NewRequest("GET", ts.URL+"/", nil)
+		request.Header.Set("Authorization", fmt.Sprintf("%s %s", scheme, token.Token()))
+		if len(proof) > 0 {
+			request.Header.Set("DPoP", proof)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response
+	}
+
+	// [Test 1] Bound token is rejected as bearer token, even with a proof
+	// [Test 2] Bound token is rejected without proof
+	responses := []*http.Response{
+		callResource("Bearer", ""),
+		callResource("Bearer", createDPoPProof(t, privateKey, proofClaims)),
+		callResource(DPoPTokenType, ""),
+	}
+	for i, response := range responses {
+		if response.StatusCode != 401 {
+			t.Errorf("[%d] Expected status should be 401 but found %d.", i, response.StatusCode)
+		}
+		if challenge := response.Header.Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "DPoP ") {
+			t.Errorf("[%d] Expected DPoP challenge but found %q.", i, challenge)
+		}
+	}
+
+	// [Test 3] Bound token is accepted with a proof of its key
+	if response := callResource(DPoPTokenType, createDPoPProof(t, privateKey, proofClaims)); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+}
@@ -0,0 +1,233 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MethodPolicy describes the requirements of a gRPC method.
+type MethodPolicy struct {
+	Public bool     // Method can be called without token
+	Roles  []string // User must have at least one of roles, empty means all roles
+	Scopes []string // Access token must be granted all scopes
+}
+
+// MethodPolicies maps gRPC's full method names, e.g. "/pkg.Service/Method", to their policies. A
+// service's policy, e.g. "/pkg.Service/*", applies to methods that are not in the map. Methods
+// that do not have any policy require a valid token.
+type MethodPolicies map[string]MethodPolicy
+
+// UnaryServerInterceptor returns a gRPC unary interceptor that validates bearer token with the
+// default server, see OAuthServer.UnaryServerInterceptor.
+//
+// @param
+// - policies {MethodPolicies} (methods' policies)
+//
+// @return
+// - interceptor {grpc.UnaryServerInterceptor} (a gRPC unary interceptor)
+func UnaryServerInterceptor(policies MethodPolicies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return defaultServer().UnaryServerInterceptor(policies)(ctx, req, info, handler)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream interceptor that validates bearer token with the
+// default server, see OAuthServer.StreamServerInterceptor.
+//
+// @param
+// - policies {MethodPolicies} (methods' policies)
+//
+// @return
+// - interceptor {grpc.StreamServerInterceptor} (a gRPC stream interceptor)
+func StreamServerInterceptor(policies MethodPolicies) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return defaultServer().StreamServerInterceptor(policies)(srv, stream, info, handler)
+	}
+}
+
+// UnaryServerInterceptor returns a gRPC unary interceptor. Bearer token is read from
+// "authorization" metadata and validated like ValidateToken, then method's roles and scopes are
+// enforced. Oauth context is available to handler with FromContext.
+//
+// @param
+// - policies {MethodPolicies} (methods' policies)
+//
+// @return
+// - interceptor {grpc.UnaryServerInterceptor} (a gRPC unary interceptor)
+func (s *OAuthServer) UnaryServerInterceptor(policies MethodPolicies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.authorizeCall(ctx, info.FullMethod, policies)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream interceptor, see UnaryServerInterceptor.
+//
+// @param
+// - policies {MethodPolicies} (methods' policies)
+//
+// @return
+// - interceptor {grpc.StreamServerInterceptor} (a gRPC stream interceptor)
+func (s *OAuthServer) StreamServerInterceptor(policies MethodPolicies) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := s.authorizeCall(stream.Context(), info.FullMethod, policies)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &oauthServerStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authorizeCall validates a gRPC call against method's policy.
+//
+// @param
+// - ctx {context.Context} (call's context)
+// - fullMethod {string} (gRPC's full method name)
+// - policies {MethodPolicies} (methods' policies)
+//
+// @return
+// - ctx {context.Context} (call's context that carries oauth context)
+// - err {error} (a gRPC status error if call is rejected)
+func (s *OAuthServer) authorizeCall(ctx context.Context, fullMethod string, policies MethodPolicies) (context.Context, error) {
+	policy := policies.find(fullMethod)
+	if policy.Public {
+		return ctx, nil
+	}
+
+	startTime := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	/* Condition validation: Validate existing of authorization metadata */
+	tokenString, isDPoP := "", false
+	if authorization := firstValue(md.Get("authorization")); bearerFinder.MatchString(authorization) {
+		tokenString = authorization[7:]
+	} else if dpopFinder.MatchString(authorization) {
+		tokenString, isDPoP = authorization[5:], true
+	}
+
+	// Realms are selected by host, gRPC methods do not have path prefix
+	authority := firstValue(md.Get(":authority"))
+	realm := s.matchRealm(authority, "")
+
+	/* Condition validation: validate token */
+	oauthContext, accessToken := validateAccessToken(realm, tokenString)
+	Metrics.ObserveValidation(time.Since(startTime))
+	if oauthContext == nil || oauthContext.User == nil {
+		Metrics.ObserveValidationFailure(tokenFailureReason(tokenString, accessToken))
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired access token.")
+	}
+
+	/* Condition validation: Bound token must be presented with its certificate */
+	if !isBoundTo(accessToken, peerCertificates(ctx)) {
+		Metrics.ObserveValidationFailure(CertificateMismatchReason)
+		return nil, status.Error(codes.Unauthenticated, "Access token is bound to another certificate.")
+	}
+
+	/* Condition validation: DPoP-bound token must be presented with a proof of its key */
+	target := dpopTarget{method: "POST", host: authority, path: fullMethod, accessToken: tokenString}
+	if errorCode := verifyDPoPBinding(realm, firstValue(md.Get("dpop")), isDPoP, accessToken, target); len(errorCode) > 0 {
+		Metrics.ObserveValidationFailure(InvalidDPoPProofReason)
+		return nil, status.Error(codes.Unauthenticated, dpopErrorDescription(errorCode))
+	}
+
+	/* Condition validation: validate roles */
+	if len(policy.Roles) > 0 && !AnyOf(policy.Roles...).Match(oauthContext.EffectiveRoles()) {
+		s.denyCall(realm, oauthContext, InsufficientRolesReason)
+		return nil, status.Error(codes.PermissionDenied, "Insufficient roles.")
+	}
+
+	/* Condition validation: validate scopes */
+	for _, scope := range policy.Scopes {
+		if !oauthContext.HasScope(scope) {
+			s.denyCall(realm, oauthContext, InsufficientScopesReason)
+			return nil, status.Error(codes.PermissionDenied, "Insufficient scopes.")
+		}
+	}
+	return NewContext(ctx, oauthContext), nil
+}
+
+// denyCall records a rejected gRPC call.
+//
+// @param
+// - realm {Realm} (call's realm)
+// - oauthContext {OAuthContext} (call's oauth context)
+// - reason {string} (a failure reason)
+func (s *OAuthServer) denyCall(realm *Realm, oauthContext *OAuthContext, reason string) {
+	Metrics.ObserveValidationFailure(reason)
+
+	event := createSecurityEvent(nil, RoleCheckDeniedEvent, oauthContext, reason)
+	event.Realm = realm.Name
+	publishEvent(event)
+}
+
+// find returns the policy of a gRPC method.
+//
+// @param
+// - fullMethod {string} (gRPC's full method name)
+//
+// @return
+// - policy {MethodPolicy} (method's policy, service's policy or an empty policy)
+func (p MethodPolicies) find(fullMethod string) MethodPolicy {
+	if policy, ok := p[fullMethod]; ok {
+		return policy
+	}
+	if index := strings.LastIndex(fullMethod, "/"); index >= 0 {
+		if policy, ok := p[fullMethod[:index+1]+"*"]; ok {
+			return policy
+		}
+	}
+	return MethodPolicy{}
+}
+
+// firstValue returns the first value of a metadata's key.
+//
+// @param
+// - values {[]string} (metadata's values)
+//
+// @return
+// - value {string} (the first value or empty string)
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// peerCertificates returns the certificate chain that client had presented over TLS.
+//
+// @param
+// - ctx {context.Context} (call's context)
+//
+// @return
+// - certificates {[]*x509.Certificate} (client's certificate chain or null)
+func peerCertificates(ctx context.Context) []*x509.Certificate {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return tlsInfo.State.PeerCertificates
+		}
+	}
+	return nil
+}
+
+// oauthServerStream is a server stream that carries oauth context in its context.
+type oauthServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns stream's context that carries oauth context.
+func (s *oauthServerStream) Context() context.Context {
+	return s.ctx
+}
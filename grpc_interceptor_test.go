@@ -0,0 +1,157 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server/expected_format"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthCheckMethod is the full method name of health service's unary method.
+const healthCheckMethod = "/grpc.health.v1.Health/Check"
+
+// startGRPCServer starts an in-process health service that is protected by interceptors.
+func startGRPCServer(t *testing.T, policies MethodPolicies) (grpc_health_v1.HealthClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(policies)),
+		grpc.StreamInterceptor(StreamServerInterceptor(policies)),
+	)
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grpc_health_v1.NewHealthClient(conn), func() {
+		conn.Close()
+		grpcServer.Stop()
+	}
+}
+
+// withToken returns a call's context that carries a bearer token.
+func withToken(token string) context.Context {
+	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", fmt.Sprintf("Bearer %s", token)))
+}
+
+func Test_MethodPolicies_Find(t *testing.T) {
+	policies := MethodPolicies{
+		healthCheckMethod:          {Roles: []string{"r_admin"}},
+		"/grpc.health.v1.Health/*": {Public: true},
+	}
+
+	if policy := policies.find(healthCheckMethod); len(policy.Roles) != 1 || policy.Public {
+		t.Errorf("Expected method's policy but found %v.", policy)
+	}
+	if policy := policies.find("/grpc.health.v1.Health/Watch"); !policy.Public {
+		t.Errorf("Expected service's policy but found %v.", policy)
+	}
+	if policy := policies.find("/pkg.Service/Method"); policy.Public || len(policy.Roles) > 0 {
+		t.Errorf("Expected empty policy but found %v.", policy)
+	}
+}
+
+func Test_UnaryServerInterceptor(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	client, stop := startGRPCServer(t, MethodPolicies{
+		healthCheckMethod: {Roles: []string{"r_admin"}},
+	})
+	defer stop()
+
+	// [Test 1] Call without token
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Errorf(expectedFormat.StringButFoundString, codes.Unauthenticated, code)
+	}
+
+	// [Test 2] Call with invalid token
+	_, err = client.Check(withToken("invalid"), &grpc_health_v1.HealthCheckRequest{})
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Errorf(expectedFormat.StringButFoundString, codes.Unauthenticated, code)
+	}
+
+	// [Test 3] Call with valid token of an user with required role
+	now := time.Now().UTC()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	if _, err = client.Check(withToken(token.Token()), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Error(err)
+	}
+
+	// [Test 4] Call with valid token of an user without required role
+	token = Store.CreateAccessToken(u.ClientID, u.MachineID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	_, err = client.Check(withToken(token.Token()), &grpc_health_v1.HealthCheckRequest{})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf(expectedFormat.StringButFoundString, codes.PermissionDenied, code)
+	}
+
+	// [Test 5] Handler receives oauth context
+	token = Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", fmt.Sprintf("Bearer %s", token.Token())))
+	info := &grpc.UnaryServerInfo{FullMethod: healthCheckMethod}
+	UnaryServerInterceptor(nil)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if s, ok := FromContext(ctx); !ok || s.User == nil || s.User.UserID() != u.UserID.Hex() {
+			t.Error("Expected handler should receive oauth context.")
+		}
+		return nil, nil
+	})
+}
+
+func Test_StreamServerInterceptor(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	client, stop := startGRPCServer(t, nil)
+	defer stop()
+
+	// [Test 1] Call without token
+	stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Errorf(expectedFormat.StringButFoundString, codes.Unauthenticated, code)
+	}
+
+	// [Test 2] Call with valid token
+	now := time.Now().UTC()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	stream, err = client.Watch(withToken(token.Token()), &grpc_health_v1.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_UnaryServerInterceptor_PublicMethod(t *testing.T) {
+	client, stop := startGRPCServer(t, MethodPolicies{
+		healthCheckMethod: {Public: true},
+	})
+	defer stop()
+
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,185 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+)
+
+// contextKey describes a key of standard context's values that belong to this package.
+type contextKey int
+
+// Key of oauth context in standard context.
+const oauthContextKey contextKey = 0
+
+// NewContext returns a copy of a standard context that carries an oauth context.
+//
+// @param
+// - ctx {context.Context} (a standard context)
+// - s {OAuthContext} (an oauth context)
+//
+// @return
+// - ctx {context.Context} (a standard context that carries oauth context)
+func NewContext(ctx context.Context, s *OAuthContext) context.Context {
+	return context.WithValue(ctx, oauthContextKey, s)
+}
+
+// FromContext returns the oauth context that had been stored by an HTTP middleware.
+//
+// @param
+// - ctx {context.Context} (a standard context)
+//
+// @return
+// - s {OAuthContext} (an oauth context)
+// - ok {bool} (false if context does not carry an oauth context)
+func FromContext(ctx context.Context) (*OAuthContext, bool) {
+	s, ok := ctx.Value(oauthContextKey).(*OAuthContext)
+	return s, ok && s != nil
+}
+
+// Middleware converts an adapter to a net/http middleware, e.g. Middleware(ValidatePolicy(...)).
+// Oauth context is passed between middlewares and to next handler with standard context. If
+// adapter rejects request, next handler is not called.
+//
+// @param
+// - adapter {server.Adapter} (a wrapper func around server.HandleContextFunc)
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func Middleware(adapter server.Adapter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ctx context.Context
+			func() {
+				defer server.Recovery(w, r)
+
+				c := createContext(w, r)
+				if s, ok := FromContext(r.Context()); ok {
+					c.SetExtra(oauthKey.Context, s)
+					if s.Realm != nil {
+						c.SetExtra(oauthKey.Realm, s.Realm)
+					}
+				}
+
+				adapter(func(c *server.RequestContext) {
+					ctx = r.Context()
+					if s, ok := c.GetExtra(oauthKey.Context).(*OAuthContext); ok {
+						ctx = NewContext(ctx, s)
+					}
+				})(c)
+			}()
+
+			/* Condition validation: Validate if adapter accepted request */
+			if ctx == nil {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Handler converts a HandleContextFunc to a net/http handler.
+//
+// @param
+// - f {server.HandleContextFunc} (the callback func)
+//
+// @return
+// - handler {http.Handler} (a net/http handler)
+func Handler(f server.HandleContextFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer server.Recovery(w, r)
+
+		c := createContext(w, r)
+		f(c)
+	})
+}
+
+// createContext creates a request context, connection's remote address and client's TLS
+// certificates are kept in context.
+//
+// @param
+// - w {http.ResponseWriter} (a response writer)
+// - r {http.Request} (a request)
+//
+// @return
+// - c {server.RequestContext} (a request context)
+func createContext(w http.ResponseWriter, r *http.Request) *server.RequestContext {
+	c := server.CreateContext(w, r)
+	c.SetExtra(oauthKey.RemoteAddr, r.RemoteAddr)
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		c.SetExtra(oauthKey.ClientCertificates, r.TLS.PeerCertificates)
+	}
+	return c
+}
+
+// TokenMiddleware is the net/http equivalent of ValidateToken.
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func TokenMiddleware() func(http.Handler) http.Handler {
+	return Middleware(ValidateToken())
+}
+
+// RolesMiddleware is the net/http equivalent of ValidateRoles, it must be used after
+// TokenMiddleware.
+//
+// @param
+// - roles {[]string} (a list of acceptable users' roles)
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func RolesMiddleware(roles ...string) func(http.Handler) http.Handler {
+	return Middleware(ValidateRoles(roles...))
+}
+
+// ScopesMiddleware is the net/http equivalent of ValidateScopes, it must be used after
+// TokenMiddleware.
+//
+// @param
+// - scopes {[]string} (a list of required scopes)
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func ScopesMiddleware(scopes ...string) func(http.Handler) http.Handler {
+	return Middleware(ValidateScopes(scopes...))
+}
+
+// PermissionsMiddleware is the net/http equivalent of ValidatePermissions, it must be used after
+// TokenMiddleware.
+//
+// @param
+// - permissions {[]string} (a list of required permissions)
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func PermissionsMiddleware(permissions ...string) func(http.Handler) http.Handler {
+	return Middleware(ValidatePermissions(permissions...))
+}
+
+// TokenHandler returns the token endpoint as a net/http handler, e.g.
+// mux.Handle("/token", oauth2.TokenHandler()).
+//
+// @return
+// - handler {http.Handler} (a net/http handler)
+func TokenHandler() http.Handler {
+	return Handler(new(TokenGrant).HandleForm)
+}
+
+// TokenMiddleware is the net/http equivalent of ValidateToken that validates token against
+// server's realms.
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func (s *OAuthServer) TokenMiddleware() func(http.Handler) http.Handler {
+	return Middleware(s.ValidateToken())
+}
+
+// TokenHandler returns server's token endpoint as a net/http handler.
+//
+// @return
+// - handler {http.Handler} (a net/http handler)
+func (s *OAuthServer) TokenHandler() http.Handler {
+	return Handler(s.HandleToken)
+}
@@ -0,0 +1,98 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// authenticate returns a middleware that authenticates every request as an user with roles.
+func authenticate(roles ...string) func(http.Handler) http.Handler {
+	return Middleware(func(f server.HandleContextFunc) server.HandleContextFunc {
+		return func(c *server.RequestContext) {
+			c.SetExtra(oauthKey.Context, &OAuthContext{User: &MongoDBUser{ID: bson.NewObjectId(), Roles: roles}})
+			f(c)
+		}
+	})
+}
+
+func Test_FromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("Expected empty context should not carry oauth context.")
+	}
+
+	oauthContext := &OAuthContext{GrantType: PasswordGrant}
+	if s, ok := FromContext(NewContext(context.Background(), oauthContext)); !ok || s != oauthContext {
+		t.Errorf("Expected context should carry %v but found %v.", oauthContext, s)
+	}
+}
+
+func Test_Middleware_ValidRoles(t *testing.T) {
+	isCalled := false
+	handler := authenticate("r_admin")(RolesMiddleware("r_admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isCalled = true
+		if s, ok := FromContext(r.Context()); !ok || s.User == nil {
+			t.Error("Expected next handler should receive oauth context.")
+		}
+	})))
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+	if !isCalled {
+		t.Error("Expected next handler should be called.")
+	}
+}
+
+func Test_Middleware_InvalidRoles(t *testing.T) {
+	handler := authenticate("r_user")(RolesMiddleware("r_admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected next handler should not be called.")
+	})))
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+}
+
+func Test_TokenMiddleware_InvalidBasicAuth(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	handler := TokenMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected next handler should not be called.")
+	}))
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	request, _ := http.NewRequest("GET", ts.URL, nil)
+	request.SetBasicAuth(u.ClientID, "InvalidSecret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+}
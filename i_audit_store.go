@@ -0,0 +1,26 @@
+package oauth2
+
+// AuditStore describes an append-only, hash-chained audit log's storage.
+type AuditStore interface {
+
+	// AppendRecord appends a security event to the end of audit chain.
+	//
+	// @param
+	// - event {SecurityEvent} (a security event)
+	//
+	// @return
+	// - record {AuditRecord} (an audit record or null)
+	AppendRecord(event SecurityEvent) *AuditRecord
+
+	// FindRecords returns a page of audit records in chain's order.
+	//
+	// @param
+	// - filter {AuditFilter} (records' filter, null will return all records)
+	// - offset {int} (number of records to skip)
+	// - limit {int} (maximum number of records to return)
+	//
+	// @return
+	// - records {[]AuditRecord} (a list of audit records)
+	// - total {int} (total number of matched audit records)
+	FindRecords(filter *AuditFilter, offset int, limit int) ([]*AuditRecord, int)
+}
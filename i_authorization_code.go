@@ -0,0 +1,26 @@
+package oauth2
+
+import "time"
+
+// AuthorizationCode describes an authorization code that had been issued to a client on behalf
+// of user. The code itself is only shown once, only its hash should be persisted.
+type AuthorizationCode interface {
+
+	// Return client's ID.
+	ClientID() string
+
+	// Return user's ID.
+	UserID() string
+
+	// Return the redirect URI that code had been issued with.
+	RedirectURI() string
+
+	// Return the scopes that user had consented to.
+	CodeScopes() []string
+
+	// Check if code is expired or not.
+	IsExpired() bool
+
+	// Return code's expired time.
+	ExpiredTime() time.Time
+}
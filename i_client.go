@@ -6,7 +6,7 @@ type Client interface {
 	// Return client's ID.
 	ClientID() string
 
-	// Return client's secret.
+	// Return client's hashed secret.
 	ClientSecret() string
 
 	// Return client's allowed grant types.
@@ -15,3 +15,55 @@ type Client interface {
 	// Return client's registered redirect URIs.
 	RedirectURIs() []string
 }
+
+// RateLimitedClient describes a client that overrides configured rate limits.
+type RateLimitedClient interface {
+
+	// Return client's rate limit for an endpoint or null to use configured rate limit.
+	ClientRateLimit(endpoint string) *RateLimit
+}
+
+// ScopedClient describes a client that is restricted to a set of scopes. Tokens that are issued
+// to client carry client's allowed scopes.
+type ScopedClient interface {
+
+	// Return client's allowed scopes.
+	ClientScopes() []string
+}
+
+// FirstPartyClient describes a client that is operated by authorization server's owner. Users
+// are not asked for consent when they authorize first-party clients.
+type FirstPartyClient interface {
+
+	// Check if client is a first-party client.
+	IsFirstParty() bool
+}
+
+// NamedClient describes a client that has a human readable name.
+type NamedClient interface {
+
+	// Return client's name.
+	ClientName() string
+}
+
+// TLSClient describes a client that authenticates at token endpoint with a TLS client certificate
+// (RFC 8705).
+type TLSClient interface {
+
+	// Return client's token endpoint authentication method.
+	TokenEndpointAuthMethod() string
+
+	// Return the subject DN that client's certificate must have, for tls_client_auth.
+	TLSClientAuthSubjectDN() string
+
+	// Return SHA-256 thumbprints of client's certificates, for self_signed_tls_client_auth.
+	TLSClientCertificates() []string
+}
+
+// RoleRestrictedClient describes a client that may only carry a subset of user's roles. Client
+// that does not implement this interface or returns null is not restricted.
+type RoleRestrictedClient interface {
+
+	// Return client's allowed roles or null.
+	ClientRoles() []string
+}
@@ -0,0 +1,22 @@
+package oauth2
+
+import "time"
+
+// ClientSecret describes a client's secret metadata. The secret itself is never exposed.
+type ClientSecret interface {
+
+	// Return secret's ID.
+	SecretID() string
+
+	// Return secret's label.
+	Label() string
+
+	// Check if secret is expired or not.
+	IsExpired() bool
+
+	// Return secret's created time.
+	CreatedTime() time.Time
+
+	// Return secret's expired time, zero time means the secret never expires.
+	ExpiredTime() time.Time
+}
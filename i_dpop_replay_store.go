@@ -0,0 +1,19 @@
+package oauth2
+
+import "time"
+
+// DPoPReplayStore describes a storage of DPoP proofs that had been used (RFC 9449), so a proof
+// cannot be replayed.
+type DPoPReplayStore interface {
+
+	// SaveProof records a proof until it is expired.
+	//
+	// @param
+	// - key {string} (proof's key, e.g. key's thumbprint and proof's jti)
+	// - now {time.Time} (current time according to realm's clock)
+	// - expiredTime {time.Time} (the time after which proof will not be accepted anyway)
+	//
+	// @return
+	// - isSaved {bool} (false if proof had been used)
+	SaveProof(key string, now time.Time, expiredTime time.Time) bool
+}
@@ -0,0 +1,12 @@
+package oauth2
+
+// EventSubscriber describes a security event's subscriber, e.g. a SIEM forwarder.
+type EventSubscriber interface {
+
+	// HandleEvent handles a security event. This func is called synchronously within request's
+	// goroutine, long running work should be dispatched to another goroutine.
+	//
+	// @param
+	// - event {SecurityEvent} (a security event)
+	HandleEvent(event SecurityEvent)
+}
@@ -0,0 +1,33 @@
+package oauth2
+
+import "time"
+
+// LoginAttemptStore describes a failed login attempts' counter storage.
+type LoginAttemptStore interface {
+
+	// FindFailures returns the number of consecutive failed attempts and the last failed time.
+	//
+	// @param
+	// - key {string} (a counter's key, e.g. username or client's IP)
+	//
+	// @return
+	// - count {int} (number of consecutive failed attempts)
+	// - lastFailedTime {time.Time} (the last failed attempt's time)
+	FindFailures(key string) (count int, lastFailedTime time.Time)
+
+	// SaveFailure increases failed attempts' counter.
+	//
+	// @param
+	// - key {string} (a counter's key, e.g. username or client's IP)
+	// - failedTime {time.Time} (failed attempt's time)
+	//
+	// @return
+	// - count {int} (number of consecutive failed attempts, including this one)
+	SaveFailure(key string, failedTime time.Time) int
+
+	// DeleteFailures resets failed attempts' counter.
+	//
+	// @param
+	// - key {string} (a counter's key, e.g. username or client's IP)
+	DeleteFailures(key string)
+}
@@ -0,0 +1,15 @@
+package oauth2
+
+// PasswordHasher describes a password hashing algorithm's characteristic. Hashed passwords must
+// be self-describing, so that the algorithm and its parameters can be detected from the hash.
+type PasswordHasher interface {
+
+	// Hash returns hashed password.
+	Hash(password string) (string, error)
+
+	// Compare compares a hashed password with a plain text password.
+	Compare(hashedPassword string, password string) bool
+
+	// NeedsRehash checks if a hashed password is outdated or weaker than current policy.
+	NeedsRehash(hashedPassword string) bool
+}
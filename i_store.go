@@ -135,3 +135,383 @@ type TokenStore interface {
 	//	FindAuthorizationCode(authorizationCode string)
 	//	SaveAuthorizationCode(authorizationCode string, clientID string, expires time.Time)
 }
+
+// ClientSecretStore describes a store that allows a client to hold multiple active secrets.
+type ClientSecretStore interface {
+
+	// AddClientSecret generates a new secret for a client.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - label {string} (a human readable label for this secret)
+	// - expiredTime {time.Time} (secret's expired time, zero time means never expires)
+	//
+	// @return
+	// - secret {string} (client's secret in plain text, it will not be available again)
+	// - record {ClientSecret} (secret's metadata or null)
+	AddClientSecret(clientID string, label string, expiredTime time.Time) (secret string, record ClientSecret)
+
+	// FindClientSecrets returns all secrets' metadata of a client.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	//
+	// @return
+	// - secrets {[]ClientSecret} (a list of secrets' metadata)
+	FindClientSecrets(clientID string) []ClientSecret
+
+	// RetireClientSecret expires a client's secret immediately.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - secretID {string} (secret's ID)
+	//
+	// @return
+	// - isRetired {bool} (true if the secret had been retired)
+	RetireClientSecret(clientID string, secretID string) bool
+}
+
+// ClientRegistrationStore describes a store that allows clients to be registered dynamically.
+type ClientRegistrationStore interface {
+
+	// CreateClient creates a new client's instance.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - clientSecret {string} (client's client_secret in plain text)
+	// - registrationToken {string} (registration access token in plain text)
+	// - metadata {ClientMetadata} (client's registered metadata)
+	//
+	// @return
+	// - client {Client} (a client entity or null)
+	CreateClient(clientID string, clientSecret string, registrationToken string, metadata *ClientMetadata) Client
+
+	// FindClientWithRegistrationToken returns a client entity according to clientID and
+	// registration access token or null.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - registrationToken {string} (registration access token in plain text)
+	//
+	// @return
+	// - client {Client} (a client entity or null)
+	FindClientWithRegistrationToken(clientID string, registrationToken string) Client
+
+	// FindClientMetadata returns client's registered metadata or null.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	//
+	// @return
+	// - metadata {ClientMetadata} (client's registered metadata or null)
+	FindClientMetadata(clientID string) *ClientMetadata
+
+	// UpdateClientMetadata replaces client's registered metadata.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - metadata {ClientMetadata} (client's new metadata)
+	//
+	// @return
+	// - isUpdated {bool} (true if client had been updated)
+	UpdateClientMetadata(clientID string, metadata *ClientMetadata) bool
+
+	// DeleteClient deletes a client and all of its tokens from database.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	//
+	// @return
+	// - isDeleted {bool} (true if client had been deleted)
+	DeleteClient(clientID string) bool
+}
+
+// AdminStore describes a store that allows users, clients and tokens to be managed.
+type AdminStore interface {
+
+	// FindUsers returns a page of user entities.
+	//
+	// @param
+	// - offset {int} (number of records to skip)
+	// - limit {int} (maximum number of records to return)
+	//
+	// @return
+	// - users {[]User} (a list of user entities)
+	// - total {int} (total number of user entities)
+	FindUsers(offset int, limit int) (users []User, total int)
+
+	// CreateUser creates a new human user entity.
+	//
+	// @param
+	// - username {string} (user's username, must be unique)
+	// - password {string} (user's password in plain text)
+	// - roles {[]string} (user's roles)
+	//
+	// @return
+	// - user {User} (an user entity or null)
+	CreateUser(username string, password string, roles []string) User
+
+	// UpdateUserRoles replaces user's roles.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	// - roles {[]string} (user's new roles)
+	//
+	// @return
+	// - isUpdated {bool} (true if user had been updated)
+	UpdateUserRoles(userID string, roles []string) bool
+
+	// UpdateUserPassword replaces user's password and revokes user's login sessions, access
+	// tokens and refresh tokens, except the given access token and its client's refresh token.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	// - password {string} (user's new password in plain text)
+	// - token {Token} (an access token's instance that should be kept or null)
+	//
+	// @return
+	// - isUpdated {bool} (true if user had been updated)
+	UpdateUserPassword(userID string, password string, token Token) bool
+
+	// DeleteUser deletes an user and all of its tokens from database.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	//
+	// @return
+	// - isDeleted {bool} (true if user had been deleted)
+	DeleteUser(userID string) bool
+
+	// FindClients returns a page of client entities.
+	//
+	// @param
+	// - offset {int} (number of records to skip)
+	// - limit {int} (maximum number of records to return)
+	//
+	// @return
+	// - clients {[]Client} (a list of client entities)
+	// - total {int} (total number of client entities)
+	FindClients(offset int, limit int) (clients []Client, total int)
+
+	// UpdateClientRestrictions replaces client's allowed roles and scopes. Null roles means client
+	// is not restricted. All client's tokens are deleted, so new restrictions take effect.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - roles {[]string} (client's allowed roles or null)
+	// - scopes {[]string} (client's allowed scopes)
+	//
+	// @return
+	// - isUpdated {bool} (true if client had been updated)
+	UpdateClientRestrictions(clientID string, roles []string, scopes []string) bool
+
+	// UpdateClientFirstParty marks or unmarks client as a first-party client.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - isFirstParty {bool} (true if client is operated by authorization server's owner)
+	//
+	// @return
+	// - isUpdated {bool} (true if client had been updated)
+	UpdateClientFirstParty(clientID string, isFirstParty bool) bool
+
+	// FindAccessTokens returns a page of unexpired access token entities. Empty userID or
+	// clientID will not be used as filter.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	// - clientID {string} (client's client_id)
+	// - offset {int} (number of records to skip)
+	// - limit {int} (maximum number of records to return)
+	//
+	// @return
+	// - tokens {[]Token} (a list of access token entities)
+	// - total {int} (total number of matched access token entities)
+	FindAccessTokens(userID string, clientID string, offset int, limit int) (tokens []Token, total int)
+
+	// DeleteAccessTokenWithID deletes an access token from database.
+	//
+	// @param
+	// - tokenID {string} (access token's ID)
+	//
+	// @return
+	// - isDeleted {bool} (true if access token had been deleted)
+	DeleteAccessTokenWithID(tokenID string) bool
+
+	// DeleteUserTokens deletes all access tokens and refresh tokens of an user and revokes user's
+	// login sessions.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	DeleteUserTokens(userID string)
+
+	// DeleteUserTokensExcept deletes all access tokens and refresh tokens of an user, except the
+	// given access token and the refresh token that belongs to the same client. User's login
+	// sessions are revoked as well.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	// - token {Token} (an access token's instance that should be kept)
+	DeleteUserTokensExcept(userID string, token Token)
+}
+
+// ActiveTokenCounter describes a store that can count unexpired access tokens.
+type ActiveTokenCounter interface {
+
+	// CountActiveTokens returns the number of unexpired access tokens.
+	//
+	// @return
+	// - count {int} (number of unexpired access tokens)
+	CountActiveTokens() int
+}
+
+// RoleStore describes a store that keeps role definitions.
+type RoleStore interface {
+
+	// FindRoleDefinitions returns all role definitions.
+	//
+	// @return
+	// - definitions {[]RoleDefinition} (a list of role definitions)
+	FindRoleDefinitions() []RoleDefinition
+}
+
+// TLSClientStore describes a store that allows clients to authenticate with TLS certificates and
+// issues certificate-bound access tokens (RFC 8705).
+type TLSClientStore interface {
+
+	// FindUserWithClientID returns the machine user of a client that had authenticated without
+	// client_secret.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	//
+	// @return
+	// - user {User} (a machine user entity or null)
+	FindUserWithClientID(clientID string) User
+
+	// CreateBoundAccessToken creates an access token's instance that is bound to a certificate.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - userID {string} (userID that associated with user's entity)
+	// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+	// - thumbprint {string} (SHA-256 thumbprint of client's certificate)
+	// - createdTime {time.Time} (token's issued time)
+	// - expiredTime {time.Time} (token's expired time)
+	//
+	// @return
+	// - token {Token} (a token's instance)
+	CreateBoundAccessToken(clientID string, userID string, scopes []string, thumbprint string, createdTime time.Time, expiredTime time.Time) Token
+}
+
+// DPoPTokenStore describes a store that issues access tokens and refresh tokens that are bound
+// to a DPoP key (RFC 9449).
+type DPoPTokenStore interface {
+
+	// CreateDPoPAccessToken creates an access token's instance that is bound to a DPoP key.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - userID {string} (userID that associated with user's entity)
+	// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+	// - keyThumbprint {string} (JWK SHA-256 thumbprint of client's DPoP key)
+	// - createdTime {time.Time} (token's issued time)
+	// - expiredTime {time.Time} (token's expired time)
+	//
+	// @return
+	// - token {Token} (a token's instance)
+	CreateDPoPAccessToken(clientID string, userID string, scopes []string, keyThumbprint string, createdTime time.Time, expiredTime time.Time) Token
+
+	// CreateDPoPRefreshToken creates a refresh token's instance that is bound to a DPoP key.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - userID {string} (userID that associated with user's entity)
+	// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+	// - keyThumbprint {string} (JWK SHA-256 thumbprint of client's DPoP key)
+	// - createdTime {time.Time} (token's issued time)
+	// - expiredTime {time.Time} (token's expired time)
+	//
+	// @return
+	// - token {Token} (a token's instance)
+	CreateDPoPRefreshToken(clientID string, userID string, scopes []string, keyThumbprint string, createdTime time.Time, expiredTime time.Time) Token
+}
+
+// ScopedTokenStore describes a store that can issue tokens with a narrower set of scopes than
+// client's allowed scopes.
+type ScopedTokenStore interface {
+
+	// CreateScopedAccessToken creates an access token's instance that carries scopes.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - userID {string} (userID that associated with user's entity)
+	// - scopes {[]string} (token's scopes)
+	// - createdTime {time.Time} (token's issued time)
+	// - expiredTime {time.Time} (token's expired time)
+	//
+	// @return
+	// - token {Token} (a token's instance)
+	CreateScopedAccessToken(clientID string, userID string, scopes []string, createdTime time.Time, expiredTime time.Time) Token
+
+	// CreateScopedRefreshToken creates a refresh token's instance that carries scopes.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - userID {string} (userID that associated with user's entity)
+	// - scopes {[]string} (token's scopes)
+	// - createdTime {time.Time} (token's issued time)
+	// - expiredTime {time.Time} (token's expired time)
+	//
+	// @return
+	// - token {Token} (a token's instance)
+	CreateScopedRefreshToken(clientID string, userID string, scopes []string, createdTime time.Time, expiredTime time.Time) Token
+}
+
+// AuthorizationStore describes a store that keeps authorization codes and users' consents.
+type AuthorizationStore interface {
+
+	// CreateAuthorizationCode creates a single use authorization code.
+	//
+	// @param
+	// - clientID {string} (client's client_id)
+	// - userID {string} (userID that associated with user's entity)
+	// - redirectURI {string} (the redirect URI that code is issued with)
+	// - scopes {[]string} (the scopes that user had consented to)
+	// - expiredTime {time.Time} (code's expired time)
+	//
+	// @return
+	// - code {string} (authorization code in plain text or empty string)
+	CreateAuthorizationCode(clientID string, userID string, redirectURI string, scopes []string, expiredTime time.Time) string
+
+	// ConsumeAuthorizationCode returns an authorization code entity and deletes it, so a code can
+	// only be exchanged once.
+	//
+	// @param
+	// - code {string} (authorization code in plain text)
+	//
+	// @return
+	// - code {AuthorizationCode} (an authorization code entity or null)
+	ConsumeAuthorizationCode(code string) AuthorizationCode
+
+	// FindConsent returns the scopes that user had consented to client or null.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	// - clientID {string} (client's client_id)
+	//
+	// @return
+	// - scopes {[]string} (a list of consented scopes or null if user had never consented)
+	FindConsent(userID string, clientID string) []string
+
+	// SaveConsent adds scopes to user's consent to client.
+	//
+	// @param
+	// - userID {string} (userID that associated with user's entity)
+	// - clientID {string} (client's client_id)
+	// - scopes {[]string} (the scopes that user had consented to)
+	//
+	// @return
+	// - isSaved {bool} (true if consent had been saved)
+	SaveConsent(userID string, clientID string, scopes []string) bool
+}
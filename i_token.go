@@ -23,3 +23,48 @@ type Token interface {
 	// Return token's expired time.
 	ExpiredTime() time.Time
 }
+
+// IdentifiedToken describes a token that can be addressed by its ID, e.g. to be revoked by an
+// administrator.
+type IdentifiedToken interface {
+
+	// Return token's ID.
+	TokenID() string
+}
+
+// ScopedToken describes a token that had been granted with scopes.
+type ScopedToken interface {
+
+	// Return token's scopes.
+	TokenScopes() []string
+}
+
+// BoundToken describes a token that is bound to a client's TLS certificate (RFC 8705).
+type BoundToken interface {
+
+	// Return SHA-256 thumbprint of the certificate that token is bound to, empty if token is not
+	// bound.
+	CertificateThumbprint() string
+}
+
+// DPoPBoundToken describes a token that is bound to a client's DPoP key (RFC 9449).
+type DPoPBoundToken interface {
+
+	// Return JWK SHA-256 thumbprint of the key that token is bound to, empty if token is not
+	// bound.
+	KeyThumbprint() string
+}
+
+// tokenID returns token's ID or empty if token cannot be addressed by its ID.
+//
+// @param
+// - token {Token} (a token's instance)
+//
+// @return
+// - tokenID {string} (token's ID)
+func tokenID(token Token) string {
+	if identifiedToken, ok := token.(IdentifiedToken); ok {
+		return identifiedToken.TokenID()
+	}
+	return ""
+}
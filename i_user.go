@@ -15,3 +15,11 @@ type User interface {
 	// Return user's roles.
 	UserRoles() []string
 }
+
+// SessionVersionedUser describes an user whose login sessions can be revoked. Login sessions that
+// were created with an older version are rejected.
+type SessionVersionedUser interface {
+
+	// Return user's login session version.
+	SessionVersion() int
+}
@@ -0,0 +1,312 @@
+package oauth2
+
+import "time"
+
+// InstrumentedStore describes a token store's wrapper that times every store call. Optional
+// store capabilities are forwarded to the wrapped store, use unwrapStore to check them.
+type InstrumentedStore struct {
+	store   TokenStore
+	metrics *MetricsRegistry
+}
+
+// CreateInstrumentedStore returns a token store that reports every call's latency.
+//
+// @param
+// - tokenStore {TokenStore} (a token store that should be timed)
+// - metrics {MetricsRegistry} (a metrics registry's instance)
+//
+// @return
+// - tokenStore {TokenStore} (an instrumented token store's instance)
+func CreateInstrumentedStore(tokenStore TokenStore, metrics *MetricsRegistry) TokenStore {
+	return &InstrumentedStore{
+		store:   tokenStore,
+		metrics: metrics,
+	}
+}
+
+// Unwrap returns the wrapped token store.
+func (d *InstrumentedStore) Unwrap() TokenStore {
+	return d.store
+}
+
+// FindUserWithID is a timed wrapper for TokenStore.FindUserWithID.
+func (d *InstrumentedStore) FindUserWithID(userID string) User {
+	defer d.observe("FindUserWithID", time.Now())
+	return d.store.FindUserWithID(userID)
+}
+
+// FindUserWithClient is a timed wrapper for TokenStore.FindUserWithClient.
+func (d *InstrumentedStore) FindUserWithClient(clientID string, clientSecret string) User {
+	defer d.observe("FindUserWithClient", time.Now())
+	return d.store.FindUserWithClient(clientID, clientSecret)
+}
+
+// FindUserWithCredential is a timed wrapper for TokenStore.FindUserWithCredential.
+func (d *InstrumentedStore) FindUserWithCredential(username string, password string) User {
+	defer d.observe("FindUserWithCredential", time.Now())
+	return d.store.FindUserWithCredential(username, password)
+}
+
+// FindClientWithID is a timed wrapper for TokenStore.FindClientWithID.
+func (d *InstrumentedStore) FindClientWithID(clientID string) Client {
+	defer d.observe("FindClientWithID", time.Now())
+	return d.store.FindClientWithID(clientID)
+}
+
+// FindClientWithCredential is a timed wrapper for TokenStore.FindClientWithCredential.
+func (d *InstrumentedStore) FindClientWithCredential(clientID string, clientSecret string) Client {
+	defer d.observe("FindClientWithCredential", time.Now())
+	return d.store.FindClientWithCredential(clientID, clientSecret)
+}
+
+// FindAccessToken is a timed wrapper for TokenStore.FindAccessToken.
+func (d *InstrumentedStore) FindAccessToken(token string) Token {
+	defer d.observe("FindAccessToken", time.Now())
+	return d.store.FindAccessToken(token)
+}
+
+// FindAccessTokenWithCredential is a timed wrapper for TokenStore.FindAccessTokenWithCredential.
+func (d *InstrumentedStore) FindAccessTokenWithCredential(clientID string, userID string) Token {
+	defer d.observe("FindAccessTokenWithCredential", time.Now())
+	return d.store.FindAccessTokenWithCredential(clientID, userID)
+}
+
+// CreateAccessToken is a timed wrapper for TokenStore.CreateAccessToken.
+func (d *InstrumentedStore) CreateAccessToken(clientID string, userID string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateAccessToken", time.Now())
+	return d.store.CreateAccessToken(clientID, userID, createdTime, expiredTime)
+}
+
+// DeleteAccessToken is a timed wrapper for TokenStore.DeleteAccessToken.
+func (d *InstrumentedStore) DeleteAccessToken(token Token) {
+	defer d.observe("DeleteAccessToken", time.Now())
+	d.store.DeleteAccessToken(token)
+}
+
+// FindRefreshToken is a timed wrapper for TokenStore.FindRefreshToken.
+func (d *InstrumentedStore) FindRefreshToken(token string) Token {
+	defer d.observe("FindRefreshToken", time.Now())
+	return d.store.FindRefreshToken(token)
+}
+
+// FindRefreshTokenWithCredential is a timed wrapper for TokenStore.FindRefreshTokenWithCredential.
+func (d *InstrumentedStore) FindRefreshTokenWithCredential(clientID string, userID string) Token {
+	defer d.observe("FindRefreshTokenWithCredential", time.Now())
+	return d.store.FindRefreshTokenWithCredential(clientID, userID)
+}
+
+// CreateRefreshToken is a timed wrapper for TokenStore.CreateRefreshToken.
+func (d *InstrumentedStore) CreateRefreshToken(clientID string, userID string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateRefreshToken", time.Now())
+	return d.store.CreateRefreshToken(clientID, userID, createdTime, expiredTime)
+}
+
+// DeleteRefreshToken is a timed wrapper for TokenStore.DeleteRefreshToken.
+func (d *InstrumentedStore) DeleteRefreshToken(token Token) {
+	defer d.observe("DeleteRefreshToken", time.Now())
+	d.store.DeleteRefreshToken(token)
+}
+
+// CreateScopedAccessToken is a timed wrapper for ScopedTokenStore.CreateScopedAccessToken.
+func (d *InstrumentedStore) CreateScopedAccessToken(clientID string, userID string, scopes []string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateScopedAccessToken", time.Now())
+	return d.store.(ScopedTokenStore).CreateScopedAccessToken(clientID, userID, scopes, createdTime, expiredTime)
+}
+
+// CreateScopedRefreshToken is a timed wrapper for ScopedTokenStore.CreateScopedRefreshToken.
+func (d *InstrumentedStore) CreateScopedRefreshToken(clientID string, userID string, scopes []string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateScopedRefreshToken", time.Now())
+	return d.store.(ScopedTokenStore).CreateScopedRefreshToken(clientID, userID, scopes, createdTime, expiredTime)
+}
+
+// FindUserWithClientID is a timed wrapper for TLSClientStore.FindUserWithClientID.
+func (d *InstrumentedStore) FindUserWithClientID(clientID string) User {
+	defer d.observe("FindUserWithClientID", time.Now())
+	return d.store.(TLSClientStore).FindUserWithClientID(clientID)
+}
+
+// CreateBoundAccessToken is a timed wrapper for TLSClientStore.CreateBoundAccessToken.
+func (d *InstrumentedStore) CreateBoundAccessToken(clientID string, userID string, scopes []string, thumbprint string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateBoundAccessToken", time.Now())
+	return d.store.(TLSClientStore).CreateBoundAccessToken(clientID, userID, scopes, thumbprint, createdTime, expiredTime)
+}
+
+// CreateDPoPAccessToken is a timed wrapper for DPoPTokenStore.CreateDPoPAccessToken.
+func (d *InstrumentedStore) CreateDPoPAccessToken(clientID string, userID string, scopes []string, keyThumbprint string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateDPoPAccessToken", time.Now())
+	return d.store.(DPoPTokenStore).CreateDPoPAccessToken(clientID, userID, scopes, keyThumbprint, createdTime, expiredTime)
+}
+
+// CreateDPoPRefreshToken is a timed wrapper for DPoPTokenStore.CreateDPoPRefreshToken.
+func (d *InstrumentedStore) CreateDPoPRefreshToken(clientID string, userID string, scopes []string, keyThumbprint string, createdTime time.Time, expiredTime time.Time) Token {
+	defer d.observe("CreateDPoPRefreshToken", time.Now())
+	return d.store.(DPoPTokenStore).CreateDPoPRefreshToken(clientID, userID, scopes, keyThumbprint, createdTime, expiredTime)
+}
+
+// AddClientSecret is a timed wrapper for ClientSecretStore.AddClientSecret.
+func (d *InstrumentedStore) AddClientSecret(clientID string, label string, expiredTime time.Time) (string, ClientSecret) {
+	defer d.observe("AddClientSecret", time.Now())
+	return d.store.(ClientSecretStore).AddClientSecret(clientID, label, expiredTime)
+}
+
+// FindClientSecrets is a timed wrapper for ClientSecretStore.FindClientSecrets.
+func (d *InstrumentedStore) FindClientSecrets(clientID string) []ClientSecret {
+	defer d.observe("FindClientSecrets", time.Now())
+	return d.store.(ClientSecretStore).FindClientSecrets(clientID)
+}
+
+// RetireClientSecret is a timed wrapper for ClientSecretStore.RetireClientSecret.
+func (d *InstrumentedStore) RetireClientSecret(clientID string, secretID string) bool {
+	defer d.observe("RetireClientSecret", time.Now())
+	return d.store.(ClientSecretStore).RetireClientSecret(clientID, secretID)
+}
+
+// CreateClient is a timed wrapper for ClientRegistrationStore.CreateClient.
+func (d *InstrumentedStore) CreateClient(clientID string, clientSecret string, registrationToken string, metadata *ClientMetadata) Client {
+	defer d.observe("CreateClient", time.Now())
+	return d.store.(ClientRegistrationStore).CreateClient(clientID, clientSecret, registrationToken, metadata)
+}
+
+// FindClientWithRegistrationToken is a timed wrapper for
+// ClientRegistrationStore.FindClientWithRegistrationToken.
+func (d *InstrumentedStore) FindClientWithRegistrationToken(clientID string, registrationToken string) Client {
+	defer d.observe("FindClientWithRegistrationToken", time.Now())
+	return d.store.(ClientRegistrationStore).FindClientWithRegistrationToken(clientID, registrationToken)
+}
+
+// FindClientMetadata is a timed wrapper for ClientRegistrationStore.FindClientMetadata.
+func (d *InstrumentedStore) FindClientMetadata(clientID string) *ClientMetadata {
+	defer d.observe("FindClientMetadata", time.Now())
+	return d.store.(ClientRegistrationStore).FindClientMetadata(clientID)
+}
+
+// UpdateClientMetadata is a timed wrapper for ClientRegistrationStore.UpdateClientMetadata.
+func (d *InstrumentedStore) UpdateClientMetadata(clientID string, metadata *ClientMetadata) bool {
+	defer d.observe("UpdateClientMetadata", time.Now())
+	return d.store.(ClientRegistrationStore).UpdateClientMetadata(clientID, metadata)
+}
+
+// DeleteClient is a timed wrapper for ClientRegistrationStore.DeleteClient.
+func (d *InstrumentedStore) DeleteClient(clientID string) bool {
+	defer d.observe("DeleteClient", time.Now())
+	return d.store.(ClientRegistrationStore).DeleteClient(clientID)
+}
+
+// FindUsers is a timed wrapper for AdminStore.FindUsers.
+func (d *InstrumentedStore) FindUsers(offset int, limit int) ([]User, int) {
+	defer d.observe("FindUsers", time.Now())
+	return d.store.(AdminStore).FindUsers(offset, limit)
+}
+
+// CreateUser is a timed wrapper for AdminStore.CreateUser.
+func (d *InstrumentedStore) CreateUser(username string, password string, roles []string) User {
+	defer d.observe("CreateUser", time.Now())
+	return d.store.(AdminStore).CreateUser(username, password, roles)
+}
+
+// UpdateUserRoles is a timed wrapper for AdminStore.UpdateUserRoles.
+func (d *InstrumentedStore) UpdateUserRoles(userID string, roles []string) bool {
+	defer d.observe("UpdateUserRoles", time.Now())
+	return d.store.(AdminStore).UpdateUserRoles(userID, roles)
+}
+
+// UpdateUserPassword is a timed wrapper for AdminStore.UpdateUserPassword.
+func (d *InstrumentedStore) UpdateUserPassword(userID string, password string, token Token) bool {
+	defer d.observe("UpdateUserPassword", time.Now())
+	return d.store.(AdminStore).UpdateUserPassword(userID, password, token)
+}
+
+// DeleteUser is a timed wrapper for AdminStore.DeleteUser.
+func (d *InstrumentedStore) DeleteUser(userID string) bool {
+	defer d.observe("DeleteUser", time.Now())
+	return d.store.(AdminStore).DeleteUser(userID)
+}
+
+// FindClients is a timed wrapper for AdminStore.FindClients.
+func (d *InstrumentedStore) FindClients(offset int, limit int) ([]Client, int) {
+	defer d.observe("FindClients", time.Now())
+	return d.store.(AdminStore).FindClients(offset, limit)
+}
+
+// UpdateClientRestrictions is a timed wrapper for AdminStore.UpdateClientRestrictions.
+func (d *InstrumentedStore) UpdateClientRestrictions(clientID string, roles []string, scopes []string) bool {
+	defer d.observe("UpdateClientRestrictions", time.Now())
+	return d.store.(AdminStore).UpdateClientRestrictions(clientID, roles, scopes)
+}
+
+// UpdateClientFirstParty is a timed wrapper for AdminStore.UpdateClientFirstParty.
+func (d *InstrumentedStore) UpdateClientFirstParty(clientID string, isFirstParty bool) bool {
+	defer d.observe("UpdateClientFirstParty", time.Now())
+	return d.store.(AdminStore).UpdateClientFirstParty(clientID, isFirstParty)
+}
+
+// FindAccessTokens is a timed wrapper for AdminStore.FindAccessTokens.
+func (d *InstrumentedStore) FindAccessTokens(userID string, clientID string, offset int, limit int) ([]Token, int) {
+	defer d.observe("FindAccessTokens", time.Now())
+	return d.store.(AdminStore).FindAccessTokens(userID, clientID, offset, limit)
+}
+
+// DeleteAccessTokenWithID is a timed wrapper for AdminStore.DeleteAccessTokenWithID.
+func (d *InstrumentedStore) DeleteAccessTokenWithID(tokenID string) bool {
+	defer d.observe("DeleteAccessTokenWithID", time.Now())
+	return d.store.(AdminStore).DeleteAccessTokenWithID(tokenID)
+}
+
+// DeleteUserTokens is a timed wrapper for AdminStore.DeleteUserTokens.
+func (d *InstrumentedStore) DeleteUserTokens(userID string) {
+	defer d.observe("DeleteUserTokens", time.Now())
+	d.store.(AdminStore).DeleteUserTokens(userID)
+}
+
+// DeleteUserTokensExcept is a timed wrapper for AdminStore.DeleteUserTokensExcept.
+func (d *InstrumentedStore) DeleteUserTokensExcept(userID string, token Token) {
+	defer d.observe("DeleteUserTokensExcept", time.Now())
+	d.store.(AdminStore).DeleteUserTokensExcept(userID, token)
+}
+
+// CreateAuthorizationCode is a timed wrapper for AuthorizationStore.CreateAuthorizationCode.
+func (d *InstrumentedStore) CreateAuthorizationCode(clientID string, userID string, redirectURI string, scopes []string, expiredTime time.Time) string {
+	defer d.observe("CreateAuthorizationCode", time.Now())
+	return d.store.(AuthorizationStore).CreateAuthorizationCode(clientID, userID, redirectURI, scopes, expiredTime)
+}
+
+// ConsumeAuthorizationCode is a timed wrapper for AuthorizationStore.ConsumeAuthorizationCode.
+func (d *InstrumentedStore) ConsumeAuthorizationCode(code string) AuthorizationCode {
+	defer d.observe("ConsumeAuthorizationCode", time.Now())
+	return d.store.(AuthorizationStore).ConsumeAuthorizationCode(code)
+}
+
+// FindConsent is a timed wrapper for AuthorizationStore.FindConsent.
+func (d *InstrumentedStore) FindConsent(userID string, clientID string) []string {
+	defer d.observe("FindConsent", time.Now())
+	return d.store.(AuthorizationStore).FindConsent(userID, clientID)
+}
+
+// SaveConsent is a timed wrapper for AuthorizationStore.SaveConsent.
+func (d *InstrumentedStore) SaveConsent(userID string, clientID string, scopes []string) bool {
+	defer d.observe("SaveConsent", time.Now())
+	return d.store.(AuthorizationStore).SaveConsent(userID, clientID, scopes)
+}
+
+// observe reports a store call's latency.
+//
+// @param
+// - method {string} (store's method name)
+// - startTime {time.Time} (the time that store call had been started)
+func (d *InstrumentedStore) observe(method string, startTime time.Time) {
+	d.metrics.ObserveStoreCall(method, time.Since(startTime))
+}
+
+// unwrapStore returns the underlying token store, so optional capabilities can be checked.
+//
+// @param
+// - tokenStore {TokenStore} (a token store, might be instrumented)
+//
+// @return
+// - tokenStore {TokenStore} (the underlying token store)
+func unwrapStore(tokenStore TokenStore) TokenStore {
+	if instrumented, ok := tokenStore.(*InstrumentedStore); ok {
+		return instrumented.Unwrap()
+	}
+	return tokenStore
+}
@@ -0,0 +1,455 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// IntrospectionResponse describes a token introspection response (RFC 7662). Roles is not part
+// of the RFC, it is read if introspection endpoint returns it.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	TokenID   string   `json:"jti,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Expired   int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+
+	Confirmation *IntrospectionConfirmation `json:"cnf,omitempty"`
+}
+
+// IntrospectionConfirmation describes the certificate (RFC 8705) or the DPoP key (RFC 9449) that
+// a token is bound to.
+type IntrospectionConfirmation struct {
+	Thumbprint    string `json:"x5t#S256,omitempty"`
+	KeyThumbprint string `json:"jkt,omitempty"`
+}
+
+// Default values of IntrospectionValidator.
+const (
+	defaultIntrospectionTTL     = 5 * time.Minute
+	defaultIntrospectionTimeout = 10 * time.Second
+)
+
+// Default HTTP client of IntrospectionValidator.
+var introspectionClient = &http.Client{Timeout: defaultIntrospectionTimeout}
+
+// IntrospectionValidator validates tokens with a remote introspection endpoint, so resource
+// servers that do not share token store with authorization server can validate opaque and
+// revocable tokens. Results are cached up to token's expired time and MaxTTL, concurrent lookups
+// of the same token share one introspection request.
+//
+// This package does not serve an introspection endpoint (RFC 7662), Endpoint must be provided by
+// the authorization server. The validator can be created by CreateIntrospectionValidator or
+// literally, zero MaxTTL and null HTTPClient fall back to their defaults.
+type IntrospectionValidator struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	MaxTTL       time.Duration // Active and inactive results are cached at most this long, default 5 minutes
+	HTTPClient   *http.Client  // Default client times out after 10 seconds
+
+	cache     map[string]*introspectionEntry
+	calls     map[string]*introspectionCall
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+// introspectionEntry describes a cached introspection result.
+type introspectionEntry struct {
+	response *IntrospectionResponse
+	expired  time.Time
+}
+
+// introspectionCall describes an in-flight introspection request.
+type introspectionCall struct {
+	done     chan struct{}
+	response *IntrospectionResponse
+	err      error
+}
+
+// CreateIntrospectionValidator returns an IntrospectionValidator's instance. Results are cached
+// for at most 5 minutes.
+//
+// @param
+// - endpoint {string} (introspection endpoint's URL)
+// - clientID {string} (resource server's client ID)
+// - clientSecret {string} (resource server's client secret)
+//
+// @return
+// - validator {IntrospectionValidator} (an introspection validator's instance)
+func CreateIntrospectionValidator(endpoint string, clientID string, clientSecret string) *IntrospectionValidator {
+	return &IntrospectionValidator{
+		Endpoint:     endpoint,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		MaxTTL:       defaultIntrospectionTTL,
+		HTTPClient:   &http.Client{Timeout: defaultIntrospectionTimeout},
+
+		cache:     make(map[string]*introspectionEntry),
+		calls:     make(map[string]*introspectionCall),
+		lastPrune: time.Now(),
+	}
+}
+
+// Introspect returns token's introspection result, from cache if possible. Errors are not
+// cached.
+//
+// @param
+// - tokenString {string} (an access token)
+//
+// @return
+// - response {IntrospectionResponse} (token's introspection result)
+// - err {error} (an error if introspection endpoint cannot be reached)
+func (v *IntrospectionValidator) Introspect(tokenString string) (*IntrospectionResponse, error) {
+	key := introspectionKey(tokenString)
+
+	v.mutex.Lock()
+	now := time.Now()
+	v.prepare(now)
+	v.prune(now)
+
+	if entry, ok := v.cache[key]; ok && now.Before(entry.expired) {
+		v.mutex.Unlock()
+		return entry.response, nil
+	}
+
+	// Join an in-flight request of the same token
+	if call, ok := v.calls[key]; ok {
+		v.mutex.Unlock()
+		<-call.done
+		return call.response, call.err
+	}
+
+	call := &introspectionCall{done: make(chan struct{})}
+	v.calls[key] = call
+	v.mutex.Unlock()
+
+	call.response, call.err = v.introspect(tokenString)
+
+	v.mutex.Lock()
+	delete(v.calls, key)
+	if call.err == nil {
+		v.cache[key] = &introspectionEntry{
+			response: call.response,
+			expired:  v.cacheExpiredTime(time.Now(), call.response),
+		}
+	}
+	v.mutex.Unlock()
+
+	close(call.done)
+	return call.response, call.err
+}
+
+// ValidateToken returns a wrapper oauth token validation func before HandleContextFunc. It
+// produces the same oauth context as ValidateToken, so ValidateRoles, ValidateScopes and
+// ValidatePermissions can be used after it.
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func (v *IntrospectionValidator) ValidateToken() server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		return func(c *server.RequestContext) {
+			tokenString, isDPoP := readAccessToken(c)
+
+			/* Condition validation: Validate existing of access token */
+			if len(tokenString) == 0 {
+				Metrics.ObserveValidationFailure(MissingTokenReason)
+				panic(util.Status401())
+			}
+
+			response, err := v.Introspect(tokenString)
+			if err != nil {
+				panic(&util.Status{Code: 503, Description: "Token introspection is not available, please try again later."})
+			}
+			if !response.Active || (response.Expired > 0 && time.Now().Unix() >= response.Expired) {
+				Metrics.ObserveValidationFailure(InvalidTokenReason)
+				panic(util.Status401())
+			}
+
+			/* Condition validation: Bound token must be presented with its certificate */
+			realm := RealmOf(c)
+			oauthContext := createIntrospectionContext(realm, tokenString, response)
+			if !isBoundTo(oauthContext.AccessToken, clientCertificates(c)) {
+				Metrics.ObserveValidationFailure(CertificateMismatchReason)
+				panic(util.Status401())
+			}
+
+			/* Condition validation: DPoP-bound token must be presented with a proof of its key */
+			if errorCode := verifyDPoPBinding(realm, c.Header["dpop"], isDPoP, oauthContext.AccessToken, createDPoPTarget(c, tokenString)); len(errorCode) > 0 {
+				Metrics.ObserveValidationFailure(InvalidDPoPProofReason)
+				outputDPoPChallenge(c, realm, errorCode)
+				panic(util.Status401())
+			}
+
+			c.SetExtra(oauthKey.Context, oauthContext)
+			f(c)
+		}
+	}
+}
+
+// Middleware is the net/http equivalent of ValidateToken.
+//
+// @return
+// - middleware {func} (a net/http middleware)
+func (v *IntrospectionValidator) Middleware() func(http.Handler) http.Handler {
+	return Middleware(v.ValidateToken())
+}
+
+// introspect sends an introspection request with resource server's client credentials.
+//
+// @param
+// - tokenString {string} (an access token)
+//
+// @return
+// - response {IntrospectionResponse} (token's introspection result)
+// - err {error} (an error if introspection endpoint cannot be reached or returns an error)
+func (v *IntrospectionValidator) introspect(tokenString string) (*IntrospectionResponse, error) {
+	form := url.Values{"token": {tokenString}, "token_type_hint": {"access_token"}}
+	request, err := http.NewRequest("POST", v.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(v.ClientID, v.ClientSecret)
+
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = introspectionClient
+	}
+
+	httpResponse, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Introspection endpoint returned status %d.", httpResponse.StatusCode)
+	}
+
+	response := new(IntrospectionResponse)
+	if err = json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// cacheExpiredTime returns the time when an introspection result must be looked up again.
+//
+// @param
+// - now {time.Time} (current time)
+// - response {IntrospectionResponse} (token's introspection result)
+//
+// @return
+// - expiredTime {time.Time} (result's expired time in cache)
+func (v *IntrospectionValidator) cacheExpiredTime(now time.Time, response *IntrospectionResponse) time.Time {
+	expiredTime := now.Add(v.maxTTL())
+	if response.Active && response.Expired > 0 {
+		if tokenExpiredTime := time.Unix(response.Expired, 0); tokenExpiredTime.Before(expiredTime) {
+			expiredTime = tokenExpiredTime
+		}
+	}
+	return expiredTime
+}
+
+// maxTTL returns how long a result can be cached.
+//
+// @return
+// - ttl {time.Duration} (MaxTTL or its default value)
+func (v *IntrospectionValidator) maxTTL() time.Duration {
+	if v.MaxTTL <= 0 {
+		return defaultIntrospectionTTL
+	}
+	return v.MaxTTL
+}
+
+// prepare allocates cache of a validator that had been created literally. Must be called with
+// lock held.
+//
+// @param
+// - now {time.Time} (current time)
+func (v *IntrospectionValidator) prepare(now time.Time) {
+	if v.cache == nil {
+		v.cache = make(map[string]*introspectionEntry)
+		v.lastPrune = now
+	}
+	if v.calls == nil {
+		v.calls = make(map[string]*introspectionCall)
+	}
+}
+
+// prune removes expired results. Must be called with lock held.
+//
+// @param
+// - now {time.Time} (current time)
+func (v *IntrospectionValidator) prune(now time.Time) {
+	if now.Sub(v.lastPrune) < v.maxTTL() {
+		return
+	}
+
+	for key, entry := range v.cache {
+		if !now.Before(entry.expired) {
+			delete(v.cache, key)
+		}
+	}
+	v.lastPrune = now
+}
+
+// introspectionKey returns cache's key of a token, so raw tokens are not kept in memory.
+//
+// @param
+// - tokenString {string} (an access token)
+//
+// @return
+// - key {string} (token's SHA-256 digest)
+func introspectionKey(tokenString string) string {
+	digest := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(digest[:])
+}
+
+// createIntrospectionContext converts an introspection result to an oauth context.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - tokenString {string} (an access token)
+// - response {IntrospectionResponse} (an active token's introspection result)
+//
+// @return
+// - oauthContext {OAuthContext} (token's oauth context)
+func createIntrospectionContext(realm *Realm, tokenString string, response *IntrospectionResponse) *OAuthContext {
+	return &OAuthContext{
+		Realm:       realm,
+		Client:      &introspectedClient{id: response.ClientID},
+		User:        &introspectedUser{response: response},
+		AccessToken: &introspectedToken{token: tokenString, response: response},
+	}
+}
+
+// introspectedUser describes the user of an introspected token.
+type introspectedUser struct {
+	response *IntrospectionResponse
+}
+
+// UserID returns token's subject.
+func (u *introspectedUser) UserID() string {
+	return u.response.Subject
+}
+
+// Username returns user's username.
+func (u *introspectedUser) Username() string {
+	return u.response.Username
+}
+
+// Password returns empty string, user's password is never exposed by introspection.
+func (u *introspectedUser) Password() string {
+	return ""
+}
+
+// UserRoles returns user's roles.
+func (u *introspectedUser) UserRoles() []string {
+	return u.response.Roles
+}
+
+// introspectedClient describes the client of an introspected token.
+type introspectedClient struct {
+	id string
+}
+
+// ClientID returns client's ID.
+func (c *introspectedClient) ClientID() string {
+	return c.id
+}
+
+// ClientSecret returns empty string, client's secret is never exposed by introspection.
+func (c *introspectedClient) ClientSecret() string {
+	return ""
+}
+
+// GrantTypes returns null, client's grant types are not exposed by introspection.
+func (c *introspectedClient) GrantTypes() []string {
+	return nil
+}
+
+// RedirectURIs returns null, client's redirect URIs are not exposed by introspection.
+func (c *introspectedClient) RedirectURIs() []string {
+	return nil
+}
+
+// introspectedToken describes an introspected access token.
+type introspectedToken struct {
+	token    string
+	response *IntrospectionResponse
+}
+
+// TokenID returns token's ID.
+func (t *introspectedToken) TokenID() string {
+	return t.response.TokenID
+}
+
+// ClientID returns client's ID.
+func (t *introspectedToken) ClientID() string {
+	return t.response.ClientID
+}
+
+// UserID returns token's subject.
+func (t *introspectedToken) UserID() string {
+	return t.response.Subject
+}
+
+// Token returns the access token.
+func (t *introspectedToken) Token() string {
+	return t.token
+}
+
+// IsExpired checks if token is expired or not.
+func (t *introspectedToken) IsExpired() bool {
+	return t.response.Expired > 0 && time.Now().Unix() >= t.response.Expired
+}
+
+// CreatedTime returns token's issued time.
+func (t *introspectedToken) CreatedTime() time.Time {
+	return time.Unix(t.response.IssuedAt, 0)
+}
+
+// ExpiredTime returns token's expired time.
+func (t *introspectedToken) ExpiredTime() time.Time {
+	return time.Unix(t.response.Expired, 0)
+}
+
+// TokenScopes returns token's scopes.
+func (t *introspectedToken) TokenScopes() []string {
+	return strings.Fields(t.response.Scope)
+}
+
+// CertificateThumbprint returns the thumbprint of the certificate that token is bound to.
+func (t *introspectedToken) CertificateThumbprint() string {
+	if t.response.Confirmation == nil {
+		return ""
+	}
+	return t.response.Confirmation.Thumbprint
+}
+
+// KeyThumbprint returns the thumbprint of the DPoP key that token is bound to.
+func (t *introspectedToken) KeyThumbprint() string {
+	if t.response.Confirmation == nil {
+		return ""
+	}
+	return t.response.Confirmation.KeyThumbprint
+}
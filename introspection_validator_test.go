@@ -0,0 +1,184 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+// createIntrospectionServer returns a fake introspection endpoint that counts its requests.
+func createIntrospectionServer(t *testing.T, handler func(token string) (int, *IntrospectionResponse)) (*httptest.Server, *int32) {
+	count := new(int32)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+
+		if clientID, clientSecret, ok := r.BasicAuth(); !ok || clientID != "resource" || clientSecret != "secret" {
+			t.Error("Expected introspection request should carry client credentials.")
+		}
+
+		status, response := handler(r.PostFormValue("token"))
+		w.WriteHeader(status)
+		if response != nil {
+			json.NewEncoder(w).Encode(response)
+		}
+	}))
+	return ts, count
+}
+
+func Test_IntrospectionValidator_Cache(t *testing.T) {
+	expired := time.Now().Add(time.Hour).Unix()
+	ts, count := createIntrospectionServer(t, func(token string) (int, *IntrospectionResponse) {
+		if token == "active" {
+			return 200, &IntrospectionResponse{Active: true, Subject: "user-1", Expired: expired}
+		}
+		return 200, &IntrospectionResponse{Active: false}
+	})
+	defer ts.Close()
+
+	validator := CreateIntrospectionValidator(ts.URL, "resource", "secret")
+
+	// [Test 1] Active result is cached
+	for i := 0; i < 3; i++ {
+		response, err := validator.Introspect("active")
+		if err != nil || !response.Active || response.Subject != "user-1" {
+			t.Fatalf("Expected active result but found %v, %v.", response, err)
+		}
+	}
+	if *count != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, *count)
+	}
+
+	// [Test 2] Inactive result is cached
+	for i := 0; i < 3; i++ {
+		if response, _ := validator.Introspect("revoked"); response == nil || response.Active {
+			t.Fatalf("Expected inactive result but found %v.", response)
+		}
+	}
+	if *count != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, *count)
+	}
+}
+
+func Test_IntrospectionValidator_Error(t *testing.T) {
+	ts, count := createIntrospectionServer(t, func(token string) (int, *IntrospectionResponse) {
+		return 500, nil
+	})
+	defer ts.Close()
+
+	validator := CreateIntrospectionValidator(ts.URL, "resource", "secret")
+	for i := 0; i < 2; i++ {
+		if _, err := validator.Introspect("active"); err == nil {
+			t.Error(expectedFormat.NotNil)
+		}
+	}
+	if *count != 2 {
+		t.Errorf("Expected errors should not be cached but found %d requests.", *count)
+	}
+}
+
+func Test_IntrospectionValidator_Coalesce(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	ts, count := createIntrospectionServer(t, func(token string) (int, *IntrospectionResponse) {
+		received <- struct{}{}
+		<-release
+		return 200, &IntrospectionResponse{Active: true}
+	})
+	defer ts.Close()
+
+	validator := CreateIntrospectionValidator(ts.URL, "resource", "secret")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if response, err := validator.Introspect("active"); err != nil || !response.Active {
+				t.Errorf("Expected active result but found %v, %v.", response, err)
+			}
+		}()
+	}
+
+	// Wait until every lookup has joined the in-flight request
+	<-received
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if *count != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, *count)
+	}
+}
+
+func Test_IntrospectionValidator_Literal(t *testing.T) {
+	ts, count := createIntrospectionServer(t, func(token string) (int, *IntrospectionResponse) {
+		return 200, &IntrospectionResponse{Active: true}
+	})
+	defer ts.Close()
+
+	validator := &IntrospectionValidator{Endpoint: ts.URL, ClientID: "resource", ClientSecret: "secret"}
+	for i := 0; i < 2; i++ {
+		if response, err := validator.Introspect("active"); err != nil || !response.Active {
+			t.Fatalf("Expected active result but found %v, %v.", response, err)
+		}
+	}
+	if *count != 1 {
+		t.Errorf("Expected result should be cached with default max TTL but found %d requests.", *count)
+	}
+	if expiredTime := validator.cacheExpiredTime(time.Unix(0, 0), &IntrospectionResponse{}); !expiredTime.Equal(time.Unix(0, 0).Add(defaultIntrospectionTTL)) {
+		t.Errorf("Expected result should be cached until %v but found %v.", time.Unix(0, 0).Add(defaultIntrospectionTTL), expiredTime)
+	}
+}
+
+func Test_IntrospectionValidator_CacheExpiredTime(t *testing.T) {
+	validator := CreateIntrospectionValidator("", "", "")
+	now := time.Now()
+
+	// [Test 1] Token expires before max TTL
+	expired := now.Add(10 * time.Second).Unix()
+	if expiredTime := validator.cacheExpiredTime(now, &IntrospectionResponse{Active: true, Expired: expired}); expiredTime.Unix() != expired {
+		t.Errorf(expectedFormat.NumberButFoundNumber, expired, expiredTime.Unix())
+	}
+
+	// [Test 2] Token expires after max TTL
+	expired = now.Add(time.Hour).Unix()
+	if expiredTime := validator.cacheExpiredTime(now, &IntrospectionResponse{Active: true, Expired: expired}); !expiredTime.Equal(now.Add(validator.MaxTTL)) {
+		t.Errorf("Expected result should be cached until %v but found %v.", now.Add(validator.MaxTTL), expiredTime)
+	}
+
+	// [Test 3] Inactive token
+	if expiredTime := validator.cacheExpiredTime(now, &IntrospectionResponse{}); !expiredTime.Equal(now.Add(validator.MaxTTL)) {
+		t.Errorf("Expected result should be cached until %v but found %v.", now.Add(validator.MaxTTL), expiredTime)
+	}
+}
+
+func Test_createIntrospectionContext(t *testing.T) {
+	response := &IntrospectionResponse{
+		Active:   true,
+		Scope:    "orders:read orders:write",
+		ClientID: "web",
+		Username: "admin",
+		Subject:  "user-1",
+		Roles:    []string{"r_admin"},
+	}
+
+	s := createIntrospectionContext(&Realm{}, "token", response)
+	if s.User.UserID() != "user-1" || s.User.Username() != "admin" || s.Client.ClientID() != "web" {
+		t.Errorf("Expected oauth context should match %v.", response)
+	}
+	if !s.HasScope("orders:write") || s.HasScope("orders:delete") {
+		t.Error("Expected oauth context should carry token's scopes.")
+	}
+	if !s.EffectiveRoles()["r_admin"] {
+		t.Error("Expected oauth context should carry user's roles.")
+	}
+	if s.AccessToken.Token() != "token" {
+		t.Errorf(expectedFormat.StringButFoundString, "token", s.AccessToken.Token())
+	}
+}
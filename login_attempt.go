@@ -0,0 +1,201 @@
+package oauth2
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// Login attempt counter's key prefixes.
+const (
+	loginAttemptUserPrefix = "user:"
+	loginAttemptIPPrefix   = "ip:"
+)
+
+// UnlockUser resets failed login attempts of an user of the default realm, so the user can log
+// in immediately.
+//
+// @param
+// - username {string} (user's username)
+func UnlockUser(username string) {
+	unlockUser(defaultRealm(), username)
+}
+
+// unlockUser resets failed login attempts of an user of a realm.
+//
+// @param
+// - realm {Realm} (user's realm)
+// - username {string} (user's username)
+func unlockUser(realm *Realm, username string) {
+	/* Condition validation */
+	if Attempts == nil || len(username) == 0 {
+		return
+	}
+	Attempts.DeleteFailures(realmKey(realm, loginAttemptUserPrefix+username))
+}
+
+// loginAttemptKeys returns counter's keys for a login attempt.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - username {string} (user's username)
+//
+// @return
+// - keys {[]string} (a list of counter's keys)
+func loginAttemptKeys(c *server.RequestContext, username string) []string {
+	realm := RealmOf(c)
+
+	keys := []string{realmKey(realm, loginAttemptUserPrefix+username)}
+	if ip := clientIP(c); len(ip) > 0 {
+		keys = append(keys, realmKey(realm, loginAttemptIPPrefix+ip))
+	}
+	return keys
+}
+
+// validateLoginAttempts rejects a login attempt if any of its keys is in backoff or locked.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - keys {[]string} (a list of counter's keys)
+// - event {SecurityEvent} (a login failed event that will be published if attempt is rejected)
+func validateLoginAttempts(c *server.RequestContext, keys []string, event SecurityEvent) {
+	realm := RealmOf(c)
+	config := realm.Config
+
+	/* Condition validation */
+	if Attempts == nil || config.MaxLoginAttempts < 0 {
+		return
+	}
+
+	now := realm.now()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		count, lastFailedTime := Attempts.FindFailures(key)
+		if count == 0 {
+			continue
+		}
+
+		// Forget failed attempts after lockout duration
+		if now.Sub(lastFailedTime) >= config.LoginLockoutDuration {
+			Attempts.DeleteFailures(key)
+			continue
+		}
+
+		// Exponential backoff until lockout
+		delay := config.LoginLockoutDuration
+		if count < config.MaxLoginAttempts {
+			backoff := float64(config.LoginBackoffDuration) * math.Pow(2, float64(count-1))
+			if backoff < float64(config.LoginLockoutDuration) {
+				delay = time.Duration(backoff)
+			}
+		}
+
+		if wait := lastFailedTime.Add(delay).Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		event.Reason = LockedReason
+		publishEvent(event)
+
+		c.OutputHeader("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(retryAfter.Seconds()))))
+		panic(&util.Status{Code: 429, Description: "Too many failed login attempts, please try again later."})
+	}
+}
+
+// saveLoginFailure increases failed attempts' counters.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - keys {[]string} (a list of counter's keys)
+func saveLoginFailure(c *server.RequestContext, keys []string) {
+	realm := RealmOf(c)
+
+	/* Condition validation */
+	if Attempts == nil || realm.Config.MaxLoginAttempts < 0 {
+		return
+	}
+
+	now := realm.now()
+	for _, key := range keys {
+		Attempts.SaveFailure(key, now)
+	}
+}
+
+// clientIP returns client's IP address. It is connection's remote address, unless request comes
+// from a trusted proxy, then the nearest untrusted address in X-Forwarded-For or X-Real-IP is
+// used. Routes that are bound to go-server's router do not expose connection's remote address,
+// it is read from config's RemoteAddrHeader instead.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - ip {string} (client's IP address or empty string if it is unknown)
+func clientIP(c *server.RequestContext) string {
+	config := RealmOf(c).Config
+	remoteAddr, ok := c.GetExtra(oauthKey.RemoteAddr).(string)
+	if addr := strings.TrimSpace(c.Header[strings.ToLower(config.RemoteAddrHeader)]); !ok && len(config.RemoteAddrHeader) > 0 && net.ParseIP(addr) != nil {
+		remoteAddr = addr
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+
+	/* Condition validation: Forwarded headers are only honoured from trusted proxies */
+	proxies := config.TrustedProxies
+	if net.ParseIP(remoteAddr) == nil || !isTrustedProxy(proxies, remoteAddr) {
+		return remoteAddr
+	}
+
+	// Proxies append to the right, so the nearest untrusted hop is the client
+	if forwardedFor := c.Header["x-forwarded-for"]; len(forwardedFor) > 0 {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+
+			remoteAddr = hop
+			if !isTrustedProxy(proxies, hop) {
+				break
+			}
+		}
+		return remoteAddr
+	}
+
+	if realIP := strings.TrimSpace(c.Header["x-real-ip"]); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return remoteAddr
+}
+
+// isTrustedProxy checks if an address belongs to one of trusted proxies.
+//
+// @param
+// - proxies {[]string} (addresses or CIDR ranges of trusted proxies)
+// - addr {string} (an IP address)
+//
+// @return
+// - isTrusted {bool} (true if address is trusted)
+func isTrustedProxy(proxies []string, addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package oauth2
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_MemoryLoginAttemptStore(t *testing.T) {
+	attemptStore := CreateMemoryLoginAttemptStore(time.Minute)
+	now := time.Now()
+
+	attemptStore.SaveFailure("user:admin", now)
+	if count := attemptStore.SaveFailure("user:admin", now); count != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, count)
+	}
+
+	count, lastFailedTime := attemptStore.FindFailures("user:admin")
+	if count != 2 || !lastFailedTime.Equal(now) {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, count)
+	}
+
+	attemptStore.DeleteFailures("user:admin")
+	if count, _ := attemptStore.FindFailures("user:admin"); count != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, count)
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	defer func() { Cfg.TrustedProxies = nil }()
+
+	var ip string
+	ts := httptest.NewServer(Handler(func(c *server.RequestContext) {
+		ip = clientIP(c)
+	}))
+	defer ts.Close()
+
+	send := func(headers map[string]string) string {
+		request, _ := http.NewRequest("GET", ts.URL, nil)
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		http.DefaultClient.Do(request)
+		return ip
+	}
+
+	// [Test 1] Forwarded headers from untrusted peer are ignored
+	if ip := send(map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}); ip != "127.0.0.1" {
+		t.Errorf(expectedFormat.StringButFoundString, "127.0.0.1", ip)
+	}
+
+	// [Test 2] Nearest untrusted hop is used, spoofed hops on the left are ignored
+	Cfg.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+	if ip := send(map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"}); ip != "1.2.3.4" {
+		t.Errorf(expectedFormat.StringButFoundString, "1.2.3.4", ip)
+	}
+
+	// [Test 3] X-Real-IP from trusted peer
+	if ip := send(map[string]string{"X-Real-IP": "1.2.3.4"}); ip != "1.2.3.4" {
+		t.Errorf(expectedFormat.StringButFoundString, "1.2.3.4", ip)
+	}
+
+	// [Test 4] Trusted peer without forwarded headers
+	if ip := send(nil); ip != "127.0.0.1" {
+		t.Errorf(expectedFormat.StringButFoundString, "127.0.0.1", ip)
+	}
+}
+
+func Test_isTrustedProxy(t *testing.T) {
+	proxies := []string{"192.168.1.1", "10.0.0.0/8", "invalid"}
+	if !isTrustedProxy(proxies, "192.168.1.1") || !isTrustedProxy(proxies, "10.1.2.3") {
+		t.Error("Expected address should be trusted.")
+	}
+	if isTrustedProxy(proxies, "192.168.1.2") || isTrustedProxy(proxies, "invalid") || isTrustedProxy(nil, "10.1.2.3") {
+		t.Error("Expected address should not be trusted.")
+	}
+}
+
+func Test_TokenGrant_passwordFlow_Lockout(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+
+	// Setup server
+	controller := new(TokenGrant)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleForm(context)
+	}))
+	defer ts.Close()
+
+	login := func(password string) *http.Response {
+		response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
+			PasswordGrant,
+			u.ClientID,
+			u.ClientSecret,
+			u.Username,
+			password,
+		)))
+		return response
+	}
+
+	// [Test 1] First failure
+	if response := login("InvalidPassword"); response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+
+	// [Test 2] Retry immediately should be rejected, even with valid password
+	response := login(u.Password)
+	if response.StatusCode != 429 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 429, response.StatusCode)
+	}
+	if len(response.Header.Get("Retry-After")) == 0 {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// [Test 3] Unlocked user should be able to log in
+	UnlockUser(u.Username)
+	if response := login(u.Password); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+}
+
+func Test_TokenGrant_passwordFlow_RouterLockout(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+	Cfg.RemoteAddrHeader = "X-Real-IP"
+	defer func() { Cfg.RemoteAddrHeader = "" }()
+
+	// Token route as it is bound to go-server's router, without connection's remote address
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		defaultServer().HandleToken(context)
+	}))
+	defer ts.Close()
+
+	login := func(username string, password string, ip string) *http.Response {
+		request, _ := http.NewRequest("POST", ts.URL, strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
+			PasswordGrant,
+			u.ClientID,
+			u.ClientSecret,
+			username,
+			password,
+		)))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("X-Real-IP", ip)
+		response, _ := http.DefaultClient.Do(request)
+		return response
+	}
+
+	// [Test 1] First failure
+	if response := login(u.Username, "InvalidPassword", "1.2.3.4"); response.StatusCode != 400 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 400, response.StatusCode)
+	}
+
+	// [Test 2] Other user from the same IP is rejected
+	if response := login("guest", "InvalidPassword", "1.2.3.4"); response.StatusCode != 429 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 429, response.StatusCode)
+	}
+
+	// [Test 3] Unlocked user from other IP should be able to log in
+	UnlockUser(u.Username)
+	if response := login(u.Username, u.Password, "5.6.7.8"); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+}
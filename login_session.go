@@ -0,0 +1,455 @@
+package oauth2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// Login session's cookie name.
+const sessionCookieName = "oauth_session"
+
+// Name of the form field that carries CSRF token.
+const csrfField = "csrf_token"
+
+// LoginSession describes a browser's session. It is kept in a cookie that is encrypted and
+// authenticated with AES-GCM, so it cannot be read nor modified by browser.
+type LoginSession struct {
+	UserID   string    `json:"uid,omitempty"`
+	Version  int       `json:"ver,omitempty"` // User's session version at login time
+	CSRF     string    `json:"csrf"`
+	Created  time.Time `json:"iat"`
+	LastSeen time.Time `json:"lst"`
+
+	// Realm that session belongs to.
+	realm *Realm
+}
+
+// IsAuthenticated checks if user had logged in.
+func (s *LoginSession) IsAuthenticated() bool {
+	return len(s.UserID) > 0
+}
+
+// IsExpired checks if session had reached its lifetime or had been idle for too long, according
+// to its realm's clock.
+func (s *LoginSession) IsExpired() bool {
+	realm := s.realm
+	if realm == nil {
+		realm = defaultRealm()
+	}
+
+	now := realm.now()
+	return now.After(s.Created.Add(realm.Config.SessionLifetime)) || now.After(s.LastSeen.Add(realm.Config.SessionIdleTimeout))
+}
+
+// createLoginSession returns a new session with a fresh CSRF token.
+//
+// @param
+// - realm {Realm} (realm that session belongs to)
+// - userID {string} (authenticated user's ID or empty string for anonymous session)
+//
+// @return
+// - session {LoginSession} (a login session)
+func createLoginSession(realm *Realm, userID string) *LoginSession {
+	csrf, err := GenerateClientSecret()
+	if err != nil {
+		panic(util.Status500())
+	}
+
+	now := realm.now()
+	return &LoginSession{
+		UserID:   userID,
+		CSRF:     csrf,
+		Created:  now,
+		LastSeen: now,
+		realm:    realm,
+	}
+}
+
+// ValidateLoginSession returns a wrapper browser's session validation func before
+// HandleContextFunc. Requests that carry a bearer token or Basic auth are validated by
+// ValidateToken instead.
+//
+// @param
+// - loginURL {string} (unauthenticated browser is redirected to this URL, empty means 401)
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func ValidateLoginSession(loginURL string) server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		validateToken := ValidateToken()(f)
+
+		return func(c *server.RequestContext) {
+			// API clients authenticate themselves
+			if len(c.Header["authorization"]) > 0 || len(c.QueryParams["access_token"]) > 0 {
+				validateToken(c)
+				return
+			}
+
+			var user User
+			realm := RealmOf(c)
+			session := readLoginSession(c)
+			if session != nil && session.IsAuthenticated() && !session.IsExpired() {
+				user = realm.Store.FindUserWithID(session.UserID)
+			}
+
+			/* Condition validation: Session must not be revoked after login */
+			if user != nil && session.Version != sessionVersion(user) {
+				user = nil
+			}
+
+			/* Condition validation: Browser must log in first */
+			if user == nil {
+				if len(loginURL) == 0 {
+					panic(util.Status401())
+				}
+				query := url.Values{"return_to": {requestURI(c)}}
+				c.OutputRedirect(util.Status302(), loginURL+"?"+query.Encode())
+				return
+			}
+
+			// Extend idle timeout
+			session.LastSeen = realm.now()
+			writeLoginSession(c, session)
+
+			c.SetExtra(oauthKey.Session, session)
+			c.SetExtra(oauthKey.Context, &OAuthContext{User: user, Realm: realm})
+			f(c)
+		}
+	}
+}
+
+// LoginPage describes the model that will be rendered by login template. The form must be
+// submitted to Action by POST with "username", "password" and all hidden fields.
+type LoginPage struct {
+	Action   string
+	Username string
+	Error    string
+	Hidden   map[string]string
+}
+
+// LoginTemplate renders login page. It can be replaced directly or by Config's LoginTemplate
+// path.
+var LoginTemplate = template.Must(template.New("login").Parse(defaultLoginTemplate))
+
+// Default login page.
+const defaultLoginTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Sign in</title>
+</head>
+<body>
+	<h1>Sign in</h1>
+	{{if .Error}}<p>{{.Error}}</p>{{end}}
+	<form method="post" action="{{.Action}}">
+		{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">{{end}}
+		<input type="text" name="username" value="{{.Username}}" autocomplete="username" required>
+		<input type="password" name="password" autocomplete="current-password" required>
+		<button type="submit">Sign in</button>
+	</form>
+</body>
+</html>
+`
+
+// LoginController describes a browser's login controller.
+type LoginController struct {
+}
+
+// HandleLoginPage renders login page.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (l *LoginController) HandleLoginPage(c *server.RequestContext) {
+	session := readLoginSession(c)
+	if session == nil || session.IsExpired() {
+		session = createLoginSession(RealmOf(c), "")
+		writeLoginSession(c, session)
+	}
+	l.renderLoginPage(c, util.Status200(), session, "", "")
+}
+
+// HandleLogin validates user's credentials. On success, session is rotated and browser is
+// redirected back to where it came from.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (l *LoginController) HandleLogin(c *server.RequestContext) {
+	username := c.QueryParams["username"]
+	password := c.QueryParams["password"]
+
+	/* Condition validation: Validate CSRF token */
+	session := readLoginSession(c)
+	if session == nil || !compareCSRF(session, c.QueryParams[csrfField]) {
+		session = createLoginSession(RealmOf(c), "")
+		writeLoginSession(c, session)
+		l.renderLoginPage(c, util.Status403(), session, username, "Your session has expired, please try again.")
+		return
+	}
+
+	/* Condition validation: Validate failed login attempts */
+	event := createSecurityEvent(c, LoginFailedEvent, nil, InvalidCredentialsReason)
+	event.Username = username
+
+	attemptKeys := loginAttemptKeys(c, username)
+	validateLoginAttempts(c, attemptKeys, event)
+
+	/* Condition validation: Validate user's credentials */
+	realm := RealmOf(c)
+	user := realm.Store.FindUserWithCredential(username, password)
+	if len(username) == 0 || len(password) == 0 || user == nil {
+		saveLoginFailure(c, attemptKeys)
+		publishEvent(event)
+		l.renderLoginPage(c, util.Status401(), session, username, "Invalid username or password.")
+		return
+	}
+	unlockUser(realm, username)
+
+	// Rotate session, so an anonymous session cannot be fixated
+	session = createLoginSession(realm, user.UserID())
+	session.Version = sessionVersion(user)
+	writeLoginSession(c, session)
+	c.OutputRedirect(util.Status302(), safeReturnURL(c.QueryParams["return_to"]))
+}
+
+// HandleLogout ends browser's session.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (l *LoginController) HandleLogout(c *server.RequestContext) {
+	/* Condition validation: Validate CSRF token */
+	if session := readLoginSession(c); session == nil || !compareCSRF(session, c.QueryParams[csrfField]) {
+		panic(util.Status403())
+	}
+
+	clearLoginSession(c)
+	c.OutputRedirect(util.Status302(), safeReturnURL(c.QueryParams["return_to"]))
+}
+
+// renderLoginPage renders login page with session's CSRF token.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - status {util.Status} (response's status)
+// - session {LoginSession} (browser's session)
+// - username {string} (previously entered username)
+// - message {string} (an error message or empty string)
+func (l *LoginController) renderLoginPage(c *server.RequestContext, status *util.Status, session *LoginSession, username string, message string) {
+	hidden := map[string]string{csrfField: session.CSRF}
+	if returnTo := c.QueryParams["return_to"]; len(returnTo) > 0 {
+		hidden["return_to"] = safeReturnURL(returnTo)
+	}
+
+	renderTemplate(c, status, LoginTemplate, &LoginPage{
+		Action:   c.Path,
+		Username: username,
+		Error:    message,
+		Hidden:   hidden,
+	})
+}
+
+// loadLoginTemplate replaces login template with a template file.
+//
+// @param
+// - path {string} (template file's path)
+func loadLoginTemplate(path string) {
+	LoginTemplate = template.Must(template.ParseFiles(path))
+}
+
+// readLoginSession decrypts browser's session cookie.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - session {LoginSession} (browser's session or null)
+func readLoginSession(c *server.RequestContext) *LoginSession {
+	request := &http.Request{Header: http.Header{"Cookie": {c.Header["cookie"]}}}
+	cookie, err := request.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	realm := RealmOf(c)
+	session := decodeLoginSession(realm.Config, cookie.Value)
+	if session != nil {
+		session.realm = realm
+	}
+	return session
+}
+
+// writeLoginSession encrypts browser's session into a cookie.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - session {LoginSession} (browser's session)
+func writeLoginSession(c *server.RequestContext, session *LoginSession) {
+	realm := RealmOf(c)
+
+	cookie := createSessionCookie(realm, encodeLoginSession(realm.Config, session))
+	cookie.Expires = session.Created.Add(realm.Config.SessionLifetime)
+	c.OutputHeader("Set-Cookie", cookie.String())
+}
+
+// decodeLoginSession decrypts and authenticates a session cookie's value.
+//
+// @param
+// - config {Config} (realm's config)
+// - value {string} (session cookie's value)
+//
+// @return
+// - session {LoginSession} (browser's session or null if cookie had been tampered)
+func decodeLoginSession(config *Config, value string) *LoginSession {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+
+	aead := sessionCipher(config)
+	if len(data) < aead.NonceSize() {
+		return nil
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], sessionData(config))
+	if err != nil {
+		return nil
+	}
+
+	session := new(LoginSession)
+	if err := json.Unmarshal(plaintext, session); err != nil {
+		return nil
+	}
+	return session
+}
+
+// encodeLoginSession encrypts browser's session into a cookie's value.
+//
+// @param
+// - config {Config} (realm's config)
+// - session {LoginSession} (browser's session)
+//
+// @return
+// - value {string} (session cookie's value)
+func encodeLoginSession(config *Config, session *LoginSession) string {
+	plaintext, _ := json.Marshal(session)
+
+	aead := sessionCipher(config)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(util.Status500())
+	}
+	data := aead.Seal(nonce, nonce, plaintext, sessionData(config))
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// clearLoginSession removes browser's session cookie.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func clearLoginSession(c *server.RequestContext) {
+	cookie := createSessionCookie(RealmOf(c), "")
+	cookie.MaxAge = -1
+	c.OutputHeader("Set-Cookie", cookie.String())
+}
+
+// createSessionCookie returns realm's session cookie with its security attributes.
+func createSessionCookie(realm *Realm, value string) *http.Cookie {
+	path := "/"
+	if len(realm.PathPrefix) > 0 {
+		path = realm.PathPrefix
+	}
+
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   !realm.Config.SessionInsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sessionCipher returns AES-256-GCM cipher that is keyed by realm's SessionSecret.
+func sessionCipher(config *Config) cipher.AEAD {
+	key := sha256.Sum256([]byte(config.SessionSecret))
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// sessionData returns cookie's additional authenticated data. It is bound to realm's issuer, so
+// a session of one realm cannot be replayed in another realm that shares the same secret.
+func sessionData(config *Config) []byte {
+	return []byte(sessionCookieName + config.Issuer)
+}
+
+// sessionVersion returns user's login session version.
+//
+// @param
+// - user {User} (an user entity)
+//
+// @return
+// - version {int} (user's session version, 0 if user's sessions cannot be revoked)
+func sessionVersion(user User) int {
+	if versionedUser, ok := user.(SessionVersionedUser); ok {
+		return versionedUser.SessionVersion()
+	}
+	return 0
+}
+
+// compareCSRF compares a submitted CSRF token with session's CSRF token in constant time.
+//
+// @param
+// - session {LoginSession} (browser's session)
+// - csrf {string} (submitted CSRF token)
+//
+// @return
+// - isValid {bool} (true if token is matched)
+func compareCSRF(session *LoginSession, csrf string) bool {
+	return len(csrf) > 0 && subtle.ConstantTimeCompare([]byte(session.CSRF), []byte(csrf)) == 1
+}
+
+// requestURI rebuilds request's path with its query.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - uri {string} (request's URI)
+func requestURI(c *server.RequestContext) string {
+	query := url.Values{}
+	for key, value := range c.QueryParams {
+		query.Set(key, value)
+	}
+
+	if len(query) == 0 {
+		return c.Path
+	}
+	return c.Path + "?" + query.Encode()
+}
+
+// safeReturnURL only accepts local paths, so login cannot be used as an open redirect.
+//
+// @param
+// - returnTo {string} (requested return URL)
+//
+// @return
+// - url {string} (a local path, "/" if returnTo is not local)
+func safeReturnURL(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
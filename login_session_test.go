@@ -0,0 +1,376 @@
+package oauth2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+// useSessionConfig replaces global config with a session config and returns a restore func.
+func useSessionConfig() func() {
+	config := Cfg
+	Cfg = &Config{
+		SessionSecret:      "session-secret",
+		SessionLifetime:    time.Hour,
+		SessionIdleTimeout: 10 * time.Minute,
+	}
+	return func() { Cfg = config }
+}
+
+func Test_LoginSession_Cookie(t *testing.T) {
+	defer useSessionConfig()()
+
+	session := createLoginSession(defaultRealm(), "user-1")
+	value := encodeLoginSession(Cfg, session)
+
+	// [Test 1] Session can be decoded
+	decoded := decodeLoginSession(Cfg, value)
+	if decoded == nil {
+		t.Fatal(expectedFormat.NotNil)
+	}
+	if decoded.UserID != "user-1" || decoded.CSRF != session.CSRF || !decoded.IsAuthenticated() {
+		t.Errorf("Expected decoded session should match %v but found %v.", session, decoded)
+	}
+
+	// [Test 2] Tampered cookie is rejected
+	tampered, _ := base64.RawURLEncoding.DecodeString(value)
+	tampered[len(tampered)/2] ^= 0xff
+	if decodeLoginSession(Cfg, base64.RawURLEncoding.EncodeToString(tampered)) != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if decodeLoginSession(Cfg, "invalid") != nil {
+		t.Error(expectedFormat.Nil)
+	}
+
+	// [Test 3] Cookie cannot be decoded by another realm
+	if decodeLoginSession(&Config{SessionSecret: Cfg.SessionSecret, Issuer: "brand"}, value) != nil {
+		t.Error(expectedFormat.Nil)
+	}
+
+	// [Test 4] Cookie cannot be decoded with another secret
+	Cfg.SessionSecret = "another-secret"
+	if decodeLoginSession(Cfg, value) != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
+func Test_LoginSession_IsExpired(t *testing.T) {
+	defer useSessionConfig()()
+
+	now := time.Now()
+	if (&LoginSession{Created: now, LastSeen: now}).IsExpired() {
+		t.Error("Expected fresh session should not be expired.")
+	}
+	if !(&LoginSession{Created: now, LastSeen: now.Add(-11 * time.Minute)}).IsExpired() {
+		t.Error("Expected idle session should be expired.")
+	}
+	if !(&LoginSession{Created: now.Add(-2 * time.Hour), LastSeen: now}).IsExpired() {
+		t.Error("Expected session that had reached its lifetime should be expired.")
+	}
+}
+
+func Test_compareCSRF(t *testing.T) {
+	session := &LoginSession{CSRF: "csrf-token"}
+	if !compareCSRF(session, "csrf-token") {
+		t.Error("Expected matched CSRF token should be valid.")
+	}
+	if compareCSRF(session, "other-token") || compareCSRF(session, "") || compareCSRF(&LoginSession{}, "") {
+		t.Error("Expected mismatched or empty CSRF token should be invalid.")
+	}
+}
+
+func Test_safeReturnURL(t *testing.T) {
+	cases := map[string]string{
+		"/authorize?client_id=web": "/authorize?client_id=web",
+		"":                         "/",
+		"https://evil.example":     "/",
+		"//evil.example":           "/",
+		"/\\evil.example":          "/",
+	}
+	for returnTo, expected := range cases {
+		if url := safeReturnURL(returnTo); url != expected {
+			t.Errorf(expectedFormat.StringButFoundString, expected, url)
+		}
+	}
+}
+
+func Test_LoginTemplate(t *testing.T) {
+	var buffer bytes.Buffer
+	page := &LoginPage{
+		Action:   "/login",
+		Username: `"><script>`,
+		Hidden:   map[string]string{csrfField: "csrf-token"},
+	}
+	if err := LoginTemplate.Execute(&buffer, page); err != nil {
+		t.Fatal(err)
+	}
+
+	html := buffer.String()
+	if strings.Contains(html, "<script>") {
+		t.Error("Expected login page should escape user's input.")
+	}
+	if !strings.Contains(html, `name="csrf_token" value="csrf-token"`) {
+		t.Error("Expected login page should render CSRF token.")
+	}
+}
+
+func Test_ValidateLoginSession_RedirectToLogin(t *testing.T) {
+	defer useSessionConfig()()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		f := ValidateLoginSession("/login")(func(c *server.RequestContext) {
+			t.Error("Expected unauthenticated browser should not reach handler.")
+		})
+		f(context)
+	}))
+	defer ts.Close()
+
+	response, err := noRedirectClient.Get(ts.URL + "/authorize?client_id=web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 302 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 302, response.StatusCode)
+	}
+
+	location := response.Header.Get("Location")
+	if location != "/login?return_to=%2Fauthorize%3Fclient_id%3Dweb" {
+		t.Errorf(expectedFormat.StringButFoundString, "/login?return_to=%2Fauthorize%3Fclient_id%3Dweb", location)
+	}
+}
+
+// sessionCookie returns the session cookie that had been set by a response or null.
+func sessionCookie(response *http.Response) *http.Cookie {
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == sessionCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// postLogin submits a form to login server with an optional session cookie.
+func postLogin(serverURL string, form url.Values, cookie *http.Cookie) *http.Response {
+	request, _ := http.NewRequest("POST", serverURL, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+
+	response, err := noRedirectClient.Do(request)
+	if err != nil {
+		return nil
+	}
+	return response
+}
+
+// createLoginServer returns a test server that serves login and logout handlers.
+func createLoginServer() *httptest.Server {
+	controller := new(LoginController)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		if strings.HasSuffix(r.URL.Path, "/logout") {
+			controller.HandleLogout(context)
+		} else {
+			controller.HandleLogin(context)
+		}
+	}))
+}
+
+func Test_LoginController_HandleLogin_CSRF(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	ts := createLoginServer()
+	defer ts.Close()
+
+	session := createLoginSession(defaultRealm(), "")
+	cookie := &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, session)}
+	form := url.Values{"username": {u.Username}, "password": {u.Password}}
+
+	// [Test 1] Missing session
+	form.Set(csrfField, session.CSRF)
+	if response := postLogin(ts.URL, form, nil); response.StatusCode != 403 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 403, response.StatusCode)
+	}
+
+	// [Test 2] Missing CSRF token
+	form.Del(csrfField)
+	if response := postLogin(ts.URL, form, cookie); response.StatusCode != 403 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 403, response.StatusCode)
+	}
+
+	// [Test 3] Wrong CSRF token
+	form.Set(csrfField, "wrong-token")
+	response := postLogin(ts.URL, form, cookie)
+	if response.StatusCode != 403 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 403, response.StatusCode)
+	}
+	if newCookie := sessionCookie(response); newCookie == nil {
+		t.Error("Expected a fresh anonymous session should be issued.")
+	} else if decoded := decodeLoginSession(Cfg, newCookie.Value); decoded == nil || decoded.IsAuthenticated() || decoded.CSRF == session.CSRF {
+		t.Error("Expected a fresh anonymous session should be issued.")
+	}
+}
+
+func Test_LoginController_HandleLogin(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	ts := createLoginServer()
+	defer ts.Close()
+
+	session := createLoginSession(defaultRealm(), "")
+	cookie := &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, session)}
+	returnTo := "/authorize?client_id=" + u.ClientID + "&response_type=code"
+
+	response := postLogin(ts.URL, url.Values{
+		"username":  {u.Username},
+		"password":  {u.Password},
+		"return_to": {returnTo},
+		csrfField:   {session.CSRF},
+	}, cookie)
+
+	// [Test 1] Browser is redirected back to authorization request
+	if response.StatusCode != 302 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 302, response.StatusCode)
+	}
+	if location := response.Header.Get("Location"); location != returnTo {
+		t.Errorf(expectedFormat.StringButFoundString, returnTo, location)
+	}
+
+	// [Test 2] Session is rotated
+	newCookie := sessionCookie(response)
+	if newCookie == nil {
+		t.Fatal(expectedFormat.NotNil)
+	}
+	decoded := decodeLoginSession(Cfg, newCookie.Value)
+	if decoded == nil || decoded.UserID != u.UserID.Hex() {
+		t.Fatal("Expected session should be authenticated.")
+	}
+	if decoded.CSRF == session.CSRF {
+		t.Error("Expected session should be rotated.")
+	}
+
+	// [Test 3] Open redirect is not allowed
+	session = createLoginSession(defaultRealm(), "")
+	cookie = &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, session)}
+	response = postLogin(ts.URL, url.Values{
+		"username":  {u.Username},
+		"password":  {u.Password},
+		"return_to": {"//attacker.com"},
+		csrfField:   {session.CSRF},
+	}, cookie)
+	if location := response.Header.Get("Location"); location != "/" {
+		t.Errorf(expectedFormat.StringButFoundString, "/", location)
+	}
+}
+
+func Test_LoginController_HandleLogin_Lockout(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+
+	ts := createLoginServer()
+	defer ts.Close()
+
+	session := createLoginSession(defaultRealm(), "")
+	cookie := &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, session)}
+	login := func(password string) *http.Response {
+		return postLogin(ts.URL, url.Values{"username": {u.Username}, "password": {password}, csrfField: {session.CSRF}}, cookie)
+	}
+
+	// [Test 1] First failure
+	if response := login("InvalidPassword"); response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+
+	// [Test 2] Retry immediately should be rejected, even with valid password
+	response := login(u.Password)
+	if response.StatusCode != 429 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 429, response.StatusCode)
+	}
+	if len(response.Header.Get("Retry-After")) == 0 {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// [Test 3] Unlocked user should be able to log in
+	UnlockUser(u.Username)
+	if response := login(u.Password); response.StatusCode != 302 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 302, response.StatusCode)
+	}
+}
+
+func Test_LoginController_HandleLogout(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	ts := createLoginServer()
+	defer ts.Close()
+
+	session := createLoginSession(defaultRealm(), u.UserID.Hex())
+	cookie := &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, session)}
+
+	// [Test 1] Missing or wrong CSRF token
+	if response := postLogin(ts.URL+"/logout", url.Values{}, cookie); response.StatusCode != 403 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 403, response.StatusCode)
+	}
+	if response := postLogin(ts.URL+"/logout", url.Values{csrfField: {"wrong-token"}}, cookie); response.StatusCode != 403 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 403, response.StatusCode)
+	}
+
+	// [Test 2] Session cookie is cleared
+	response := postLogin(ts.URL+"/logout", url.Values{csrfField: {session.CSRF}}, cookie)
+	if response.StatusCode != 302 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 302, response.StatusCode)
+	}
+	if cleared := sessionCookie(response); cleared == nil || cleared.MaxAge >= 0 || len(cleared.Value) > 0 {
+		t.Error("Expected session cookie should be cleared.")
+	}
+}
+
+func Test_ValidateLoginSession_PasswordChanged(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		ValidateLoginSession("")(func(c *server.RequestContext) {})(context)
+	}))
+	defer ts.Close()
+
+	cookie := &http.Cookie{Name: sessionCookieName, Value: encodeLoginSession(Cfg, createLoginSession(defaultRealm(), u.UserID.Hex()))}
+	send := func() int {
+		request, _ := http.NewRequest("GET", ts.URL, nil)
+		request.AddCookie(cookie)
+		response, _ := http.DefaultClient.Do(request)
+		return response.StatusCode
+	}
+
+	if status := send(); status != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, status)
+	}
+
+	Store.(AdminStore).UpdateUserPassword(u.UserID.Hex(), "NewPassword", nil)
+	if status := send(); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
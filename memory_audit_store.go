@@ -0,0 +1,69 @@
+package oauth2
+
+import "sync"
+
+// MemoryAuditStore describes an in-memory audit log's storage. Records will be lost when the
+// process exits, this store is meant for testing and development.
+type MemoryAuditStore struct {
+	records []AuditRecord
+	mutex   sync.RWMutex
+}
+
+// CreateMemoryAuditStore returns a default MemoryAuditStore's instance.
+//
+// @return
+// - auditStore {AuditStore} (an in-memory audit store's instance)
+func CreateMemoryAuditStore() AuditStore {
+	return &MemoryAuditStore{records: make([]AuditRecord, 0)}
+}
+
+// AppendRecord appends a security event to the end of audit chain.
+//
+// @param
+// - event {SecurityEvent} (a security event)
+//
+// @return
+// - record {AuditRecord} (an audit record or null)
+func (m *MemoryAuditStore) AppendRecord(event SecurityEvent) *AuditRecord {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var previous *AuditRecord
+	if count := len(m.records); count > 0 {
+		previous = &m.records[count-1]
+	}
+
+	record := createAuditRecord(previous, event)
+	m.records = append(m.records, *record)
+	return record
+}
+
+// FindRecords returns a page of audit records in chain's order.
+//
+// @param
+// - filter {AuditFilter} (records' filter, null will return all records)
+// - offset {int} (number of records to skip)
+// - limit {int} (maximum number of records to return)
+//
+// @return
+// - records {[]AuditRecord} (a list of audit records)
+// - total {int} (total number of matched audit records)
+func (m *MemoryAuditStore) FindRecords(filter *AuditFilter, offset int, limit int) ([]*AuditRecord, int) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	total := 0
+	records := make([]*AuditRecord, 0, limit)
+	for i := range m.records {
+		if !filter.Match(&m.records[i]) {
+			continue
+		}
+
+		if total >= offset && len(records) < limit {
+			record := m.records[i]
+			records = append(records, &record)
+		}
+		total++
+	}
+	return records, total
+}
@@ -0,0 +1,65 @@
+package oauth2
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryDPoPReplayStore describes an in-memory DPoP proofs' storage. Proofs are not shared
+// between processes.
+type MemoryDPoPReplayStore struct {
+	lastPrune time.Time
+	proofs    map[string]time.Time
+	mutex     sync.Mutex
+}
+
+// CreateMemoryDPoPReplayStore returns a default MemoryDPoPReplayStore's instance.
+//
+// @return
+// - replayStore {DPoPReplayStore} (an in-memory DPoP replay store's instance)
+func CreateMemoryDPoPReplayStore() DPoPReplayStore {
+	return &MemoryDPoPReplayStore{
+		lastPrune: time.Now(),
+		proofs:    make(map[string]time.Time),
+	}
+}
+
+// SaveProof records a proof until it is expired.
+//
+// @param
+// - key {string} (proof's key, e.g. key's thumbprint and proof's jti)
+// - now {time.Time} (current time according to realm's clock)
+// - expiredTime {time.Time} (the time after which proof will not be accepted anyway)
+//
+// @return
+// - isSaved {bool} (false if proof had been used)
+func (m *MemoryDPoPReplayStore) SaveProof(key string, now time.Time, expiredTime time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.prune(now)
+
+	if proofExpiredTime, ok := m.proofs[key]; ok && now.Before(proofExpiredTime) {
+		return false
+	}
+	m.proofs[key] = expiredTime
+	return true
+}
+
+// prune removes expired proofs. Must be called with lock held.
+//
+// @param
+// - now {time.Time} (current time)
+func (m *MemoryDPoPReplayStore) prune(now time.Time) {
+	/* Condition validation: prune at most once per minute */
+	if now.Sub(m.lastPrune) < time.Minute {
+		return
+	}
+
+	for key, expiredTime := range m.proofs {
+		if !now.Before(expiredTime) {
+			delete(m.proofs, key)
+		}
+	}
+	m.lastPrune = now
+}
@@ -0,0 +1,106 @@
+package oauth2
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryLoginAttempt describes a failed login attempts' counter.
+type memoryLoginAttempt struct {
+	count          int
+	lastFailedTime time.Time
+}
+
+// MemoryLoginAttemptStore describes an in-memory failed login attempts' storage. Counters are
+// not shared between processes.
+type MemoryLoginAttemptStore struct {
+	ttl       time.Duration
+	lastPrune time.Time
+	attempts  map[string]*memoryLoginAttempt
+	mutex     sync.Mutex
+}
+
+// CreateMemoryLoginAttemptStore returns a default MemoryLoginAttemptStore's instance.
+//
+// @param
+// - ttl {time.Duration} (how long a counter will be kept after its last failed attempt)
+//
+// @return
+// - attemptStore {LoginAttemptStore} (an in-memory login attempt store's instance)
+func CreateMemoryLoginAttemptStore(ttl time.Duration) LoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		ttl:       ttl,
+		lastPrune: time.Now(),
+		attempts:  make(map[string]*memoryLoginAttempt),
+	}
+}
+
+// FindFailures returns the number of consecutive failed attempts and the last failed time.
+//
+// @param
+// - key {string} (a counter's key, e.g. username or client's IP)
+//
+// @return
+// - count {int} (number of consecutive failed attempts)
+// - lastFailedTime {time.Time} (the last failed attempt's time)
+func (m *MemoryLoginAttemptStore) FindFailures(key string) (int, time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if attempt, ok := m.attempts[key]; ok {
+		return attempt.count, attempt.lastFailedTime
+	}
+	return 0, time.Time{}
+}
+
+// SaveFailure increases failed attempts' counter.
+//
+// @param
+// - key {string} (a counter's key, e.g. username or client's IP)
+// - failedTime {time.Time} (failed attempt's time)
+//
+// @return
+// - count {int} (number of consecutive failed attempts, including this one)
+func (m *MemoryLoginAttemptStore) SaveFailure(key string, failedTime time.Time) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.prune(failedTime)
+
+	attempt, ok := m.attempts[key]
+	if !ok {
+		attempt = new(memoryLoginAttempt)
+		m.attempts[key] = attempt
+	}
+	attempt.count++
+	attempt.lastFailedTime = failedTime
+	return attempt.count
+}
+
+// DeleteFailures resets failed attempts' counter.
+//
+// @param
+// - key {string} (a counter's key, e.g. username or client's IP)
+func (m *MemoryLoginAttemptStore) DeleteFailures(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.attempts, key)
+}
+
+// prune removes counters that had not been failed within ttl. Must be called with lock held.
+//
+// @param
+// - now {time.Time} (current time)
+func (m *MemoryLoginAttemptStore) prune(now time.Time) {
+	/* Condition validation: prune at most once per ttl */
+	if m.ttl <= 0 || now.Sub(m.lastPrune) < m.ttl {
+		return
+	}
+
+	for key, attempt := range m.attempts {
+		if now.Sub(attempt.lastFailedTime) >= m.ttl {
+			delete(m.attempts, key)
+		}
+	}
+	m.lastPrune = now
+}
@@ -0,0 +1,254 @@
+package oauth2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// Grant's outcomes.
+const (
+	SuccessOutcome = "success"
+	FailureOutcome = "failure"
+)
+
+// Token validation failure's reasons, in addition to security event's reasons.
+const (
+	MissingTokenReason = "missing_token"
+	InvalidTokenReason = "invalid_token"
+	ExpiredTokenReason = "expired_token"
+
+	CertificateMismatchReason = "certificate_mismatch"
+	InvalidDPoPProofReason    = "invalid_dpop_proof"
+)
+
+// Histogram's upper bounds, in seconds.
+var metricsDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metricsHistogram describes a cumulative histogram.
+type metricsHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// observe records a value into histogram.
+func (h *metricsHistogram) observe(value float64) {
+	for i, bound := range metricsDurationBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// MetricsRegistry describes an in-process metrics collector that can be exposed in Prometheus
+// text format.
+type MetricsRegistry struct {
+	grants             map[string]float64
+	validationFailures map[string]float64
+	validations        *metricsHistogram
+	storeCalls         map[string]*metricsHistogram
+	mutex              sync.Mutex
+}
+
+// CreateMetricsRegistry returns a default MetricsRegistry's instance.
+//
+// @return
+// - registry {MetricsRegistry} (a metrics registry's instance)
+func CreateMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		grants:             make(map[string]float64),
+		validationFailures: make(map[string]float64),
+		validations:        createMetricsHistogram(),
+		storeCalls:         make(map[string]*metricsHistogram),
+	}
+}
+
+// ObserveGrant counts a token grant.
+//
+// @param
+// - grantType {string} (requested grant type)
+// - outcome {string} (either success or failure)
+func (m *MetricsRegistry) ObserveGrant(grantType string, outcome string) {
+	if len(grantType) == 0 {
+		grantType = "unknown"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.grants[fmt.Sprintf(`grant_type="%s",outcome="%s"`, escapeLabel(grantType), escapeLabel(outcome))]++
+}
+
+// ObserveValidationFailure counts a rejected request.
+//
+// @param
+// - reason {string} (failure's reason)
+func (m *MetricsRegistry) ObserveValidationFailure(reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.validationFailures[fmt.Sprintf(`reason="%s"`, escapeLabel(reason))]++
+}
+
+// ObserveValidation records token validation's latency.
+//
+// @param
+// - duration {time.Duration} (time spent in ValidateToken)
+func (m *MetricsRegistry) ObserveValidation(duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.validations.observe(duration.Seconds())
+}
+
+// ObserveStoreCall records store call's latency.
+//
+// @param
+// - method {string} (store's method name)
+// - duration {time.Duration} (time spent in store)
+func (m *MetricsRegistry) ObserveStoreCall(method string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	histogram := m.storeCalls[method]
+	if histogram == nil {
+		histogram = createMetricsHistogram()
+		m.storeCalls[method] = histogram
+	}
+	histogram.observe(duration.Seconds())
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format. Active tokens are counted in
+// the default server's token store.
+//
+// @param
+// - w {io.Writer} (output's writer)
+//
+// @return
+// - n {int64} (number of written bytes)
+// - err {error} (writer's error)
+func (m *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	return m.write(w, Store)
+}
+
+// write writes all metrics in Prometheus text exposition format.
+//
+// @param
+// - w {io.Writer} (output's writer)
+// - tokenStore {TokenStore} (the token store whose active tokens are counted, might be null)
+//
+// @return
+// - n {int64} (number of written bytes)
+// - err {error} (writer's error)
+func (m *MetricsRegistry) write(w io.Writer, tokenStore TokenStore) (int64, error) {
+	buffer := new(bytes.Buffer)
+
+	// Active tokens are counted outside of lock, it might hit database
+	if counter, ok := unwrapStore(tokenStore).(ActiveTokenCounter); ok {
+		fmt.Fprint(buffer, "# HELP oauth2_active_tokens Number of unexpired access tokens.\n")
+		fmt.Fprint(buffer, "# TYPE oauth2_active_tokens gauge\n")
+		fmt.Fprintf(buffer, "oauth2_active_tokens %d\n", counter.CountActiveTokens())
+	}
+
+	m.mutex.Lock()
+	writeMetricsCounter(buffer, "oauth2_grants_total", "Number of token grants by type and outcome.", m.grants)
+	writeMetricsCounter(buffer, "oauth2_validation_failures_total", "Number of rejected requests by reason.", m.validationFailures)
+
+	fmt.Fprint(buffer, "# HELP oauth2_validate_token_duration_seconds Latency of token validation.\n")
+	fmt.Fprint(buffer, "# TYPE oauth2_validate_token_duration_seconds histogram\n")
+	writeMetricsHistogram(buffer, "oauth2_validate_token_duration_seconds", "", m.validations)
+
+	fmt.Fprint(buffer, "# HELP oauth2_store_call_duration_seconds Latency of token store calls.\n")
+	fmt.Fprint(buffer, "# TYPE oauth2_store_call_duration_seconds histogram\n")
+	for _, method := range sortedKeys(m.storeCalls) {
+		writeMetricsHistogram(buffer, "oauth2_store_call_duration_seconds", fmt.Sprintf(`method="%s"`, escapeLabel(method)), m.storeCalls[method])
+	}
+	m.mutex.Unlock()
+
+	return buffer.WriteTo(w)
+}
+
+// bindMetricsRoute binds metrics endpoint. It is restricted to administrators, so scrapers must
+// present an administrator's token, unless policy manifest defines another policy for it.
+//
+// @param
+// - bind {func} (go-server's bind func)
+func bindMetricsRoute(bind func(string, server.HandleContextFunc)) {
+	bindRoute("GET", "/metrics", []string{oauthRole.Admin}, nil, Metrics.HandleMetrics, bind)
+}
+
+// HandleMetrics outputs all metrics in Prometheus text exposition format. Active tokens are
+// counted in request's realm.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (m *MetricsRegistry) HandleMetrics(c *server.RequestContext) {
+	buffer := new(bytes.Buffer)
+	m.write(buffer, RealmOf(c).Store)
+
+	c.OutputHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.OutputText(util.Status200(), buffer.String())
+}
+
+// createMetricsHistogram returns an empty histogram.
+func createMetricsHistogram() *metricsHistogram {
+	return &metricsHistogram{counts: make([]uint64, len(metricsDurationBuckets))}
+}
+
+// writeMetricsCounter writes a labelled counter.
+func writeMetricsCounter(w io.Writer, name string, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s} %g\n", name, label, values[label])
+	}
+}
+
+// writeMetricsHistogram writes histogram's buckets, sum and count.
+func writeMetricsHistogram(w io.Writer, name string, labels string, h *metricsHistogram) {
+	prefix := labels
+	if len(prefix) > 0 {
+		prefix += ","
+	}
+
+	for i, bound := range metricsDurationBuckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, prefix, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+
+	if len(labels) > 0 {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// sortedKeys returns histograms' keys in alphabetical order.
+func sortedKeys(histograms map[string]*metricsHistogram) []string {
+	keys := make([]string, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeLabel escapes a Prometheus label's value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
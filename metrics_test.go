@@ -0,0 +1,95 @@
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_MetricsRegistry_WriteTo(t *testing.T) {
+	metrics := CreateMetricsRegistry()
+	metrics.ObserveGrant(PasswordGrant, FailureOutcome)
+	metrics.ObserveGrant(PasswordGrant, FailureOutcome)
+	metrics.ObserveGrant(RefreshTokenGrant, SuccessOutcome)
+	metrics.ObserveValidationFailure(ExpiredTokenReason)
+	metrics.ObserveValidation(3 * time.Millisecond)
+	metrics.ObserveStoreCall("FindAccessToken", 20*time.Millisecond)
+
+	buffer := new(bytes.Buffer)
+	metrics.WriteTo(buffer)
+	output := buffer.String()
+
+	for _, line := range []string{
+		`oauth2_grants_total{grant_type="password",outcome="failure"} 2`,
+		`oauth2_grants_total{grant_type="refresh_token",outcome="success"} 1`,
+		`oauth2_validation_failures_total{reason="expired_token"} 1`,
+		`oauth2_validate_token_duration_seconds_bucket{le="0.0025"} 0`,
+		`oauth2_validate_token_duration_seconds_bucket{le="0.005"} 1`,
+		`oauth2_validate_token_duration_seconds_count 1`,
+		`oauth2_store_call_duration_seconds_bucket{method="FindAccessToken",le="0.025"} 1`,
+		`oauth2_store_call_duration_seconds_count{method="FindAccessToken"} 1`,
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf(expectedFormat.StringButFoundString, line, output)
+		}
+	}
+}
+
+func Test_InstrumentedStore(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	metrics := CreateMetricsRegistry()
+	tokenStore := CreateInstrumentedStore(Store, metrics)
+
+	if user := tokenStore.FindUserWithID(u.UserID.Hex()); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if _, ok := unwrapStore(tokenStore).(AdminStore); !ok {
+		t.Error("Expected instrumented store should keep admin capability.")
+	}
+
+	buffer := new(bytes.Buffer)
+	metrics.WriteTo(buffer)
+	if line := `oauth2_store_call_duration_seconds_count{method="FindUserWithID"} 1`; !strings.Contains(buffer.String(), line) {
+		t.Errorf(expectedFormat.StringButFoundString, line, buffer.String())
+	}
+}
+
+func Test_bindMetricsRoute(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	boundRoutes = nil
+	defer func() { boundRoutes = nil }()
+
+	var handler server.HandleContextFunc
+	bindMetricsRoute(func(patternURL string, f server.HandleContextFunc) {
+		handler = f
+	})
+
+	if table := PolicyTable(); len(table) != 1 || table[0].Path != "/metrics" || len(table[0].Roles) != 1 || table[0].Roles[0] != oauthRole.Admin {
+		t.Error("Expected metrics should be restricted to administrators.")
+	}
+
+	// Anonymous scraper is rejected
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		handler(context)
+	}))
+	defer ts.Close()
+
+	if response, _ := http.Get(ts.URL); response.StatusCode != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, response.StatusCode)
+	}
+}
@@ -0,0 +1,121 @@
+package oauth2
+
+import (
+	"sync"
+
+	"github.com/phuc0302/go-mongo"
+	"github.com/phuc0302/go-oauth2/oauth_table"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Maximum number of retries when another process appended a record at the same time.
+const mongoAuditMaxRetries = 5
+
+// MongoDBAuditStore describes a mongodb audit log's storage. Record's sequence is used as
+// document's ID, so concurrent appends from different processes cannot fork the chain.
+type MongoDBAuditStore struct {
+	mutex sync.Mutex
+}
+
+// CreateMongoDBAuditStore returns a default MongoDBAuditStore's instance.
+//
+// @return
+// - auditStore {AuditStore} (a mongoDB audit store's instance)
+func CreateMongoDBAuditStore() AuditStore {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	collection := database.C(oauthTable.AuditLog)
+	collection.EnsureIndexKey("user_id", "time")
+	collection.EnsureIndexKey("client_id", "time")
+	collection.EnsureIndexKey("type", "time")
+	collection.EnsureIndexKey("realm", "time")
+	return new(MongoDBAuditStore)
+}
+
+// AppendRecord appends a security event to the end of audit chain.
+//
+// @param
+// - event {SecurityEvent} (a security event)
+//
+// @return
+// - record {AuditRecord} (an audit record or null)
+func (d *MongoDBAuditStore) AppendRecord(event SecurityEvent) *AuditRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	collection := database.C(oauthTable.AuditLog)
+	for i := 0; i < mongoAuditMaxRetries; i++ {
+		var previous *AuditRecord
+		last := new(AuditRecord)
+		if err := collection.Find(nil).Sort("-_id").One(last); err == nil {
+			previous = last
+		} else if err != mgo.ErrNotFound {
+			return nil
+		}
+
+		record := createAuditRecord(previous, event)
+		err := collection.Insert(record)
+		if err == nil {
+			return record
+		}
+		if !mgo.IsDup(err) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// FindRecords returns a page of audit records in chain's order.
+//
+// @param
+// - filter {AuditFilter} (records' filter, null will return all records)
+// - offset {int} (number of records to skip)
+// - limit {int} (maximum number of records to return)
+//
+// @return
+// - records {[]AuditRecord} (a list of audit records)
+// - total {int} (total number of matched audit records)
+func (d *MongoDBAuditStore) FindRecords(filter *AuditFilter, offset int, limit int) ([]*AuditRecord, int) {
+	criteria := bson.M{}
+	if filter != nil {
+		if len(filter.Realm) > 0 {
+			criteria["realm"] = filter.Realm
+		}
+		if len(filter.UserID) > 0 {
+			criteria["user_id"] = filter.UserID
+		}
+		if len(filter.ClientID) > 0 {
+			criteria["client_id"] = filter.ClientID
+		}
+		if len(filter.Type) > 0 {
+			criteria["type"] = filter.Type
+		}
+
+		timeRange := bson.M{}
+		if !filter.From.IsZero() {
+			timeRange["$gte"] = filter.From
+		}
+		if !filter.To.IsZero() {
+			timeRange["$lt"] = filter.To
+		}
+		if len(timeRange) > 0 {
+			criteria["time"] = timeRange
+		}
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	var records []*AuditRecord
+	query := database.C(oauthTable.AuditLog).Find(criteria).Sort("_id")
+	total, _ := query.Count()
+	if err := query.Skip(offset).Limit(limit).All(&records); err != nil {
+		return nil, 0
+	}
+	return records, total
+}
@@ -0,0 +1,56 @@
+package oauth2
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoDBAuthorizationCode describes a mongodb authorization code.
+type MongoDBAuthorizationCode struct {
+	ID       string        `bson:"_id"`
+	User     bson.ObjectId `bson:"user_id,omitempty"`
+	Client   string        `bson:"client_id,omitempty"`
+	Redirect string        `bson:"redirect_uri,omitempty"`
+	Scopes   []string      `bson:"scopes,omitempty"`
+	Expired  time.Time     `bson:"expired_time,omitempty"`
+}
+
+// ClientID returns client_id.
+func (a *MongoDBAuthorizationCode) ClientID() string {
+	return a.Client
+}
+
+// UserID returns user_id.
+func (a *MongoDBAuthorizationCode) UserID() string {
+	return a.User.Hex()
+}
+
+// RedirectURI returns redirect_uri.
+func (a *MongoDBAuthorizationCode) RedirectURI() string {
+	return a.Redirect
+}
+
+// CodeScopes returns consented scopes.
+func (a *MongoDBAuthorizationCode) CodeScopes() []string {
+	return a.Scopes
+}
+
+// IsExpired validate if this code is expired or not.
+func (a *MongoDBAuthorizationCode) IsExpired() bool {
+	return time.Now().UTC().Unix() >= a.Expired.Unix()
+}
+
+// ExpiredTime returns expired_time.
+func (a *MongoDBAuthorizationCode) ExpiredTime() time.Time {
+	return a.Expired
+}
+
+// MongoDBConsent describes a mongodb user's consent to a client.
+type MongoDBConsent struct {
+	ID      string        `bson:"_id"`
+	User    bson.ObjectId `bson:"user_id"`
+	Client  string        `bson:"client_id"`
+	Scopes  []string      `bson:"scopes,omitempty"`
+	Updated time.Time     `bson:"updated_time,omitempty"`
+}
@@ -1,23 +1,46 @@
 package oauth2
 
-import "gopkg.in/mgo.v2/bson"
+import "time"
 
 // MongoDBClient describes a mongodb client.
 type MongoDBClient struct {
-	ID        bson.ObjectId `bson:"_id"`
-	Secret    bson.ObjectId `bson:"client_secret"`
-	Grants    []string      `bson:"grant_types,omitempty"`
-	Redirects []string      `bson:"redirect_uris,omitempty"`
+	ID        string                `bson:"_id"`
+	Secret    string                `bson:"client_secret,omitempty"`
+	Secrets   []MongoDBClientSecret `bson:"client_secrets,omitempty"`
+	Grants    []string              `bson:"grant_types,omitempty"`
+	Redirects []string              `bson:"redirect_uris,omitempty"`
+
+	Name              string    `bson:"client_name,omitempty"`
+	AuthMethod        string    `bson:"token_endpoint_auth_method,omitempty"`
+	SubjectDN         string    `bson:"tls_client_auth_subject_dn,omitempty"`
+	Certificates      []string  `bson:"tls_client_certificates,omitempty"`
+	RegistrationToken string    `bson:"registration_access_token,omitempty"`
+	Created           time.Time `bson:"created_time,omitempty"`
+
+	RateLimits map[string]RateLimit `bson:"rate_limits,omitempty"`
+
+	Roles      []string `bson:"roles,omitempty"`
+	Scopes     []string `bson:"scopes,omitempty"`
+	FirstParty bool     `bson:"first_party,omitempty"`
 }
 
 // ClientID returns client_id.
 func (a *MongoDBClient) ClientID() string {
-	return a.ID.Hex()
+	return a.ID
 }
 
-// ClientSecret returns client_secret.
+// ClientSecret returns hashed client_secret.
 func (a *MongoDBClient) ClientSecret() string {
-	return a.Secret.Hex()
+	if len(a.Secret) > 0 {
+		return a.Secret
+	}
+
+	for i := range a.Secrets {
+		if a.Secrets[i].Name == PrimarySecretLabel && !a.Secrets[i].IsExpired() {
+			return a.Secrets[i].Hash
+		}
+	}
+	return ""
 }
 
 // GrantTypes returns grant_types.
@@ -29,3 +52,66 @@ func (a *MongoDBClient) GrantTypes() []string {
 func (a *MongoDBClient) RedirectURIs() []string {
 	return a.Redirects
 }
+
+// ClientRateLimit returns client's rate limit for an endpoint or null.
+func (a *MongoDBClient) ClientRateLimit(endpoint string) *RateLimit {
+	if limit, ok := a.RateLimits[endpoint]; ok {
+		return &limit
+	}
+	return nil
+}
+
+// ClientName returns client_name.
+func (a *MongoDBClient) ClientName() string {
+	return a.Name
+}
+
+// IsFirstParty returns first_party.
+func (a *MongoDBClient) IsFirstParty() bool {
+	return a.FirstParty
+}
+
+// ClientRoles returns client's allowed roles or null if client is not restricted.
+func (a *MongoDBClient) ClientRoles() []string {
+	return a.Roles
+}
+
+// ClientScopes returns client's allowed scopes.
+func (a *MongoDBClient) ClientScopes() []string {
+	return a.Scopes
+}
+
+// TokenEndpointAuthMethod returns token_endpoint_auth_method.
+func (a *MongoDBClient) TokenEndpointAuthMethod() string {
+	return a.AuthMethod
+}
+
+// TLSClientAuthSubjectDN returns tls_client_auth_subject_dn.
+func (a *MongoDBClient) TLSClientAuthSubjectDN() string {
+	return a.SubjectDN
+}
+
+// TLSClientCertificates returns thumbprints of client's self-signed certificates.
+func (a *MongoDBClient) TLSClientCertificates() []string {
+	return a.Certificates
+}
+
+// verifySecret validates a plain text secret against the legacy primary secret and all
+// unexpired rotating secrets.
+//
+// @param
+// - secret {string} (a client's secret in plain text)
+//
+// @return
+// - isValid {bool} (true if the secret is accepted)
+func (a *MongoDBClient) verifySecret(secret string) bool {
+	isValid := CompareClientSecret(a.Secret, secret)
+
+	// Always go through all secrets, so the time spent does not depend on which secret matched
+	for i := range a.Secrets {
+		if CompareClientSecret(a.Secrets[i].Hash, secret) && !a.Secrets[i].IsExpired() {
+			isValid = true
+		}
+	}
+	return isValid
+}
@@ -0,0 +1,44 @@
+package oauth2
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoDBClientSecret describes a mongodb client's secret.
+type MongoDBClientSecret struct {
+	ID      bson.ObjectId `bson:"_id"`
+	Name    string        `bson:"label,omitempty"`
+	Hash    string        `bson:"secret"`
+	Created time.Time     `bson:"created_time,omitempty"`
+	Expired time.Time     `bson:"expired_time,omitempty"`
+}
+
+// SecretID returns secret's ID.
+func (s *MongoDBClientSecret) SecretID() string {
+	return s.ID.Hex()
+}
+
+// Label returns secret's label.
+func (s *MongoDBClientSecret) Label() string {
+	return s.Name
+}
+
+// IsExpired validate if this secret is expired or not.
+func (s *MongoDBClientSecret) IsExpired() bool {
+	if s.Expired.IsZero() {
+		return false
+	}
+	return time.Now().UTC().Unix() >= s.Expired.Unix()
+}
+
+// CreatedTime returns created_time.
+func (s *MongoDBClientSecret) CreatedTime() time.Time {
+	return s.Created
+}
+
+// ExpiredTime returns expired_time.
+func (s *MongoDBClientSecret) ExpiredTime() time.Time {
+	return s.Expired
+}
@@ -6,19 +6,22 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/phuc0302/go-mongo"
 	"github.com/phuc0302/go-oauth2/oauth_table"
 	"github.com/phuc0302/go-server"
-	"github.com/phuc0302/go-server/util"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 // MongoDBStore describes a mongodb token store.
 type MongoDBStore struct {
 	privateKey *rsa.PrivateKey
+	issuer     string
+	namespace  string
 }
 
 // CreateMongoDBStore return a default MongoDBStore's instance.
@@ -26,12 +29,34 @@ type MongoDBStore struct {
 // @return
 // - tokenStore {TokenStore} (a mongoDB token store's instance)
 func CreateMongoDBStore() (tokenStore TokenStore) {
+	issuer := ""
+	if Cfg != nil {
+		issuer = Cfg.Issuer
+	}
+	return CreateMongoDBRealmStore("", issuer)
+}
+
+// CreateMongoDBRealmStore return a MongoDBStore's instance of a realm. Realm's collections are
+// prefixed by namespace and realm has its own JWT key.
+//
+// @param
+// - namespace {string} (realm's name, empty string for the default realm)
+// - issuer {string} (realm's issuer, empty string if tokens do not carry issuer)
+//
+// @return
+// - tokenStore {TokenStore} (a mongoDB token store's instance)
+func CreateMongoDBRealmStore(namespace string, issuer string) (tokenStore TokenStore) {
 	if server.Cfg == nil {
 		panic("Please call server.Initialize before create store.")
 	}
 
+	keyName := "jwt_key"
+	if len(namespace) > 0 {
+		keyName = fmt.Sprintf("%s_%s", keyName, namespace)
+	}
+
 	var privateKey *rsa.PrivateKey
-	if base64Encoded, ok := server.Cfg.GetExtension("jwt_key").(string); ok {
+	if base64Encoded, ok := server.Cfg.GetExtension(keyName).(string); ok {
 		if keyDER, err := base64.StdEncoding.DecodeString(base64Encoded); err == nil {
 			privateKey, _ = x509.ParsePKCS1PrivateKey(keyDER)
 		}
@@ -44,13 +69,28 @@ func CreateMongoDBStore() (tokenStore TokenStore) {
 
 		// Save to config
 		base64Encoded := base64.StdEncoding.EncodeToString(keyDER)
-		server.Cfg.SetExtension("jwt_key", base64Encoded)
+		server.Cfg.SetExtension(keyName, base64Encoded)
 		server.Cfg.Save()
 	}
 
-	return &MongoDBStore{
+	store := &MongoDBStore{
 		privateKey: privateKey,
+		issuer:     issuer,
+		namespace:  namespace,
 	}
+	store.ensureIndexes()
+	store.migrateLegacyClients()
+	store.migrateClientSecrets()
+	return store
+}
+
+// ensureIndexes creates store's indexes. Usernames are unique, users without username (e.g.
+// legacy machine users) are not indexed.
+func (d *MongoDBStore) ensureIndexes() {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	database.C(d.table(oauthTable.User)).EnsureIndex(mgo.Index{Key: []string{"username"}, Unique: true, Sparse: true})
 }
 
 // FindUserWithID returns an user entity according to userID or null. A user entity can either
@@ -68,7 +108,7 @@ func (d *MongoDBStore) FindUserWithID(userID string) User {
 	}
 
 	user := new(MongoDBUser)
-	if err := mongo.EntityWithID(oauthTable.User, bson.ObjectIdHex(userID), user); err == nil {
+	if err := mongo.EntityWithID(d.table(oauthTable.User), bson.ObjectIdHex(userID), user); err == nil {
 		return user
 	}
 	return nil
@@ -88,8 +128,41 @@ func (d *MongoDBStore) FindUserWithClient(clientID string, clientSecret string)
 		return nil
 	}
 
-	user := new(MongoDBUser)
-	if err := mongo.EntityWithID(oauthTable.User, bson.ObjectIdHex(clientID), user); err == nil && util.ComparePassword(user.Pass, clientSecret) {
+	user := d.findMachineUser(clientID)
+	if user == nil {
+		return nil
+	}
+
+	// Machine users of registered clients do not have password, they share their client's secrets
+	if len(user.Pass) == 0 {
+		if d.FindClientWithCredential(clientID, clientSecret) == nil {
+			return nil
+		}
+		return user
+	}
+
+	if !comparePassword(user.Pass, clientSecret) {
+		return nil
+	}
+	d.rehashPassword(user, clientSecret)
+	return user
+}
+
+// FindUserWithClientID returns the machine user of a client that had authenticated without
+// client_secret.
+//
+// @param
+// - clientID {string} (client's client_id)
+//
+// @return
+// - user {User} (a machine user entity or null)
+func (d *MongoDBStore) FindUserWithClientID(clientID string) User {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return nil
+	}
+
+	if user := d.findMachineUser(clientID); user != nil {
 		return user
 	}
 	return nil
@@ -110,12 +183,120 @@ func (d *MongoDBStore) FindUserWithCredential(username string, password string)
 	}
 
 	user := new(MongoDBUser)
-	if err := mongo.EntityWithCriteria(oauthTable.User, bson.M{"username": username}, user); err == nil && util.ComparePassword(user.Pass, password) {
+	if err := mongo.EntityWithCriteria(d.table(oauthTable.User), bson.M{"username": username}, user); err != nil || !comparePassword(user.Pass, password) {
+		return nil
+	}
+
+	d.rehashPassword(user, password)
+	return user
+}
+
+// findMachineUser returns the machine user of a client. Machine users are named after their
+// client, machine users that were created before client IDs became free-form share their
+// client's ObjectId instead.
+//
+// @param
+// - clientID {string} (client's client_id)
+//
+// @return
+// - user {MongoDBUser} (a machine user entity or null)
+func (d *MongoDBStore) findMachineUser(clientID string) *MongoDBUser {
+	user := new(MongoDBUser)
+	if err := mongo.EntityWithCriteria(d.table(oauthTable.User), bson.M{"username": clientID}, user); err == nil {
 		return user
 	}
+
+	// Legacy machine user
+	if bson.IsObjectIdHex(clientID) {
+		if err := mongo.EntityWithID(d.table(oauthTable.User), bson.ObjectIdHex(clientID), user); err == nil {
+			return user
+		}
+	}
 	return nil
 }
 
+// ensureMachineUser creates a passwordless machine user for a registered client that may use
+// client_credentials grant.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - grantTypes {[]string} (client's grant types)
+func (d *MongoDBStore) ensureMachineUser(clientID string, grantTypes []string) {
+	/* Condition validation */
+	if !containsString(grantTypes, ClientCredentialsGrant) {
+		return
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	update := bson.M{"$setOnInsert": bson.M{"_id": bson.NewObjectId(), "username": clientID}}
+	database.C(d.table(oauthTable.User)).Upsert(bson.M{"username": clientID}, update)
+}
+
+// rehashPassword upgrades password's hash of an authenticated user if it is weaker than current
+// policy.
+//
+// @param
+// - user {MongoDBUser} (an authenticated user entity)
+// - password {string} (user's password in plain text)
+func (d *MongoDBStore) rehashPassword(user *MongoDBUser, password string) {
+	/* Condition validation */
+	if !Hasher.NeedsRehash(user.Pass) {
+		return
+	}
+
+	hashedPassword, err := Hasher.Hash(password)
+	if err != nil {
+		return
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	if err := database.C(d.table(oauthTable.User)).UpdateId(user.ID, bson.M{"$set": bson.M{"password": hashedPassword}}); err == nil {
+		user.Pass = hashedPassword
+	}
+}
+
+// migrateLegacyClients re-keys clients that were created before client IDs became free-form.
+// Their _id and client_secret were ObjectIds, so the secret can be hashed without client's help.
+// Client's tokens are re-keyed as well.
+func (d *MongoDBStore) migrateLegacyClients() {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	// Legacy clients' _id has BSON type 7, ObjectId
+	collection := database.C(d.table(oauthTable.Client))
+	var records []bson.M
+	if err := collection.Find(bson.M{"_id": bson.M{"$type": 7}}).All(&records); err != nil {
+		return
+	}
+
+	for _, record := range records {
+		legacyID, ok := record["_id"].(bson.ObjectId)
+		if !ok {
+			continue
+		}
+		clientID := legacyID.Hex()
+
+		record["_id"] = clientID
+		if secret, ok := record["client_secret"].(bson.ObjectId); ok {
+			record["client_secret"] = HashClientSecret(secret.Hex())
+		}
+
+		// Another process may have migrated the same client
+		if err := collection.Insert(record); err != nil && !mgo.IsDup(err) {
+			continue
+		}
+		collection.RemoveId(legacyID)
+
+		for _, table := range []string{oauthTable.AccessToken, oauthTable.RefreshToken} {
+			database.C(d.table(table)).UpdateAll(bson.M{"client_id": legacyID}, bson.M{"$set": bson.M{"client_id": clientID}})
+		}
+	}
+}
+
 // FindClientWithID returns a client entity according to clientID or null.
 //
 // @param
@@ -125,12 +306,12 @@ func (d *MongoDBStore) FindUserWithCredential(username string, password string)
 // - client {Client} (a client entity or null)
 func (d *MongoDBStore) FindClientWithID(clientID string) Client {
 	/* Condition validation */
-	if len(clientID) == 0 || !bson.IsObjectIdHex(clientID) {
+	if len(clientID) == 0 {
 		return nil
 	}
 
 	client := new(MongoDBClient)
-	if err := mongo.EntityWithID(oauthTable.Client, bson.ObjectIdHex(clientID), client); err == nil {
+	if err := mongo.EntityWithID(d.table(oauthTable.Client), clientID, client); err == nil {
 		return client
 	}
 	return nil
@@ -147,17 +328,321 @@ func (d *MongoDBStore) FindClientWithID(clientID string) Client {
 // - client {Client} (a client entity or null)
 func (d *MongoDBStore) FindClientWithCredential(clientID string, clientSecret string) Client {
 	/* Condition validation */
-	if len(clientID) == 0 || len(clientSecret) == 0 || !bson.IsObjectIdHex(clientID) || !bson.IsObjectIdHex(clientSecret) {
+	if len(clientID) == 0 || len(clientSecret) == 0 {
+		return nil
+	}
+
+	client := new(MongoDBClient)
+	if err := mongo.EntityWithID(d.table(oauthTable.Client), clientID, client); err != nil || !client.verifySecret(clientSecret) {
+		return nil
+	}
+
+	// Client may have been created by a process that still stores legacy primary secret
+	d.migrateClientSecret(client)
+	return client
+}
+
+// AddClientSecret generates a new secret for a client.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - label {string} (a human readable label for this secret)
+// - expiredTime {time.Time} (secret's expired time, zero time means never expires)
+//
+// @return
+// - secret {string} (client's secret in plain text, it will not be available again)
+// - record {ClientSecret} (secret's metadata or null)
+func (d *MongoDBStore) AddClientSecret(clientID string, label string, expiredTime time.Time) (string, ClientSecret) {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return "", nil
+	}
+
+	secret, err := GenerateClientSecret()
+	if err != nil {
+		return "", nil
+	}
+
+	record := &MongoDBClientSecret{
+		ID:      bson.NewObjectId(),
+		Name:    label,
+		Hash:    HashClientSecret(secret),
+		Created: time.Now().UTC(),
+	}
+	if !expiredTime.IsZero() {
+		record.Expired = expiredTime.UTC()
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	if err := database.C(d.table(oauthTable.Client)).UpdateId(clientID, bson.M{"$push": bson.M{"client_secrets": record}}); err != nil {
+		return "", nil
+	}
+	return secret, record
+}
+
+// FindClientSecrets returns all secrets' metadata of a client.
+//
+// @param
+// - clientID {string} (client's client_id)
+//
+// @return
+// - secrets {[]ClientSecret} (a list of secrets' metadata)
+func (d *MongoDBStore) FindClientSecrets(clientID string) []ClientSecret {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return nil
+	}
+
+	client := new(MongoDBClient)
+	if err := mongo.EntityWithID(d.table(oauthTable.Client), clientID, client); err != nil {
 		return nil
 	}
+	d.migrateClientSecret(client)
+
+	secrets := make([]ClientSecret, len(client.Secrets))
+	for i := range client.Secrets {
+		secrets[i] = &client.Secrets[i]
+	}
+	return secrets
+}
+
+// RetireClientSecret expires a client's secret immediately.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - secretID {string} (secret's ID)
+//
+// @return
+// - isRetired {bool} (true if the secret had been retired)
+func (d *MongoDBStore) RetireClientSecret(clientID string, secretID string) bool {
+	/* Condition validation */
+	if len(clientID) == 0 || len(secretID) == 0 || !bson.IsObjectIdHex(secretID) {
+		return false
+	}
 
 	client := new(MongoDBClient)
-	if err := mongo.EntityWithCriteria(oauthTable.Client, bson.M{"_id": bson.ObjectIdHex(clientID), "client_secret": bson.ObjectIdHex(clientSecret)}, client); err == nil {
+	if err := mongo.EntityWithID(d.table(oauthTable.Client), clientID, client); err != nil {
+		return false
+	}
+	d.migrateClientSecret(client)
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	selector := bson.M{"_id": clientID, "client_secrets._id": bson.ObjectIdHex(secretID)}
+	update := bson.M{"$set": bson.M{"client_secrets.$.expired_time": time.Now().UTC()}}
+	return database.C(d.table(oauthTable.Client)).Update(selector, update) == nil
+}
+
+// migrateClientSecrets moves legacy primary secrets of all clients into client_secrets, so
+// rotation and verification see the same secrets.
+func (d *MongoDBStore) migrateClientSecrets() {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	var clients []MongoDBClient
+	if err := database.C(d.table(oauthTable.Client)).Find(bson.M{"client_secret": bson.M{"$exists": true}}).All(&clients); err != nil {
+		return
+	}
+	for i := range clients {
+		d.migrateClientSecret(&clients[i])
+	}
+}
+
+// migrateClientSecret moves client's legacy primary secret into client_secrets, so it can be
+// listed and retired like any other secret.
+//
+// @param
+// - client {*MongoDBClient} (a client entity)
+func (d *MongoDBStore) migrateClientSecret(client *MongoDBClient) {
+	/* Condition validation */
+	if len(client.Secret) == 0 {
+		return
+	}
+
+	record := MongoDBClientSecret{
+		ID:      bson.NewObjectId(),
+		Name:    PrimarySecretLabel,
+		Hash:    client.Secret,
+		Created: client.Created,
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	// Match the legacy secret as well, so concurrent migrations do not push it twice
+	selector := bson.M{"_id": client.ID, "client_secret": client.Secret}
+	update := bson.M{"$push": bson.M{"client_secrets": record}, "$unset": bson.M{"client_secret": ""}}
+	if err := database.C(d.table(oauthTable.Client)).Update(selector, update); err != nil {
+		// Someone else had migrated it, reload the client
+		reloaded := new(MongoDBClient)
+		if err := mongo.EntityWithID(d.table(oauthTable.Client), client.ID, reloaded); err == nil {
+			*client = *reloaded
+		}
+		return
+	}
+	client.Secret = ""
+	client.Secrets = append(client.Secrets, record)
+}
+
+// CreateClient creates a new client's instance.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - clientSecret {string} (client's client_secret in plain text)
+// - registrationToken {string} (registration access token in plain text)
+// - metadata {ClientMetadata} (client's registered metadata)
+//
+// @return
+// - client {Client} (a client entity or null)
+func (d *MongoDBStore) CreateClient(clientID string, clientSecret string, registrationToken string, metadata *ClientMetadata) Client {
+	/* Condition validation */
+	if len(clientID) == 0 || metadata == nil {
+		return nil
+	}
+
+	client := &MongoDBClient{
+		ID:         clientID,
+		Grants:     metadata.GrantTypes,
+		Redirects:  metadata.RedirectURIs,
+		Name:       metadata.ClientName,
+		AuthMethod: metadata.TokenEndpointAuthMethod,
+		Created:    time.Now().UTC(),
+
+		SubjectDN:    metadata.TLSClientAuthSubjectDN,
+		Certificates: metadata.TLSClientCertificates,
+	}
+	if len(clientSecret) > 0 {
+		client.Secrets = []MongoDBClientSecret{{
+			ID:      bson.NewObjectId(),
+			Name:    PrimarySecretLabel,
+			Hash:    HashClientSecret(clientSecret),
+			Created: client.Created,
+		}}
+	}
+	if len(registrationToken) > 0 {
+		client.RegistrationToken = HashClientSecret(registrationToken)
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	if err := database.C(d.table(oauthTable.Client)).Insert(client); err != nil {
+		return nil
+	}
+	d.ensureMachineUser(clientID, metadata.GrantTypes)
+	return client
+}
+
+// FindClientWithRegistrationToken returns a client entity according to clientID and
+// registration access token or null.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - registrationToken {string} (registration access token in plain text)
+//
+// @return
+// - client {Client} (a client entity or null)
+func (d *MongoDBStore) FindClientWithRegistrationToken(clientID string, registrationToken string) Client {
+	/* Condition validation */
+	if len(clientID) == 0 || len(registrationToken) == 0 {
+		return nil
+	}
+
+	client := new(MongoDBClient)
+	if err := mongo.EntityWithID(d.table(oauthTable.Client), clientID, client); err == nil && CompareClientSecret(client.RegistrationToken, registrationToken) {
 		return client
 	}
 	return nil
 }
 
+// FindClientMetadata returns client's registered metadata or null.
+//
+// @param
+// - clientID {string} (client's client_id)
+//
+// @return
+// - metadata {ClientMetadata} (client's registered metadata or null)
+func (d *MongoDBStore) FindClientMetadata(clientID string) *ClientMetadata {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return nil
+	}
+
+	client := new(MongoDBClient)
+	if err := mongo.EntityWithID(d.table(oauthTable.Client), clientID, client); err != nil {
+		return nil
+	}
+
+	return &ClientMetadata{
+		ClientName:              client.Name,
+		RedirectURIs:            client.Redirects,
+		GrantTypes:              client.Grants,
+		TokenEndpointAuthMethod: client.AuthMethod,
+		TLSClientAuthSubjectDN:  client.SubjectDN,
+		TLSClientCertificates:   client.Certificates,
+	}
+}
+
+// UpdateClientMetadata replaces client's registered metadata.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - metadata {ClientMetadata} (client's new metadata)
+//
+// @return
+// - isUpdated {bool} (true if client had been updated)
+func (d *MongoDBStore) UpdateClientMetadata(clientID string, metadata *ClientMetadata) bool {
+	/* Condition validation */
+	if len(clientID) == 0 || metadata == nil {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	update := bson.M{"$set": bson.M{
+		"client_name":                metadata.ClientName,
+		"redirect_uris":              metadata.RedirectURIs,
+		"grant_types":                metadata.GrantTypes,
+		"token_endpoint_auth_method": metadata.TokenEndpointAuthMethod,
+		"tls_client_auth_subject_dn": metadata.TLSClientAuthSubjectDN,
+		"tls_client_certificates":    metadata.TLSClientCertificates,
+	}}
+	if err := database.C(d.table(oauthTable.Client)).UpdateId(clientID, update); err != nil {
+		return false
+	}
+	d.ensureMachineUser(clientID, metadata.GrantTypes)
+	return true
+}
+
+// DeleteClient deletes a client and all of its tokens from database.
+//
+// @param
+// - clientID {string} (client's client_id)
+//
+// @return
+// - isDeleted {bool} (true if client had been deleted)
+func (d *MongoDBStore) DeleteClient(clientID string) bool {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	if err := database.C(d.table(oauthTable.Client)).RemoveId(clientID); err != nil {
+		return false
+	}
+	database.C(d.table(oauthTable.AccessToken)).RemoveAll(bson.M{"client_id": clientID})
+	database.C(d.table(oauthTable.RefreshToken)).RemoveAll(bson.M{"client_id": clientID})
+	database.C(d.table(oauthTable.User)).RemoveAll(bson.M{"username": clientID, "password": bson.M{"$exists": false}})
+	return true
+}
+
 // FindAccessToken returns an access token entity according to token string or null.
 //
 // @param
@@ -166,7 +651,7 @@ func (d *MongoDBStore) FindClientWithCredential(clientID string, clientSecret st
 // @return
 // - token {Token} (a token's instance or null)
 func (d *MongoDBStore) FindAccessToken(token string) Token {
-	return d.parseToken(token)
+	return d.findToken(d.table(oauthTable.AccessToken), token)
 }
 
 // FindAccessTokenWithCredential returns an access token entity according to clientID and
@@ -179,7 +664,7 @@ func (d *MongoDBStore) FindAccessToken(token string) Token {
 // @return
 // - token {Token} (a token's instance or null)
 func (d *MongoDBStore) FindAccessTokenWithCredential(clientID string, userID string) Token {
-	return d.queryTokenWithCredential(oauthTable.AccessToken, clientID, userID)
+	return d.queryTokenWithCredential(d.table(oauthTable.AccessToken), clientID, userID)
 }
 
 // CreateAccessToken creates a token's instance.
@@ -193,7 +678,54 @@ func (d *MongoDBStore) FindAccessTokenWithCredential(clientID string, userID str
 // @return
 // - token {Token} (an access token's instance)
 func (d *MongoDBStore) CreateAccessToken(clientID string, userID string, createdTime time.Time, expiredTime time.Time) Token {
-	return d.createToken(oauthTable.AccessToken, clientID, userID, createdTime, expiredTime)
+	return d.createToken(d.table(oauthTable.AccessToken), clientID, userID, nil, tokenBinding{}, createdTime, expiredTime)
+}
+
+// CreateScopedAccessToken creates an access token's instance that carries scopes.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - userID {string} (userID that associated with user's entity)
+// - scopes {[]string} (token's scopes)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (a token's instance)
+func (d *MongoDBStore) CreateScopedAccessToken(clientID string, userID string, scopes []string, createdTime time.Time, expiredTime time.Time) Token {
+	return d.createToken(d.table(oauthTable.AccessToken), clientID, userID, scopes, tokenBinding{}, createdTime, expiredTime)
+}
+
+// CreateBoundAccessToken creates an access token's instance that is bound to a certificate.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - userID {string} (userID that associated with user's entity)
+// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+// - thumbprint {string} (SHA-256 thumbprint of client's certificate)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (a token's instance)
+func (d *MongoDBStore) CreateBoundAccessToken(clientID string, userID string, scopes []string, thumbprint string, createdTime time.Time, expiredTime time.Time) Token {
+	return d.createToken(d.table(oauthTable.AccessToken), clientID, userID, scopes, tokenBinding{certificateThumbprint: thumbprint}, createdTime, expiredTime)
+}
+
+// CreateDPoPAccessToken creates an access token's instance that is bound to a DPoP key.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - userID {string} (userID that associated with user's entity)
+// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+// - keyThumbprint {string} (JWK SHA-256 thumbprint of client's DPoP key)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (a token's instance)
+func (d *MongoDBStore) CreateDPoPAccessToken(clientID string, userID string, scopes []string, keyThumbprint string, createdTime time.Time, expiredTime time.Time) Token {
+	return d.createToken(d.table(oauthTable.AccessToken), clientID, userID, scopes, tokenBinding{keyThumbprint: keyThumbprint}, createdTime, expiredTime)
 }
 
 // DeleteAccessToken deletes an access token from database.
@@ -201,7 +733,7 @@ func (d *MongoDBStore) CreateAccessToken(clientID string, userID string, created
 // @param
 // - token {Token} (an access token's instance)
 func (d *MongoDBStore) DeleteAccessToken(token Token) {
-	d.deleteToken(oauthTable.AccessToken, token)
+	d.deleteToken(d.table(oauthTable.AccessToken), token)
 }
 
 // FindRefreshToken returns a refresh token entity according to token string or null.
@@ -212,7 +744,7 @@ func (d *MongoDBStore) DeleteAccessToken(token Token) {
 // @return
 // - token {Token} (a token's instance or null)
 func (d *MongoDBStore) FindRefreshToken(token string) Token {
-	return d.parseToken(token)
+	return d.findToken(d.table(oauthTable.RefreshToken), token)
 }
 
 // FindRefreshTokenWithCredential returns a refresh token entity according to clientID and
@@ -225,7 +757,7 @@ func (d *MongoDBStore) FindRefreshToken(token string) Token {
 // @return
 // - token {Token} (a token's instance or null)
 func (d *MongoDBStore) FindRefreshTokenWithCredential(clientID string, userID string) Token {
-	return d.queryTokenWithCredential(oauthTable.RefreshToken, clientID, userID)
+	return d.queryTokenWithCredential(d.table(oauthTable.RefreshToken), clientID, userID)
 }
 
 // CreateRefreshToken creates a token's instance.
@@ -239,7 +771,38 @@ func (d *MongoDBStore) FindRefreshTokenWithCredential(clientID string, userID st
 // @return
 // - token {Token} (a refresh token's instance)
 func (d *MongoDBStore) CreateRefreshToken(clientID string, userID string, createdTime time.Time, expiredTime time.Time) Token {
-	return d.createToken(oauthTable.RefreshToken, clientID, userID, createdTime, expiredTime)
+	return d.createToken(d.table(oauthTable.RefreshToken), clientID, userID, nil, tokenBinding{}, createdTime, expiredTime)
+}
+
+// CreateScopedRefreshToken creates a refresh token's instance that carries scopes.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - userID {string} (userID that associated with user's entity)
+// - scopes {[]string} (token's scopes)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (a token's instance)
+func (d *MongoDBStore) CreateScopedRefreshToken(clientID string, userID string, scopes []string, createdTime time.Time, expiredTime time.Time) Token {
+	return d.createToken(d.table(oauthTable.RefreshToken), clientID, userID, scopes, tokenBinding{}, createdTime, expiredTime)
+}
+
+// CreateDPoPRefreshToken creates a refresh token's instance that is bound to a DPoP key.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - userID {string} (userID that associated with user's entity)
+// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+// - keyThumbprint {string} (JWK SHA-256 thumbprint of client's DPoP key)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (a token's instance)
+func (d *MongoDBStore) CreateDPoPRefreshToken(clientID string, userID string, scopes []string, keyThumbprint string, createdTime time.Time, expiredTime time.Time) Token {
+	return d.createToken(d.table(oauthTable.RefreshToken), clientID, userID, scopes, tokenBinding{keyThumbprint: keyThumbprint}, createdTime, expiredTime)
 }
 
 // DeleteRefreshToken deletes a refresh token from database.
@@ -247,7 +810,7 @@ func (d *MongoDBStore) CreateRefreshToken(clientID string, userID string, create
 // @param
 // - token {Token} (a refresh token's instance)
 func (d *MongoDBStore) DeleteRefreshToken(token Token) {
-	d.deleteToken(oauthTable.RefreshToken, token)
+	d.deleteToken(d.table(oauthTable.RefreshToken), token)
 }
 
 //func (d *MongoDBTokenStore) FindAuthorizationCode(authorizationCode string) {
@@ -283,6 +846,11 @@ func (d *MongoDBStore) parseToken(token string) Token {
 	}
 
 	if claims, ok := jwtToken.Claims.(jwt.MapClaims); ok {
+		/* Condition validation: Token must be issued by this store's realm */
+		if issuer, _ := claims["iss"].(string); issuer != d.issuer {
+			return nil
+		}
+
 		tokenID, _ := claims["_id"].(string)
 		userID, _ := claims["user_id"].(string)
 		clientID, _ := claims["client_id"].(string)
@@ -290,21 +858,55 @@ func (d *MongoDBStore) parseToken(token string) Token {
 		expiredTime, _ := claims["expired_time"].(string)
 		created, _ := time.Parse(time.RFC3339, createdTime)
 		expired, _ := time.Parse(time.RFC3339, expiredTime)
+		scope, _ := claims["scope"].(string)
+		confirmation, _ := claims["cnf"].(map[string]interface{})
+		thumbprint, _ := confirmation["x5t#S256"].(string)
+		keyThumbprint, _ := confirmation["jkt"].(string)
 
 		t := &MongoDBToken{
 			ID:      bson.ObjectIdHex(tokenID),
 			User:    bson.ObjectIdHex(userID),
-			Client:  bson.ObjectIdHex(clientID),
+			Client:  clientID,
 			Created: created,
 			Expired: expired,
+			Scopes:  strings.Fields(scope),
+
+			Thumbprint:    thumbprint,
+			JWKThumbprint: keyThumbprint,
 
 			privateKey: d.privateKey,
+			issuer:     d.issuer,
 		}
 		return t
 	}
 	return nil
 }
 
+// findToken returns a token entity according to token string, only if token's record is still
+// in table. Revoked tokens are deleted from table, so they are rejected even though their
+// signatures are still valid.
+//
+// @param
+// - table {string} (access token table or refresh token table)
+// - token {string} (token in string form)
+//
+// @return
+// - token {Token} (a token's instance or null)
+func (d *MongoDBStore) findToken(table string, token string) Token {
+	parsedToken, _ := d.parseToken(token).(*MongoDBToken)
+	if parsedToken == nil {
+		return nil
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	if count, err := database.C(table).FindId(parsedToken.ID).Count(); err != nil || count == 0 {
+		return nil
+	}
+	return parsedToken
+}
+
 // queryTokenWithCredential returns a token entity base on search criteria.
 //
 // @param
@@ -316,16 +918,17 @@ func (d *MongoDBStore) parseToken(token string) Token {
 // - token {Token} (a token's instance or null)
 func (d *MongoDBStore) queryTokenWithCredential(table string, clientID string, userID string) Token {
 	/* Condition validation */
-	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(clientID) || !bson.IsObjectIdHex(userID) {
+	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(userID) {
 		return nil
 	}
 
 	var token MongoDBToken
-	if err := mongo.EntityWithCriteria(table, bson.M{"user_id": bson.ObjectIdHex(userID), "client_id": bson.ObjectIdHex(clientID)}, &token); err != nil {
+	if err := mongo.EntityWithCriteria(table, bson.M{"user_id": bson.ObjectIdHex(userID), "client_id": clientID}, &token); err != nil {
 		return nil
 	}
 
 	token.privateKey = d.privateKey
+	token.issuer = d.issuer
 	return &token
 }
 
@@ -335,25 +938,37 @@ func (d *MongoDBStore) queryTokenWithCredential(table string, clientID string, u
 // - table {string} (access token table or refresh token table)
 // - clientID {string} (client's client_id)
 // - userID {string} (userID that associated with user's entity)
+// - scopes {[]string} (token's scopes, null means client's allowed scopes)
+// - binding {tokenBinding} (the keys that token is bound to, empty if unbound)
 // - createdTime {time.Time} (token's issued time)
 // - expiredTime {time.Time} (token's expired time)
 //
 // @return
 // - token {Token} (a token's instance)
-func (d *MongoDBStore) createToken(table string, clientID string, userID string, createdTime time.Time, expiredTime time.Time) Token {
+func (d *MongoDBStore) createToken(table string, clientID string, userID string, scopes []string, binding tokenBinding, createdTime time.Time, expiredTime time.Time) Token {
 	/* Condition validation */
-	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(clientID) || !bson.IsObjectIdHex(userID) {
+	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(userID) {
 		return nil
 	}
 
 	newToken := &MongoDBToken{
 		ID:      bson.NewObjectId(),
 		User:    bson.ObjectIdHex(userID),
-		Client:  bson.ObjectIdHex(clientID),
+		Client:  clientID,
 		Created: createdTime.UTC(),
 		Expired: expiredTime.UTC(),
+		Scopes:  scopes,
+
+		Thumbprint:    binding.certificateThumbprint,
+		JWKThumbprint: binding.keyThumbprint,
 
 		privateKey: d.privateKey,
+		issuer:     d.issuer,
+	}
+
+	// By default, token carries client's allowed scopes
+	if client, ok := d.FindClientWithID(clientID).(ScopedClient); ok && scopes == nil {
+		newToken.Scopes = client.ClientScopes()
 	}
 
 	if err := mongo.SaveEntity(table, newToken.ID, newToken); err == nil {
@@ -369,7 +984,7 @@ func (d *MongoDBStore) createToken(table string, clientID string, userID string,
 // - token {Token} (a token's instance)
 func (d *MongoDBStore) deleteToken(table string, token Token) {
 	/* Condition validation */
-	if token == nil || len(token.ClientID()) == 0 || len(token.UserID()) == 0 || !bson.IsObjectIdHex(token.ClientID()) || !bson.IsObjectIdHex(token.UserID()) {
+	if token == nil || len(token.ClientID()) == 0 || len(token.UserID()) == 0 || !bson.IsObjectIdHex(token.UserID()) {
 		return
 	}
 
@@ -377,7 +992,20 @@ func (d *MongoDBStore) deleteToken(table string, token Token) {
 		mongo.DeleteEntity(table, defaultToken.ID)
 	} else {
 		u := bson.ObjectIdHex(token.UserID())
-		c := bson.ObjectIdHex(token.ClientID())
-		mongo.DeleteEntityWithCriteria(table, bson.M{"user_id": u, "client_id": c})
+		mongo.DeleteEntityWithCriteria(table, bson.M{"user_id": u, "client_id": token.ClientID()})
+	}
+}
+
+// table returns collection's name in store's namespace.
+//
+// @param
+// - name {string} (collection's name)
+//
+// @return
+// - table {string} (namespaced collection's name)
+func (d *MongoDBStore) table(name string) string {
+	if len(d.namespace) == 0 {
+		return name
 	}
+	return fmt.Sprintf("%s_%s", d.namespace, name)
 }
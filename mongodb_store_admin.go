@@ -0,0 +1,341 @@
+package oauth2
+
+import (
+	"time"
+
+	"github.com/phuc0302/go-mongo"
+	"github.com/phuc0302/go-oauth2/oauth_table"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FindUsers returns a page of user entities.
+//
+// @param
+// - offset {int} (number of records to skip)
+// - limit {int} (maximum number of records to return)
+//
+// @return
+// - users {[]User} (a list of user entities)
+// - total {int} (total number of user entities)
+func (d *MongoDBStore) FindUsers(offset int, limit int) ([]User, int) {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	var records []MongoDBUser
+	query := database.C(d.table(oauthTable.User)).Find(nil).Sort("username")
+	total, _ := query.Count()
+	if err := query.Skip(offset).Limit(limit).All(&records); err != nil {
+		return nil, 0
+	}
+
+	users := make([]User, len(records))
+	for i := range records {
+		users[i] = &records[i]
+	}
+	return users, total
+}
+
+// CreateUser creates a new human user entity.
+//
+// @param
+// - username {string} (user's username, must be unique)
+// - password {string} (user's password in plain text)
+// - roles {[]string} (user's roles)
+//
+// @return
+// - user {User} (an user entity or null)
+func (d *MongoDBStore) CreateUser(username string, password string, roles []string) User {
+	/* Condition validation */
+	if len(username) == 0 || len(password) == 0 {
+		return nil
+	}
+
+	hashedPassword, err := Hasher.Hash(password)
+	if err != nil {
+		return nil
+	}
+
+	user := &MongoDBUser{
+		ID:    bson.NewObjectId(),
+		User:  username,
+		Pass:  hashedPassword,
+		Roles: roles,
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	// Username's unique index rejects duplicated username
+	if err := database.C(d.table(oauthTable.User)).Insert(user); err != nil {
+		return nil
+	}
+	return user
+}
+
+// UpdateUserRoles replaces user's roles.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+// - roles {[]string} (user's new roles)
+//
+// @return
+// - isUpdated {bool} (true if user had been updated)
+func (d *MongoDBStore) UpdateUserRoles(userID string, roles []string) bool {
+	/* Condition validation */
+	if len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	return database.C(d.table(oauthTable.User)).UpdateId(bson.ObjectIdHex(userID), bson.M{"$set": bson.M{"roles": roles}}) == nil
+}
+
+// UpdateUserPassword replaces user's password and revokes user's login sessions, access tokens
+// and refresh tokens, except the given access token and its client's refresh token.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+// - password {string} (user's new password in plain text)
+// - token {Token} (an access token's instance that should be kept or null)
+//
+// @return
+// - isUpdated {bool} (true if user had been updated)
+func (d *MongoDBStore) UpdateUserPassword(userID string, password string, token Token) bool {
+	/* Condition validation */
+	if len(userID) == 0 || len(password) == 0 || !bson.IsObjectIdHex(userID) {
+		return false
+	}
+
+	hashedPassword, err := Hasher.Hash(password)
+	if err != nil {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	if err := database.C(d.table(oauthTable.User)).UpdateId(bson.ObjectIdHex(userID), bson.M{"$set": bson.M{"password": hashedPassword}}); err != nil {
+		return false
+	}
+
+	// Tokens and login sessions that were created with old password are revoked as well
+	d.DeleteUserTokensExcept(userID, token)
+	return true
+}
+
+// DeleteUser deletes an user and all of its tokens from database.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+//
+// @return
+// - isDeleted {bool} (true if user had been deleted)
+func (d *MongoDBStore) DeleteUser(userID string) bool {
+	/* Condition validation */
+	if len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return false
+	}
+
+	if err := mongo.DeleteEntity(d.table(oauthTable.User), bson.ObjectIdHex(userID)); err != nil {
+		return false
+	}
+	d.DeleteUserTokens(userID)
+	return true
+}
+
+// FindClients returns a page of client entities.
+//
+// @param
+// - offset {int} (number of records to skip)
+// - limit {int} (maximum number of records to return)
+//
+// @return
+// - clients {[]Client} (a list of client entities)
+// - total {int} (total number of client entities)
+func (d *MongoDBStore) FindClients(offset int, limit int) ([]Client, int) {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	var records []MongoDBClient
+	query := database.C(d.table(oauthTable.Client)).Find(nil).Sort("_id")
+	total, _ := query.Count()
+	if err := query.Skip(offset).Limit(limit).All(&records); err != nil {
+		return nil, 0
+	}
+
+	clients := make([]Client, len(records))
+	for i := range records {
+		clients[i] = &records[i]
+	}
+	return clients, total
+}
+
+// UpdateClientRestrictions replaces client's allowed roles and scopes. Null roles means client
+// is not restricted. All client's tokens are deleted, so new restrictions take effect.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - roles {[]string} (client's allowed roles or null)
+// - scopes {[]string} (client's allowed scopes)
+//
+// @return
+// - isUpdated {bool} (true if client had been updated)
+func (d *MongoDBStore) UpdateClientRestrictions(clientID string, roles []string, scopes []string) bool {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	update := bson.M{"$set": bson.M{"roles": roles, "scopes": scopes}}
+	if roles == nil {
+		update = bson.M{"$set": bson.M{"scopes": scopes}, "$unset": bson.M{"roles": ""}}
+	}
+	if err := database.C(d.table(oauthTable.Client)).UpdateId(clientID, update); err != nil {
+		return false
+	}
+	database.C(d.table(oauthTable.AccessToken)).RemoveAll(bson.M{"client_id": clientID})
+	database.C(d.table(oauthTable.RefreshToken)).RemoveAll(bson.M{"client_id": clientID})
+	return true
+}
+
+// UpdateClientFirstParty marks or unmarks client as a first-party client.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - isFirstParty {bool} (true if client is operated by authorization server's owner)
+//
+// @return
+// - isUpdated {bool} (true if client had been updated)
+func (d *MongoDBStore) UpdateClientFirstParty(clientID string, isFirstParty bool) bool {
+	/* Condition validation */
+	if len(clientID) == 0 {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	return database.C(d.table(oauthTable.Client)).UpdateId(clientID, bson.M{"$set": bson.M{"first_party": isFirstParty}}) == nil
+}
+
+// FindAccessTokens returns a page of unexpired access token entities. Empty userID or clientID
+// will not be used as filter.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+// - clientID {string} (client's client_id)
+// - offset {int} (number of records to skip)
+// - limit {int} (maximum number of records to return)
+//
+// @return
+// - tokens {[]Token} (a list of access token entities)
+// - total {int} (total number of matched access token entities)
+func (d *MongoDBStore) FindAccessTokens(userID string, clientID string, offset int, limit int) ([]Token, int) {
+	criteria := bson.M{"expired_time": bson.M{"$gt": time.Now()}}
+	if len(userID) > 0 {
+		/* Condition validation */
+		if !bson.IsObjectIdHex(userID) {
+			return nil, 0
+		}
+		criteria["user_id"] = bson.ObjectIdHex(userID)
+	}
+	if len(clientID) > 0 {
+		criteria["client_id"] = clientID
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	var records []MongoDBToken
+	query := database.C(d.table(oauthTable.AccessToken)).Find(criteria).Sort("-created_time")
+	total, _ := query.Count()
+	if err := query.Skip(offset).Limit(limit).All(&records); err != nil {
+		return nil, 0
+	}
+
+	tokens := make([]Token, len(records))
+	for i := range records {
+		records[i].privateKey = d.privateKey
+		records[i].issuer = d.issuer
+		tokens[i] = &records[i]
+	}
+	return tokens, total
+}
+
+// DeleteAccessTokenWithID deletes an access token from database.
+//
+// @param
+// - tokenID {string} (access token's ID)
+//
+// @return
+// - isDeleted {bool} (true if access token had been deleted)
+func (d *MongoDBStore) DeleteAccessTokenWithID(tokenID string) bool {
+	/* Condition validation */
+	if len(tokenID) == 0 || !bson.IsObjectIdHex(tokenID) {
+		return false
+	}
+	return mongo.DeleteEntity(d.table(oauthTable.AccessToken), bson.ObjectIdHex(tokenID)) == nil
+}
+
+// DeleteUserTokens deletes all access tokens and refresh tokens of an user and revokes user's
+// login sessions.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+func (d *MongoDBStore) DeleteUserTokens(userID string) {
+	/* Condition validation */
+	if len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	criteria := bson.M{"user_id": bson.ObjectIdHex(userID)}
+	database.C(d.table(oauthTable.AccessToken)).RemoveAll(criteria)
+	database.C(d.table(oauthTable.RefreshToken)).RemoveAll(criteria)
+	database.C(d.table(oauthTable.User)).UpdateId(bson.ObjectIdHex(userID), bson.M{"$inc": bson.M{"session_version": 1}})
+}
+
+// DeleteUserTokensExcept deletes all access tokens and refresh tokens of an user, except the
+// given access token and the refresh token that belongs to the same client. User's login
+// sessions are revoked as well.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+// - token {Token} (an access token's instance that should be kept)
+func (d *MongoDBStore) DeleteUserTokensExcept(userID string, token Token) {
+	/* Condition validation */
+	if token == nil || !bson.IsObjectIdHex(tokenID(token)) {
+		d.DeleteUserTokens(userID)
+		return
+	}
+	if len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	user := bson.ObjectIdHex(userID)
+	database.C(d.table(oauthTable.AccessToken)).RemoveAll(bson.M{"user_id": user, "_id": bson.M{"$ne": bson.ObjectIdHex(tokenID(token))}})
+	database.C(d.table(oauthTable.RefreshToken)).RemoveAll(bson.M{"user_id": user, "client_id": bson.M{"$ne": token.ClientID()}})
+	database.C(d.table(oauthTable.User)).UpdateId(user, bson.M{"$inc": bson.M{"session_version": 1}})
+}
+
+// CountActiveTokens returns the number of unexpired access tokens.
+//
+// @return
+// - count {int} (number of unexpired access tokens)
+func (d *MongoDBStore) CountActiveTokens() int {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	count, _ := database.C(d.table(oauthTable.AccessToken)).Find(bson.M{"expired_time": bson.M{"$gt": time.Now()}}).Count()
+	return count
+}
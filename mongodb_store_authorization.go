@@ -0,0 +1,126 @@
+package oauth2
+
+import (
+	"time"
+
+	"github.com/phuc0302/go-mongo"
+	"github.com/phuc0302/go-oauth2/oauth_table"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CreateAuthorizationCode creates a single use authorization code.
+//
+// @param
+// - clientID {string} (client's client_id)
+// - userID {string} (userID that associated with user's entity)
+// - redirectURI {string} (the redirect URI that code is issued with)
+// - scopes {[]string} (the scopes that user had consented to)
+// - expiredTime {time.Time} (code's expired time)
+//
+// @return
+// - code {string} (authorization code in plain text or empty string)
+func (d *MongoDBStore) CreateAuthorizationCode(clientID string, userID string, redirectURI string, scopes []string, expiredTime time.Time) string {
+	/* Condition validation */
+	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return ""
+	}
+
+	code, err := GenerateClientSecret()
+	if err != nil {
+		return ""
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	record := &MongoDBAuthorizationCode{
+		ID:       HashClientSecret(code),
+		User:     bson.ObjectIdHex(userID),
+		Client:   clientID,
+		Redirect: redirectURI,
+		Scopes:   scopes,
+		Expired:  expiredTime.UTC(),
+	}
+	if err := database.C(d.table(oauthTable.AuthorizationCode)).Insert(record); err != nil {
+		return ""
+	}
+	return code
+}
+
+// ConsumeAuthorizationCode returns an authorization code entity and deletes it, so a code can
+// only be exchanged once.
+//
+// @param
+// - code {string} (authorization code in plain text)
+//
+// @return
+// - code {AuthorizationCode} (an authorization code entity or null)
+func (d *MongoDBStore) ConsumeAuthorizationCode(code string) AuthorizationCode {
+	/* Condition validation */
+	if len(code) == 0 {
+		return nil
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	record := new(MongoDBAuthorizationCode)
+	if _, err := database.C(d.table(oauthTable.AuthorizationCode)).FindId(HashClientSecret(code)).Apply(mgo.Change{Remove: true}, record); err != nil {
+		return nil
+	}
+	return record
+}
+
+// FindConsent returns the scopes that user had consented to client or null.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+// - clientID {string} (client's client_id)
+//
+// @return
+// - scopes {[]string} (a list of consented scopes or null if user had never consented)
+func (d *MongoDBStore) FindConsent(userID string, clientID string) []string {
+	/* Condition validation */
+	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return nil
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	consent := new(MongoDBConsent)
+	if err := database.C(d.table(oauthTable.Consent)).FindId(userID + ":" + clientID).One(consent); err != nil {
+		return nil
+	}
+	if consent.Scopes == nil {
+		return []string{}
+	}
+	return consent.Scopes
+}
+
+// SaveConsent adds scopes to user's consent to client.
+//
+// @param
+// - userID {string} (userID that associated with user's entity)
+// - clientID {string} (client's client_id)
+// - scopes {[]string} (the scopes that user had consented to)
+//
+// @return
+// - isSaved {bool} (true if consent had been saved)
+func (d *MongoDBStore) SaveConsent(userID string, clientID string, scopes []string) bool {
+	/* Condition validation */
+	if len(clientID) == 0 || len(userID) == 0 || !bson.IsObjectIdHex(userID) {
+		return false
+	}
+
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	update := bson.M{
+		"$set":      bson.M{"user_id": bson.ObjectIdHex(userID), "client_id": clientID, "updated_time": time.Now().UTC()},
+		"$addToSet": bson.M{"scopes": bson.M{"$each": scopes}},
+	}
+	_, err := database.C(d.table(oauthTable.Consent)).UpsertId(userID+":"+clientID, update)
+	return err == nil
+}
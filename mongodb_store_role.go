@@ -0,0 +1,21 @@
+package oauth2
+
+import (
+	"github.com/phuc0302/go-mongo"
+	"github.com/phuc0302/go-oauth2/oauth_table"
+)
+
+// FindRoleDefinitions returns all role definitions.
+//
+// @return
+// - definitions {[]RoleDefinition} (a list of role definitions)
+func (d *MongoDBStore) FindRoleDefinitions() []RoleDefinition {
+	session, database := mongo.GetMonotonicSession()
+	defer session.Close()
+
+	var definitions []RoleDefinition
+	if err := database.C(d.table(oauthTable.Role)).Find(nil).All(&definitions); err != nil {
+		return nil
+	}
+	return definitions
+}
@@ -2,11 +2,14 @@ package oauth2
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/phuc0302/go-oauth2/oauth_table"
 	"github.com/phuc0302/go-server"
 	"github.com/phuc0302/go-server/expected_format"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func Test_MongoDBStore_CreateMongoDBStore(t *testing.T) {
@@ -55,15 +58,15 @@ func Test_MongoDBStore_FindUserWithClient(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	user := Store.FindUserWithClient(u.ClientID.Hex(), u.ClientSecret.Hex())
+	user := Store.FindUserWithClient(u.ClientID, u.ClientSecret)
 	if user == nil {
 		t.Error(expectedFormat.NotNil)
 	} else {
-		if user.UserID() != u.ClientID.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientID.Hex(), user.UserID())
+		if user.UserID() != u.MachineID.Hex() {
+			t.Errorf(expectedFormat.StringButFoundString, u.MachineID.Hex(), user.UserID())
 		}
-		if user.Username() != u.ClientID.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientID.Hex(), user.Username())
+		if user.Username() != u.ClientID {
+			t.Errorf(expectedFormat.StringButFoundString, u.ClientID, user.Username())
 		}
 		if !reflect.DeepEqual(user.UserRoles(), []string{"r_device"}) {
 			t.Errorf(expectedFormat.StringButFoundString, []string{"r_device"}, user.UserRoles())
@@ -92,20 +95,42 @@ func Test_MongoDBStore_FindUserWithCredential(t *testing.T) {
 	}
 }
 
+func Test_MongoDBStore_FindUserWithCredential_Rehash(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	defer func() { Hasher = createPasswordHasher(Cfg) }()
+	Hasher = &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}
+
+	if user := Store.FindUserWithCredential(u.Username, u.Password); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// Password's hash should be upgraded to argon2id
+	user := Store.FindUserWithID(u.UserID.Hex())
+	if !strings.HasPrefix(user.Password(), "$argon2id$") {
+		t.Errorf(expectedFormat.StringButFoundString, "$argon2id$", user.Password())
+	}
+	if user := Store.FindUserWithCredential(u.Username, u.Password); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
 func Test_MongoDBStore_FindClientWithID(t *testing.T) {
 	u := new(TestEnv)
 	defer u.Teardown()
 	u.Setup()
 
-	client := Store.FindClientWithID(u.ClientID.Hex())
+	client := Store.FindClientWithID(u.ClientID)
 	if client == nil {
 		t.Error(expectedFormat.NotNil)
 	} else {
-		if client.ClientID() != u.ClientID.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientID.Hex(), client.ClientID())
+		if client.ClientID() != u.ClientID {
+			t.Errorf(expectedFormat.StringButFoundString, u.ClientID, client.ClientID())
 		}
-		if client.ClientSecret() != u.ClientSecret.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientSecret.Hex(), client.ClientSecret())
+		if client.ClientSecret() != HashClientSecret(u.ClientSecret) {
+			t.Errorf(expectedFormat.StringButFoundString, HashClientSecret(u.ClientSecret), client.ClientSecret())
 		}
 		if !reflect.DeepEqual(client.GrantTypes(), []string{AuthorizationCodeGrant, PasswordGrant, RefreshTokenGrant}) {
 			t.Errorf(expectedFormat.StringButFoundString, []string{AuthorizationCodeGrant, PasswordGrant, RefreshTokenGrant}, client.GrantTypes())
@@ -121,15 +146,15 @@ func Test_MongoDBStore_FindClientWithCredential(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	client := Store.FindClientWithCredential(u.ClientID.Hex(), u.ClientSecret.Hex())
+	client := Store.FindClientWithCredential(u.ClientID, u.ClientSecret)
 	if client == nil {
 		t.Error(expectedFormat.NotNil)
 	} else {
-		if client.ClientID() != u.ClientID.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientID.Hex(), client.ClientID())
+		if client.ClientID() != u.ClientID {
+			t.Errorf(expectedFormat.StringButFoundString, u.ClientID, client.ClientID())
 		}
-		if client.ClientSecret() != u.ClientSecret.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientSecret.Hex(), client.ClientSecret())
+		if client.ClientSecret() != HashClientSecret(u.ClientSecret) {
+			t.Errorf(expectedFormat.StringButFoundString, HashClientSecret(u.ClientSecret), client.ClientSecret())
 		}
 		if !reflect.DeepEqual(client.GrantTypes(), []string{AuthorizationCodeGrant, PasswordGrant, RefreshTokenGrant}) {
 			t.Errorf(expectedFormat.StringButFoundString, []string{AuthorizationCodeGrant, PasswordGrant, RefreshTokenGrant}, client.GrantTypes())
@@ -140,17 +165,270 @@ func Test_MongoDBStore_FindClientWithCredential(t *testing.T) {
 	}
 }
 
+func Test_MongoDBStore_FindClientWithInvalidCredential(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	if client := Store.FindClientWithCredential(u.ClientID, HashClientSecret(u.ClientSecret)); client != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if client := Store.FindClientWithCredential(u.ClientID, "invalid_secret"); client != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
+func Test_MongoDBStore_LegacyClient(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	// Client and machine user that were created with ObjectIds
+	legacyID := bson.NewObjectId()
+	legacySecret := bson.NewObjectId()
+	password, _ := Hasher.Hash(legacySecret.Hex())
+	u.Database.C(oauthTable.Client).Insert(bson.M{"_id": legacyID, "client_secret": legacySecret, "grant_types": []string{ClientCredentialsGrant}})
+	u.Database.C(oauthTable.User).Insert(bson.M{"_id": legacyID, "username": "legacy-device", "password": password})
+
+	now := time.Now()
+	u.Database.C(oauthTable.AccessToken).Insert(bson.M{"_id": bson.NewObjectId(), "user_id": legacyID, "client_id": legacyID, "created_time": now, "expired_time": now.Add(time.Hour)})
+
+	store := Store.(*MongoDBStore)
+	store.migrateLegacyClients()
+
+	// [Test 1] Client authenticates with its legacy credentials
+	client := Store.FindClientWithCredential(legacyID.Hex(), legacySecret.Hex())
+	if client == nil {
+		t.Fatal(expectedFormat.NotNil)
+	}
+	if client.ClientID() != legacyID.Hex() {
+		t.Errorf(expectedFormat.StringButFoundString, legacyID.Hex(), client.ClientID())
+	}
+
+	// [Test 2] Machine user is found by client's ObjectId
+	if user := Store.FindUserWithClient(legacyID.Hex(), legacySecret.Hex()); user == nil || user.UserID() != legacyID.Hex() {
+		t.Errorf(expectedFormat.StringButFoundString, legacyID.Hex(), user)
+	}
+	if user := Store.FindUserWithClient(legacyID.Hex(), "invalid_secret"); user != nil {
+		t.Error(expectedFormat.Nil)
+	}
+
+	// [Test 3] Client's tokens are re-keyed
+	if token := Store.FindAccessTokenWithCredential(legacyID.Hex(), legacyID.Hex()); token == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if count, _ := u.Database.C(oauthTable.Client).FindId(legacyID).Count(); count != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, count)
+	}
+}
+
+func Test_MongoDBStore_AddClientSecret(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	secretStore, _ := Store.(ClientSecretStore)
+	secret, record := secretStore.AddClientSecret(u.ClientID, "ios-2017", time.Now().Add(time.Hour))
+	if record == nil {
+		t.Error(expectedFormat.NotNil)
+		return
+	}
+	if record.Label() != "ios-2017" {
+		t.Errorf(expectedFormat.StringButFoundString, "ios-2017", record.Label())
+	}
+
+	// Both old and new secrets should be accepted
+	if client := Store.FindClientWithCredential(u.ClientID, secret); client == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if client := Store.FindClientWithCredential(u.ClientID, u.ClientSecret); client == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// The primary secret is listed together with the new one
+	secrets := secretStore.FindClientSecrets(u.ClientID)
+	if len(secrets) != 2 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, len(secrets))
+	} else if secrets[0].SecretID() != record.SecretID() {
+		t.Errorf(expectedFormat.StringButFoundString, record.SecretID(), secrets[0].SecretID())
+	} else if secrets[1].Label() != PrimarySecretLabel {
+		t.Errorf(expectedFormat.StringButFoundString, PrimarySecretLabel, secrets[1].Label())
+	}
+}
+
+func Test_MongoDBStore_AddExpiredClientSecret(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	secretStore, _ := Store.(ClientSecretStore)
+	secret, _ := secretStore.AddClientSecret(u.ClientID, "expired", time.Now().Add(-time.Hour))
+	if client := Store.FindClientWithCredential(u.ClientID, secret); client != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
+func Test_MongoDBStore_RetireClientSecret(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	secretStore, _ := Store.(ClientSecretStore)
+	secret, record := secretStore.AddClientSecret(u.ClientID, "android", time.Time{})
+	if !secretStore.RetireClientSecret(u.ClientID, record.SecretID()) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+
+	if client := Store.FindClientWithCredential(u.ClientID, secret); client != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if secrets := secretStore.FindClientSecrets(u.ClientID); len(secrets) != 2 || !secrets[0].IsExpired() {
+		t.Error("Expected retired secret should be expired.")
+	}
+}
+
+func Test_MongoDBStore_RetirePrimaryClientSecret(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	secretStore, _ := Store.(ClientSecretStore)
+	secret, _ := secretStore.AddClientSecret(u.ClientID, "rotated", time.Time{})
+
+	var primaryID string
+	for _, record := range secretStore.FindClientSecrets(u.ClientID) {
+		if record.Label() == PrimarySecretLabel {
+			primaryID = record.SecretID()
+		}
+	}
+	if len(primaryID) == 0 {
+		t.Error("Expected primary secret should be listed.")
+		return
+	}
+
+	if !secretStore.RetireClientSecret(u.ClientID, primaryID) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if client := Store.FindClientWithCredential(u.ClientID, u.ClientSecret); client != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if client := Store.FindClientWithCredential(u.ClientID, secret); client == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_MongoDBStore_MigrateClientSecrets(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	isMigrated := func(clientID string) bool {
+		client := new(MongoDBClient)
+		if err := u.Database.C(oauthTable.Client).FindId(clientID).One(client); err != nil {
+			return false
+		}
+		return len(client.Secret) == 0 && len(client.Secrets) == 1 && client.Secrets[0].Label() == PrimarySecretLabel
+	}
+
+	// [Test 1] Legacy primary secret is migrated on authentication
+	if client := Store.FindClientWithCredential(u.ClientID, u.ClientSecret); client == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if !isMigrated(u.ClientID) {
+		t.Error("Expected primary secret should be migrated on authentication.")
+	}
+
+	// [Test 2] Legacy primary secrets are migrated at startup
+	u.Database.C(oauthTable.Client).Insert(&MongoDBClient{ID: "legacy-app", Secret: HashClientSecret("secret")})
+	Store.(*MongoDBStore).migrateClientSecrets()
+	if !isMigrated("legacy-app") {
+		t.Error("Expected primary secret should be migrated at startup.")
+	}
+	if client := Store.FindClientWithCredential("legacy-app", "secret"); client == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_MongoDBStore_RegisteredMachineUser(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	registrationStore := Store.(ClientRegistrationStore)
+	metadata := &ClientMetadata{GrantTypes: []string{ClientCredentialsGrant}, TokenEndpointAuthMethod: ClientSecretBasic}
+	if client := registrationStore.CreateClient("service-app", "service-secret", "registration-token", metadata); client == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// [Test 1] Machine user shares client's secrets
+	if user := Store.FindUserWithClient("service-app", "service-secret"); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if user := Store.FindUserWithClient("service-app", "wrong-secret"); user != nil {
+		t.Error(expectedFormat.Nil)
+	}
+
+	// [Test 2] Machine user is deleted with its client
+	registrationStore.DeleteClient("service-app")
+	if count, _ := u.Database.C(oauthTable.User).Find(bson.M{"username": "service-app"}).Count(); count != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, count)
+	}
+}
+
+func Test_MongoDBStore_UpdateUserPassword(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	currentToken := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	otherToken := Store.CreateAccessToken("web-app-test", u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	refreshToken := Store.CreateRefreshToken("web-app-test", u.UserID.Hex(), now, now.Add(Cfg.RefreshTokenDuration))
+
+	// [Test 1] Keep current token
+	adminStore := Store.(AdminStore)
+	if !adminStore.UpdateUserPassword(u.UserID.Hex(), "NewPassword", currentToken) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if Store.FindAccessToken(currentToken.Token()) == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if Store.FindAccessToken(otherToken.Token()) != nil || Store.FindRefreshToken(refreshToken.Token()) != nil {
+		t.Error(expectedFormat.Nil)
+	}
+
+	// [Test 2] Revoke all tokens
+	adminStore.UpdateUserPassword(u.UserID.Hex(), "OtherPassword", nil)
+	if Store.FindAccessToken(currentToken.Token()) != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
+func Test_MongoDBStore_CreateUser_UniqueUsername(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	adminStore := Store.(AdminStore)
+	if user := adminStore.CreateUser("manager", "Password", nil); user == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if user := adminStore.CreateUser("manager", "Password", nil); user != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
 func Test_MongoDBStore_CreateAccessToken(t *testing.T) {
 	u := new(TestEnv)
 	defer u.Teardown()
 	u.Setup()
 
-	token := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	if token == nil {
 		t.Error(expectedFormat.NotNil)
 	} else {
-		if token.ClientID() != u.ClientID.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientID.Hex(), token.ClientID())
+		if token.ClientID() != u.ClientID {
+			t.Errorf(expectedFormat.StringButFoundString, u.ClientID, token.ClientID())
 		}
 		if token.UserID() != u.UserID.Hex() {
 			t.Errorf(expectedFormat.StringButFoundString, u.UserID.Hex(), token.ClientID())
@@ -166,7 +444,7 @@ func Test_MongoDBStore_FindAccessToken(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	token1 := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token1 := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	token2 := Store.FindAccessToken(token1.Token())
 	if token2 == nil {
 		t.Error(expectedFormat.NotNil)
@@ -183,13 +461,33 @@ func Test_MongoDBStore_FindAccessToken(t *testing.T) {
 	}
 }
 
+func Test_MongoDBStore_FindAccessToken_Deleted(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	accessToken := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	refreshToken := Store.CreateRefreshToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.RefreshTokenDuration))
+	Store.DeleteAccessToken(accessToken)
+	Store.DeleteRefreshToken(refreshToken)
+
+	// Signatures are still valid but records are gone
+	if token := Store.FindAccessToken(accessToken.Token()); token != nil {
+		t.Error(expectedFormat.Nil)
+	}
+	if token := Store.FindRefreshToken(refreshToken.Token()); token != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
 func Test_MongoDBStore_FindAccessTokenWithCredential(t *testing.T) {
 	u := new(TestEnv)
 	defer u.Teardown()
 	u.Setup()
 
-	token1 := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
-	token2 := Store.FindAccessTokenWithCredential(u.ClientID.Hex(), u.UserID.Hex())
+	token1 := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token2 := Store.FindAccessTokenWithCredential(u.ClientID, u.UserID.Hex())
 	if token2 == nil {
 		t.Error(expectedFormat.NotNil)
 	} else {
@@ -210,7 +508,7 @@ func Test_MongoDBStore_DeleteAccessToken(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	token1 := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token1 := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	Store.DeleteAccessToken(token1)
 
 	token2 := Store.FindAccessTokenWithCredential(token1.ClientID(), token1.UserID())
@@ -224,12 +522,12 @@ func Test_MongoDBStore_CreateRefreshToken(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	token := Store.CreateRefreshToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token := Store.CreateRefreshToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	if token == nil {
 		t.Error(expectedFormat.NotNil)
 	} else {
-		if token.ClientID() != u.ClientID.Hex() {
-			t.Errorf(expectedFormat.StringButFoundString, u.ClientID.Hex(), token.ClientID())
+		if token.ClientID() != u.ClientID {
+			t.Errorf(expectedFormat.StringButFoundString, u.ClientID, token.ClientID())
 		}
 		if token.UserID() != u.UserID.Hex() {
 			t.Errorf(expectedFormat.StringButFoundString, u.UserID.Hex(), token.ClientID())
@@ -245,7 +543,7 @@ func Test_MongoDBStore_FindRefreshToken(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	token1 := Store.CreateRefreshToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token1 := Store.CreateRefreshToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	token2 := Store.FindRefreshToken(token1.Token())
 	if token2 == nil {
 		t.Error(expectedFormat.NotNil)
@@ -267,7 +565,7 @@ func Test_MongoDBStore_FindRefreshTokenWithCredential(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	token1 := Store.CreateRefreshToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token1 := Store.CreateRefreshToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	token2 := Store.FindRefreshTokenWithCredential(token1.ClientID(), token1.UserID())
 	if token2 == nil {
 		t.Error(expectedFormat.NotNil)
@@ -289,7 +587,7 @@ func Test_MongoDBStore_DeleteRefreshToken(t *testing.T) {
 	defer u.Teardown()
 	u.Setup()
 
-	token1 := Store.CreateRefreshToken(u.ClientID.Hex(), u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
+	token1 := Store.CreateRefreshToken(u.ClientID, u.UserID.Hex(), time.Now(), time.Now().Add(Cfg.AccessTokenDuration))
 	Store.DeleteRefreshToken(token1)
 
 	token2 := Store.FindRefreshTokenWithCredential(token1.ClientID(), token1.UserID())
@@ -2,6 +2,7 @@ package oauth2
 
 import (
 	"crypto/rsa"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -12,16 +13,34 @@ import (
 type MongoDBToken struct {
 	ID      bson.ObjectId `bson:"_id"`
 	User    bson.ObjectId `bson:"user_id,omitempty"`
-	Client  bson.ObjectId `bson:"client_id,omitempty"`
+	Client  string        `bson:"client_id,omitempty"`
 	Created time.Time     `bson:"created_time,omitempty"`
 	Expired time.Time     `bson:"expired_time,omitempty"`
+	Scopes  []string      `bson:"scopes,omitempty"`
+
+	// SHA-256 thumbprint of the certificate that token is bound to
+	Thumbprint string `bson:"cnf_x5t_s256,omitempty"`
+	// JWK SHA-256 thumbprint of the DPoP key that token is bound to
+	JWKThumbprint string `bson:"cnf_jkt,omitempty"`
 
 	privateKey *rsa.PrivateKey
+	issuer     string
+}
+
+// tokenBinding describes the keys that a new token is bound to.
+type tokenBinding struct {
+	certificateThumbprint string
+	keyThumbprint         string
+}
+
+// TokenID returns token's ID.
+func (t *MongoDBToken) TokenID() string {
+	return t.ID.Hex()
 }
 
 // ClientID returns client_id.
 func (t *MongoDBToken) ClientID() string {
-	return t.Client.Hex()
+	return t.Client
 }
 
 // UserID returns user_id.
@@ -39,10 +58,19 @@ func (t *MongoDBToken) Token() string {
 	token.Claims = jwt.MapClaims{
 		"_id":          t.ID.Hex(),
 		"user_id":      t.User.Hex(),
-		"client_id":    t.Client.Hex(),
+		"client_id":    t.Client,
 		"created_time": string(createdTime),
 		"expired_time": string(expiredTime),
 	}
+	if len(t.Scopes) > 0 {
+		token.Claims.(jwt.MapClaims)["scope"] = strings.Join(t.Scopes, " ")
+	}
+	if len(t.issuer) > 0 {
+		token.Claims.(jwt.MapClaims)["iss"] = t.issuer
+	}
+	if confirmation := t.confirmation(); len(confirmation) > 0 {
+		token.Claims.(jwt.MapClaims)["cnf"] = confirmation
+	}
 
 	// Generate token
 	tokenString, _ := token.SignedString(t.privateKey)
@@ -63,3 +91,30 @@ func (t *MongoDBToken) CreatedTime() time.Time {
 func (t *MongoDBToken) ExpiredTime() time.Time {
 	return t.Expired
 }
+
+// TokenScopes returns token's scopes.
+func (t *MongoDBToken) TokenScopes() []string {
+	return t.Scopes
+}
+
+// CertificateThumbprint returns thumbprint of the certificate that token is bound to.
+func (t *MongoDBToken) CertificateThumbprint() string {
+	return t.Thumbprint
+}
+
+// KeyThumbprint returns thumbprint of the DPoP key that token is bound to.
+func (t *MongoDBToken) KeyThumbprint() string {
+	return t.JWKThumbprint
+}
+
+// confirmation returns token's confirmation claim, it is empty if token is not bound.
+func (t *MongoDBToken) confirmation() map[string]string {
+	confirmation := make(map[string]string)
+	if len(t.Thumbprint) > 0 {
+		confirmation["x5t#S256"] = t.Thumbprint
+	}
+	if len(t.JWKThumbprint) > 0 {
+		confirmation["jkt"] = t.JWKThumbprint
+	}
+	return confirmation
+}
@@ -1,6 +1,8 @@
 package oauth2
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
 	"testing"
 	"time"
@@ -20,7 +22,7 @@ func Test_MongoDBToken(t *testing.T) {
 	token := MongoDBToken{
 		ID:      bson.NewObjectId(),
 		User:    bson.NewObjectId(),
-		Client:  bson.NewObjectId(),
+		Client:  "sample-client",
 		Created: time.Now(),
 
 		privateKey: mongoStore.privateKey,
@@ -44,3 +46,25 @@ func Test_MongoDBToken(t *testing.T) {
 		}
 	}
 }
+
+func Test_MongoDBToken_Scopes(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	store := &MongoDBStore{privateKey: privateKey}
+
+	now := time.Now()
+	token := &MongoDBToken{
+		ID:      bson.NewObjectId(),
+		User:    bson.NewObjectId(),
+		Client:  "web",
+		Created: now,
+		Expired: now.Add(time.Hour),
+		Scopes:  []string{"orders:read", "orders:write"},
+
+		privateKey: privateKey,
+	}
+
+	s := &OAuthContext{AccessToken: store.parseToken(token.Token())}
+	if !s.HasScope("orders:write") || s.HasScope("orders:delete") {
+		t.Error("Expected parsed token should carry its scopes.")
+	}
+}
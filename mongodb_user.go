@@ -9,6 +9,9 @@ type MongoDBUser struct {
 	Pass  string        `bson:"password,omitempty"`
 	Roles []string      `bson:"roles,omitempty"`
 
+	// Incremented whenever user's login sessions are revoked.
+	Sessions int `bson:"session_version,omitempty"`
+
 	FacebookID    string `bson:"facebook_id,omitempty"`
 	FacebookToken string `bson:"facebook_token,omitempty"`
 }
@@ -32,3 +35,8 @@ func (a *MongoDBUser) Password() string {
 func (a *MongoDBUser) UserRoles() []string {
 	return a.Roles
 }
+
+// SessionVersion returns user's login session version.
+func (a *MongoDBUser) SessionVersion() int {
+	return a.Sessions
+}
@@ -0,0 +1,172 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+)
+
+// Global public pool of CAs that issue certificates for tls_client_auth. If null, system's roots
+// are used.
+var ClientCAs *x509.CertPool
+
+// MutualTLSConfig returns a TLS config that asks clients for their certificates. Certificates
+// are not verified during handshake, so self-signed certificates are accepted; they are verified
+// at token endpoint according to client's authentication method.
+//
+// go-server's RunTLS does not ask clients for certificates and go-server's router does not
+// expose them, serve mTLS endpoints, e.g. OAuthServer.TokenHandler, with this config instead and
+// set Config's MutualTLS.
+//
+// @return
+// - config {tls.Config} (a TLS config)
+func MutualTLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// ServeMutualTLS starts an HTTPS server that asks clients for their certificates.
+//
+// @param
+// - address {string} (server's address)
+// - certFile {string} (server's certificate file)
+// - keyFile {string} (server's private key file)
+// - handler {http.Handler} (a net/http handler)
+//
+// @return
+// - err {error} (an error if server stops)
+func ServeMutualTLS(address string, certFile string, keyFile string, handler http.Handler) error {
+	httpServer := &http.Server{
+		Addr:      address,
+		Handler:   handler,
+		TLSConfig: MutualTLSConfig(),
+	}
+	return httpServer.ListenAndServeTLS(certFile, keyFile)
+}
+
+// CertificateThumbprint returns the SHA-256 thumbprint of a certificate (x5t#S256).
+//
+// @param
+// - certificate {x509.Certificate} (a certificate)
+//
+// @return
+// - thumbprint {string} (base64url encoded SHA-256 digest of certificate's DER)
+func CertificateThumbprint(certificate *x509.Certificate) string {
+	digest := sha256.Sum256(certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// clientCertificates returns the certificate chain that client had presented over TLS.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - certificates {[]*x509.Certificate} (client's certificate chain or null)
+func clientCertificates(c *server.RequestContext) []*x509.Certificate {
+	certificates, _ := c.GetExtra(oauthKey.ClientCertificates).([]*x509.Certificate)
+	return certificates
+}
+
+// validateRouterBinding panics if config requires client's certificates, because routes that are
+// bound to go-server's router never see them: TLS clients could not authenticate and bound
+// tokens would always be rejected.
+//
+// @param
+// - config {Config} (config of the server or realm that route belongs to)
+func validateRouterBinding(config *Config) {
+	if config != nil && config.MutualTLS {
+		panic("Mutual TLS routes cannot be bound to go-server's router, please serve them with Handler or Middleware over MutualTLSConfig.")
+	}
+}
+
+// isTLSAuthMethod checks if an authentication method uses client certificates.
+//
+// @param
+// - authMethod {string} (client's token endpoint authentication method)
+//
+// @return
+// - isTLS {bool} (true if client authenticates with certificates)
+func isTLSAuthMethod(authMethod string) bool {
+	return authMethod == TLSClientAuth || authMethod == SelfSignedTLSClientAuth
+}
+
+// authenticateTLSClient validates client's certificate against client's authentication method.
+//
+// @param
+// - client {Client} (a client entity)
+// - certificates {[]*x509.Certificate} (client's certificate chain)
+// - now {time.Time} (current time)
+//
+// @return
+// - thumbprint {string} (thumbprint of client's certificate)
+// - ok {bool} (true if client had been authenticated)
+func authenticateTLSClient(client Client, certificates []*x509.Certificate, now time.Time) (string, bool) {
+	tlsClient, ok := client.(TLSClient)
+
+	/* Condition validation */
+	if !ok || len(certificates) == 0 {
+		return "", false
+	}
+	certificate := certificates[0]
+	thumbprint := CertificateThumbprint(certificate)
+
+	switch tlsClient.TokenEndpointAuthMethod() {
+
+	case TLSClientAuth:
+		if len(tlsClient.TLSClientAuthSubjectDN()) == 0 || certificate.Subject.String() != tlsClient.TLSClientAuthSubjectDN() {
+			return "", false
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, intermediate := range certificates[1:] {
+			intermediates.AddCert(intermediate)
+		}
+		options := x509.VerifyOptions{
+			Roots:         ClientCAs,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if _, err := certificate.Verify(options); err != nil {
+			return "", false
+		}
+		return thumbprint, true
+
+	case SelfSignedTLSClientAuth:
+		if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+			return "", false
+		}
+		for _, registeredThumbprint := range tlsClient.TLSClientCertificates() {
+			if registeredThumbprint == thumbprint {
+				return thumbprint, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isBoundTo checks if a token can be used over a connection. Bound tokens must be presented with
+// the certificate that they are bound to, unbound tokens can be presented over any connection.
+//
+// @param
+// - token {Token} (an access token)
+// - certificates {[]*x509.Certificate} (client's certificate chain or null)
+//
+// @return
+// - isBound {bool} (true if token can be used)
+func isBoundTo(token Token, certificates []*x509.Certificate) bool {
+	boundToken, ok := token.(BoundToken)
+	if !ok || len(boundToken.CertificateThumbprint()) == 0 {
+		return true
+	}
+	return len(certificates) > 0 && CertificateThumbprint(certificates[0]) == boundToken.CertificateThumbprint()
+}
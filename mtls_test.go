@@ -0,0 +1,302 @@
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_table"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// createCertificate issues a client certificate, the certificate is self-signed if parent is
+// null.
+func createCertificate(t *testing.T, commonName string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"Example"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, privateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &privateKey.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	return certificate, privateKey
+}
+
+func Test_CertificateThumbprint(t *testing.T) {
+	certificate, _ := createCertificate(t, "client", false, nil, nil)
+
+	thumbprint := CertificateThumbprint(certificate)
+	if len(thumbprint) != 43 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 43, len(thumbprint))
+	}
+	if thumbprint != CertificateThumbprint(certificate) {
+		t.Error("Expected thumbprint should be stable.")
+	}
+}
+
+func Test_authenticateTLSClient_TLSClientAuth(t *testing.T) {
+	defer func() { ClientCAs = nil }()
+
+	ca, caKey := createCertificate(t, "ca", true, nil, nil)
+	certificate, _ := createCertificate(t, "client", false, ca, caKey)
+	ClientCAs = x509.NewCertPool()
+	ClientCAs.AddCert(ca)
+
+	client := &MongoDBClient{ID: "service", AuthMethod: TLSClientAuth, SubjectDN: certificate.Subject.String()}
+	now := time.Now()
+
+	// [Test 1] Certificate is issued by a trusted CA and matches subject DN
+	if thumbprint, ok := authenticateTLSClient(client, []*x509.Certificate{certificate}, now); !ok || thumbprint != CertificateThumbprint(certificate) {
+		t.Error("Expected client should be authenticated.")
+	}
+
+	// [Test 2] Certificate does not match subject DN
+	client.SubjectDN = "CN=other"
+	if _, ok := authenticateTLSClient(client, []*x509.Certificate{certificate}, now); ok {
+		t.Error("Expected client should not be authenticated with another subject.")
+	}
+
+	// [Test 3] Self-signed certificate is not trusted
+	selfSigned, _ := createCertificate(t, "client", false, nil, nil)
+	client.SubjectDN = selfSigned.Subject.String()
+	if _, ok := authenticateTLSClient(client, []*x509.Certificate{selfSigned}, now); ok {
+		t.Error("Expected client should not be authenticated with an untrusted certificate.")
+	}
+
+	// [Test 4] Certificate is expired
+	client.SubjectDN = certificate.Subject.String()
+	if _, ok := authenticateTLSClient(client, []*x509.Certificate{certificate}, now.Add(2*time.Hour)); ok {
+		t.Error("Expected client should not be authenticated with an expired certificate.")
+	}
+}
+
+func Test_authenticateTLSClient_SelfSignedTLSClientAuth(t *testing.T) {
+	certificate, _ := createCertificate(t, "client", false, nil, nil)
+	other, _ := createCertificate(t, "client", false, nil, nil)
+
+	client := &MongoDBClient{ID: "service", AuthMethod: SelfSignedTLSClientAuth, Certificates: []string{CertificateThumbprint(certificate)}}
+	now := time.Now()
+
+	// [Test 1] Registered certificate
+	if _, ok := authenticateTLSClient(client, []*x509.Certificate{certificate}, now); !ok {
+		t.Error("Expected client should be authenticated.")
+	}
+
+	// [Test 2] Unregistered certificate
+	if _, ok := authenticateTLSClient(client, []*x509.Certificate{other}, now); ok {
+		t.Error("Expected client should not be authenticated with an unregistered certificate.")
+	}
+
+	// [Test 3] Client does not use mutual TLS
+	client.AuthMethod = ClientSecretBasic
+	if _, ok := authenticateTLSClient(client, []*x509.Certificate{certificate}, now); ok {
+		t.Error("Expected client should not be authenticated with certificate.")
+	}
+
+	// [Test 4] No certificate
+	client.AuthMethod = SelfSignedTLSClientAuth
+	if _, ok := authenticateTLSClient(client, nil, now); ok {
+		t.Error("Expected client should not be authenticated without certificate.")
+	}
+}
+
+func Test_isBoundTo(t *testing.T) {
+	certificate, _ := createCertificate(t, "client", false, nil, nil)
+	other, _ := createCertificate(t, "client", false, nil, nil)
+
+	// [Test 1] Unbound token
+	token := &MongoDBToken{}
+	if !isBoundTo(token, nil) || !isBoundTo(token, []*x509.Certificate{certificate}) {
+		t.Error("Expected unbound token should be accepted over any connection.")
+	}
+
+	// [Test 2] Bound token
+	token.Thumbprint = CertificateThumbprint(certificate)
+	if !isBoundTo(token, []*x509.Certificate{certificate}) {
+		t.Error("Expected bound token should be accepted with its certificate.")
+	}
+	if isBoundTo(token, []*x509.Certificate{other}) || isBoundTo(token, nil) {
+		t.Error("Expected bound token should be rejected without its certificate.")
+	}
+}
+
+func Test_MongoDBStore_BoundToken(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	store := &MongoDBStore{privateKey: privateKey}
+	certificate, _ := createCertificate(t, "client", false, nil, nil)
+
+	now := time.Now()
+	token := &MongoDBToken{
+		ID:      bson.NewObjectId(),
+		User:    bson.NewObjectId(),
+		Client:  "service",
+		Created: now,
+		Expired: now.Add(time.Hour),
+
+		Thumbprint: CertificateThumbprint(certificate),
+
+		privateKey: privateKey,
+	}
+
+	parsedToken, ok := store.parseToken(token.Token()).(BoundToken)
+	if !ok {
+		t.Fatal("Expected token should be parsed.")
+	}
+	if parsedToken.CertificateThumbprint() != token.Thumbprint {
+		t.Errorf(expectedFormat.StringButFoundString, token.Thumbprint, parsedToken.CertificateThumbprint())
+	}
+}
+
+// didPanic checks if a func panics.
+func didPanic(f func()) (isPanicked bool) {
+	defer func() {
+		isPanicked = recover() != nil
+	}()
+	f()
+	return
+}
+
+func Test_validateRouterBinding(t *testing.T) {
+	defer func() { boundRoutes = nil }()
+
+	config := DefaultConfig()
+	config.MutualTLS = true
+	s := CreateOAuthServer(WithConfig(config))
+
+	bind := func(patternURL string, handler server.HandleContextFunc) {}
+	handler := func(c *server.RequestContext) {}
+
+	// [Test 1] Protected route cannot be bound to go-server's router
+	if !didPanic(func() { s.Bind(func() { bindRoute("GET", "/orders", nil, nil, handler, bind) }) }) {
+		t.Error(expectedFormat.Panic)
+	}
+	if len(boundRoutes) != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, len(boundRoutes))
+	}
+
+	// [Test 2] Token endpoint cannot be bound to go-server's router
+	previousServer := serviceServer
+	defer func() { serviceServer = previousServer }()
+	serviceServer = nil
+	if !didPanic(s.BindService) {
+		t.Error(expectedFormat.Panic)
+	}
+
+	// [Test 3] Routes of a server without mutual TLS can be bound
+	if didPanic(func() { CreateOAuthServer().Bind(func() { bindRoute("GET", "/orders", nil, nil, handler, bind) }) }) {
+		t.Error("Expected route should be bound without mutual TLS.")
+	}
+}
+
+// createTLSClient returns a client of a TLS test server that presents a certificate, or no
+// certificate if certificate is null.
+func createTLSClient(ts *httptest.Server, certificate *x509.Certificate, privateKey *ecdsa.PrivateKey) *http.Client {
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	if certificate != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{certificate.Raw}, PrivateKey: privateKey}}
+	}
+	return &http.Client{Transport: transport}
+}
+
+func Test_MutualTLS_BoundToken(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	certificate, privateKey := createCertificate(t, "service", false, nil, nil)
+	otherCertificate, otherKey := createCertificate(t, "service", false, nil, nil)
+	u.Database.C(oauthTable.Client).Insert(&MongoDBClient{
+		ID:           "mtls-service",
+		Grants:       []string{PasswordGrant},
+		AuthMethod:   SelfSignedTLSClientAuth,
+		Certificates: []string{CertificateThumbprint(certificate)},
+	})
+
+	tokenServer := httptest.NewUnstartedServer(TokenHandler())
+	tokenServer.TLS = MutualTLSConfig()
+	tokenServer.StartTLS()
+	defer tokenServer.Close()
+
+	resourceServer := httptest.NewUnstartedServer(Handler(server.Adapt(func(c *server.RequestContext) {
+		c.OutputStatus(util.Status200())
+	}, ValidateToken())))
+	resourceServer.TLS = MutualTLSConfig()
+	resourceServer.StartTLS()
+	defer resourceServer.Close()
+
+	// Issue a token over mutual TLS, it is bound to client's certificate
+	form := url.Values{
+		"grant_type": {PasswordGrant},
+		"client_id":  {"mtls-service"},
+		"username":   {u.Username},
+		"password":   {u.Password},
+	}
+	response, err := createTLSClient(tokenServer, certificate, privateKey).PostForm(tokenServer.URL, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+	token := parseResult(response)
+	if boundToken, ok := Store.FindAccessToken(token.AccessToken).(BoundToken); !ok || boundToken.CertificateThumbprint() != CertificateThumbprint(certificate) {
+		t.Error("Expected token should be bound to client's certificate.")
+	}
+
+	callResource := func(client *http.Client) int {
+		request, _ := http.NewRequest("GET", resourceServer.URL, nil)
+		request.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// [Test 1] Token is accepted with its certificate
+	if status := callResource(createTLSClient(resourceServer, certificate, privateKey)); status != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, status)
+	}
+
+	// [Test 2] Token is rejected with a different certificate
+	if status := callResource(createTLSClient(resourceServer, otherCertificate, otherKey)); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+
+	// [Test 3] Token is rejected without certificate
+	if status := callResource(createTLSClient(resourceServer, nil, nil)); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
@@ -3,4 +3,9 @@ package oauthKey
 const (
 	Config  = "oauth_config"
 	Context = "oauth_context"
+	Session = "oauth_session"
+	Realm   = "oauth_realm"
+
+	ClientCertificates = "oauth_client_certificates"
+	RemoteAddr         = "oauth_remote_addr"
 )
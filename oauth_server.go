@@ -0,0 +1,397 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+)
+
+// Clock describes a source of current time. Tokens, authorization codes and login attempts are
+// timed by server's clock.
+type Clock interface {
+
+	// Return current time.
+	Now() time.Time
+}
+
+// systemClock is the default clock, it returns system's time.
+type systemClock struct {
+}
+
+// Now returns system's time.
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// OAuthServer describes an oauth2 server's instance. Each instance has its own config, token
+// store, clock and realms, so several instances can be served by one process through their
+// handlers (HandleToken, ...) and adapters (ValidateToken, ...). Hasher, Attempts, Events, Roles,
+// Policies, Metrics and Audit are still shared by all instances.
+//
+// Route binding is not per-instance: go-server has only one router and route policies are
+// tracked globally, so only one instance can BindService per process. Other instances must be
+// mounted with Handler or Middleware.
+type OAuthServer struct {
+	Config *Config
+	Store  TokenStore
+
+	clock            Clock
+	privateKey       *rsa.PrivateKey
+	grantsValidation *regexp.Regexp
+	realms           *[]*Realm
+}
+
+// ServerOption describes an option of CreateOAuthServer.
+type ServerOption func(s *OAuthServer)
+
+// Server that Bind* funcs are bound to, it is only defined inside OAuthServer.Bind.
+var bindingServer *OAuthServer
+
+// Server whose service had been bound to go-server's router.
+var serviceServer *OAuthServer
+
+// WithConfig defines server's config. Config's durations must be converted already, use
+// DefaultConfig as a base.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+func WithConfig(config *Config) ServerOption {
+	return func(s *OAuthServer) {
+		s.Config = config
+	}
+}
+
+// WithStore defines server's token store. If not defined, a MongoDB token store will be used.
+//
+// @param
+// - tokenStore {TokenStore} (a token store's instance)
+func WithStore(tokenStore TokenStore) ServerOption {
+	return func(s *OAuthServer) {
+		s.Store = tokenStore
+	}
+}
+
+// WithClock defines server's clock. If not defined, system's clock will be used.
+//
+// @param
+// - clock {Clock} (a clock's instance)
+func WithClock(clock Clock) ServerOption {
+	return func(s *OAuthServer) {
+		s.clock = clock
+	}
+}
+
+// WithKeys defines JWT key of the default MongoDB token store. If not defined, a new key will be
+// generated and tokens will not survive restart. It is ignored if a token store is defined.
+//
+// @param
+// - privateKey {rsa.PrivateKey} (JWT signing key)
+func WithKeys(privateKey *rsa.PrivateKey) ServerOption {
+	return func(s *OAuthServer) {
+		s.privateKey = privateKey
+	}
+}
+
+// CreateOAuthServer returns an oauth2 server's instance. Unlike Initialize, it does not read
+// config file and does not bind any route, realms must be registered with RegisterRealm.
+//
+// @param
+// - options {[]ServerOption} (a list of server's options)
+//
+// @return
+// - s {OAuthServer} (an oauth2 server's instance)
+func CreateOAuthServer(options ...ServerOption) *OAuthServer {
+	s := &OAuthServer{
+		clock:  systemClock{},
+		realms: new([]*Realm),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.Config == nil {
+		s.Config = DefaultConfig()
+	}
+	if s.Store == nil {
+		if s.privateKey == nil {
+			s.privateKey, _ = rsa.GenerateKey(rand.Reader, 1024)
+		}
+		s.Store = &MongoDBStore{
+			privateKey: s.privateKey,
+			issuer:     s.Config.Issuer,
+		}
+	}
+	s.grantsValidation = compileGrantsValidation(s.Config.GrantTypes)
+	return s
+}
+
+// defaultServer returns the server that is backed by global config, global token store and
+// global realms. Package's funcs are wrappers over this server.
+//
+// @return
+// - s {OAuthServer} (the default oauth2 server)
+func defaultServer() *OAuthServer {
+	return &OAuthServer{
+		Config:           Cfg,
+		Store:            Store,
+		clock:            systemClock{},
+		grantsValidation: grantsValidation,
+		realms:           &realms,
+	}
+}
+
+// RegisterRealm registers a realm. Requests are matched against realms in registration's order,
+// requests that are not matched by any realm belong to the default realm.
+//
+// @param
+// - realm {Realm} (a realm's instance)
+//
+// @return
+// - err {error} (an error if realm is invalid or duplicated)
+func (s *OAuthServer) RegisterRealm(realm *Realm) error {
+	if err := prepareRealm(*s.realms, realm); err != nil {
+		return err
+	}
+
+	realm.clock = s.clock
+	*s.realms = append(*s.realms, realm)
+	return nil
+}
+
+// UnlockUser resets failed login attempts of an user of the default realm, so the user can log
+// in immediately.
+//
+// @param
+// - username {string} (user's username)
+func (s *OAuthServer) UnlockUser(username string) {
+	unlockUser(s.defaultRealm(), username)
+}
+
+// Attach returns a wrapper func that binds request to server's realms. Every handler and adapter
+// of this package resolves request's realm from the server that request is attached to.
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func (s *OAuthServer) Attach() server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		return func(c *server.RequestContext) {
+			c.SetExtra(oauthKey.Realm, s.matchRealm(c.Header["host"], c.Path))
+			f(c)
+		}
+	}
+}
+
+// ValidateToken returns a wrapper oauth token validation func that validates token against
+// server's realms.
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func (s *OAuthServer) ValidateToken() server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		return s.Attach()(ValidateToken()(f))
+	}
+}
+
+// ValidateLoginSession returns a wrapper func that requires an authenticated browser session of
+// server's realms, see ValidateLoginSession.
+//
+// @param
+// - loginURL {string} (login page's URL, empty string to reject with 401)
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func (s *OAuthServer) ValidateLoginSession(loginURL string) server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		return s.Attach()(ValidateLoginSession(loginURL)(f))
+	}
+}
+
+// HandleToken handles token request, see TokenGrant.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (s *OAuthServer) HandleToken(c *server.RequestContext) {
+	s.Attach()(new(TokenGrant).HandleForm)(c)
+}
+
+// HandleAuthorize handles authorization request, it must be wrapped by ValidateLoginSession.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (s *OAuthServer) HandleAuthorize(c *server.RequestContext) {
+	s.Attach()(new(AuthorizationGrant).HandleAuthorize)(c)
+}
+
+// HandleDecision handles user's consent decision, it must be wrapped by ValidateLoginSession.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (s *OAuthServer) HandleDecision(c *server.RequestContext) {
+	s.Attach()(new(AuthorizationGrant).HandleDecision)(c)
+}
+
+// HandleLoginPage renders login page.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (s *OAuthServer) HandleLoginPage(c *server.RequestContext) {
+	s.Attach()(new(LoginController).HandleLoginPage)(c)
+}
+
+// HandleLogin authenticates user's credential and starts a login session.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (s *OAuthServer) HandleLogin(c *server.RequestContext) {
+	s.Attach()(new(LoginController).HandleLogin)(c)
+}
+
+// HandleLogout ends current login session.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+func (s *OAuthServer) HandleLogout(c *server.RequestContext) {
+	s.Attach()(new(LoginController).HandleLogout)(c)
+}
+
+// Bind runs a func that binds routes with Bind* funcs, routes that are bound inside it are
+// attached to this server. Routes are still bound to go-server's only router, so Bind must not
+// be called concurrently and two instances must not bind the same path.
+//
+// @param
+// - bindHandler {server.HandleGroupFunc} (the func that binds routes)
+func (s *OAuthServer) Bind(bindHandler server.HandleGroupFunc) {
+	previousServer := bindingServer
+	bindingServer = s
+	defer func() { bindingServer = previousServer }()
+
+	bindHandler()
+}
+
+// BindService binds oauth2 service to go-server's router. Realms that are selected by host share
+// default routes, realms that are selected by path prefix have their own routes. It panics if
+// another instance had bound its service already, because service's paths are fixed, or if
+// server or any of its realms requires mutual TLS.
+func (s *OAuthServer) BindService() {
+	/* Condition validation: default server is recreated on every call, but its realms are not */
+	if serviceServer != nil && serviceServer.realms != s.realms {
+		panic("Service had been bound by another server, please mount it with Handler or Middleware.")
+	}
+
+	/* Condition validation: Token endpoint must see client's certificates */
+	validateRouterBinding(s.Config)
+	for _, realm := range *s.realms {
+		validateRouterBinding(realm.Config)
+	}
+	serviceServer = s
+
+	s.Bind(func() {
+		allowsAuthorizationCode := s.grantsValidation.MatchString(AuthorizationCodeGrant)
+		for _, realm := range *s.realms {
+			if len(realm.PathPrefix) > 0 {
+				s.bindServiceRoutes(realm.PathPrefix, realm.Store, realm.AllowsGrant(AuthorizationCodeGrant))
+			} else if realm.AllowsGrant(AuthorizationCodeGrant) {
+				allowsAuthorizationCode = true
+			}
+		}
+		s.bindServiceRoutes("", s.Store, allowsAuthorizationCode)
+	})
+}
+
+// bindServiceRoutes binds oauth2 service under a prefix URI. Optional endpoints are only bound if
+// token store supports them.
+//
+// @param
+// - prefixURI {string} (the prefix for url)
+// - tokenStore {TokenStore} (token store of the realms that are served under prefix)
+// - allowsAuthorizationCode {bool} (instruction in which should bind authorize service or not)
+func (s *OAuthServer) bindServiceRoutes(prefixURI string, tokenStore TokenStore, allowsAuthorizationCode bool) {
+	server.BindGet(prefixURI+"/token", s.HandleToken)
+	server.BindPost(prefixURI+"/token", s.HandleToken)
+
+	// Authorization code flow is only available if token store supports it
+	if _, ok := unwrapStore(tokenStore).(AuthorizationStore); ok && allowsAuthorizationCode {
+		server.BindGet(prefixURI+"/authorize", server.Adapt(s.HandleAuthorize, s.ValidateLoginSession(prefixURI+"/login")))
+		server.BindPost(prefixURI+"/authorize", server.Adapt(s.HandleDecision, s.ValidateLoginSession("")))
+
+		server.BindGet(prefixURI+"/login", s.HandleLoginPage)
+		server.BindPost(prefixURI+"/login", s.HandleLogin)
+		server.BindPost(prefixURI+"/logout", s.HandleLogout)
+	}
+
+	// Dynamic client registration is only available if token store supports it
+	if _, ok := unwrapStore(tokenStore).(ClientRegistrationStore); ok && s.Config.AllowRegistration {
+		clientRegistration := new(ClientRegistration)
+
+		server.BindPost(prefixURI+"/register", s.Attach()(clientRegistration.HandleRegister))
+		server.BindGet(prefixURI+"/register/{client_id}", s.Attach()(clientRegistration.HandleRead))
+		server.BindPut(prefixURI+"/register/{client_id}", s.Attach()(clientRegistration.HandleUpdate))
+		server.BindDelete(prefixURI+"/register/{client_id}", s.Attach()(clientRegistration.HandleDelete))
+	}
+
+	// Administration is only available if token store supports it
+	if _, ok := unwrapStore(tokenStore).(AdminStore); ok && s.Config.AllowAdministration {
+		administration := new(Administration)
+		administration.BindRoutes(prefixURI + "/admin")
+	}
+
+	// Self-service account is only available if token store supports it
+	if _, ok := unwrapStore(tokenStore).(AdminStore); ok && s.Config.AllowAccount {
+		account := new(Account)
+		account.BindRoutes(prefixURI + "/account")
+	}
+}
+
+// matchRealm finds the realm of a host and a path.
+//
+// @param
+// - host {string} (request's host, port is ignored)
+// - path {string} (request's path)
+//
+// @return
+// - realm {Realm} (a matched realm or the default realm)
+func (s *OAuthServer) matchRealm(host string, path string) *Realm {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+
+	for _, realm := range *s.realms {
+		/* Condition validation: Validate path prefix */
+		if len(realm.PathPrefix) > 0 && path != realm.PathPrefix && !strings.HasPrefix(path, realm.PathPrefix+"/") {
+			continue
+		}
+
+		/* Condition validation: Validate host */
+		isMatched := len(realm.Hosts) == 0
+		for _, realmHost := range realm.Hosts {
+			if realmHost == host {
+				isMatched = true
+				break
+			}
+		}
+		if isMatched {
+			return realm
+		}
+	}
+	return s.defaultRealm()
+}
+
+// defaultRealm returns the realm that is backed by server's config and server's token store.
+//
+// @return
+// - realm {Realm} (the default realm)
+func (s *OAuthServer) defaultRealm() *Realm {
+	return &Realm{
+		Config:           s.Config,
+		Store:            s.Store,
+		clock:            s.clock,
+		grantsValidation: s.grantsValidation,
+	}
+}
@@ -0,0 +1,240 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fixedClock is a clock that always returns the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func Test_CreateOAuthServer_Defaults(t *testing.T) {
+	s := CreateOAuthServer()
+
+	if s.Config == nil || s.Config.AccessTokenDuration != 259200*time.Second {
+		t.Errorf("Expected default config with converted durations but found %v.", s.Config)
+	}
+	if store, ok := s.Store.(*MongoDBStore); !ok || store.privateKey == nil {
+		t.Error("Expected default token store should be a MongoDB token store with a JWT key.")
+	}
+	if _, ok := s.clock.(systemClock); !ok {
+		t.Error("Expected default clock should be system's clock.")
+	}
+	if !s.defaultRealm().AllowsGrant(PasswordGrant) || s.defaultRealm().AllowsGrant(ImplicitGrant) {
+		t.Error("Expected default realm should only allow config's grant types.")
+	}
+}
+
+func Test_OAuthServer_Isolation(t *testing.T) {
+	defer func() { realms = nil }()
+
+	brandKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	brandConfig := DefaultConfig()
+	brandConfig.Issuer = "brand"
+	brandConfig.GrantTypes = []string{PasswordGrant}
+
+	brand := CreateOAuthServer(WithConfig(brandConfig), WithKeys(brandKey))
+	other := CreateOAuthServer(WithKeys(otherKey))
+
+	// [Test 1] Servers do not accept each other's tokens
+	now := time.Now()
+	token := &MongoDBToken{
+		ID:      bson.NewObjectId(),
+		User:    bson.NewObjectId(),
+		Client:  "web",
+		Created: now,
+		Expired: now.Add(time.Hour),
+
+		privateKey: brandKey,
+		issuer:     "brand",
+	}
+	if brand.Store.(*MongoDBStore).parseToken(token.Token()) == nil {
+		t.Error("Expected token should be accepted by its server.")
+	}
+	if other.Store.(*MongoDBStore).parseToken(token.Token()) != nil {
+		t.Error("Expected token should be rejected by another server.")
+	}
+
+	// [Test 2] Servers have their own config
+	if brand.defaultRealm().AllowsGrant(ClientCredentialsGrant) || !other.defaultRealm().AllowsGrant(ClientCredentialsGrant) {
+		t.Error("Expected servers should validate grant types with their own config.")
+	}
+
+	// [Test 3] Servers have their own realms
+	if err := brand.RegisterRealm(&Realm{Name: "app", PathPrefix: "/app", Config: brandConfig, Store: brand.Store}); err != nil {
+		t.Fatal(err)
+	}
+	if realm := brand.matchRealm("example.com", "/app/token"); realm.Name != "app" {
+		t.Errorf(expectedFormat.StringButFoundString, "app", realm.Name)
+	}
+	if realm := other.matchRealm("example.com", "/app/token"); !realm.IsDefault() {
+		t.Errorf(expectedFormat.StringButFoundString, "", realm.Name)
+	}
+	if len(realms) != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, len(realms))
+	}
+	if err := other.RegisterRealm(&Realm{Name: "app", PathPrefix: "/app", Config: brandConfig, Store: other.Store}); err != nil {
+		t.Error("Expected realm's name should only be unique within a server.")
+	}
+}
+
+func Test_OAuthServer_Clock(t *testing.T) {
+	now := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := CreateOAuthServer(WithClock(fixedClock(now)))
+
+	realm := s.defaultRealm()
+	if !realm.now().Equal(now) {
+		t.Errorf("Expected realm's time should be %v but found %v.", now, realm.now())
+	}
+	if realm.isExpired(now.Add(time.Second)) {
+		t.Error("Expected token should not be expired according to server's clock.")
+	}
+	if !realm.isExpired(now) {
+		t.Error("Expected token should be expired according to server's clock.")
+	}
+
+	if err := s.RegisterRealm(&Realm{Name: "brand", Hosts: []string{"brand.example.com"}, Config: s.Config, Store: s.Store}); err != nil {
+		t.Fatal(err)
+	}
+	if realm = s.matchRealm("brand.example.com", "/token"); !realm.now().Equal(now) {
+		t.Error("Expected registered realm should use server's clock.")
+	}
+}
+
+func Test_OAuthServer_ClockOfSessionsAndProofs(t *testing.T) {
+	now := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	realm := CreateOAuthServer(WithClock(fixedClock(now))).defaultRealm()
+
+	// [Test 1] Login session is timed by realm's clock
+	session := createLoginSession(realm, "user-1")
+	if !session.Created.Equal(now) || !session.LastSeen.Equal(now) {
+		t.Errorf("Expected session's time should be %v but found %v.", now, session.Created)
+	}
+	if session.IsExpired() {
+		t.Error("Expected session should not be expired according to server's clock.")
+	}
+	session.LastSeen = now.Add(-realm.Config.SessionIdleTimeout - time.Second)
+	if !session.IsExpired() {
+		t.Error("Expected idle session should be expired according to server's clock.")
+	}
+
+	// [Test 2] Proof is remembered until it is expired according to realm's clock
+	store := CreateMemoryDPoPReplayStore()
+	if !store.SaveProof("proof", realm.now(), now.Add(time.Minute)) {
+		t.Error("Expected new proof should be saved.")
+	}
+	if store.SaveProof("proof", realm.now(), now.Add(time.Minute)) {
+		t.Error("Expected used proof should be rejected.")
+	}
+}
+
+func Test_OAuthServer_ClockOfEvents(t *testing.T) {
+	now := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := CreateOAuthServer(WithClock(fixedClock(now)))
+
+	var event SecurityEvent
+	ts := httptest.NewServer(Handler(s.Attach()(func(c *server.RequestContext) {
+		event = createSecurityEvent(c, LoginFailedEvent, nil, InvalidCredentialsReason)
+	})))
+	defer ts.Close()
+
+	http.Get(ts.URL)
+	if !event.Time.Equal(now) {
+		t.Errorf("Expected event's time should be %v but found %v.", now, event.Time)
+	}
+}
+
+func Test_OAuthServer_BindService(t *testing.T) {
+	previousServer := serviceServer
+	defer func() { serviceServer = previousServer }()
+
+	serviceServer = CreateOAuthServer()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(expectedFormat.Panic)
+		}
+	}()
+	CreateOAuthServer().BindService()
+}
+
+func Test_OAuthServer_ParallelInstances(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	createInstance := func(issuer string) (*httptest.Server, *httptest.Server) {
+		privateKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+		config := DefaultConfig()
+		config.Issuer = issuer
+
+		s := CreateOAuthServer(WithConfig(config), WithKeys(privateKey))
+		tokenServer := httptest.NewServer(Handler(s.HandleToken))
+		resourceServer := httptest.NewServer(Handler(server.Adapt(func(c *server.RequestContext) {
+			c.OutputStatus(util.Status200())
+		}, s.ValidateToken())))
+		return tokenServer, resourceServer
+	}
+	brandToken, brandResource := createInstance("brand")
+	defer brandToken.Close()
+	defer brandResource.Close()
+	otherToken, otherResource := createInstance("other")
+	defer otherToken.Close()
+	defer otherResource.Close()
+
+	callResource := func(resourceURL string, accessToken string) int {
+		request, _ := http.NewRequest("GET", resourceURL, nil)
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return 0
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// Each instance issues and validates its own tokens while the other one is serving
+	var wg sync.WaitGroup
+	serve := func(tokenURL string, resourceURL string, otherResourceURL string) {
+		defer wg.Done()
+
+		for i := 0; i < 5; i++ {
+			response, err := http.Post(tokenURL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
+				PasswordGrant, u.ClientID, u.ClientSecret, u.Username, u.Password)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			token := parseResult(response)
+
+			if status := callResource(resourceURL, token.AccessToken); status != 200 {
+				t.Errorf(expectedFormat.NumberButFoundNumber, 200, status)
+			}
+			if status := callResource(otherResourceURL, token.AccessToken); status != 401 {
+				t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+			}
+		}
+	}
+
+	wg.Add(2)
+	go serve(brandToken.URL, brandResource.URL, otherResource.URL)
+	go serve(otherToken.URL, otherResource.URL, brandResource.URL)
+	wg.Wait()
+}
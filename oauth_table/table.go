@@ -5,4 +5,9 @@ const (
 	Client       = "oauth_client"
 	AccessToken  = "oauth_access_token"
 	RefreshToken = "oauth_refresh_token"
+	AuditLog     = "oauth_audit_log"
+	Role         = "oauth_role"
+
+	AuthorizationCode = "oauth_authorization_code"
+	Consent           = "oauth_consent"
 )
@@ -1,9 +1,7 @@
 package oauth2
 
 import (
-	"fmt"
-	"regexp"
-	"strings"
+	"time"
 
 	"github.com/phuc0302/go-oauth2/oauth_key"
 	"github.com/phuc0302/go-server"
@@ -21,6 +19,97 @@ type OAuthContext struct {
 	AccessToken Token
 	// Refresh token that had been given to user. Might not be available all the time.
 	RefreshToken Token
+	// Realm that user belongs to. Always available.
+	Realm *Realm
+	// Grant type that had been requested. Only available at token endpoint.
+	GrantType string
+	// Scopes that user had consented to, null means client's allowed scopes. Only available at
+	// token endpoint.
+	Scopes []string
+	// Thumbprint of client's TLS certificate that access token is bound to. Only available at
+	// token endpoint if client had authenticated with mutual TLS.
+	CertificateThumbprint string
+	// JWK thumbprint of client's DPoP key that access token is bound to. Only available at token
+	// endpoint if client had presented a DPoP proof.
+	KeyThumbprint string
+
+	// User's effective roles and permissions, resolved once per request.
+	effectiveRoles map[string]bool
+	permissions    map[string]bool
+}
+
+// EffectiveRoles returns user's roles, including all inherited roles. If client is restricted,
+// only client's allowed roles are kept.
+//
+// @return
+// - roles {map[string]bool} (a set of effective roles)
+func (s *OAuthContext) EffectiveRoles() map[string]bool {
+	if s.effectiveRoles == nil {
+		if s.User == nil {
+			return map[string]bool{}
+		}
+		s.effectiveRoles = restrictRoles(s.Client, Roles.ResolveRoles(s.User.UserRoles()))
+	}
+	return s.effectiveRoles
+}
+
+// GrantedRoles returns the user's own roles that client may carry on behalf of user. Inherited
+// roles are never returned, they are resolved again whenever roles are validated.
+//
+// @return
+// - roles {[]string} (a list of granted roles)
+func (s *OAuthContext) GrantedRoles() []string {
+	if s.User == nil {
+		return nil
+	}
+
+	effectiveRoles := s.EffectiveRoles()
+	roles := make([]string, 0, len(s.User.UserRoles()))
+	for _, role := range s.User.UserRoles() {
+		if effectiveRoles[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// HasPermission checks if user's roles grant a permission.
+//
+// @param
+// - permission {string} (a required permission, e.g. "orders:write")
+//
+// @return
+// - isGranted {bool} (true if permission is granted)
+func (s *OAuthContext) HasPermission(permission string) bool {
+	if s.permissions == nil {
+		if s.User == nil {
+			return false
+		}
+		s.permissions = Roles.ResolvePermissions(sortedSet(s.EffectiveRoles()))
+	}
+	return matchPermission(s.permissions, permission)
+}
+
+// HasScope checks if access token had been granted a scope. Tokens that do not support scopes
+// have no scope.
+//
+// @param
+// - scope {string} (a required scope)
+//
+// @return
+// - isGranted {bool} (true if scope is granted)
+func (s *OAuthContext) HasScope(scope string) bool {
+	token, ok := s.AccessToken.(ScopedToken)
+	if !ok {
+		return false
+	}
+
+	for _, tokenScope := range token.TokenScopes() {
+		if tokenScope == scope {
+			return true
+		}
+	}
+	return false
 }
 
 // OAuthResponse describes a granted response that will be returned to client.
@@ -29,10 +118,34 @@ type OAuthResponse struct {
 	AccessToken  string `json:"access_token,omitempty"`
 	ExpiresIn    int64  `json:"expires_in,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 
 	Roles []string `json:"roles,omitempty"`
 }
 
+// restrictRoles keeps only client's allowed roles.
+//
+// @param
+// - client {Client} (a client entity, might be null)
+// - roles {map[string]bool} (a set of user's effective roles)
+//
+// @return
+// - roles {map[string]bool} (a set of roles that client may carry)
+func restrictRoles(client Client, roles map[string]bool) map[string]bool {
+	restrictedClient, ok := client.(RoleRestrictedClient)
+	if !ok || restrictedClient.ClientRoles() == nil {
+		return roles
+	}
+
+	allowedRoles := make(map[string]bool, len(roles))
+	for _, role := range restrictedClient.ClientRoles() {
+		if roles[role] {
+			allowedRoles[role] = true
+		}
+	}
+	return allowedRoles
+}
+
 // ValidateToken returns a wrapper oauth token validation func before HandleContextFunc.
 //
 // @return
@@ -40,50 +153,163 @@ type OAuthResponse struct {
 func ValidateToken() server.Adapter {
 	return func(f server.HandleContextFunc) server.HandleContextFunc {
 		return func(c *server.RequestContext) {
-			tokenString := c.Header["authorization"]
+			startTime := time.Now()
+			realm := RealmOf(c)
+			tokenString, isDPoP := readAccessToken(c)
 
-			/* Condition validation: Validate existing of authorization header */
-			if isBearer := bearerFinder.MatchString(tokenString); isBearer {
-				tokenString = tokenString[7:]
-			} else {
-				if tokenString = c.QueryParams["access_token"]; len(tokenString) > 0 {
-					delete(c.QueryParams, "access_token")
-				}
+			/* Condition validation: validate token */
+			oauthContext, accessToken := validateAccessToken(realm, tokenString)
+
+			/* Condition validation: Bound token must be presented with its certificate */
+			if oauthContext != nil && !isBoundTo(accessToken, clientCertificates(c)) {
+				Metrics.ObserveValidationFailure(CertificateMismatchReason)
+				Metrics.ObserveValidation(time.Since(startTime))
+				panic(util.Status401())
 			}
 
-			/* Condition validation: validate token */
-			if accessToken := Store.FindAccessToken(tokenString); accessToken != nil && !accessToken.IsExpired() {
-				client := Store.FindClientWithID(accessToken.ClientID())
-				user := Store.FindUserWithID(accessToken.UserID())
-
-				oauthContext := &OAuthContext{
-					Client:      client,
-					User:        user,
-					AccessToken: accessToken,
+			/* Condition validation: DPoP-bound token must be presented with a proof of its key */
+			if oauthContext != nil {
+				if errorCode := verifyDPoPBinding(realm, c.Header["dpop"], isDPoP, accessToken, createDPoPTarget(c, tokenString)); len(errorCode) > 0 {
+					Metrics.ObserveValidationFailure(InvalidDPoPProofReason)
+					Metrics.ObserveValidation(time.Since(startTime))
+					outputDPoPChallenge(c, realm, errorCode)
+					panic(util.Status401())
 				}
+			}
+
+			if oauthContext != nil {
 				c.SetExtra(oauthKey.Context, oauthContext)
 
 			} else if username, password, ok := c.BasicAuth(); ok {
-				client := Store.FindClientWithCredential(username, password)
-				user := Store.FindUserWithClient(username, password)
+				event := createSecurityEvent(c, ClientAuthFailedEvent, nil, InvalidCredentialsReason)
+				event.ClientID = username
+
+				/* Condition validation: Validate failed login attempts */
+				attemptKeys := loginAttemptKeys(c, username)
+				validateLoginAttempts(c, attemptKeys, event)
+
+				client := realm.Store.FindClientWithCredential(username, password)
+				user := realm.Store.FindUserWithClient(username, password)
 
 				if client != nil && user != nil {
+					unlockUser(realm, username)
 					oauthContext := &OAuthContext{
+						Realm:       realm,
 						Client:      client,
 						User:        user,
 						AccessToken: accessToken,
 					}
 					c.SetExtra(oauthKey.Context, oauthContext)
+				} else {
+					Metrics.ObserveValidationFailure(InvalidCredentialsReason)
+
+					Metrics.ObserveValidation(time.Since(startTime))
+
+					saveLoginFailure(c, attemptKeys)
+					publishEvent(event)
+					panic(util.Status401())
 				}
 			} else {
+				Metrics.ObserveValidationFailure(tokenFailureReason(tokenString, accessToken))
+				Metrics.ObserveValidation(time.Since(startTime))
 				panic(util.Status401())
 			}
+			Metrics.ObserveValidation(time.Since(startTime))
 			f(c)
 		}
 	}
 }
 
-// ValidateRoles returns a wrapper user's roles validation func before HandleContextFunc.
+// readAccessToken reads access token and its authorization scheme. DPoP-bound tokens are sent with
+// DPoP scheme, other tokens are read as bearer tokens.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - tokenString {string} (an access token or empty string)
+// - isDPoP {bool} (true if token had been sent with DPoP scheme)
+func readAccessToken(c *server.RequestContext) (string, bool) {
+	if authorization := c.Header["authorization"]; dpopFinder.MatchString(authorization) {
+		return authorization[5:], true
+	}
+	return readBearerToken(c), false
+}
+
+// readBearerToken reads access token from authorization header or from access_token query param.
+// The query param is removed, so it will not be passed to handler.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - tokenString {string} (an access token or empty string)
+func readBearerToken(c *server.RequestContext) string {
+	tokenString := c.Header["authorization"]
+
+	/* Condition validation: Validate existing of authorization header */
+	if isBearer := bearerFinder.MatchString(tokenString); isBearer {
+		return tokenString[7:]
+	}
+	if tokenString = c.QueryParams["access_token"]; len(tokenString) > 0 {
+		delete(c.QueryParams, "access_token")
+	}
+	return tokenString
+}
+
+// validateAccessToken finds an access token in realm's token store and returns its oauth context.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - tokenString {string} (an access token)
+//
+// @return
+// - oauthContext {OAuthContext} (token's oauth context or null if token is invalid or expired)
+// - accessToken {Token} (the access token if it exists, even if it is expired)
+func validateAccessToken(realm *Realm, tokenString string) (*OAuthContext, Token) {
+	/* Condition validation */
+	if len(tokenString) == 0 {
+		return nil, nil
+	}
+
+	accessToken := realm.Store.FindAccessToken(tokenString)
+	if accessToken == nil || realm.isExpired(accessToken.ExpiredTime()) {
+		return nil, accessToken
+	}
+
+	oauthContext := &OAuthContext{
+		Realm:       realm,
+		Client:      realm.Store.FindClientWithID(accessToken.ClientID()),
+		User:        realm.Store.FindUserWithID(accessToken.UserID()),
+		AccessToken: accessToken,
+	}
+	return oauthContext, accessToken
+}
+
+// tokenFailureReason describes why an access token had been rejected.
+//
+// @param
+// - tokenString {string} (an access token)
+// - accessToken {Token} (the access token if it exists)
+//
+// @return
+// - reason {string} (a failure reason)
+func tokenFailureReason(tokenString string, accessToken Token) string {
+	switch {
+
+	case len(tokenString) == 0:
+		return MissingTokenReason
+
+	case accessToken != nil:
+		return ExpiredTokenReason
+
+	default:
+		return InvalidTokenReason
+	}
+}
+
+// ValidateRoles returns a wrapper user's roles validation func before HandleContextFunc. User
+// must have at least one of roles.
 //
 // @param
 // - roles {[]string} (a list of acceptable users' roles)
@@ -91,26 +317,35 @@ func ValidateToken() server.Adapter {
 // @return
 // - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
 func ValidateRoles(roles ...string) server.Adapter {
+	/* Condition validation: validate role input */
+	if len(roles) == 0 {
+		return ValidateRoleMatcher(nil)
+	}
+	return ValidateRoleMatcher(AnyOf(roles...))
+}
+
+// ValidateRoleMatcher returns a wrapper user's roles validation func before HandleContextFunc.
+// The matcher is built once when route is bound, e.g.
+// ValidateRoleMatcher(MatchAll(AllOf(oauthRole.Manager), NoneOf(oauthRole.Web))).
+//
+// @param
+// - matcher {RoleMatcher} (a role matcher, null will accept all roles)
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func ValidateRoleMatcher(matcher RoleMatcher) server.Adapter {
 	return func(f server.HandleContextFunc) server.HandleContextFunc {
-		/* Condition validation: validate role input */
-		if roles == nil || len(roles) <= 0 {
+		/* Condition validation: validate matcher input */
+		if matcher == nil {
 			return f
 		}
 
 		return func(c *server.RequestContext) {
-			if oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext); ok && Store != nil && oauthContext.User != nil {
-				roleValidator := regexp.MustCompile(fmt.Sprintf("^(%s)$", strings.Join(roles, "|")))
-				isAuthorized := false
-
-				for _, role := range oauthContext.User.UserRoles() {
-					if roleValidator.MatchString(role) {
-						isAuthorized = true
-						break
-					}
-				}
-
+			if oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext); ok && oauthContext.User != nil {
 				// If user is not authorized, break
-				if !isAuthorized {
+				if !matcher.Match(oauthContext.EffectiveRoles()) {
+					Metrics.ObserveValidationFailure(InsufficientRolesReason)
+					publishEvent(createSecurityEvent(c, RoleCheckDeniedEvent, oauthContext, InsufficientRolesReason))
 					panic(util.Status401())
 				}
 			} else {
@@ -120,3 +355,97 @@ func ValidateRoles(roles ...string) server.Adapter {
 		}
 	}
 }
+
+// ValidatePermissions returns a wrapper user's permissions validation func before
+// HandleContextFunc. User must be granted all permissions.
+//
+// @param
+// - permissions {[]string} (a list of required permissions)
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func ValidatePermissions(permissions ...string) server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		/* Condition validation: validate permission input */
+		if len(permissions) == 0 {
+			return f
+		}
+
+		return func(c *server.RequestContext) {
+			oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext)
+			if !ok || oauthContext.User == nil {
+				panic(util.Status401())
+			}
+
+			for _, permission := range permissions {
+				if !oauthContext.HasPermission(permission) {
+					Metrics.ObserveValidationFailure(InsufficientPermissionsReason)
+					publishEvent(createSecurityEvent(c, RoleCheckDeniedEvent, oauthContext, InsufficientPermissionsReason))
+					panic(util.Status401())
+				}
+			}
+			f(c)
+		}
+	}
+}
+
+// ValidatePolicy validates request against a policy expression. Expression is compiled when
+// adapter is created, an invalid expression will panic at bind time.
+//
+// @param
+// - expression {string} (a policy expression, see PolicyExpression)
+//
+// @return
+// - adapter {server.Adapter} (a policy validation adapter)
+func ValidatePolicy(expression string) server.Adapter {
+	policy := MustCompilePolicy(expression)
+
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		return func(c *server.RequestContext) {
+			oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext)
+			if !ok {
+				panic(util.Status401())
+			}
+
+			if !policy.Evaluate(c, oauthContext) {
+				Metrics.ObserveValidationFailure(PolicyDeniedReason)
+				publishEvent(createSecurityEvent(c, RoleCheckDeniedEvent, oauthContext, PolicyDeniedReason))
+				panic(util.Status401())
+			}
+			f(c)
+		}
+	}
+}
+
+// ValidateScopes returns a wrapper access token's scopes validation func before
+// HandleContextFunc. Access token must be granted all scopes.
+//
+// @param
+// - scopes {[]string} (a list of required scopes)
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func ValidateScopes(scopes ...string) server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		/* Condition validation: validate scope input */
+		if len(scopes) == 0 {
+			return f
+		}
+
+		return func(c *server.RequestContext) {
+			oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext)
+			if !ok || oauthContext.User == nil {
+				panic(util.Status401())
+			}
+
+			for _, scope := range scopes {
+				if !oauthContext.HasScope(scope) {
+					Metrics.ObserveValidationFailure(InsufficientScopesReason)
+					publishEvent(createSecurityEvent(c, RoleCheckDeniedEvent, oauthContext, InsufficientScopesReason))
+					panic(util.Status401())
+				}
+			}
+			f(c)
+		}
+	}
+}
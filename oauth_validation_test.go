@@ -66,11 +66,44 @@ func Test_ValidateToken_WithBasicAuth(t *testing.T) {
 
 	// Send token as query param
 	request, _ := http.NewRequest("Get", ts.URL, nil)
-	request.SetBasicAuth(u.ClientID.Hex(), u.ClientSecret.Hex())
+	request.SetBasicAuth(u.ClientID, u.ClientSecret)
 
 	http.DefaultClient.Do(request)
 }
 
+func Test_ValidateToken_BasicAuthLockout(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+
+	ts := httptest.NewServer(Handler(server.Adapt(func(c *server.RequestContext) {
+		c.OutputStatus(util.Status200())
+	}, ValidateToken())))
+	defer ts.Close()
+
+	send := func(clientSecret string) int {
+		request, _ := http.NewRequest("GET", ts.URL, nil)
+		request.SetBasicAuth(u.ClientID, clientSecret)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return 0
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// [Test 1] First failure
+	if status := send("InvalidSecret"); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+
+	// [Test 2] Retry immediately should be rejected, even with valid secret
+	if status := send(u.ClientSecret); status != 429 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 429, status)
+	}
+}
+
 func Test_ValidateToken_WithGetAccessToken(t *testing.T) {
 	u := new(TestEnv)
 	defer u.Teardown()
@@ -105,7 +138,7 @@ func Test_ValidateToken_WithGetAccessToken(t *testing.T) {
 
 	// Generate token
 	now := time.Now()
-	token := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
 
 	// Send token as query param
 	http.Get(fmt.Sprintf("%s?access_token=%s", ts.URL, token.Token()))
@@ -145,7 +178,7 @@ func Test_ValidateToken_WithPostAccessToken(t *testing.T) {
 
 	// Generate token
 	now := time.Now().UTC()
-	token := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
 
 	// Send token as authorization header
 	request, _ := http.NewRequest("POST", ts.URL, nil)
@@ -155,6 +188,89 @@ func Test_ValidateToken_WithPostAccessToken(t *testing.T) {
 	client.Do(request)
 }
 
+// callProtectedRoute sends a request with an access token to a route that is protected by
+// ValidateToken and returns response's status code.
+func callProtectedRoute(token string) int {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		ValidateToken()(func(c *server.RequestContext) {})(context)
+	}))
+	defer ts.Close()
+
+	request, _ := http.NewRequest("GET", ts.URL, nil)
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0
+	}
+	return response.StatusCode
+}
+
+func Test_ValidateToken_RevokedToken(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	if status := callProtectedRoute(token.Token()); status != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, status)
+	}
+
+	adminStore, _ := Store.(AdminStore)
+	adminStore.DeleteAccessTokenWithID(tokenID(token))
+	if status := callProtectedRoute(token.Token()); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
+
+func Test_ValidateToken_RevokedUserTokens(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+
+	adminStore, _ := Store.(AdminStore)
+	adminStore.DeleteUserTokens(u.UserID.Hex())
+	if status := callProtectedRoute(token.Token()); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
+
+func Test_ValidateToken_DeletedUser(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+
+	adminStore, _ := Store.(AdminStore)
+	adminStore.DeleteUser(u.UserID.Hex())
+	if status := callProtectedRoute(token.Token()); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
+
+func Test_ValidateToken_DeletedClient(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	now := time.Now()
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+
+	registrationStore, _ := Store.(ClientRegistrationStore)
+	registrationStore.DeleteClient(u.ClientID)
+	if status := callProtectedRoute(token.Token()); status != 401 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 401, status)
+	}
+}
+
 func Test_ValidateRoles_InvalidRoles(t *testing.T) {
 	u := new(TestEnv)
 	defer u.Teardown()
@@ -185,7 +301,7 @@ func Test_ValidateRoles_InvalidRoles(t *testing.T) {
 
 	// Generate token
 	now := time.Now().UTC()
-	token := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
 
 	// Send token as authorization header
 	request, _ := http.NewRequest("POST", ts.URL, nil)
@@ -214,7 +330,7 @@ func Test_ValidateRoles_ValidRoles(t *testing.T) {
 
 	// Generate token
 	now := time.Now().UTC()
-	token := Store.CreateAccessToken(u.ClientID.Hex(), u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
+	token := Store.CreateAccessToken(u.ClientID, u.UserID.Hex(), now, now.Add(Cfg.AccessTokenDuration))
 
 	// Send token as authorization header
 	request, _ := http.NewRequest("POST", ts.URL, nil)
@@ -228,3 +344,43 @@ func Test_ValidateRoles_ValidRoles(t *testing.T) {
 		t.Errorf(expectedFormat.NumberButFoundNumber, 200, status.Code)
 	}
 }
+
+func Test_OAuthContext_RestrictedClient(t *testing.T) {
+	Roles, _ = CreateRoleHierarchy([]RoleDefinition{
+		{Name: "r_admin", Inherits: []string{"r_user"}, Permissions: []string{"users:*"}},
+		{Name: "r_user", Permissions: []string{"orders:read"}},
+	})
+	defer func() { Roles = nil }()
+
+	user := &MongoDBUser{Roles: []string{"r_admin"}}
+
+	// [Test 1] Unrestricted client carries all user's roles
+	s := &OAuthContext{User: user, Client: &MongoDBClient{}}
+	if !s.EffectiveRoles()["r_admin"] || len(s.GrantedRoles()) != 1 || s.GrantedRoles()[0] != "r_admin" {
+		t.Error("Expected unrestricted client should carry all user's roles.")
+	}
+
+	// [Test 2] Restricted client carries intersection of roles
+	s = &OAuthContext{User: user, Client: &MongoDBClient{Roles: []string{"r_user", "r_manager"}}}
+	if s.EffectiveRoles()["r_admin"] || !s.EffectiveRoles()["r_user"] {
+		t.Error("Expected restricted client should only carry allowed roles.")
+	}
+	if roles := s.GrantedRoles(); len(roles) != 0 {
+		t.Errorf("Expected inherited roles should not be granted but found %v.", roles)
+	}
+	if s.HasPermission("users:delete") || !s.HasPermission("orders:read") {
+		t.Error("Expected restricted client should only carry allowed roles' permissions.")
+	}
+
+	// [Test 3] Restricted client carries user's own roles that are allowed
+	s = &OAuthContext{User: &MongoDBUser{Roles: []string{"r_manager", "r_admin"}}, Client: &MongoDBClient{Roles: []string{"r_user", "r_manager"}}}
+	if roles := s.GrantedRoles(); len(roles) != 1 || roles[0] != "r_manager" {
+		t.Errorf("Expected roles [r_manager] but found %v.", roles)
+	}
+
+	// [Test 4] Client that allows no role
+	s = &OAuthContext{User: user, Client: &MongoDBClient{Roles: []string{}}}
+	if len(s.EffectiveRoles()) != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, len(s.EffectiveRoles()))
+	}
+}
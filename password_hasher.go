@@ -0,0 +1,268 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password hashing algorithms.
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+	PBKDF2Algorithm   = "pbkdf2"
+)
+
+// Hashed password's prefixes.
+const (
+	argon2idPrefix = "$argon2id$"
+	pbkdf2Prefix   = "$pbkdf2-sha256$"
+)
+
+// Length of generated salt and derived key, in bytes.
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+// BcryptHasher describes a bcrypt password hasher.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash returns hashed password in "$2a$<cost>$<salt+hash>" form.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+// Compare compares a bcrypt hashed password with a plain text password.
+func (h *BcryptHasher) Compare(hashedPassword string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+// NeedsRehash checks if a hashed password is not bcrypt or has lower cost than current policy.
+func (h *BcryptHasher) NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost < h.Cost
+}
+
+// Argon2idHasher describes an argon2id password hasher.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // In KiB
+	Threads uint8
+}
+
+// Hash returns hashed password in "$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>"
+// form.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, passwordKeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Memory,
+		h.Time,
+		h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Compare compares an argon2id hashed password with a plain text password.
+func (h *Argon2idHasher) Compare(hashedPassword string, password string) bool {
+	params, salt, key, ok := h.decode(hashedPassword)
+	if !ok {
+		return false
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
+
+// NeedsRehash checks if a hashed password is not argon2id or has weaker parameters than current
+// policy.
+func (h *Argon2idHasher) NeedsRehash(hashedPassword string) bool {
+	params, _, _, ok := h.decode(hashedPassword)
+	return !ok || params.Time < h.Time || params.Memory < h.Memory || params.Threads < h.Threads
+}
+
+// decode parses an argon2id hashed password.
+//
+// @param
+// - hashedPassword {string} (an argon2id hashed password)
+//
+// @return
+// - params {Argon2idHasher} (parameters that had been used to hash password)
+// - salt {[]byte} (password's salt)
+// - key {[]byte} (password's derived key)
+// - ok {bool} (false if hashed password is malformed)
+func (h *Argon2idHasher) decode(hashedPassword string) (params *Argon2idHasher, salt []byte, key []byte, ok bool) {
+	/* Condition validation */
+	if !strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		return nil, nil, nil, false
+	}
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, false
+	}
+
+	params = new(Argon2idHasher)
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, false
+	}
+
+	var err error
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, false
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return nil, nil, nil, false
+	}
+	return params, salt, key, true
+}
+
+// PBKDF2Hasher describes a PBKDF2-HMAC-SHA256 password hasher.
+type PBKDF2Hasher struct {
+	Iterations int
+}
+
+// Hash returns hashed password in "$pbkdf2-sha256$i=<iterations>$<salt>$<hash>" form.
+func (h *PBKDF2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, h.Iterations, passwordKeyLength, sha256.New)
+	return fmt.Sprintf("%si=%d$%s$%s",
+		pbkdf2Prefix,
+		h.Iterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Compare compares a PBKDF2 hashed password with a plain text password.
+func (h *PBKDF2Hasher) Compare(hashedPassword string, password string) bool {
+	iterations, salt, key, ok := h.decode(hashedPassword)
+	if !ok {
+		return false
+	}
+
+	otherKey := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha256.New)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
+
+// NeedsRehash checks if a hashed password is not PBKDF2 or has less iterations than current
+// policy.
+func (h *PBKDF2Hasher) NeedsRehash(hashedPassword string) bool {
+	iterations, _, _, ok := h.decode(hashedPassword)
+	return !ok || iterations < h.Iterations
+}
+
+// decode parses a PBKDF2 hashed password.
+//
+// @param
+// - hashedPassword {string} (a PBKDF2 hashed password)
+//
+// @return
+// - iterations {int} (number of iterations that had been used to hash password)
+// - salt {[]byte} (password's salt)
+// - key {[]byte} (password's derived key)
+// - ok {bool} (false if hashed password is malformed)
+func (h *PBKDF2Hasher) decode(hashedPassword string) (iterations int, salt []byte, key []byte, ok bool) {
+	/* Condition validation */
+	if !strings.HasPrefix(hashedPassword, pbkdf2Prefix) {
+		return 0, nil, nil, false
+	}
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 5 {
+		return 0, nil, nil, false
+	}
+	if _, err := fmt.Sscanf(parts[2], "i=%d", &iterations); err != nil || iterations <= 0 {
+		return 0, nil, nil, false
+	}
+
+	var err error
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return 0, nil, nil, false
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(key) == 0 {
+		return 0, nil, nil, false
+	}
+	return iterations, salt, key, true
+}
+
+// createPasswordHasher creates password hasher according to configuration.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+//
+// @return
+// - hasher {PasswordHasher} (a password hasher's instance)
+func createPasswordHasher(config *Config) PasswordHasher {
+	switch config.PasswordAlgorithm {
+
+	case Argon2idAlgorithm:
+		return &Argon2idHasher{
+			Time:    config.Argon2Time,
+			Memory:  config.Argon2Memory,
+			Threads: config.Argon2Threads,
+		}
+
+	case PBKDF2Algorithm:
+		return &PBKDF2Hasher{Iterations: config.PBKDF2Iterations}
+
+	default:
+		return &BcryptHasher{Cost: config.BcryptCost}
+	}
+}
+
+// comparePassword compares a hashed password with a plain text password. The algorithm is
+// detected from hashed password, so passwords that had been hashed with older policy can still
+// be verified.
+//
+// @param
+// - hashedPassword {string} (a self-describing hashed password)
+// - password {string} (a plain text password)
+//
+// @return
+// - isMatched {bool} (true if both passwords are matched)
+func comparePassword(hashedPassword string, password string) bool {
+	/* Condition validation */
+	if len(hashedPassword) == 0 || len(password) == 0 {
+		return false
+	}
+
+	switch {
+
+	case strings.HasPrefix(hashedPassword, argon2idPrefix):
+		return new(Argon2idHasher).Compare(hashedPassword, password)
+
+	case strings.HasPrefix(hashedPassword, pbkdf2Prefix):
+		return new(PBKDF2Hasher).Compare(hashedPassword, password)
+
+	default:
+		return new(BcryptHasher).Compare(hashedPassword, password)
+	}
+}
@@ -0,0 +1,76 @@
+package oauth2
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_PasswordHasher_Bcrypt(t *testing.T) {
+	hasher := &BcryptHasher{Cost: 10}
+	hashedPassword, _ := hasher.Hash("Password")
+
+	if !hasher.Compare(hashedPassword, "Password") || !comparePassword(hashedPassword, "Password") {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if hasher.Compare(hashedPassword, "password") {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+	if hasher.NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+	if !(&BcryptHasher{Cost: 11}).NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+}
+
+func Test_PasswordHasher_Argon2id(t *testing.T) {
+	hasher := &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}
+	hashedPassword, _ := hasher.Hash("Password")
+
+	if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf(expectedFormat.StringButFoundString, "$argon2id$v=19$m=1024,t=1,p=1$", hashedPassword)
+	}
+	if !hasher.Compare(hashedPassword, "Password") || !comparePassword(hashedPassword, "Password") {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if hasher.Compare(hashedPassword, "password") {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+	if hasher.NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+	if !(&Argon2idHasher{Time: 1, Memory: 2048, Threads: 1}).NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+}
+
+func Test_PasswordHasher_PBKDF2(t *testing.T) {
+	hasher := &PBKDF2Hasher{Iterations: 1000}
+	hashedPassword, _ := hasher.Hash("Password")
+
+	if !strings.HasPrefix(hashedPassword, "$pbkdf2-sha256$i=1000$") {
+		t.Errorf(expectedFormat.StringButFoundString, "$pbkdf2-sha256$i=1000$", hashedPassword)
+	}
+	if !hasher.Compare(hashedPassword, "Password") || !comparePassword(hashedPassword, "Password") {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if hasher.Compare(hashedPassword, "password") {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+	if !(&PBKDF2Hasher{Iterations: 2000}).NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+}
+
+func Test_PasswordHasher_NeedsRehashWithDifferentAlgorithm(t *testing.T) {
+	hashedPassword, _ := (&BcryptHasher{Cost: 10}).Hash("Password")
+
+	if !(&Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}).NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if !(&PBKDF2Hasher{Iterations: 1000}).NeedsRehash(hashedPassword) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+}
@@ -0,0 +1,473 @@
+package oauth2
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/phuc0302/go-server"
+)
+
+// PolicyExpression describes a compiled attribute-based policy. The grammar is:
+//
+//	expr       := and ("||" and)*
+//	and        := unary ("&&" unary)*
+//	unary      := "!" unary | "(" expr ")" | call | comparison
+//	call       := ("role" | "permission" | "scope" | "client_scope") "(" string ")"
+//	comparison := operand ("==" | "!=") operand
+//	operand    := string | "user.id" | "user.username" | "client.id" | "grant_type" |
+//	              "param." name | "header." name
+//
+// e.g. `user.id == param.user_id || role("r_manager")` or
+// `role("r_ios") && client_scope("offline")`.
+type PolicyExpression struct {
+	source string
+	root   policyNode
+}
+
+// CompilePolicy compiles a policy expression.
+//
+// @param
+// - expression {string} (a policy expression)
+//
+// @return
+// - policy {PolicyExpression} (a compiled policy)
+// - err {error} (a syntax error with its position)
+func CompilePolicy(expression string) (*PolicyExpression, error) {
+	tokens, err := tokenizePolicy(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &policyParser{source: expression, tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != policyEOF {
+		return nil, parser.errorAt(token, "unexpected \"%s\"", token.value)
+	}
+	return &PolicyExpression{source: expression, root: root}, nil
+}
+
+// MustCompilePolicy is like CompilePolicy but panics if expression is invalid.
+//
+// @param
+// - expression {string} (a policy expression)
+//
+// @return
+// - policy {PolicyExpression} (a compiled policy)
+func MustCompilePolicy(expression string) *PolicyExpression {
+	policy, err := CompilePolicy(expression)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// String returns policy's source expression.
+func (p *PolicyExpression) String() string {
+	return p.source
+}
+
+// Evaluate evaluates policy against an oauth context and a request.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - s {OAuthContext} (an oauth context)
+//
+// @return
+// - isAllowed {bool} (true if policy is satisfied)
+func (p *PolicyExpression) Evaluate(c *server.RequestContext, s *OAuthContext) bool {
+	return p.root.evaluate(&policyEnv{c: c, s: s})
+}
+
+// policyEnv describes policy's evaluation environment.
+type policyEnv struct {
+	c *server.RequestContext
+	s *OAuthContext
+}
+
+// policyNode describes a boolean node.
+type policyNode interface {
+	evaluate(env *policyEnv) bool
+}
+
+// policyOperand describes a string operand.
+type policyOperand interface {
+	value(env *policyEnv) (string, bool)
+}
+
+// Boolean nodes.
+type (
+	policyOr struct {
+		left  policyNode
+		right policyNode
+	}
+	policyAnd struct {
+		left  policyNode
+		right policyNode
+	}
+	policyNot struct {
+		node policyNode
+	}
+	policyCompare struct {
+		left    policyOperand
+		right   policyOperand
+		isEqual bool
+	}
+	policyCall struct {
+		name     string
+		argument string
+	}
+)
+
+func (n *policyOr) evaluate(env *policyEnv) bool {
+	return n.left.evaluate(env) || n.right.evaluate(env)
+}
+
+func (n *policyAnd) evaluate(env *policyEnv) bool {
+	return n.left.evaluate(env) && n.right.evaluate(env)
+}
+
+func (n *policyNot) evaluate(env *policyEnv) bool {
+	return !n.node.evaluate(env)
+}
+
+// evaluate compares two operands. Missing attributes are never equal to anything.
+func (n *policyCompare) evaluate(env *policyEnv) bool {
+	left, ok1 := n.left.value(env)
+	right, ok2 := n.right.value(env)
+	if !ok1 || !ok2 {
+		return !n.isEqual
+	}
+	return (left == right) == n.isEqual
+}
+
+func (n *policyCall) evaluate(env *policyEnv) bool {
+	s := env.s
+	if s == nil {
+		return false
+	}
+
+	switch n.name {
+
+	case "role":
+		return s.EffectiveRoles()[n.argument]
+
+	case "permission":
+		return s.HasPermission(n.argument)
+
+	case "scope":
+		return s.HasScope(n.argument)
+
+	default:
+		client, ok := s.Client.(ScopedClient)
+		if !ok {
+			return false
+		}
+		for _, scope := range client.ClientScopes() {
+			if scope == n.argument {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Operands.
+type (
+	policyLiteral struct {
+		text string
+	}
+	policyAttribute struct {
+		name string
+		key  string
+	}
+)
+
+func (o *policyLiteral) value(env *policyEnv) (string, bool) {
+	return o.text, true
+}
+
+func (o *policyAttribute) value(env *policyEnv) (string, bool) {
+	s := env.s
+	switch o.name {
+
+	case "user.id":
+		if s != nil && s.User != nil {
+			return s.User.UserID(), true
+		}
+
+	case "user.username":
+		if s != nil && s.User != nil {
+			return s.User.Username(), true
+		}
+
+	case "client.id":
+		if s != nil && s.Client != nil {
+			return s.Client.ClientID(), true
+		}
+
+	case "grant_type":
+		if s != nil && len(s.GrantType) > 0 {
+			return s.GrantType, true
+		}
+
+	case "param":
+		if env.c != nil {
+			value, ok := env.c.PathParams[o.key]
+			return value, ok && len(value) > 0
+		}
+
+	case "header":
+		if env.c != nil {
+			value, ok := env.c.Header[o.key]
+			return value, ok && len(value) > 0
+		}
+	}
+	return "", false
+}
+
+// Policy token's kinds.
+const (
+	policyEOF = iota
+	policyIdentifier
+	policyString
+	policySymbol
+)
+
+// policyToken describes a lexical token.
+type policyToken struct {
+	kind     int
+	value    string
+	position int
+}
+
+// tokenizePolicy splits a policy expression into tokens.
+//
+// @param
+// - expression {string} (a policy expression)
+//
+// @return
+// - tokens {[]policyToken} (a list of tokens, always ends with EOF)
+// - err {error} (a lexical error)
+func tokenizePolicy(expression string) ([]policyToken, error) {
+	var tokens []policyToken
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("Invalid policy \"%s\": unterminated string at %d.", expression, start)
+			}
+			tokens = append(tokens, policyToken{kind: policyString, value: string(runes[start+1 : i]), position: start})
+			i++
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.-", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, policyToken{kind: policyIdentifier, value: string(runes[start:i]), position: start})
+
+		default:
+			start := i
+			symbol := string(r)
+			if i+1 < len(runes) {
+				if pair := string(runes[i : i+2]); pair == "&&" || pair == "||" || pair == "==" || pair == "!=" {
+					symbol = pair
+				}
+			}
+			switch symbol {
+
+			case "&&", "||", "==", "!=", "!", "(", ")":
+				break
+
+			default:
+				return nil, fmt.Errorf("Invalid policy \"%s\": unexpected \"%s\" at %d.", expression, symbol, start)
+			}
+			tokens = append(tokens, policyToken{kind: policySymbol, value: symbol, position: start})
+			i += len([]rune(symbol))
+		}
+	}
+	return append(tokens, policyToken{kind: policyEOF, position: len(runes)}), nil
+}
+
+// policyParser describes a recursive descent parser.
+type policyParser struct {
+	source string
+	tokens []policyToken
+	index  int
+}
+
+func (p *policyParser) peek() policyToken {
+	return p.tokens[p.index]
+}
+
+func (p *policyParser) next() policyToken {
+	token := p.tokens[p.index]
+	if token.kind != policyEOF {
+		p.index++
+	}
+	return token
+}
+
+func (p *policyParser) isSymbol(symbol string) bool {
+	token := p.peek()
+	return token.kind == policySymbol && token.value == symbol
+}
+
+func (p *policyParser) expectSymbol(symbol string) error {
+	if token := p.next(); token.kind != policySymbol || token.value != symbol {
+		return p.errorAt(token, "expected \"%s\"", symbol)
+	}
+	return nil
+}
+
+func (p *policyParser) errorAt(token policyToken, format string, args ...interface{}) error {
+	if token.kind == policyEOF {
+		format = "unexpected end, " + format
+	}
+	return fmt.Errorf("Invalid policy \"%s\": %s at %d.", p.source, fmt.Sprintf(format, args...), token.position)
+}
+
+func (p *policyParser) parseOr() (policyNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isSymbol("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &policyOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseAnd() (policyNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isSymbol("&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &policyAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseUnary() (policyNode, error) {
+	switch {
+
+	case p.isSymbol("!"):
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &policyNot{node: node}, nil
+
+	case p.isSymbol("("):
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	// Function call
+	token := p.peek()
+	if token.kind == policyIdentifier && p.tokens[p.index+1].kind == policySymbol && p.tokens[p.index+1].value == "(" {
+		return p.parseCall()
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseCall() (policyNode, error) {
+	name := p.next()
+	switch name.value {
+
+	case "role", "permission", "scope", "client_scope":
+		break
+
+	default:
+		return nil, p.errorAt(name, "unknown function \"%s\"", name.value)
+	}
+
+	p.next()
+	argument := p.next()
+	if argument.kind != policyString {
+		return nil, p.errorAt(argument, "expected string argument")
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return &policyCall{name: name.value, argument: argument.value}, nil
+}
+
+func (p *policyParser) parseComparison() (policyNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	operator := p.next()
+	if operator.kind != policySymbol || (operator.value != "==" && operator.value != "!=") {
+		return nil, p.errorAt(operator, "expected \"==\" or \"!=\"")
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &policyCompare{left: left, right: right, isEqual: operator.value == "=="}, nil
+}
+
+func (p *policyParser) parseOperand() (policyOperand, error) {
+	token := p.next()
+	switch token.kind {
+
+	case policyString:
+		return &policyLiteral{text: token.value}, nil
+
+	case policyIdentifier:
+		switch {
+
+		case token.value == "user.id" || token.value == "user.username" || token.value == "client.id" || token.value == "grant_type":
+			return &policyAttribute{name: token.value}, nil
+
+		case strings.HasPrefix(token.value, "param.") && len(token.value) > len("param."):
+			return &policyAttribute{name: "param", key: strings.TrimPrefix(token.value, "param.")}, nil
+
+		case strings.HasPrefix(token.value, "header.") && len(token.value) > len("header."):
+			return &policyAttribute{name: "header", key: strings.ToLower(strings.TrimPrefix(token.value, "header."))}, nil
+		}
+		return nil, p.errorAt(token, "unknown attribute \"%s\"", token.value)
+	}
+	return nil, p.errorAt(token, "expected attribute or string")
+}
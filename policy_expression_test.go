@@ -0,0 +1,106 @@
+package oauth2
+
+import (
+	"testing"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// testScopedClient describes a client that is restricted to a set of scopes.
+type testScopedClient struct {
+	MongoDBClient
+	scopes []string
+}
+
+func (c *testScopedClient) ClientScopes() []string {
+	return c.scopes
+}
+
+func Test_CompilePolicy(t *testing.T) {
+	// [Test 1] Valid expressions
+	validExpressions := []string{
+		`role("r_manager")`,
+		`user.id == param.user_id || role("r_manager")`,
+		`role("r_ios") && client_scope('offline')`,
+		`!(header.x-debug == "1") && (scope("orders") || permission("orders:*"))`,
+	}
+	for _, expression := range validExpressions {
+		if _, err := CompilePolicy(expression); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// [Test 2] Invalid expressions
+	invalidExpressions := []string{
+		``,
+		`role(r_manager)`,
+		`role("r_manager"`,
+		`owner("r_manager")`,
+		`user.name == "a"`,
+		`user.id = param.user_id`,
+		`role("r_manager") ||`,
+		`role("r_manager") role("r_user")`,
+		`param. == "1"`,
+		`role("r_manager) `,
+	}
+	for _, expression := range invalidExpressions {
+		if _, err := CompilePolicy(expression); err == nil {
+			t.Errorf("Expected \"%s\" should be rejected.", expression)
+		}
+	}
+}
+
+func Test_PolicyExpression_Evaluate(t *testing.T) {
+	owner := &MongoDBUser{ID: bson.NewObjectId(), User: "owner", Roles: []string{oauthRole.IOS}}
+	c := &server.RequestContext{
+		Header:     map[string]string{"x-tenant": "acme"},
+		PathParams: map[string]string{"user_id": owner.ID.Hex()},
+	}
+	s := &OAuthContext{User: owner, Client: &testScopedClient{scopes: []string{"offline"}}}
+
+	policy := MustCompilePolicy(`user.id == param.user_id || role("r_manager")`)
+
+	// [Test 1] Owner of resource
+	if !policy.Evaluate(c, s) {
+		t.Error("Expected owner should be allowed.")
+	}
+
+	// [Test 2] Neither owner nor manager
+	other := &OAuthContext{User: &MongoDBUser{ID: bson.NewObjectId(), Roles: []string{oauthRole.User}}}
+	if policy.Evaluate(c, other) {
+		t.Error("Expected other user should be denied.")
+	}
+
+	// [Test 3] Manager
+	manager := &OAuthContext{User: &MongoDBUser{ID: bson.NewObjectId(), Roles: []string{oauthRole.Manager}}}
+	if !policy.Evaluate(c, manager) {
+		t.Error("Expected manager should be allowed.")
+	}
+
+	// [Test 4] Role and client's scope
+	policy = MustCompilePolicy(`role("r_ios") && client_scope("offline") && header.X-Tenant == "acme"`)
+	if !policy.Evaluate(c, s) {
+		t.Error("Expected iOS user with offline client should be allowed.")
+	}
+	if policy.Evaluate(c, &OAuthContext{User: owner, Client: &MongoDBClient{}}) {
+		t.Error("Expected client without scopes should be denied.")
+	}
+
+	// [Test 5] Missing attribute is never equal
+	policy = MustCompilePolicy(`param.order_id == ""`)
+	if policy.Evaluate(c, s) {
+		t.Error("Expected missing path param should not be equal.")
+	}
+}
+
+func Test_ValidatePolicy(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error(expectedFormat.NotNil)
+		}
+	}()
+	ValidatePolicy(`role("r_manager") &&`)
+}
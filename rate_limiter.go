@@ -0,0 +1,174 @@
+package oauth2
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/util"
+)
+
+// Rate limited endpoints. Token endpoint is limited by the service. The service does not bind an
+// introspection endpoint, so IntrospectionEndpoint's limit only applies to application's own
+// routes that are wrapped with LimitRate(IntrospectionEndpoint).
+const (
+	IntrospectionEndpoint = "introspect"
+	TokenEndpoint         = "token"
+)
+
+// RateLimit describes a token bucket's policy: a client can burst up to Limit requests and the
+// bucket is refilled with Limit requests per Period.
+type RateLimit struct {
+	Limit  int           `json:"limit" bson:"limit"`
+	Period time.Duration `json:"period" bson:"period"` // In seconds
+}
+
+// Idle buckets are pruned at most once per this interval.
+const rateLimiterPruneInterval = time.Minute
+
+// rateBucket describes a token bucket's state.
+type rateBucket struct {
+	tokens     float64
+	period     time.Duration // Bucket is full again after being idle for this period
+	lastRefill time.Time
+}
+
+// RateLimiter describes an in-memory token bucket rate limiter.
+type RateLimiter struct {
+	buckets   map[string]*rateBucket
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+// CreateRateLimiter returns a default RateLimiter's instance.
+//
+// @return
+// - limiter {RateLimiter} (a rate limiter's instance)
+func CreateRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*rateBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes one request from a bucket.
+//
+// @param
+// - key {string} (bucket's key)
+// - limit {RateLimit} (bucket's policy, period must be in time.Duration unit)
+// - now {time.Time} (current time according to realm's clock)
+//
+// @return
+// - isAllowed {bool} (true if request is allowed)
+// - remaining {int} (number of requests remaining in the bucket)
+// - reset {time.Duration} (time until bucket is full, or until next request if not allowed)
+func (r *RateLimiter) Allow(key string, limit RateLimit, now time.Time) (isAllowed bool, remaining int, reset time.Duration) {
+	/* Condition validation */
+	if limit.Limit <= 0 || limit.Period <= 0 {
+		return true, 0, 0
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.prune(now)
+
+	capacity := float64(limit.Limit)
+	rate := capacity / float64(limit.Period) // Tokens per nanosecond
+
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: capacity, lastRefill: now}
+		r.buckets[key] = bucket
+	}
+
+	// Refill bucket
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.lastRefill))*rate)
+	bucket.period = limit.Period
+	bucket.lastRefill = now
+
+	if bucket.tokens < 1 {
+		return false, 0, time.Duration((1 - bucket.tokens) / rate)
+	}
+
+	bucket.tokens--
+	return true, int(bucket.tokens), time.Duration((capacity - bucket.tokens) / rate)
+}
+
+// prune removes buckets that had been idle for at least their own period, they would be full
+// anyway. Must be called with lock held.
+//
+// @param
+// - now {time.Time} (current time)
+func (r *RateLimiter) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < rateLimiterPruneInterval {
+		return
+	}
+
+	for key, bucket := range r.buckets {
+		if now.Sub(bucket.lastRefill) >= bucket.period {
+			delete(r.buckets, key)
+		}
+	}
+	r.lastPrune = now
+}
+
+// LimitRate returns a wrapper per-client rate limit func before HandleContextFunc. Must be used
+// after ValidateToken.
+//
+// @param
+// - endpoint {string} (endpoint's name that will be used to look up rate limit's policy)
+//
+// @return
+// - func {server.Adapter} (a wrapper func around developer's server.HandleContextFunc)
+func LimitRate(endpoint string) server.Adapter {
+	return func(f server.HandleContextFunc) server.HandleContextFunc {
+		return func(c *server.RequestContext) {
+			if oauthContext, ok := c.GetExtra(oauthKey.Context).(*OAuthContext); ok && oauthContext.Client != nil {
+				validateRateLimit(c, endpoint, oauthContext.Client)
+			}
+			f(c)
+		}
+	}
+}
+
+// validateRateLimit takes one request from client's bucket of an endpoint, outputs rate limit
+// headers and rejects the request if the bucket is empty.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - endpoint {string} (endpoint's name)
+// - client {Client} (a client entity)
+func validateRateLimit(c *server.RequestContext, endpoint string, client Client) {
+	/* Condition validation */
+	if limiter == nil || client == nil {
+		return
+	}
+
+	realm := RealmOf(c)
+	limit, ok := realm.Config.RateLimits[endpoint]
+	if rateLimitedClient, isRateLimited := client.(RateLimitedClient); isRateLimited {
+		if clientLimit := rateLimitedClient.ClientRateLimit(endpoint); clientLimit != nil {
+			limit, ok = *clientLimit, true
+		}
+	}
+	if !ok {
+		return
+	}
+	limit.Period *= time.Second
+
+	isAllowed, remaining, reset := limiter.Allow(realmKey(realm, fmt.Sprintf("%s:%s", endpoint, client.ClientID())), limit, realm.now())
+	resetSeconds := int64(math.Ceil(reset.Seconds()))
+
+	c.OutputHeader("RateLimit-Limit", fmt.Sprintf("%d", limit.Limit))
+	c.OutputHeader("RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	c.OutputHeader("RateLimit-Reset", fmt.Sprintf("%d", resetSeconds))
+
+	if !isAllowed {
+		c.OutputHeader("Retry-After", fmt.Sprintf("%d", resetSeconds))
+		panic(&util.Status{Code: 429, Description: "Too many requests, please try again later."})
+	}
+}
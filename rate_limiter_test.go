@@ -0,0 +1,71 @@
+package oauth2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_RateLimiter_Allow(t *testing.T) {
+	limiter := CreateRateLimiter()
+	limit := RateLimit{Limit: 2, Period: time.Hour}
+	now := time.Now()
+
+	if isAllowed, remaining, _ := limiter.Allow("token:client", limit, now); !isAllowed || remaining != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, remaining)
+	}
+	if isAllowed, remaining, _ := limiter.Allow("token:client", limit, now); !isAllowed || remaining != 0 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 0, remaining)
+	}
+
+	isAllowed, _, reset := limiter.Allow("token:client", limit, now)
+	if isAllowed {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, isAllowed)
+	}
+	if reset <= 0 || reset > limit.Period/2 {
+		t.Errorf("Expected reset should be in (0, %s] but found %s.", limit.Period/2, reset)
+	}
+
+	// Other buckets should not be affected
+	if isAllowed, _, _ := limiter.Allow("token:other_client", limit, now); !isAllowed {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, isAllowed)
+	}
+}
+
+func Test_RateLimiter_Prune(t *testing.T) {
+	limiter := CreateRateLimiter()
+	slowLimit := RateLimit{Limit: 1, Period: time.Hour}
+	fastLimit := RateLimit{Limit: 1, Period: time.Millisecond}
+	now := time.Now()
+
+	limiter.Allow("token:slow_client", slowLimit, now)
+	limiter.Allow("token:fast_client", fastLimit, now)
+
+	// A short period must not evict a bucket that is still refilling
+	now = now.Add(rateLimiterPruneInterval)
+	limiter.Allow("token:other_client", fastLimit, now)
+
+	if _, ok := limiter.buckets["token:fast_client"]; ok {
+		t.Error("Expected idle bucket should be pruned.")
+	}
+	if isAllowed, _, _ := limiter.Allow("token:slow_client", slowLimit, now); isAllowed {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, isAllowed)
+	}
+}
+
+func Test_RateLimiter_Refill(t *testing.T) {
+	limiter := CreateRateLimiter()
+	limit := RateLimit{Limit: 1, Period: 50 * time.Millisecond}
+	now := time.Now()
+
+	limiter.Allow("token:client", limit, now)
+	if isAllowed, _, _ := limiter.Allow("token:client", limit, now); isAllowed {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, isAllowed)
+	}
+
+	// Bucket is refilled according to given clock
+	if isAllowed, _, _ := limiter.Allow("token:client", limit, now.Add(60*time.Millisecond)); !isAllowed {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, isAllowed)
+	}
+}
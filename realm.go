@@ -0,0 +1,208 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-server"
+)
+
+// Realm describes an isolated tenant that is served by the same deployment. Each realm has its
+// own config, token store, signing key and issuer, so tokens that are issued by one realm are
+// rejected by the others.
+type Realm struct {
+	Name       string
+	Hosts      []string // Realm is selected if request's host is one of these hosts
+	PathPrefix string   // Realm is selected if request's path starts with this prefix
+
+	Config *Config
+	Store  TokenStore
+
+	clock            Clock
+	grantsValidation *regexp.Regexp
+}
+
+// RealmDefinition describes a realm in config file. Realm's config is the default config that
+// is overridden by the values of Config. Endpoints are bound once for all realms, so the flags
+// that enable endpoints are always taken from default config.
+type RealmDefinition struct {
+	Name       string          `json:"name"`
+	Hosts      []string        `json:"hosts,omitempty"`
+	PathPrefix string          `json:"path_prefix,omitempty"`
+	Config     json.RawMessage `json:"config,omitempty"`
+
+	// Realm's resolved config.
+	config *Config
+}
+
+// Realms of the default server, in registration's order.
+var realms []*Realm
+
+// RegisterRealm registers a realm to the default server. Requests are matched against realms in
+// registration's order, requests that are not matched by any realm belong to the default realm.
+//
+// @param
+// - realm {Realm} (a realm's instance)
+//
+// @return
+// - err {error} (an error if realm is invalid or duplicated)
+func RegisterRealm(realm *Realm) error {
+	return defaultServer().RegisterRealm(realm)
+}
+
+// prepareRealm validates a realm against registered realms and normalizes its hosts.
+//
+// @param
+// - registeredRealms {[]*Realm} (a list of registered realms)
+// - realm {Realm} (a realm's instance)
+//
+// @return
+// - err {error} (an error if realm is invalid or duplicated)
+func prepareRealm(registeredRealms []*Realm, realm *Realm) error {
+	/* Condition validation */
+	if realm == nil || len(realm.Name) == 0 {
+		return fmt.Errorf("Realm's name must not be empty.")
+	}
+	if realm.Config == nil || realm.Store == nil {
+		return fmt.Errorf("Realm \"%s\" must have a config and a token store.", realm.Name)
+	}
+	if len(realm.Hosts) == 0 && len(realm.PathPrefix) == 0 {
+		return fmt.Errorf("Realm \"%s\" must have hosts or a path prefix.", realm.Name)
+	}
+	if len(realm.PathPrefix) > 0 && (!strings.HasPrefix(realm.PathPrefix, "/") || strings.HasSuffix(realm.PathPrefix, "/")) {
+		return fmt.Errorf("Realm \"%s\" has an invalid path prefix \"%s\".", realm.Name, realm.PathPrefix)
+	}
+	for _, registeredRealm := range registeredRealms {
+		if registeredRealm.Name == realm.Name {
+			return fmt.Errorf("Realm \"%s\" is duplicated.", realm.Name)
+		}
+	}
+
+	for i, host := range realm.Hosts {
+		realm.Hosts[i] = strings.ToLower(host)
+	}
+	realm.grantsValidation = compileGrantsValidation(realm.Config.GrantTypes)
+	return nil
+}
+
+// RealmOf returns the realm that request belongs to. The realm is resolved once per request, if
+// request is not attached to any server, it is resolved from the default server.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+//
+// @return
+// - realm {Realm} (request's realm)
+func RealmOf(c *server.RequestContext) *Realm {
+	if realm, ok := c.GetExtra(oauthKey.Realm).(*Realm); ok {
+		return realm
+	}
+
+	realm := defaultServer().matchRealm(c.Header["host"], c.Path)
+	c.SetExtra(oauthKey.Realm, realm)
+	return realm
+}
+
+// IsDefault checks if realm is the default realm.
+func (r *Realm) IsDefault() bool {
+	return len(r.Name) == 0
+}
+
+// AllowsGrant checks if realm's config allows a grant type.
+//
+// @param
+// - grantType {string} (a grant type)
+//
+// @return
+// - isAllowed {bool} (true if grant type is allowed)
+func (r *Realm) AllowsGrant(grantType string) bool {
+	return r.grantsValidation != nil && r.grantsValidation.MatchString(grantType)
+}
+
+// now returns current time of realm's clock.
+func (r *Realm) now() time.Time {
+	if r.clock == nil {
+		return time.Now()
+	}
+	return r.clock.Now()
+}
+
+// isExpired checks if an expired time had been reached according to realm's clock.
+//
+// @param
+// - expiredTime {time.Time} (token's or code's expired time)
+//
+// @return
+// - isExpired {bool} (true if expired time had been reached)
+func (r *Realm) isExpired(expiredTime time.Time) bool {
+	return r.now().Unix() >= expiredTime.Unix()
+}
+
+// realmKey scopes a counter's key to a realm, so realms do not share counters.
+//
+// @param
+// - realm {Realm} (request's realm)
+// - key {string} (counter's key)
+//
+// @return
+// - key {string} (realm's counter's key)
+func realmKey(realm *Realm, key string) string {
+	if realm.IsDefault() {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", realm.Name, key)
+}
+
+// defaultRealm returns the default realm of the default server.
+//
+// @return
+// - realm {Realm} (the default realm)
+func defaultRealm() *Realm {
+	return defaultServer().defaultRealm()
+}
+
+// loadRealmConfig overrides a copy of default config with realm's config. Realm's issuer is
+// derived from realm's name if it is not overridden.
+//
+// @param
+// - config {Config} (default config, its durations must not be converted yet)
+// - definition {RealmDefinition} (a realm definition)
+//
+// @return
+// - config {Config} (realm's config)
+// - err {error} (an error if realm's config is invalid)
+func loadRealmConfig(config *Config, definition RealmDefinition) (*Config, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	realmConfig := new(Config)
+	if err = json.Unmarshal(configJSON, realmConfig); err != nil {
+		return nil, err
+	}
+	if len(definition.Config) > 0 {
+		if err = json.Unmarshal(definition.Config, realmConfig); err != nil {
+			return nil, fmt.Errorf("Realm \"%s\" has an invalid config: %s", definition.Name, err.Error())
+		}
+	}
+	realmConfig.Realms = nil
+	realmConfig.AllowRegistration = config.AllowRegistration
+	realmConfig.AllowAccount = config.AllowAccount
+	realmConfig.AllowAdministration = config.AllowAdministration
+	realmConfig.AllowAudit = config.AllowAudit
+	realmConfig.AllowMetrics = config.AllowMetrics
+
+	if realmConfig.Issuer == config.Issuer {
+		if len(config.Issuer) > 0 {
+			realmConfig.Issuer = strings.TrimSuffix(config.Issuer, "/") + "/" + definition.Name
+		} else {
+			realmConfig.Issuer = definition.Name
+		}
+	}
+	return realmConfig, nil
+}
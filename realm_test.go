@@ -0,0 +1,157 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/phuc0302/go-server/expected_format"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_RegisterRealm(t *testing.T) {
+	defer func() { realms = nil }()
+
+	config := &Config{GrantTypes: []string{PasswordGrant}}
+	store := new(MongoDBStore)
+
+	invalidRealms := []*Realm{
+		{Hosts: []string{"a.example.com"}, Config: config, Store: store},
+		{Name: "a", Hosts: []string{"a.example.com"}, Store: store},
+		{Name: "a", Config: config, Store: store},
+		{Name: "a", PathPrefix: "a", Config: config, Store: store},
+		{Name: "a", PathPrefix: "/a/", Config: config, Store: store},
+	}
+	for _, realm := range invalidRealms {
+		if err := RegisterRealm(realm); err == nil {
+			t.Errorf("Expected realm %v should be rejected.", realm)
+		}
+	}
+
+	realm := &Realm{Name: "a", Hosts: []string{"A.example.com"}, Config: config, Store: store}
+	if err := RegisterRealm(realm); err != nil {
+		t.Fatal(err)
+	}
+	if realm.Hosts[0] != "a.example.com" {
+		t.Errorf(expectedFormat.StringButFoundString, "a.example.com", realm.Hosts[0])
+	}
+	if !realm.AllowsGrant(PasswordGrant) || realm.AllowsGrant(ClientCredentialsGrant) {
+		t.Error("Expected realm should only allow its config's grant types.")
+	}
+
+	// [Test] Duplicated realm
+	if err := RegisterRealm(&Realm{Name: "a", PathPrefix: "/a", Config: config, Store: store}); err == nil {
+		t.Error("Expected duplicated realm should be rejected.")
+	}
+}
+
+func Test_matchRealm(t *testing.T) {
+	defer func() { realms = nil }()
+
+	config := &Config{GrantTypes: []string{PasswordGrant}}
+	RegisterRealm(&Realm{Name: "host", Hosts: []string{"brand.example.com"}, Config: config, Store: new(MongoDBStore)})
+	RegisterRealm(&Realm{Name: "path", PathPrefix: "/brand", Config: config, Store: new(MongoDBStore)})
+	RegisterRealm(&Realm{Name: "both", Hosts: []string{"other.example.com"}, PathPrefix: "/other", Config: config, Store: new(MongoDBStore)})
+
+	cases := []struct {
+		host  string
+		path  string
+		realm string
+	}{
+		{"brand.example.com", "/token", "host"},
+		{"BRAND.example.com:8080", "/token", "host"},
+		{"example.com", "/brand", "path"},
+		{"example.com", "/brand/token", "path"},
+		{"example.com", "/brandx/token", ""},
+		{"other.example.com", "/other/token", "both"},
+		{"other.example.com", "/token", ""},
+		{"example.com", "/other/token", ""},
+		{"example.com", "/token", ""},
+	}
+	for _, testCase := range cases {
+		if realm := defaultServer().matchRealm(testCase.host, testCase.path); realm.Name != testCase.realm {
+			t.Errorf("Expected %s%s belongs to realm \"%s\" but found \"%s\".", testCase.host, testCase.path, testCase.realm, realm.Name)
+		}
+	}
+}
+
+func Test_loadRealmConfig(t *testing.T) {
+	config := &Config{
+		AllowRefreshToken:   true,
+		AllowAdministration: true,
+		GrantTypes:          []string{PasswordGrant, RefreshTokenGrant},
+		AccessTokenDuration: 3600,
+		Issuer:              "https://auth.example.com/",
+	}
+
+	// [Test 1] Realm's config overrides default config
+	realmConfig, err := loadRealmConfig(config, RealmDefinition{
+		Name:   "brand",
+		Config: json.RawMessage(`{"allow_refresh_token": false, "allow_administration": false, "access_token_duration": 60}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if realmConfig.AllowRefreshToken || realmConfig.AccessTokenDuration != 60 || len(realmConfig.GrantTypes) != 2 {
+		t.Errorf("Expected realm's config should override default config but found %v.", realmConfig)
+	}
+	if !realmConfig.AllowAdministration {
+		t.Error("Expected endpoint's flags should be taken from default config.")
+	}
+	if realmConfig.Issuer != "https://auth.example.com/brand" {
+		t.Errorf(expectedFormat.StringButFoundString, "https://auth.example.com/brand", realmConfig.Issuer)
+	}
+	if config.AllowRefreshToken != true || config.AccessTokenDuration != 3600 {
+		t.Error("Expected default config should not be modified.")
+	}
+
+	// [Test 2] Realm's issuer can be overridden
+	realmConfig, _ = loadRealmConfig(config, RealmDefinition{Name: "brand", Config: json.RawMessage(`{"issuer": "https://brand.example.com"}`)})
+	if realmConfig.Issuer != "https://brand.example.com" {
+		t.Errorf(expectedFormat.StringButFoundString, "https://brand.example.com", realmConfig.Issuer)
+	}
+
+	// [Test 3] Invalid realm's config
+	if _, err = loadRealmConfig(config, RealmDefinition{Name: "brand", Config: json.RawMessage(`{"grant_types": "password"}`)}); err == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_realmKey(t *testing.T) {
+	if key := realmKey(&Realm{}, "user:admin"); key != "user:admin" {
+		t.Errorf(expectedFormat.StringButFoundString, "user:admin", key)
+	}
+	if key := realmKey(&Realm{Name: "brand"}, "user:admin"); key != "brand:user:admin" {
+		t.Errorf(expectedFormat.StringButFoundString, "brand:user:admin", key)
+	}
+}
+
+func Test_MongoDBStore_RealmIssuer(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	brandStore := &MongoDBStore{privateKey: privateKey, issuer: "brand", namespace: "brand"}
+	otherStore := &MongoDBStore{privateKey: privateKey, issuer: "other", namespace: "other"}
+
+	now := time.Now()
+	token := &MongoDBToken{
+		ID:      bson.NewObjectId(),
+		User:    bson.NewObjectId(),
+		Client:  "web",
+		Created: now,
+		Expired: now.Add(time.Hour),
+
+		privateKey: privateKey,
+		issuer:     "brand",
+	}
+
+	if brandStore.parseToken(token.Token()) == nil {
+		t.Error("Expected token should be accepted by its realm.")
+	}
+	if otherStore.parseToken(token.Token()) != nil {
+		t.Error("Expected token should be rejected by another realm.")
+	}
+	if table := brandStore.table("oauth_user"); table != "brand_oauth_user" {
+		t.Errorf(expectedFormat.StringButFoundString, "brand_oauth_user", table)
+	}
+}
@@ -0,0 +1,215 @@
+package oauth2
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+)
+
+// Permission's wildcard.
+const permissionWildcard = "*"
+
+// RoleDefinition describes a role, the roles it inherits from and the permissions it grants.
+type RoleDefinition struct {
+	Name        string   `json:"name" bson:"_id"`
+	Inherits    []string `json:"inherits,omitempty" bson:"inherits,omitempty"`
+	Permissions []string `json:"permissions,omitempty" bson:"permissions,omitempty"`
+}
+
+// RoleHierarchy describes compiled role definitions. Each role is expanded to all of its
+// inherited roles and permissions, so lookups do not walk the hierarchy.
+type RoleHierarchy struct {
+	roles       map[string][]string
+	permissions map[string][]string
+}
+
+// CreateRoleHierarchy compiles role definitions.
+//
+// @param
+// - definitions {[]RoleDefinition} (a list of role definitions)
+//
+// @return
+// - hierarchy {RoleHierarchy} (a role hierarchy's instance)
+// - err {error} (an error if a role is invalid or its inheritance is circular)
+func CreateRoleHierarchy(definitions []RoleDefinition) (*RoleHierarchy, error) {
+	definitionMap := make(map[string]RoleDefinition, len(definitions))
+	for _, definition := range definitions {
+		/* Condition validation */
+		if len(definition.Name) == 0 {
+			return nil, fmt.Errorf("Role's name must not be empty.")
+		}
+		if _, ok := definitionMap[definition.Name]; ok {
+			return nil, fmt.Errorf("Role \"%s\" is duplicated.", definition.Name)
+		}
+		definitionMap[definition.Name] = definition
+	}
+
+	h := &RoleHierarchy{
+		roles:       make(map[string][]string, len(definitions)),
+		permissions: make(map[string][]string, len(definitions)),
+	}
+	for _, definition := range definitions {
+		roles := make(map[string]bool)
+		if err := expandRole(definitionMap, definition.Name, roles, make(map[string]bool)); err != nil {
+			return nil, err
+		}
+
+		permissions := make(map[string]bool)
+		for role := range roles {
+			for _, permission := range definitionMap[role].Permissions {
+				permissions[permission] = true
+			}
+		}
+		h.roles[definition.Name] = sortedSet(roles)
+		h.permissions[definition.Name] = sortedSet(permissions)
+	}
+	return h, nil
+}
+
+// ResolveRoles expands roles to include all inherited roles. Unknown roles are kept as is.
+//
+// @param
+// - roles {[]string} (a list of user's roles)
+//
+// @return
+// - roles {map[string]bool} (a set of effective roles)
+func (h *RoleHierarchy) ResolveRoles(roles []string) map[string]bool {
+	effectiveRoles := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		effectiveRoles[role] = true
+		if h == nil {
+			continue
+		}
+
+		for _, inheritedRole := range h.roles[role] {
+			effectiveRoles[inheritedRole] = true
+		}
+	}
+	return effectiveRoles
+}
+
+// ResolvePermissions returns all permissions that are granted to roles.
+//
+// @param
+// - roles {[]string} (a list of user's roles)
+//
+// @return
+// - permissions {map[string]bool} (a set of granted permissions)
+func (h *RoleHierarchy) ResolvePermissions(roles []string) map[string]bool {
+	permissions := make(map[string]bool)
+	if h == nil {
+		return permissions
+	}
+
+	for _, role := range roles {
+		for _, permission := range h.permissions[role] {
+			permissions[permission] = true
+		}
+	}
+	return permissions
+}
+
+// matchPermission checks if a set of granted permissions covers a required permission. A
+// granted permission can end with wildcard, e.g. "orders:*" covers "orders:write".
+//
+// @param
+// - permissions {map[string]bool} (a set of granted permissions)
+// - permission {string} (a required permission)
+//
+// @return
+// - isMatched {bool} (true if required permission is granted)
+func matchPermission(permissions map[string]bool, permission string) bool {
+	if permissions[permission] || permissions[permissionWildcard] {
+		return true
+	}
+
+	for i := len(permission) - 1; i > 0; i-- {
+		if permission[i] == ':' && permissions[permission[:i+1]+permissionWildcard] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRoleHierarchy compiles role definitions from token store if it supports role storage,
+// otherwise from configuration.
+//
+// @param
+// - config {Config} (an instance of oauth2's configuration)
+// - tokenStore {TokenStore} (a token store's instance)
+//
+// @return
+// - hierarchy {RoleHierarchy} (a role hierarchy's instance)
+func loadRoleHierarchy(config *Config, tokenStore TokenStore) *RoleHierarchy {
+	definitions := config.Roles
+	if roleStore, ok := unwrapStore(tokenStore).(RoleStore); ok {
+		if storedDefinitions := roleStore.FindRoleDefinitions(); len(storedDefinitions) > 0 {
+			definitions = storedDefinitions
+		}
+	}
+
+	hierarchy, err := CreateRoleHierarchy(definitions)
+	if err != nil {
+		panic(err)
+	}
+	return hierarchy
+}
+
+// defaultRoleDefinitions returns default role definitions, administrator inherits manager and
+// manager inherits user.
+//
+// @return
+// - definitions {[]RoleDefinition} (a list of role definitions)
+func defaultRoleDefinitions() []RoleDefinition {
+	return []RoleDefinition{
+		{Name: oauthRole.Admin, Inherits: []string{oauthRole.Manager}},
+		{Name: oauthRole.Manager, Inherits: []string{oauthRole.User}},
+		{Name: oauthRole.User},
+		{Name: oauthRole.Android},
+		{Name: oauthRole.IOS},
+		{Name: oauthRole.Web},
+		{Name: oauthRole.Windows},
+	}
+}
+
+// expandRole collects a role and all of its ancestors.
+//
+// @param
+// - definitions {map[string]RoleDefinition} (role definitions, keyed by name)
+// - name {string} (role's name)
+// - roles {map[string]bool} (collected roles)
+// - path {map[string]bool} (roles on current inheritance path, used to detect cycle)
+//
+// @return
+// - err {error} (an error if role is unknown or inheritance is circular)
+func expandRole(definitions map[string]RoleDefinition, name string, roles map[string]bool, path map[string]bool) error {
+	/* Condition validation */
+	if path[name] {
+		return fmt.Errorf("Role \"%s\" has circular inheritance.", name)
+	}
+	definition, ok := definitions[name]
+	if !ok {
+		return fmt.Errorf("Role \"%s\" is not defined.", name)
+	}
+
+	roles[name] = true
+	path[name] = true
+	for _, parent := range definition.Inherits {
+		if err := expandRole(definitions, parent, roles, path); err != nil {
+			return err
+		}
+	}
+	delete(path, name)
+	return nil
+}
+
+// sortedSet converts a set to a sorted list.
+func sortedSet(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for item := range set {
+		list = append(list, item)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,89 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/phuc0302/go-oauth2/oauth_key"
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+	"github.com/phuc0302/go-server/util"
+)
+
+func Test_CreateRoleHierarchy(t *testing.T) {
+	h, err := CreateRoleHierarchy([]RoleDefinition{
+		{Name: oauthRole.Admin, Inherits: []string{oauthRole.Manager}, Permissions: []string{"users:*"}},
+		{Name: oauthRole.Manager, Inherits: []string{oauthRole.User}, Permissions: []string{"orders:write"}},
+		{Name: oauthRole.User, Permissions: []string{"orders:read"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// [Test 1] Inherited roles
+	if roles := h.ResolveRoles([]string{oauthRole.Admin}); !roles[oauthRole.Manager] || !roles[oauthRole.User] {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 3, len(roles))
+	}
+	if roles := h.ResolveRoles([]string{oauthRole.User}); roles[oauthRole.Manager] {
+		t.Error("Expected user should not inherit manager's role.")
+	}
+
+	// [Test 2] Inherited permissions
+	permissions := h.ResolvePermissions([]string{oauthRole.Admin})
+	if !matchPermission(permissions, "orders:read") || !matchPermission(permissions, "orders:write") {
+		t.Error("Expected administrator should inherit manager's and user's permissions.")
+	}
+	if !matchPermission(permissions, "users:delete") {
+		t.Error("Expected wildcard permission should be matched.")
+	}
+	if matchPermission(h.ResolvePermissions([]string{oauthRole.User}), "orders:write") {
+		t.Error("Expected user should not be granted manager's permission.")
+	}
+}
+
+func Test_CreateRoleHierarchy_InvalidDefinitions(t *testing.T) {
+	// [Test 1] Circular inheritance
+	if _, err := CreateRoleHierarchy([]RoleDefinition{
+		{Name: "r_a", Inherits: []string{"r_b"}},
+		{Name: "r_b", Inherits: []string{"r_a"}},
+	}); err == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// [Test 2] Unknown parent
+	if _, err := CreateRoleHierarchy([]RoleDefinition{{Name: "r_a", Inherits: []string{"r_b"}}}); err == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_ValidatePermissions(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	Roles, _ = CreateRoleHierarchy([]RoleDefinition{
+		{Name: oauthRole.Admin, Inherits: []string{oauthRole.Manager}},
+		{Name: oauthRole.Manager, Permissions: []string{"orders:write"}},
+	})
+	defer func() { Roles = nil }()
+
+	handler := server.Adapt(func(c *server.RequestContext) {
+		c.OutputStatus(util.Status200())
+	}, ValidatePermissions("orders:write"))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		context.SetExtra(oauthKey.Context, &OAuthContext{User: Store.FindUserWithID(u.UserID.Hex())})
+		handler(context)
+	}))
+	defer ts.Close()
+
+	// Test user is an administrator, permission is inherited from manager's role
+	if response, _ := http.Get(ts.URL); response.StatusCode != 200 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 200, response.StatusCode)
+	}
+}
@@ -0,0 +1,132 @@
+package oauth2
+
+// RoleMatcher describes a precompiled user's roles condition.
+type RoleMatcher interface {
+
+	// Match checks if user's effective roles satisfy condition.
+	//
+	// @param
+	// - roles {map[string]bool} (a set of user's effective roles)
+	//
+	// @return
+	// - isMatched {bool} (true if condition is satisfied)
+	Match(roles map[string]bool) bool
+}
+
+// Role matcher's modes.
+const (
+	allOfMode = iota
+	anyOfMode
+	noneOfMode
+)
+
+// roleSetMatcher describes a set-based role matcher. Role names are compared as is, so special
+// characters do not have any meaning.
+type roleSetMatcher struct {
+	mode  int
+	roles []string
+}
+
+// Match checks if user's effective roles satisfy condition.
+func (m *roleSetMatcher) Match(roles map[string]bool) bool {
+	switch m.mode {
+
+	case allOfMode:
+		for _, role := range m.roles {
+			if !roles[role] {
+				return false
+			}
+		}
+		return true
+
+	case anyOfMode:
+		for _, role := range m.roles {
+			if roles[role] {
+				return true
+			}
+		}
+		return false
+
+	default:
+		for _, role := range m.roles {
+			if roles[role] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// roleMatchers describes a combination of role matchers that must all be satisfied.
+type roleMatchers []RoleMatcher
+
+// Match checks if user's effective roles satisfy all conditions.
+func (m roleMatchers) Match(roles map[string]bool) bool {
+	for _, matcher := range m {
+		if !matcher.Match(roles) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllOf returns a matcher that requires user to have all roles.
+//
+// @param
+// - roles {[]string} (a list of required roles)
+//
+// @return
+// - matcher {RoleMatcher} (a role matcher's instance)
+func AllOf(roles ...string) RoleMatcher {
+	return createRoleSetMatcher(allOfMode, roles)
+}
+
+// AnyOf returns a matcher that requires user to have at least one of roles.
+//
+// @param
+// - roles {[]string} (a list of acceptable roles)
+//
+// @return
+// - matcher {RoleMatcher} (a role matcher's instance)
+func AnyOf(roles ...string) RoleMatcher {
+	return createRoleSetMatcher(anyOfMode, roles)
+}
+
+// NoneOf returns a matcher that rejects user who has any of roles.
+//
+// @param
+// - roles {[]string} (a list of denied roles)
+//
+// @return
+// - matcher {RoleMatcher} (a role matcher's instance)
+func NoneOf(roles ...string) RoleMatcher {
+	return createRoleSetMatcher(noneOfMode, roles)
+}
+
+// MatchAll returns a matcher that requires all matchers to be satisfied, e.g.
+// MatchAll(AnyOf(oauthRole.Manager, oauthRole.Admin), NoneOf(oauthRole.Web)).
+//
+// @param
+// - matchers {[]RoleMatcher} (a list of role matchers)
+//
+// @return
+// - matcher {RoleMatcher} (a role matcher's instance)
+func MatchAll(matchers ...RoleMatcher) RoleMatcher {
+	return roleMatchers(matchers)
+}
+
+// createRoleSetMatcher returns a set-based matcher without duplicated roles.
+//
+// @param
+// - mode {int} (matcher's mode)
+// - roles {[]string} (a list of roles)
+//
+// @return
+// - matcher {RoleMatcher} (a role matcher's instance)
+func createRoleSetMatcher(mode int, roles []string) RoleMatcher {
+	set := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		set[role] = true
+	}
+	return &roleSetMatcher{mode: mode, roles: sortedSet(set)}
+}
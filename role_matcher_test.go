@@ -0,0 +1,76 @@
+package oauth2
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+)
+
+func Test_RoleMatcher(t *testing.T) {
+	roles := map[string]bool{oauthRole.Manager: true, oauthRole.User: true}
+
+	// [Test 1] All of
+	if !AllOf(oauthRole.Manager, oauthRole.User).Match(roles) {
+		t.Error("Expected all-of matcher should accept user who has all roles.")
+	}
+	if AllOf(oauthRole.Manager, oauthRole.Admin).Match(roles) {
+		t.Error("Expected all-of matcher should reject user who misses a role.")
+	}
+
+	// [Test 2] Any of
+	if !AnyOf(oauthRole.Admin, oauthRole.Manager).Match(roles) {
+		t.Error("Expected any-of matcher should accept user who has one of roles.")
+	}
+	if AnyOf(oauthRole.Admin).Match(roles) {
+		t.Error("Expected any-of matcher should reject user who has none of roles.")
+	}
+
+	// [Test 3] None of
+	if NoneOf(oauthRole.User).Match(roles) {
+		t.Error("Expected none-of matcher should reject user who has a denied role.")
+	}
+
+	// [Test 4] Combination
+	if !MatchAll(AnyOf(oauthRole.Admin, oauthRole.Manager), NoneOf(oauthRole.Web)).Match(roles) {
+		t.Error("Expected combined matcher should accept user.")
+	}
+
+	// [Test 5] Special characters must not be interpreted
+	if AnyOf("r_.*", "r_admin|r_user").Match(roles) {
+		t.Error("Expected role names should be matched literally.")
+	}
+}
+
+// legacyValidateRoles is the regex based role check that had been used by ValidateRoles.
+func legacyValidateRoles(acceptedRoles []string, roles []string) bool {
+	roleValidator := regexp.MustCompile(fmt.Sprintf("^(%s)$", strings.Join(acceptedRoles, "|")))
+	for _, role := range roles {
+		if roleValidator.MatchString(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func Benchmark_ValidateRoles_Regex(b *testing.B) {
+	acceptedRoles := []string{oauthRole.Admin, oauthRole.Manager}
+	roles := []string{oauthRole.User, oauthRole.Web, oauthRole.Manager}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyValidateRoles(acceptedRoles, roles)
+	}
+}
+
+func Benchmark_ValidateRoles_Matcher(b *testing.B) {
+	matcher := AnyOf(oauthRole.Admin, oauthRole.Manager)
+	roles := map[string]bool{oauthRole.User: true, oauthRole.Web: true, oauthRole.Manager: true}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		matcher.Match(roles)
+	}
+}
@@ -0,0 +1,270 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"gopkg.in/yaml.v2"
+)
+
+// Route policy's wildcard, matches any method or any path under a prefix.
+const routeWildcard = "*"
+
+// RoutePolicy describes the protection of a route. Roles are accepted if user has any of them,
+// scopes, permissions and expression are required all.
+type RoutePolicy struct {
+	Method      string   `json:"method" yaml:"method"`
+	Path        string   `json:"path" yaml:"path"`
+	Public      bool     `json:"public,omitempty" yaml:"public,omitempty"`
+	Roles       []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Scopes      []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	Permissions []string `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	Expression  string   `json:"expression,omitempty" yaml:"expression,omitempty"`
+}
+
+// PolicyManifest describes a declarative route-to-policy table.
+type PolicyManifest struct {
+	Policies []RoutePolicy `json:"policies" yaml:"policies"`
+}
+
+// LoadPolicyManifest loads a policy manifest from a JSON or YAML file. File format is detected
+// by file extension.
+//
+// @param
+// - path {string} (manifest file's path)
+//
+// @return
+// - manifest {PolicyManifest} (a policy manifest's instance)
+// - err {error} (an error if file cannot be read or is malformed)
+func LoadPolicyManifest(path string) (*PolicyManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := new(PolicyManifest)
+	switch strings.ToLower(filepath.Ext(path)) {
+
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, manifest)
+
+	default:
+		err = json.Unmarshal(data, manifest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range manifest.Policies {
+		policy := &manifest.Policies[i]
+		policy.Method = strings.ToUpper(policy.Method)
+
+		/* Condition validation */
+		if len(policy.Method) == 0 || len(policy.Path) == 0 {
+			return nil, fmt.Errorf("Policy #%d must have method and path.", i)
+		}
+		if policy.Public && (len(policy.Roles) > 0 || len(policy.Scopes) > 0 || len(policy.Permissions) > 0 || len(policy.Expression) > 0) {
+			return nil, fmt.Errorf("Public policy \"%s %s\" must not require roles, scopes, permissions or expression.", policy.Method, policy.Path)
+		}
+		if len(policy.Expression) > 0 {
+			if _, err := CompilePolicy(policy.Expression); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// FindPolicy returns the most specific policy for a route or null. An exact path is preferred
+// over a "/prefix/*" path, and an exact method is preferred over "*".
+//
+// @param
+// - method {string} (HTTP method)
+// - patternURL {string} (the URL matching pattern that route is bound with)
+//
+// @return
+// - policy {RoutePolicy} (a route policy or null)
+func (m *PolicyManifest) FindPolicy(method string, patternURL string) *RoutePolicy {
+	var match *RoutePolicy
+	matchScore := -1
+
+	for i := range m.Policies {
+		policy := &m.Policies[i]
+		if policy.Method != method && policy.Method != routeWildcard {
+			continue
+		}
+
+		score := -1
+		if policy.Path == patternURL {
+			score = 2 * (len(policy.Path) + 1)
+		} else if strings.HasSuffix(policy.Path, routeWildcard) {
+			prefix := strings.TrimSuffix(policy.Path, routeWildcard)
+			if strings.HasPrefix(patternURL, prefix) {
+				score = 2 * len(prefix)
+			}
+		}
+		if score < 0 {
+			continue
+		}
+
+		if policy.Method == method {
+			score++
+		}
+		if score > matchScore {
+			match = policy
+			matchScore = score
+		}
+	}
+	return match
+}
+
+// boundRoute describes a route that had been bound through Bind* wrappers.
+type boundRoute struct {
+	policy      RoutePolicy
+	hasManifest bool
+}
+
+// Global route's state.
+var (
+	// Prefix of current GroupRoute.
+	routePrefix string
+
+	// Routes that had been bound through Bind* wrappers.
+	boundRoutes []*boundRoute
+)
+
+// PolicyTable returns the effective policy of every route that had been bound through Bind*
+// wrappers, sorted by path and method. Service's own endpoints (token, authorize, login, logout
+// and register) are bound directly to go-server's router and are not listed.
+//
+// @return
+// - policies {[]RoutePolicy} (a list of effective route policies)
+func PolicyTable() []RoutePolicy {
+	policies := make([]RoutePolicy, len(boundRoutes))
+	for i, route := range boundRoutes {
+		policies[i] = route.policy
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].Path != policies[j].Path {
+			return policies[i].Path < policies[j].Path
+		}
+		return policies[i].Method < policies[j].Method
+	})
+	return policies
+}
+
+// ValidatePolicies checks that every bound route is covered by policy manifest. If manifest is
+// not loaded, all routes are considered covered. Manifest only covers routes that are bound
+// through Bind* wrappers: application's routes, administration, account and metrics. Service's
+// own endpoints (token, authorize, login, logout and register) authenticate their callers
+// themselves, so they are neither checked nor affected by manifest.
+//
+// @return
+// - err {error} (an error that lists uncovered routes)
+func ValidatePolicies() error {
+	/* Condition validation */
+	if Policies == nil {
+		return nil
+	}
+
+	var missing []string
+	for _, route := range boundRoutes {
+		if !route.hasManifest {
+			missing = append(missing, fmt.Sprintf("%s %s", route.policy.Method, route.policy.Path))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Routes without policy: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// bindRoute binds a route with its effective policy. Manifest's policy takes precedence over
+// roles and permissions that are given in code.
+//
+// @param
+// - method {string} (HTTP method)
+// - patternURL {string} (the URL matching pattern)
+// - roles {[]string} (a list of acceptable users' roles, from code)
+// - permissions {[]string} (a list of required permissions, from code)
+// - handler {server.HandleContextFunc} (the callback func)
+// - bind {func} (go-server's bind func)
+func bindRoute(method string, patternURL string, roles []string, permissions []string, handler server.HandleContextFunc, bind func(string, server.HandleContextFunc)) {
+	route := &boundRoute{
+		policy: RoutePolicy{
+			Method:      method,
+			Path:        routePrefix + patternURL,
+			Roles:       roles,
+			Permissions: permissions,
+		},
+	}
+
+	if Policies != nil {
+		if policy := Policies.FindPolicy(method, route.policy.Path); policy != nil {
+			route.hasManifest = true
+			route.policy.Public = policy.Public
+			route.policy.Roles = policy.Roles
+			route.policy.Scopes = policy.Scopes
+			route.policy.Permissions = policy.Permissions
+			route.policy.Expression = policy.Expression
+		}
+	}
+
+	// If neither roles nor permissions are defined, by default, a route will accept all roles
+	if !route.policy.Public && len(route.policy.Roles) == 0 && len(route.policy.Permissions) == 0 && len(route.policy.Scopes) == 0 && len(route.policy.Expression) == 0 {
+		route.policy.Roles = oauthRole.All()
+	}
+
+	/* Condition validation: Protected routes must see client's certificates */
+	config := Cfg
+	if bindingServer != nil {
+		config = bindingServer.Config
+	}
+	if !route.policy.Public {
+		validateRouterBinding(config)
+	}
+	boundRoutes = append(boundRoutes, route)
+
+	// Routes that are bound inside OAuthServer.Bind are attached to that server
+	if bindingServer != nil {
+		bind = attachRoute(bindingServer, bind)
+	}
+
+	if route.policy.Public {
+		bind(patternURL, handler)
+		return
+	}
+	adapters := []server.Adapter{
+		ValidateToken(),
+		ValidateRoles(route.policy.Roles...),
+		ValidateScopes(route.policy.Scopes...),
+		ValidatePermissions(route.policy.Permissions...),
+	}
+	if len(route.policy.Expression) > 0 {
+		adapters = append(adapters, ValidatePolicy(route.policy.Expression))
+	}
+	bind(patternURL, server.Adapt(handler, adapters...))
+}
+
+// attachRoute wraps go-server's bind func, so route's handler is attached to a server before any
+// validation.
+//
+// @param
+// - s {OAuthServer} (an oauth2 server's instance)
+// - bind {func} (go-server's bind func)
+//
+// @return
+// - bind {func} (a wrapper around go-server's bind func)
+func attachRoute(s *OAuthServer, bind func(string, server.HandleContextFunc)) func(string, server.HandleContextFunc) {
+	return func(patternURL string, handler server.HandleContextFunc) {
+		bind(patternURL, s.Attach()(handler))
+	}
+}
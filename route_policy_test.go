@@ -0,0 +1,114 @@
+package oauth2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/phuc0302/go-oauth2/oauth_role"
+	"github.com/phuc0302/go-server"
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+const testPolicyManifest = `
+policies:
+  - method: get
+    path: /orders/*
+    roles: [r_user]
+  - method: "*"
+    path: /orders/{order_id}
+    permissions: ["orders:write"]
+  - method: GET
+    path: /health
+    public: true
+`
+
+func Test_LoadPolicyManifest(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "policy")
+	defer os.RemoveAll(dir)
+
+	// [Test 1] YAML manifest
+	path := filepath.Join(dir, "policy.yaml")
+	ioutil.WriteFile(path, []byte(testPolicyManifest), 0644)
+
+	manifest, err := LoadPolicyManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Policies) != 3 || manifest.Policies[0].Method != "GET" {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 3, len(manifest.Policies))
+	}
+
+	// [Test 2] JSON manifest
+	path = filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(path, []byte(`{"policies":[{"method":"POST","path":"/orders","roles":["r_manager"]}]}`), 0644)
+	if manifest, err := LoadPolicyManifest(path); err != nil || len(manifest.Policies) != 1 {
+		t.Error(expectedFormat.NotNil)
+	}
+
+	// [Test 3] Public policy must not require anything
+	ioutil.WriteFile(path, []byte(`{"policies":[{"method":"GET","path":"/","public":true,"roles":["r_user"]}]}`), 0644)
+	if _, err := LoadPolicyManifest(path); err == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_PolicyManifest_FindPolicy(t *testing.T) {
+	manifest := &PolicyManifest{Policies: []RoutePolicy{
+		{Method: "GET", Path: "/orders/*", Roles: []string{oauthRole.User}},
+		{Method: "*", Path: "/orders/{order_id}", Permissions: []string{"orders:write"}},
+		{Method: "GET", Path: "/health", Public: true},
+	}}
+
+	// [Test 1] Exact path is preferred over prefix
+	if policy := manifest.FindPolicy("GET", "/orders/{order_id}"); policy == nil || len(policy.Permissions) != 1 {
+		t.Error("Expected exact path's policy.")
+	}
+
+	// [Test 2] Prefix path
+	if policy := manifest.FindPolicy("GET", "/orders/history"); policy == nil || len(policy.Roles) != 1 {
+		t.Error("Expected prefix path's policy.")
+	}
+
+	// [Test 3] Method must be matched
+	if policy := manifest.FindPolicy("POST", "/health"); policy != nil {
+		t.Error(expectedFormat.Nil)
+	}
+}
+
+func Test_ValidatePolicies(t *testing.T) {
+	Policies = &PolicyManifest{Policies: []RoutePolicy{
+		{Method: "GET", Path: "/v1/orders", Roles: []string{oauthRole.Manager}},
+	}}
+	defer func() {
+		Policies = nil
+		boundRoutes = nil
+	}()
+
+	var boundPaths []string
+	bind := func(patternURL string, handler server.HandleContextFunc) {
+		boundPaths = append(boundPaths, patternURL)
+	}
+	handler := func(c *server.RequestContext) {}
+
+	// [Test 1] Covered route, group's prefix must be included
+	routePrefix = "/v1"
+	bindRoute("GET", "/orders", []string{oauthRole.Admin}, nil, handler, bind)
+	routePrefix = ""
+	if err := ValidatePolicies(); err != nil {
+		t.Error(err)
+	}
+	if table := PolicyTable(); len(table) != 1 || table[0].Path != "/v1/orders" || table[0].Roles[0] != oauthRole.Manager {
+		t.Error("Expected manifest's policy should take precedence.")
+	}
+
+	// [Test 2] Uncovered route
+	bindRoute("DELETE", "/v1/orders", nil, nil, handler, bind)
+	if err := ValidatePolicies(); err == nil {
+		t.Error(expectedFormat.NotNil)
+	}
+	if len(boundPaths) != 2 || boundPaths[0] != "/orders" {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 2, len(boundPaths))
+	}
+}
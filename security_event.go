@@ -0,0 +1,174 @@
+package oauth2
+
+import (
+	"sync"
+	"time"
+
+	"github.com/phuc0302/go-server"
+)
+
+// Security event's types.
+const (
+	TokenIssuedEvent      = "token_issued"
+	TokenRefreshedEvent   = "token_refreshed"
+	TokenRevokedEvent     = "token_revoked"
+	LoginFailedEvent      = "login_failed"
+	ClientAuthFailedEvent = "client_auth_failed"
+	RoleCheckDeniedEvent  = "role_check_denied"
+	AdminChangeEvent      = "admin_change"
+	ConsentGrantedEvent   = "consent_granted"
+	ConsentDeniedEvent    = "consent_denied"
+)
+
+// Security event's reasons.
+const (
+	InvalidCredentialsReason      = "invalid_credentials"
+	InvalidGrantReason            = "invalid_grant"
+	LockedReason                  = "locked"
+	AdminReason                   = "admin"
+	AccountReason                 = "account"
+	RegistrationReason            = "registration"
+	InsufficientRolesReason       = "insufficient_roles"
+	InsufficientPermissionsReason = "insufficient_permissions"
+	InsufficientScopesReason      = "insufficient_scopes"
+	PolicyDeniedReason            = "policy_denied"
+	ReusedTokenReason             = "reused_token" // An unexpired access token was returned again
+)
+
+// Administrator's actions, used as admin change event's reasons.
+const (
+	CreateUserAction         = "create_user"
+	DeleteUserAction         = "delete_user"
+	UpdateUserRolesAction    = "update_user_roles"
+	UpdateUserPasswordAction = "update_user_password"
+	UnlockUserAction         = "unlock_user"
+	CreateClientAction       = "create_client"
+	UpdateClientAction       = "update_client"
+	RestrictClientAction     = "restrict_client"
+	FirstPartyClientAction   = "first_party_client"
+	DeleteClientAction       = "delete_client"
+	AddClientSecretAction    = "add_client_secret"
+	RetireClientSecretAction = "retire_client_secret"
+)
+
+// SecurityEvent describes an authentication event that will be delivered to subscribers.
+type SecurityEvent struct {
+	Type      string    `json:"type" bson:"type"`
+	Time      time.Time `json:"time" bson:"time"`
+	ClientID  string    `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	UserID    string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty" bson:"username,omitempty"`
+	TokenID   string    `json:"token_id,omitempty" bson:"token_id,omitempty"`
+	ActorID   string    `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	GrantType string    `json:"grant_type,omitempty" bson:"grant_type,omitempty"`
+	IP        string    `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Realm     string    `json:"realm,omitempty" bson:"realm,omitempty"`
+}
+
+// EventSubscriberFunc is an adapter to allow the use of ordinary funcs as event subscribers.
+type EventSubscriberFunc func(event SecurityEvent)
+
+// HandleEvent calls f(event).
+func (f EventSubscriberFunc) HandleEvent(event SecurityEvent) {
+	f(event)
+}
+
+// EventBus describes a synchronous security event's dispatcher.
+type EventBus struct {
+	subscribers []EventSubscriber
+	mutex       sync.RWMutex
+}
+
+// CreateEventBus returns a default EventBus's instance.
+//
+// @return
+// - bus {EventBus} (an event bus's instance)
+func CreateEventBus() *EventBus {
+	return &EventBus{subscribers: make([]EventSubscriber, 0)}
+}
+
+// Subscribe registers subscribers for all security events.
+//
+// @param
+// - subscribers {[]EventSubscriber} (a list of event subscribers)
+func (b *EventBus) Subscribe(subscribers ...EventSubscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		if subscriber != nil {
+			b.subscribers = append(b.subscribers, subscriber)
+		}
+	}
+}
+
+// Publish delivers a security event to all subscribers. A panicking subscriber will not break
+// the request nor the other subscribers.
+//
+// @param
+// - event {SecurityEvent} (a security event)
+func (b *EventBus) Publish(event SecurityEvent) {
+	b.mutex.RLock()
+	subscribers := b.subscribers
+	b.mutex.RUnlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, subscriber := range subscribers {
+		func() {
+			defer func() { recover() }()
+			subscriber.HandleEvent(event)
+		}()
+	}
+}
+
+// createSecurityEvent returns a security event with request's information.
+//
+// @param
+// - c {server.RequestContext} (a request context)
+// - eventType {string} (security event's type)
+// - s {OAuthContext} (an oauth context, might be null)
+// - reason {string} (security event's reason)
+//
+// @return
+// - event {SecurityEvent} (a security event)
+func createSecurityEvent(c *server.RequestContext, eventType string, s *OAuthContext, reason string) SecurityEvent {
+	event := SecurityEvent{
+		Type:   eventType,
+		Reason: reason,
+	}
+
+	if c != nil {
+		event.Time = RealmOf(c).now()
+		event.IP = clientIP(c)
+		event.UserAgent = c.Header["user-agent"]
+		event.Realm = RealmOf(c).Name
+	}
+	if s != nil {
+		event.GrantType = s.GrantType
+		if s.Client != nil {
+			event.ClientID = s.Client.ClientID()
+		}
+		if s.User != nil {
+			event.UserID = s.User.UserID()
+			event.Username = s.User.Username()
+		}
+		if s.AccessToken != nil {
+			event.TokenID = tokenID(s.AccessToken)
+		}
+	}
+	return event
+}
+
+// publishEvent publishes a security event to global event bus.
+//
+// @param
+// - event {SecurityEvent} (a security event)
+func publishEvent(event SecurityEvent) {
+	if Events != nil {
+		Events.Publish(event)
+	}
+}
@@ -0,0 +1,129 @@
+package oauth2
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/phuc0302/go-server/expected_format"
+)
+
+func Test_EventBus_Publish(t *testing.T) {
+	bus := CreateEventBus()
+
+	var events []SecurityEvent
+	bus.Subscribe(
+		EventSubscriberFunc(func(event SecurityEvent) {
+			panic("Subscriber's panic should be ignored.")
+		}),
+		EventSubscriberFunc(func(event SecurityEvent) {
+			events = append(events, event)
+		}),
+	)
+	bus.Publish(SecurityEvent{Type: TokenIssuedEvent})
+
+	if len(events) != 1 {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 1, len(events))
+	}
+	if events[0].Type != TokenIssuedEvent {
+		t.Errorf(expectedFormat.StringButFoundString, TokenIssuedEvent, events[0].Type)
+	}
+	if events[0].Time.IsZero() {
+		t.Error(expectedFormat.NotNil)
+	}
+}
+
+func Test_Initialize_Subscribers(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	defer func() { Events = CreateEventBus() }()
+
+	count := 0
+	subscriber := EventSubscriberFunc(func(event SecurityEvent) {
+		count++
+	})
+
+	// Initialize again should not duplicate subscribers
+	InitializeWithMongoDB(true, false, subscriber)
+	InitializeWithMongoDB(true, false, subscriber)
+	publishEvent(SecurityEvent{Type: TokenIssuedEvent})
+
+	if count != 1 {
+		t.Errorf(expectedFormat.NumberButFoundNumber, 1, count)
+	}
+}
+
+func Test_TokenGrant_SecurityEvents(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+	Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+
+	var events []SecurityEvent
+	Events = CreateEventBus()
+	Events.Subscribe(EventSubscriberFunc(func(event SecurityEvent) {
+		events = append(events, event)
+	}))
+	defer func() { Events = CreateEventBus() }()
+
+	// Test server is a trusted proxy
+	Cfg.TrustedProxies = []string{"127.0.0.1"}
+	defer func() { Cfg.TrustedProxies = nil }()
+
+	// Setup server
+	controller := new(TokenGrant)
+	ts := httptest.NewServer(Handler(controller.HandleForm))
+	defer ts.Close()
+
+	login := func(password string) {
+		request, _ := http.NewRequest("POST", ts.URL, strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
+			PasswordGrant,
+			u.ClientID,
+			u.ClientSecret,
+			u.Username,
+			password,
+		)))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("User-Agent", "siem-test")
+		request.Header.Set("X-Forwarded-For", "10.0.0.1")
+		http.DefaultClient.Do(request)
+	}
+
+	// [Test 1] Login failed
+	login("InvalidPassword")
+	if len(events) != 1 || events[0].Type != LoginFailedEvent {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 1, len(events))
+	}
+	if event := events[0]; event.Username != u.Username || event.ClientID != u.ClientID || event.GrantType != PasswordGrant {
+		t.Errorf(expectedFormat.StringButFoundString, u.Username, event.Username)
+	}
+	if event := events[0]; event.IP != "10.0.0.1" || event.UserAgent != "siem-test" || event.Reason != InvalidCredentialsReason {
+		t.Errorf(expectedFormat.StringButFoundString, "10.0.0.1", event.IP)
+	}
+
+	// [Test 2] Token issued
+	UnlockUser(u.Username)
+	Attempts.DeleteFailures(loginAttemptIPPrefix + "10.0.0.1")
+	login(u.Password)
+	if len(events) != 2 || events[1].Type != TokenIssuedEvent {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 2, len(events))
+	}
+	if event := events[1]; event.UserID != u.UserID.Hex() || len(event.TokenID) == 0 {
+		t.Errorf(expectedFormat.StringButFoundString, u.UserID.Hex(), event.UserID)
+	}
+	if event := events[1]; len(event.Reason) != 0 {
+		t.Errorf(expectedFormat.StringButFoundString, "", event.Reason)
+	}
+
+	// [Test 3] Unexpired token is returned again, grant is still reported
+	login(u.Password)
+	if len(events) != 3 || events[2].Type != TokenIssuedEvent {
+		t.Fatalf(expectedFormat.NumberButFoundNumber, 3, len(events))
+	}
+	if event := events[2]; event.TokenID != events[1].TokenID || event.Reason != ReusedTokenReason {
+		t.Errorf(expectedFormat.StringButFoundString, ReusedTokenReason, event.Reason)
+	}
+}
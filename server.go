@@ -4,23 +4,62 @@ import (
 	"regexp"
 
 	"github.com/phuc0302/go-mongo"
-	"github.com/phuc0302/go-oauth2/oauth_role"
 	"github.com/phuc0302/go-server"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Global variables.
 var (
-	// Global public config's instance.
+	// Global public config's instance, it is the default server's config.
 	Cfg *Config
 
-	// Global public token store's instance.
+	// Global public token store's instance, it is the default server's token store.
 	Store TokenStore
 
+	// Global public password hasher's instance.
+	Hasher PasswordHasher = &BcryptHasher{Cost: bcrypt.DefaultCost}
+
+	// Global public failed login attempts' storage. If null, an in-memory storage will be used.
+	Attempts LoginAttemptStore
+
+	// Global public DPoP proofs' storage, replace it with a shared storage if several processes
+	// serve the same realm.
+	Replays = CreateMemoryDPoPReplayStore()
+
+	// Global public security event bus's instance.
+	Events = CreateEventBus()
+
+	// Global public role hierarchy's instance.
+	Roles *RoleHierarchy
+
+	// Global public route policy manifest's instance. If null, routes are protected by roles
+	// and permissions that are given in code.
+	Policies *PolicyManifest
+
+	// Global public metrics registry's instance.
+	Metrics = CreateMetricsRegistry()
+
+	// Global public audit log's storage. If null and audit is allowed, a storage that matches
+	// token store will be used.
+	Audit AuditStore
+
 	// OAuth2 grant regex.
 	grantsValidation *regexp.Regexp
 
+	// Per-client rate limiter.
+	limiter = CreateRateLimiter()
+
 	// Bearer regex.
 	bearerFinder = regexp.MustCompile("^(B|b)earer\\s.+$")
+
+	// DPoP regex.
+	dpopFinder = regexp.MustCompile("^DPoP\\s.+$")
+
+	// Client ID regex.
+	clientIDValidation = regexp.MustCompile("^[\\w\\-\\.:~]+$")
+
+	// Password regex.
+	passwordValidation = regexp.MustCompile("^[^\\s]{8,32}$")
 )
 
 // InitializeWithMongoDB will init server with MongoDB; either in sandbox mode or production mode,
@@ -29,218 +68,398 @@ var (
 // @param
 // - sandboxMode {bool} (instruction in which config file should be loaded)
 // - sandboxMode {bool} (instruction in which should bind authorize & token service or not)
-func InitializeWithMongoDB(sandboxMode bool, bindService bool) {
+// - subscribers {[]EventSubscriber} (a list of security event subscribers)
+func InitializeWithMongoDB(sandboxMode bool, bindService bool, subscribers ...EventSubscriber) {
 	mongo.ConnectMongo()
-	Initialize(nil, sandboxMode, bindService)
+	Initialize(nil, sandboxMode, bindService, subscribers...)
 }
 
-// Initialize will init server as above func. However, the database will be your choice.
+// Initialize will init server as above func. However, the database will be your choice. Global
+// config, global token store and global realms are the default server, see OAuthServer. Global
+// event bus is recreated, so calling Initialize again does not duplicate subscribers.
 //
 // @param
 // - tokenStore {TokenStore} (your own token store implementation. If null, default will be used)
 // - sandboxMode {bool} (instruction in which config file should be loaded)
 // - sandboxMode {bool} (instruction in which should bind authorize & token service or not)
-func Initialize(tokenStore TokenStore, sandboxMode bool, bindService bool) {
+// - subscribers {[]EventSubscriber} (a list of security event subscribers)
+func Initialize(tokenStore TokenStore, sandboxMode bool, bindService bool, subscribers ...EventSubscriber) {
 	server.Initialize(sandboxMode)
 	Cfg = loadConfig()
+	Hasher = createPasswordHasher(Cfg)
+	if Attempts == nil {
+		Attempts = CreateMemoryLoginAttemptStore(Cfg.LoginLockoutDuration)
+	}
+	Events = CreateEventBus()
+	Events.Subscribe(subscribers...)
 
 	// Load token store
-	if tokenStore == nil {
+	isDefaultStore := tokenStore == nil
+	if isDefaultStore {
 		tokenStore = CreateMongoDBStore()
 	}
+	if Cfg.AllowMetrics {
+		tokenStore = CreateInstrumentedStore(tokenStore, Metrics)
+	}
 	Store = tokenStore
+	Roles = loadRoleHierarchy(Cfg, Store)
+
+	// Load realms, each realm has its own namespace in database
+	for _, definition := range Cfg.Realms {
+		if !isDefaultStore {
+			panic("Realms in config require default token store, please register your realms with RegisterRealm.")
+		}
+
+		realmStore := CreateMongoDBRealmStore(definition.Name, definition.config.Issuer)
+		if definition.config.AllowMetrics {
+			realmStore = CreateInstrumentedStore(realmStore, Metrics)
+		}
+
+		realm := &Realm{
+			Name:       definition.Name,
+			Hosts:      definition.Hosts,
+			PathPrefix: definition.PathPrefix,
+			Config:     definition.config,
+			Store:      realmStore,
+		}
+		if err := RegisterRealm(realm); err != nil {
+			panic(err)
+		}
+	}
+
+	// Load policy manifest
+	if len(Cfg.PolicyManifest) > 0 {
+		manifest, err := LoadPolicyManifest(Cfg.PolicyManifest)
+		if err != nil {
+			panic(err)
+		}
+		Policies = manifest
+	}
+
+	// Load consent template
+	if len(Cfg.ConsentTemplate) > 0 {
+		loadConsentTemplate(Cfg.ConsentTemplate)
+	}
+	if len(Cfg.LoginTemplate) > 0 {
+		loadLoginTemplate(Cfg.LoginTemplate)
+	}
+
+	// Load audit store
+	if Cfg.AllowAudit {
+		if Audit == nil {
+			if _, ok := unwrapStore(Store).(*MongoDBStore); ok {
+				Audit = CreateMongoDBAuditStore()
+			} else {
+				Audit = CreateMemoryAuditStore()
+			}
+		}
+		Events.Subscribe(CreateAuditSubscriber(Audit))
+	}
 
 	// Setup OAuth2.0
 	if bindService {
-		//	grantAuthorization := new(AuthorizationGrant)
-		tokenGrant := new(TokenGrant)
+		if Cfg.AllowMetrics {
+			bindMetricsRoute(server.BindGet)
+		}
 
-		//	server.Get("/authorize", grantAuthorization.HandleForm)
-		server.BindGet("/token", tokenGrant.HandleForm)
-		server.BindPost("/token", tokenGrant.HandleForm)
+		defaultServer().BindService()
 	}
 }
 
-// Run will start HTTP server.
+// Run will start HTTP server. It panics if any route that is bound through Bind* wrappers is not
+// covered by policy manifest, see ValidatePolicies.
 func Run() {
+	if err := ValidatePolicies(); err != nil {
+		panic(err)
+	}
 	server.Run()
 }
 
-// RunTLS will start HTTPS server.
+// RunTLS will start HTTPS server. It panics if any route that is bound through Bind* wrappers is
+// not covered by policy manifest, see ValidatePolicies.
 func RunTLS(certFile string, keyFile string) {
+	if err := ValidatePolicies(); err != nil {
+		panic(err)
+	}
 	server.RunTLS(certFile, keyFile)
 }
 
-// GroupRoute is a wrapper func for server.GroupRoute func.
+// GroupRoute is a wrapper func for server.GroupRoute func. The prefix is tracked, so routes that
+// are bound inside group will be matched against policy manifest with their full path.
 //
 // @param
 // - prefixURI {string} (the prefix for url)
 // - handler {server.HandleGroupFunc} (the callback func)
 func GroupRoute(prefixURI string, groupHandler server.HandleGroupFunc) {
-	server.GroupRoute(prefixURI, groupHandler)
+	server.GroupRoute(prefixURI, func() {
+		previousPrefix := routePrefix
+		routePrefix = previousPrefix + prefixURI
+		defer func() { routePrefix = previousPrefix }()
+
+		groupHandler()
+	})
 }
 
 // BindCopy is a wrapper func for server.BindCopy func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindCopy(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindCopy(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("COPY", patternURL, roles, nil, handler, server.BindCopy)
 }
 
 // BindDelete is a wrapper func for server.BindDelete func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindDelete(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindDelete(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("DELETE", patternURL, roles, nil, handler, server.BindDelete)
 }
 
 // BindGet is a wrapper func for server.BindGet func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindGet(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindGet(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("GET", patternURL, roles, nil, handler, server.BindGet)
 }
 
 // BindHead is a wrapper func for server.BindHead func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindHead(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindHead(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("HEAD", patternURL, roles, nil, handler, server.BindHead)
 }
 
 // BindLink is a wrapper func for server.BindLink func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindLink(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindLink(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("LINK", patternURL, roles, nil, handler, server.BindLink)
 }
 
 // BindOptions is a wrapper func for server.BindOptions func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindOptions(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindOptions(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("OPTIONS", patternURL, roles, nil, handler, server.BindOptions)
 }
 
 // BindPatch is a wrapper func for server.BindPatch func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindPatch(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindPatch(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("PATCH", patternURL, roles, nil, handler, server.BindPatch)
 }
 
 // BindPost is a wrapper func for server.BindPost func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindPost(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindPost(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("POST", patternURL, roles, nil, handler, server.BindPost)
 }
 
 // BindPurge is a wrapper func for server.BindPurge func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindPurge(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindPurge(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("PURGE", patternURL, roles, nil, handler, server.BindPurge)
 }
 
 // BindPut is a wrapper func for server.BindPut func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindPut(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindPut(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("PUT", patternURL, roles, nil, handler, server.BindPut)
 }
 
 // BindUnlink is a wrapper func for server.BindUnlink func. By default, this will add ValidateToken
 // and ValidateRoles around HandleContextFunc. If roles is not defined, by default, a route will
-// accept all roles.
+// accept all roles. If policy manifest is loaded, route's policy takes precedence.
 //
 // @param
 // - patternURL {string} (the URL matching pattern)
 // - roles {[]string} (a list of acceptable users' roles)
 // - handler {server.HandleContextFunc} (the callback func)
 func BindUnlink(patternURL string, roles []string, handler server.HandleContextFunc) {
-	if roles == nil || len(roles) == 0 {
-		roles = oauthRole.All()
-	}
-	server.BindUnlink(patternURL, server.Adapt(handler, ValidateToken(), ValidateRoles(roles...)))
+	bindRoute("UNLINK", patternURL, roles, nil, handler, server.BindUnlink)
+}
+
+// BindCopyWithPermissions is a wrapper func for server.BindCopy func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindCopyWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("COPY", patternURL, nil, permissions, handler, server.BindCopy)
+}
+
+// BindDeleteWithPermissions is a wrapper func for server.BindDelete func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindDeleteWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("DELETE", patternURL, nil, permissions, handler, server.BindDelete)
+}
+
+// BindGetWithPermissions is a wrapper func for server.BindGet func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindGetWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("GET", patternURL, nil, permissions, handler, server.BindGet)
+}
+
+// BindHeadWithPermissions is a wrapper func for server.BindHead func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindHeadWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("HEAD", patternURL, nil, permissions, handler, server.BindHead)
+}
+
+// BindLinkWithPermissions is a wrapper func for server.BindLink func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindLinkWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("LINK", patternURL, nil, permissions, handler, server.BindLink)
+}
+
+// BindOptionsWithPermissions is a wrapper func for server.BindOptions func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindOptionsWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("OPTIONS", patternURL, nil, permissions, handler, server.BindOptions)
+}
+
+// BindPatchWithPermissions is a wrapper func for server.BindPatch func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindPatchWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("PATCH", patternURL, nil, permissions, handler, server.BindPatch)
+}
+
+// BindPostWithPermissions is a wrapper func for server.BindPost func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindPostWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("POST", patternURL, nil, permissions, handler, server.BindPost)
+}
+
+// BindPurgeWithPermissions is a wrapper func for server.BindPurge func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindPurgeWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("PURGE", patternURL, nil, permissions, handler, server.BindPurge)
+}
+
+// BindPutWithPermissions is a wrapper func for server.BindPut func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindPutWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("PUT", patternURL, nil, permissions, handler, server.BindPut)
+}
+
+// BindUnlinkWithPermissions is a wrapper func for server.BindUnlink func. This will add ValidateToken
+// and ValidatePermissions around HandleContextFunc. User must be granted all permissions. If
+// policy manifest is loaded, route's policy takes precedence.
+//
+// @param
+// - patternURL {string} (the URL matching pattern)
+// - permissions {[]string} (a list of required permissions)
+// - handler {server.HandleContextFunc} (the callback func)
+func BindUnlinkWithPermissions(patternURL string, permissions []string, handler server.HandleContextFunc) {
+	bindRoute("UNLINK", patternURL, nil, permissions, handler, server.BindUnlink)
 }
@@ -31,8 +31,9 @@ type TestEnv struct {
 	Username     string
 	Password     string
 	UserID       bson.ObjectId
-	ClientID     bson.ObjectId
-	ClientSecret bson.ObjectId
+	MachineID    bson.ObjectId
+	ClientID     string
+	ClientSecret string
 	CreatedTime  time.Time
 }
 
@@ -44,14 +45,15 @@ func (u *TestEnv) Setup() {
 	u.Username = "admin"
 	u.Password = "Password"
 	u.UserID = bson.NewObjectId()
-	u.ClientID = bson.NewObjectId()
-	u.ClientSecret = bson.NewObjectId()
+	u.MachineID = bson.NewObjectId()
+	u.ClientID = "ios-app-test"
+	u.ClientSecret, _ = GenerateClientSecret()
 	u.CreatedTime, _ = time.Parse(time.RFC822, "02 Jan 06 15:04 MST")
 
 	// Generate test data
 	u.Client = &MongoDBClient{
 		ID:     u.ClientID,
-		Secret: u.ClientSecret,
+		Secret: HashClientSecret(u.ClientSecret),
 		Grants: []string{AuthorizationCodeGrant, PasswordGrant, RefreshTokenGrant},
 
 		Redirects: []string{"http://www.sample01.com", "http://www.sample02.com"},
@@ -65,10 +67,10 @@ func (u *TestEnv) Setup() {
 		Roles: []string{"r_user", "r_admin"},
 	}
 
-	password2, _ := util.EncryptPassword(u.ClientSecret.Hex())
+	password2, _ := util.EncryptPassword(u.ClientSecret)
 	u.User2 = &MongoDBUser{
-		ID:    u.ClientID,
-		User:  u.ClientID.Hex(),
+		ID:    u.MachineID,
+		User:  u.ClientID,
 		Pass:  password2,
 		Roles: []string{"r_device"},
 	}
@@ -20,7 +20,16 @@ type TokenGrant struct {
 // @param
 // - c {server.RequestContext} (a request context)
 func (t *TokenGrant) HandleForm(c *server.RequestContext) {
-	s := new(OAuthContext)
+	s := &OAuthContext{Realm: RealmOf(c)}
+
+	// Count grant's outcome
+	defer func() {
+		if err := recover(); err != nil {
+			Metrics.ObserveGrant(s.GrantType, FailureOutcome)
+			panic(err)
+		}
+		Metrics.ObserveGrant(s.GrantType, SuccessOutcome)
+	}()
 
 	t.generalValidation(c, s)
 	t.finalizeToken(c, s)
@@ -33,20 +42,22 @@ func (t *TokenGrant) HandleForm(c *server.RequestContext) {
 // - s {OAuthContext} (an oauth context)
 func (t *TokenGrant) generalValidation(c *server.RequestContext, s *OAuthContext) {
 	// If client_id and client_secret are not include, try to look at the authorization header
+	authMethod := ClientSecretPost
 	if c.QueryParams != nil && len(c.QueryParams["client_id"]) == 0 && len(c.QueryParams["client_secret"]) == 0 {
 		c.QueryParams["client_id"], c.QueryParams["client_secret"], _ = c.BasicAuth()
+		authMethod = ClientSecretBasic
 	}
 
 	// Bind
 	var inputForm struct {
 		GrantType    string `field:"grant_type"`
-		ClientID     string `field:"client_id" validation:"^\\w+$"`
-		ClientSecret string `field:"client_secret" validation:"^\\w+$"`
+		ClientID     string `field:"client_id" validation:"^[\\w\\-\\.:~]+$"`
+		ClientSecret string `field:"client_secret" validation:"^\\w*$"` // Mutual TLS clients do not have secret
 	}
 	err := c.BindForm(&inputForm)
 
 	/* Condition validation: Validate grant_type */
-	if !grantsValidation.MatchString(inputForm.GrantType) {
+	if !s.Realm.AllowsGrant(inputForm.GrantType) {
 		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "grant_type")))
 	}
 
@@ -54,10 +65,32 @@ func (t *TokenGrant) generalValidation(c *server.RequestContext, s *OAuthContext
 	if err != nil {
 		panic(util.Status400WithDescription(err.Error()))
 	}
+	s.GrantType = inputForm.GrantType
+
+	/* Condition validation: Validate DPoP proof */
+	s.KeyThumbprint = validateTokenRequestProof(c, s.Realm)
 
 	/* Condition validation: Check the store */
-	recordClient := Store.FindClientWithCredential(inputForm.ClientID, inputForm.ClientSecret)
+	var recordClient Client
+	if certificates := clientCertificates(c); len(inputForm.ClientSecret) == 0 && len(certificates) > 0 {
+		// Mutual TLS clients authenticate with their certificates
+		if client := s.Realm.Store.FindClientWithID(inputForm.ClientID); client != nil {
+			if thumbprint, ok := authenticateTLSClient(client, certificates, s.Realm.now()); ok {
+				s.CertificateThumbprint = thumbprint
+				recordClient = client
+			}
+		}
+	} else {
+		recordClient = s.Realm.Store.FindClientWithCredential(inputForm.ClientID, inputForm.ClientSecret)
+		if recordClient != nil && !allowsAuthMethod(recordClient, authMethod) {
+			recordClient = nil
+		}
+	}
 	if recordClient == nil {
+		event := createSecurityEvent(c, ClientAuthFailedEvent, s, InvalidCredentialsReason)
+		event.ClientID = inputForm.ClientID
+		publishEvent(event)
+
 		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "client_id or client_secret")))
 	}
 
@@ -68,11 +101,13 @@ func (t *TokenGrant) generalValidation(c *server.RequestContext, s *OAuthContext
 	}
 	s.Client = recordClient
 
+	/* Condition validation: Check client's rate limit */
+	validateRateLimit(c, TokenEndpoint, recordClient)
+
 	// Choose authentication flow
 	switch inputForm.GrantType {
 
 	case AuthorizationCodeGrant:
-		// TODO: Going to do soon
 		t.handleAuthorizationCodeGrant(c, s)
 		break
 
@@ -100,10 +135,15 @@ func (t *TokenGrant) generalValidation(c *server.RequestContext, s *OAuthContext
 // - c {server.RequestContext} (a request context)
 // - s {OAuthContext} (an oauth context)
 func (t *TokenGrant) handleAuthorizationCodeGrant(c *server.RequestContext, s *OAuthContext) {
+	/* Condition validation: Check the store */
+	if _, ok := unwrapStore(s.Realm.Store).(AuthorizationStore); !ok {
+		panic(util.Status400WithDescription("The \"grant_type\" is not supported."))
+	}
+
 	// Bind
 	var inputForm struct {
 		Code        string `field:"code" validation:"^\\w+$"`
-		RedirectURI string `field:"redirect_uri" validation:"^\\w+$"`
+		RedirectURI string `field:"redirect_uri"`
 	}
 
 	/* Condition validation: Validate binding process */
@@ -112,35 +152,25 @@ func (t *TokenGrant) handleAuthorizationCodeGrant(c *server.RequestContext, s *O
 		panic(util.Status400WithDescription(err.Error()))
 	}
 
-	/* Condition validation: Check redirect_uri for client */
-	isAllow := false
-	for _, redirectURI := range s.Client.RedirectURIs() {
-		if redirectURI == inputForm.RedirectURI {
-			isAllow = true
-			break
-		}
+	/* Condition validation: Code must be issued to this client with the same redirect_uri */
+	code := s.Realm.Store.(AuthorizationStore).ConsumeAuthorizationCode(inputForm.Code)
+	if code == nil || code.ClientID() != s.Client.ClientID() || code.RedirectURI() != inputForm.RedirectURI {
+		publishEvent(createSecurityEvent(c, LoginFailedEvent, s, InvalidGrantReason))
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "code")))
 	}
-	if !isAllow {
-		panic(util.Status400WithDescription("The \"redirect_uri\" had not been registered for this \"client_id\"."))
+	if s.Realm.isExpired(code.ExpiredTime()) {
+		panic(util.Status400WithDescription("\"code\" is expired."))
 	}
 
-	//	t.store.FindAuthorizationCode(authorizationCode)
-	// this.model.getAuthCode(code, function (err, authCode) {
-
-	//   if (!authCode || authCode.clientId !== self.client.clientId) {
-	//     return done(error('invalid_grant', 'Invalid code'));
-	//   } else if (authCode.expires < self.now) {
-	//     return done(error('invalid_grant', 'Code has expired'));
-	//   }
-
-	//   self.user = authCode.user || { id: authCode.userId };
-	//   if (!self.user.id) {
-	//     return done(error('server_error', false,
-	//       'No user/userId parameter returned from getauthCode'));
-	//   }
+	if s.User = s.Realm.Store.FindUserWithID(code.UserID()); s.User == nil {
+		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "code")))
+	}
 
-	//   done();
-	// });
+	// Token only carries the scopes that user had consented to
+	s.Scopes = code.CodeScopes()
+	if s.Scopes == nil {
+		s.Scopes = []string{}
+	}
 }
 
 // handleClientCredentialsGrant handles client credentials grant flow.
@@ -149,9 +179,17 @@ func (t *TokenGrant) handleAuthorizationCodeGrant(c *server.RequestContext, s *O
 // - c {server.RequestContext} (a request context)
 // - s {OAuthContext} (an oauth context)
 func (t *TokenGrant) handleClientCredentialsGrant(clientID string, clientSecret string, c *server.RequestContext, s *OAuthContext) {
-	if user := Store.FindUserWithClient(clientID, clientSecret); user != nil {
+	var user User
+	if _, ok := unwrapStore(s.Realm.Store).(TLSClientStore); ok && len(s.CertificateThumbprint) > 0 {
+		user = s.Realm.Store.(TLSClientStore).FindUserWithClientID(clientID)
+	} else {
+		user = s.Realm.Store.FindUserWithClient(clientID, clientSecret)
+	}
+
+	if user != nil {
 		s.User = user
 	} else {
+		publishEvent(createSecurityEvent(c, ClientAuthFailedEvent, s, InvalidCredentialsReason))
 		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "client_id or client_secret")))
 	}
 }
@@ -170,10 +208,20 @@ func (t *TokenGrant) passwordFlow(c *server.RequestContext, s *OAuthContext) {
 		panic(util.Status400WithDescription("Invalid 'username or password' parameter."))
 	}
 
+	/* Condition validation: Validate failed login attempts */
+	event := createSecurityEvent(c, LoginFailedEvent, s, InvalidCredentialsReason)
+	event.Username = passwordForm.Username
+
+	attemptKeys := loginAttemptKeys(c, passwordForm.Username)
+	validateLoginAttempts(c, attemptKeys, event)
+
 	/* Condition validation: Validate user's credentials */
-	if recordUser := Store.FindUserWithCredential(passwordForm.Username, passwordForm.Password); recordUser != nil {
+	if recordUser := s.Realm.Store.FindUserWithCredential(passwordForm.Username, passwordForm.Password); recordUser != nil {
+		unlockUser(s.Realm, passwordForm.Username)
 		s.User = recordUser
 	} else {
+		saveLoginFailure(c, attemptKeys)
+		publishEvent(event)
 		panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "username or password")))
 	}
 }
@@ -188,22 +236,32 @@ func (t *TokenGrant) refreshTokenFlow(c *server.RequestContext, s *OAuthContext)
 	if queryToken := c.QueryParams["refresh_token"]; len(queryToken) > 0 {
 
 		/* Condition validation: Validate refresh_token */
-		refreshToken := Store.FindRefreshToken(queryToken)
+		refreshToken := s.Realm.Store.FindRefreshToken(queryToken)
 
 		if refreshToken == nil || refreshToken.ClientID() != s.Client.ClientID() {
+			publishEvent(createSecurityEvent(c, LoginFailedEvent, s, InvalidGrantReason))
 			panic(util.Status400WithDescription(fmt.Sprintf(stringFormat.InvalidParameter, "refresh_token")))
 		}
-		if refreshToken.IsExpired() {
+		if s.Realm.isExpired(refreshToken.ExpiredTime()) {
 			panic(util.Status400WithDescription("\refresh_token\" is expired."))
 		}
-		s.User = Store.FindUserWithID(refreshToken.UserID())
+
+		/* Condition validation: Token of a DPoP key must be refreshed with a proof of the same key */
+		if keyThumbprint := boundKeyThumbprint(refreshToken); len(keyThumbprint) > 0 && keyThumbprint != s.KeyThumbprint {
+			publishEvent(createSecurityEvent(c, LoginFailedEvent, s, InvalidGrantReason))
+			panic(&util.Status{Code: 400, Title: InvalidDPoPProofError, Description: dpopErrorDescription(InvalidDPoPProofError)})
+		}
+
+		s.User = s.Realm.Store.FindUserWithID(refreshToken.UserID())
+		if scopedToken, ok := refreshToken.(ScopedToken); ok {
+			s.Scopes = scopedToken.TokenScopes()
+		}
 
 		// Delete current access token
-		accessToken := Store.FindAccessTokenWithCredential(refreshToken.ClientID(), refreshToken.UserID())
-		Store.DeleteAccessToken(accessToken)
+		s.Realm.Store.DeleteAccessToken(s.Realm.Store.FindAccessTokenWithCredential(refreshToken.ClientID(), refreshToken.UserID()))
 
 		// Delete current refresh token
-		Store.DeleteRefreshToken(refreshToken)
+		s.Realm.Store.DeleteRefreshToken(refreshToken)
 		refreshToken = nil
 
 		// Update security context
@@ -220,57 +278,199 @@ func (t *TokenGrant) refreshTokenFlow(c *server.RequestContext, s *OAuthContext)
 // - c {server.RequestContext} (a request context)
 // - s {OAuthContext} (an oauth context)
 func (t *TokenGrant) finalizeToken(c *server.RequestContext, s *OAuthContext) {
-	now := time.Now()
+	now := s.Realm.now()
 
 	// Generate access token if neccessary
+	isIssued := false
 	if s.AccessToken == nil {
-		accessToken := Store.FindAccessTokenWithCredential(s.Client.ClientID(), s.User.UserID())
-		if accessToken != nil && accessToken.IsExpired() {
-			Store.DeleteAccessToken(accessToken)
+		accessToken := s.Realm.Store.FindAccessTokenWithCredential(s.Client.ClientID(), s.User.UserID())
+		if accessToken != nil && (s.Realm.isExpired(accessToken.ExpiredTime()) || !hasSameScopes(accessToken, s.Scopes) || !hasSameBinding(accessToken, accessTokenBinding(s))) {
+			s.Realm.Store.DeleteAccessToken(accessToken)
 			accessToken = nil
 		}
 
 		if accessToken == nil {
-			accessToken = Store.CreateAccessToken(
-				s.Client.ClientID(),
-				s.User.UserID(),
-				now,
-				now.Add(Cfg.AccessTokenDuration),
-			)
+			accessToken = t.createAccessToken(s, now, now.Add(s.Realm.Config.AccessTokenDuration))
+			isIssued = true
 		}
 		s.AccessToken = accessToken
 	}
 
 	// Generate refresh token if neccessary
-	if Cfg.AllowRefreshToken && s.RefreshToken == nil {
-		refreshToken := Store.FindRefreshTokenWithCredential(s.Client.ClientID(), s.User.UserID())
-		if refreshToken != nil && refreshToken.IsExpired() {
-			Store.DeleteRefreshToken(refreshToken)
+	if s.Realm.Config.AllowRefreshToken && s.RefreshToken == nil {
+		refreshToken := s.Realm.Store.FindRefreshTokenWithCredential(s.Client.ClientID(), s.User.UserID())
+		if refreshToken != nil && (s.Realm.isExpired(refreshToken.ExpiredTime()) || !hasSameScopes(refreshToken, s.Scopes) || !hasSameBinding(refreshToken, refreshTokenBinding(s))) {
+			s.Realm.Store.DeleteRefreshToken(refreshToken)
 			refreshToken = nil
 		}
 
 		if refreshToken == nil {
-			refreshToken = Store.CreateRefreshToken(
-				s.Client.ClientID(),
-				s.User.UserID(),
-				now,
-				now.Add(Cfg.RefreshTokenDuration),
-			)
+			refreshToken = t.createRefreshToken(s, now, now.Add(s.Realm.Config.RefreshTokenDuration))
 		}
 		s.RefreshToken = refreshToken
 	}
 
 	// Generate response token
 	tokenResponse := &OAuthResponse{
-		TokenType:   "Bearer",
+		TokenType:   accessTokenType(s.AccessToken),
 		AccessToken: s.AccessToken.Token(),
-		ExpiresIn:   s.AccessToken.ExpiredTime().Unix() - time.Now().UTC().Unix(),
-		Roles:       s.User.UserRoles(),
+		ExpiresIn:   s.AccessToken.ExpiredTime().Unix() - now.UTC().Unix(),
+		Roles:       s.GrantedRoles(),
+	}
+	if token, ok := s.AccessToken.(ScopedToken); ok {
+		tokenResponse.Scope = strings.Join(token.TokenScopes(), " ")
 	}
 
 	// Only add refresh_token if allowed
-	if Cfg.AllowRefreshToken {
+	if s.Realm.Config.AllowRefreshToken {
 		tokenResponse.RefreshToken = s.RefreshToken.Token()
 	}
+
+	// Notify subscribers, every successful grant is reported even if existing token is returned
+	if s.GrantType == RefreshTokenGrant {
+		publishEvent(createSecurityEvent(c, TokenRefreshedEvent, s, ""))
+	} else if isIssued {
+		publishEvent(createSecurityEvent(c, TokenIssuedEvent, s, ""))
+	} else {
+		publishEvent(createSecurityEvent(c, TokenIssuedEvent, s, ReusedTokenReason))
+	}
 	c.OutputJSON(util.Status200(), tokenResponse)
 }
+
+// createAccessToken creates an access token. If user had consented to scopes, token only carries
+// those scopes. If client had presented a DPoP proof or had authenticated with mutual TLS, token
+// is bound to client's key or certificate.
+//
+// @param
+// - s {OAuthContext} (an oauth context)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (an access token's instance)
+func (t *TokenGrant) createAccessToken(s *OAuthContext, createdTime time.Time, expiredTime time.Time) Token {
+	if binding := accessTokenBinding(s); len(binding.keyThumbprint) > 0 {
+		return s.Realm.Store.(DPoPTokenStore).CreateDPoPAccessToken(s.Client.ClientID(), s.User.UserID(), s.Scopes, binding.keyThumbprint, createdTime, expiredTime)
+	} else if len(binding.certificateThumbprint) > 0 {
+		return s.Realm.Store.(TLSClientStore).CreateBoundAccessToken(s.Client.ClientID(), s.User.UserID(), s.Scopes, binding.certificateThumbprint, createdTime, expiredTime)
+	}
+	if _, ok := unwrapStore(s.Realm.Store).(ScopedTokenStore); ok && s.Scopes != nil {
+		return s.Realm.Store.(ScopedTokenStore).CreateScopedAccessToken(s.Client.ClientID(), s.User.UserID(), s.Scopes, createdTime, expiredTime)
+	}
+	return s.Realm.Store.CreateAccessToken(s.Client.ClientID(), s.User.UserID(), createdTime, expiredTime)
+}
+
+// createRefreshToken creates a refresh token. If user had consented to scopes, token only
+// carries those scopes. If client had presented a DPoP proof, token is bound to client's key.
+//
+// @param
+// - s {OAuthContext} (an oauth context)
+// - createdTime {time.Time} (token's issued time)
+// - expiredTime {time.Time} (token's expired time)
+//
+// @return
+// - token {Token} (a refresh token's instance)
+func (t *TokenGrant) createRefreshToken(s *OAuthContext, createdTime time.Time, expiredTime time.Time) Token {
+	if binding := refreshTokenBinding(s); len(binding.keyThumbprint) > 0 {
+		return s.Realm.Store.(DPoPTokenStore).CreateDPoPRefreshToken(s.Client.ClientID(), s.User.UserID(), s.Scopes, binding.keyThumbprint, createdTime, expiredTime)
+	}
+	if _, ok := unwrapStore(s.Realm.Store).(ScopedTokenStore); ok && s.Scopes != nil {
+		return s.Realm.Store.(ScopedTokenStore).CreateScopedRefreshToken(s.Client.ClientID(), s.User.UserID(), s.Scopes, createdTime, expiredTime)
+	}
+	return s.Realm.Store.CreateRefreshToken(s.Client.ClientID(), s.User.UserID(), createdTime, expiredTime)
+}
+
+// hasSameScopes checks if an existing token carries exactly the required scopes. Null scopes
+// means there is no requirement.
+//
+// @param
+// - token {Token} (an existing token)
+// - scopes {[]string} (the required scopes or null)
+//
+// @return
+// - isSame {bool} (true if token can be reused)
+func hasSameScopes(token Token, scopes []string) bool {
+	if scopes == nil {
+		return true
+	}
+
+	scopedToken, ok := token.(ScopedToken)
+	if !ok {
+		return len(scopes) == 0
+	}
+	tokenScopes := scopedToken.TokenScopes()
+	return len(tokenScopes) == len(scopes) && containsScopes(tokenScopes, scopes)
+}
+
+// accessTokenBinding returns the keys that a new access token will be bound to. DPoP key takes
+// precedence over client's certificate, keys are ignored if token store cannot bind tokens.
+//
+// @param
+// - s {OAuthContext} (an oauth context)
+//
+// @return
+// - binding {tokenBinding} (the keys that token will be bound to)
+func accessTokenBinding(s *OAuthContext) tokenBinding {
+	store := unwrapStore(s.Realm.Store)
+	if _, ok := store.(DPoPTokenStore); ok && len(s.KeyThumbprint) > 0 {
+		return tokenBinding{keyThumbprint: s.KeyThumbprint}
+	}
+	if _, ok := store.(TLSClientStore); ok && len(s.CertificateThumbprint) > 0 {
+		return tokenBinding{certificateThumbprint: s.CertificateThumbprint}
+	}
+	return tokenBinding{}
+}
+
+// refreshTokenBinding returns the keys that a new refresh token will be bound to. Only DPoP key
+// is used, client's certificate is verified again whenever client authenticates.
+//
+// @param
+// - s {OAuthContext} (an oauth context)
+//
+// @return
+// - binding {tokenBinding} (the keys that token will be bound to)
+func refreshTokenBinding(s *OAuthContext) tokenBinding {
+	if binding := accessTokenBinding(s); len(binding.keyThumbprint) > 0 {
+		return binding
+	}
+	return tokenBinding{}
+}
+
+// hasSameBinding checks if an existing token is bound to exactly the required keys. Empty
+// binding means token must not be bound.
+//
+// @param
+// - token {Token} (an existing token)
+// - binding {tokenBinding} (the required keys)
+//
+// @return
+// - isSame {bool} (true if token can be reused)
+func hasSameBinding(token Token, binding tokenBinding) bool {
+	var existingBinding tokenBinding
+	if boundToken, ok := token.(BoundToken); ok {
+		existingBinding.certificateThumbprint = boundToken.CertificateThumbprint()
+	}
+	if boundToken, ok := token.(DPoPBoundToken); ok {
+		existingBinding.keyThumbprint = boundToken.KeyThumbprint()
+	}
+	return existingBinding == binding
+}
+
+// allowsAuthMethod checks if client may authenticate with an authentication method. Clients
+// without a registered method accept both client_secret_basic and client_secret_post.
+//
+// @param
+// - client {Client} (a client entity)
+// - authMethod {string} (the authentication method that client had used)
+//
+// @return
+// - isAllowed {bool} (true if authentication method matches client's registered method)
+func allowsAuthMethod(client Client, authMethod string) bool {
+	tlsClient, ok := client.(TLSClient)
+
+	/* Condition validation */
+	if !ok || len(tlsClient.TokenEndpointAuthMethod()) == 0 {
+		return true
+	}
+	return tlsClient.TokenEndpointAuthMethod() == authMethod
+}
@@ -8,8 +8,6 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/phuc0302/go-mongo"
-	"github.com/phuc0302/go-oauth2/oauth_table"
 	"github.com/phuc0302/go-server"
 	"github.com/phuc0302/go-server/expected_format"
 	"github.com/phuc0302/go-server/string_format"
@@ -73,7 +71,7 @@ func Test_TokenGrant_validateForm_MissingClientID(t *testing.T) {
 	}
 
 	// [Test 3] Missing client_secret
-	response, _ = http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s", AuthorizationCodeGrant, u.ClientID.Hex())))
+	response, _ = http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s", AuthorizationCodeGrant, u.ClientID)))
 	status = util.ParseStatus(response)
 	if status.Description != fmt.Sprintf(stringFormat.InvalidParameter, "client_secret") {
 		t.Errorf(expectedFormat.InvalidParameter, "client_secret", status.Description)
@@ -94,7 +92,7 @@ func Test_TokenGrant_validateForm_MissingClientSecret(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s", AuthorizationCodeGrant, u.ClientID.Hex())))
+	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s", AuthorizationCodeGrant, u.ClientID)))
 	status := util.ParseStatus(response)
 	if status == nil {
 		t.Error(expectedFormat.NotNil)
@@ -125,8 +123,8 @@ func Test_TokenGrant_passwordFlow_MissingUsername(t *testing.T) {
 
 	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s",
 		PasswordGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 	)))
 	status := util.ParseStatus(response)
 	if status == nil {
@@ -157,8 +155,8 @@ func Test_TokenGrant_passwordFlow_MissingPassword(t *testing.T) {
 
 	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s",
 		PasswordGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 		"admin",
 	)))
 	status := util.ParseStatus(response)
@@ -190,8 +188,8 @@ func Test_TokenGrant_passwordFlow_ValidParams(t *testing.T) {
 
 	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
 		PasswordGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 		"admin",
 		"Password",
 	)))
@@ -237,8 +235,8 @@ func Test_TokenGrant_NotAllowRefreshToken(t *testing.T) {
 
 	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
 		PasswordGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 		u.Username,
 		u.Password,
 	)))
@@ -271,8 +269,8 @@ func Test_TokenGrant_refreshTokenFlow_MissingRefreshToken(t *testing.T) {
 	// Send first request to get refresh token
 	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
 		PasswordGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 		u.Username,
 		u.Password,
 	)))
@@ -280,8 +278,8 @@ func Test_TokenGrant_refreshTokenFlow_MissingRefreshToken(t *testing.T) {
 	// Send second request
 	response, _ = http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s",
 		RefreshTokenGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 	)))
 	status := util.ParseStatus(response)
 	if status == nil {
@@ -373,8 +371,8 @@ func Test_TokenGrant_refreshTokenFlow_ValidParams(t *testing.T) {
 	// Send first request to get refresh token
 	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
 		PasswordGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 		u.Username,
 		u.Password,
 	)))
@@ -383,8 +381,8 @@ func Test_TokenGrant_refreshTokenFlow_ValidParams(t *testing.T) {
 	// Send second request
 	response, _ = http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&refresh_token=%s",
 		RefreshTokenGrant,
-		u.ClientID.Hex(),
-		u.ClientSecret.Hex(),
+		u.ClientID,
+		u.ClientSecret,
 		token1.RefreshToken,
 	)))
 	token2 := parseResult(response)
@@ -395,30 +393,79 @@ func Test_TokenGrant_refreshTokenFlow_ValidParams(t *testing.T) {
 			t.Errorf("Expected new access_token but found \"%s\".", token2.AccessToken)
 		}
 
-		accessToken1, _ := Store.FindAccessToken(token1.AccessToken).(*MongoDBToken)
-		if err := mongo.EntityWithID(oauthTable.AccessToken, accessToken1.ID, new(MongoDBToken)); err == nil {
+		// Old tokens had been deleted, so they are no longer accepted
+		if accessToken1 := Store.FindAccessToken(token1.AccessToken); accessToken1 != nil {
 			t.Error(expectedFormat.Nil)
 		}
-		refreshToken1, _ := Store.FindRefreshToken(token1.RefreshToken).(*MongoDBToken)
-		if err := mongo.EntityWithID(oauthTable.RefreshToken, refreshToken1.ID, new(MongoDBToken)); err == nil {
+		if refreshToken1 := Store.FindRefreshToken(token1.RefreshToken); refreshToken1 != nil {
 			t.Error(expectedFormat.Nil)
 		}
 
-		accessToken2, _ := Store.FindAccessToken(token2.AccessToken).(*MongoDBToken)
-		if accessToken2 == nil {
+		if accessToken2 := Store.FindAccessToken(token2.AccessToken); accessToken2 == nil {
 			t.Error(expectedFormat.NotNil)
-		} else {
-			if accessToken2.ID == accessToken1.ID {
-				t.Errorf("Expected new access_token but found \"%s\".", token2.AccessToken)
-			}
 		}
-		refreshToken2, _ := Store.FindRefreshToken(token2.RefreshToken).(*MongoDBToken)
-		if refreshToken2 == nil {
+		if refreshToken2 := Store.FindRefreshToken(token2.RefreshToken); refreshToken2 == nil {
 			t.Error(expectedFormat.NotNil)
-		} else {
-			if refreshToken2.ID == refreshToken1.ID {
-				t.Errorf("Expected new refresh_token but found \"%s\".", token2.RefreshToken)
-			}
+		} else if token2.RefreshToken == token1.RefreshToken {
+			t.Errorf("Expected new refresh_token but found \"%s\".", token2.RefreshToken)
 		}
 	}
 }
+
+func Test_TokenGrant_passwordFlow_RestrictedClient(t *testing.T) {
+	u := new(TestEnv)
+	defer u.Teardown()
+	u.Setup()
+
+	// Client may only carry user's role and offline scope
+	Store.(AdminStore).UpdateClientRestrictions(u.ClientID, []string{"r_user", "r_manager"}, []string{"offline"})
+
+	// Setup server
+	controller := new(TokenGrant)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context := server.CreateContext(w, r)
+		defer server.Recovery(w, r)
+
+		controller.HandleForm(context)
+	}))
+	defer ts.Close()
+
+	response, _ := http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader(fmt.Sprintf("grant_type=%s&client_id=%s&client_secret=%s&username=%s&password=%s",
+		PasswordGrant,
+		u.ClientID,
+		u.ClientSecret,
+		"admin",
+		"Password",
+	)))
+
+	// Admin's role must be removed, inherited manager's role is not one of user's own roles
+	token := parseResult(response)
+	if len(token.Roles) != 1 || token.Roles[0] != "r_user" {
+		t.Errorf("Expected roles [r_user] but found %v.", token.Roles)
+	}
+	if token.Scope != "offline" {
+		t.Errorf(expectedFormat.StringButFoundString, "offline", token.Scope)
+	}
+
+	recordedAccessToken, ok := Store.FindAccessToken(token.AccessToken).(ScopedToken)
+	if !ok || len(recordedAccessToken.TokenScopes()) != 1 {
+		t.Error("Expected access token should carry client's scopes.")
+	}
+}
+
+func Test_TokenGrant_allowsAuthMethod(t *testing.T) {
+	// [Test 1] Client without registered method
+	client := &MongoDBClient{ID: "legacy"}
+	if !allowsAuthMethod(client, ClientSecretBasic) || !allowsAuthMethod(client, ClientSecretPost) {
+		t.Error("Expected client without registered method accepts both methods.")
+	}
+
+	// [Test 2] Client with registered method
+	client = &MongoDBClient{ID: "partner", AuthMethod: ClientSecretBasic}
+	if !allowsAuthMethod(client, ClientSecretBasic) {
+		t.Errorf(expectedFormat.BoolButFoundBool, true, false)
+	}
+	if allowsAuthMethod(client, ClientSecretPost) {
+		t.Errorf(expectedFormat.BoolButFoundBool, false, true)
+	}
+}